@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/peterhoward42/minikafka/protocol"
+)
+
+// This command line program is a general purpose MiniKafka client, for manual
+// testing and scripting. It has these subcommands:
+//
+//	produce <topic>                     - store each line read from stdin
+//	consume <topic> [-from N] [-follow] - print each message in the topic
+//	topics                              - print the name of each topic
+//	rm <topic>                          - delete the topic
+//
+// The server is specified with the -host flag, which comes before the
+// subcommand. E.g.
+//
+//	mkfk-cli -host localhost:9999 consume some_topic -from 3 -follow
+//
+// Consume prints the messages that are in the topic, and then stops, unless
+// -follow is given, in which case it carries on printing the messages as
+// they arrive until it is interrupted.
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// usage describes how to invoke the program.
+const usage = `usage: mkfk-cli -host <host> <subcommand> [arguments]
+
+subcommands:
+  produce <topic>                      store each line read from stdin
+  consume <topic> [-from N] [-follow]  print each message in the topic
+  topics                               print the name of each topic
+  rm <topic>                           delete the topic`
+
+// run carries out the subcommand given by the command line arguments, reading
+// messages to produce from stdin, and printing what it reports on stdout.
+func run(ctx context.Context, args []string, stdin io.Reader,
+	stdout io.Writer) error {
+	flags := flag.NewFlagSet("mkfk-cli", flag.ContinueOnError)
+	host := flags.String("host", "", "Specify a host. E.g. localhost:9999")
+	timeout := flags.Duration("timeout", 5*time.Second,
+		"The time allowed for each request to the server.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *host == "" {
+		return fmt.Errorf("you must specify a host with the -host flag")
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("you must specify a subcommand\n%s", usage)
+	}
+
+	conn, err := grpc.Dial(*host, grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("grpc.Dial: %v", err)
+	}
+	defer conn.Close()
+	c := command{client: pb.NewMiniKafkaClient(conn), timeout: *timeout,
+		stdout: stdout}
+
+	subcommand, subArgs := flags.Arg(0), flags.Args()[1:]
+	switch subcommand {
+	case "produce":
+		topic, err := parseTopicArgs(subcommand, subArgs, nil)
+		if err != nil {
+			return err
+		}
+		return c.produce(ctx, topic, stdin)
+	case "consume":
+		consumeFlags := flag.NewFlagSet("consume", flag.ContinueOnError)
+		from := consumeFlags.Int("from", 1,
+			"The message number to start from.")
+		follow := consumeFlags.Bool("follow", false,
+			"Carry on printing messages as they arrive.")
+		topic, err := parseTopicArgs(subcommand, subArgs, consumeFlags)
+		if err != nil {
+			return err
+		}
+		if *follow {
+			return c.follow(ctx, topic, *from)
+		}
+		return c.consume(ctx, topic, *from)
+	case "topics":
+		if len(subArgs) != 0 {
+			return fmt.Errorf("topics takes no arguments")
+		}
+		return c.topics(ctx)
+	case "rm":
+		topic, err := parseTopicArgs(subcommand, subArgs, nil)
+		if err != nil {
+			return err
+		}
+		return c.rm(ctx, topic)
+	default:
+		return fmt.Errorf("unknown subcommand: %q\n%s", subcommand, usage)
+	}
+}
+
+// parseTopicArgs parses the arguments of a subcommand that takes a single
+// topic, and, optionally, the flags given. The topic may come before, or
+// after the flags.
+func parseTopicArgs(subcommand string, args []string,
+	flags *flag.FlagSet) (topic string, err error) {
+	if flags == nil {
+		flags = flag.NewFlagSet(subcommand, flag.ContinueOnError)
+	}
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") == false {
+		topic, args = args[0], args[1:]
+	}
+	err = flags.Parse(args)
+	if err != nil {
+		return "", err
+	}
+	rest := flags.Args()
+	if topic == "" && len(rest) > 0 {
+		topic, rest = rest[0], rest[1:]
+	}
+	if topic == "" {
+		return "", fmt.Errorf("%s: you must specify a topic", subcommand)
+	}
+	if len(rest) != 0 {
+		return "", fmt.Errorf("%s: unexpected arguments: %v",
+			subcommand, rest)
+	}
+	return topic, nil
+}
+
+// command holds what the subcommands need to talk to the server, and report
+// back.
+type command struct {
+	client  pb.MiniKafkaClient // gRPC component.
+	timeout time.Duration
+	stdout  io.Writer
+}
+
+// produce stores each line read from stdin as a message in the topic.
+func (c command) produce(ctx context.Context, topic string,
+	stdin io.Reader) error {
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		reqCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		_, err := c.client.Produce(reqCtx, &pb.ProduceRequest{
+			Topic:   &pb.Topic{Topic: topic},
+			Payload: &pb.Payload{Payload: scanner.Bytes()}})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("client.Produce: %v", err)
+		}
+	}
+	err := scanner.Err()
+	if err != nil {
+		return fmt.Errorf("scanner.Scan: %v", err)
+	}
+	return nil
+}
+
+// consume prints each message in the topic, from the given message number
+// onwards, one per line.
+func (c command) consume(ctx context.Context, topic string,
+	readFrom int) error {
+	// Poll until there is nothing more to print.
+	for {
+		reqCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		resp, err := c.client.Poll(reqCtx, &pb.PollRequest{Topic: topic,
+			ReadFrom: &pb.MsgNumber{MsgNumber: uint32(readFrom)}})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("client.Poll: %v", err)
+		}
+		if len(resp.GetPayloads()) == 0 {
+			return nil
+		}
+		err = c.print(resp.GetPayloads())
+		if err != nil {
+			return err
+		}
+		readFrom = int(resp.GetNewReadFrom().GetMsgNumber())
+	}
+}
+
+// follow is like consume, except that it carries on printing messages as
+// they arrive, until the context is done.
+func (c command) follow(ctx context.Context, topic string,
+	readFrom int) error {
+	stream, err := c.client.Consume(ctx, &pb.PollRequest{Topic: topic,
+		ReadFrom: &pb.MsgNumber{MsgNumber: uint32(readFrom)}})
+	if err != nil {
+		return fmt.Errorf("client.Consume: %v", err)
+	}
+	for {
+		resp, err := stream.Recv()
+		if ctx.Err() != nil {
+			// Interrupted, which is how following is meant to end.
+			return nil
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("stream.Recv: %v", err)
+		}
+		err = c.print(resp.GetPayloads())
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// topics prints the name of each topic, one per line.
+func (c command) topics(ctx context.Context) error {
+	reqCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	resp, err := c.client.ListTopics(reqCtx, &pb.ListTopicsRequest{})
+	if err != nil {
+		return fmt.Errorf("client.ListTopics: %v", err)
+	}
+	for _, topic := range resp.GetTopics() {
+		_, err = fmt.Fprintln(c.stdout, topic)
+		if err != nil {
+			return fmt.Errorf("fmt.Fprintln: %v", err)
+		}
+	}
+	return nil
+}
+
+// rm deletes the topic.
+func (c command) rm(ctx context.Context, topic string) error {
+	reqCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	_, err := c.client.DeleteTopic(reqCtx, &pb.Topic{Topic: topic})
+	if err != nil {
+		return fmt.Errorf("client.DeleteTopic: %v", err)
+	}
+	return nil
+}
+
+// print prints the given messages, one per line.
+func (c command) print(payloads []*pb.Payload) error {
+	for _, payload := range payloads {
+		_, err := fmt.Fprintf(c.stdout, "%s\n", payload.GetPayload())
+		if err != nil {
+			return fmt.Errorf("fmt.Fprintf: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	pb "github.com/peterhoward42/minikafka/protocol"
+	"github.com/peterhoward42/minikafka/svr"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/memstore"
+)
+
+// TestProduceThenConsume drives the subcommands against a server launched
+// in-process, and makes sure that the lines produced come back out of
+// consume, and that topics are listed and removed.
+func TestProduceThenConsume(t *testing.T) {
+	ctx := context.Background()
+	host, stop := startServer(t)
+	defer stop()
+
+	lines := "first line\nsecond line\nthird line\n"
+	_, err := runCLI(ctx, strings.NewReader(lines),
+		"-host", host, "produce", "some_topic")
+	assert.Nil(t, err)
+	_, err = runCLI(ctx, strings.NewReader("other\n"),
+		"-host", host, "produce", "other_topic")
+	assert.Nil(t, err)
+
+	output, err := runCLI(ctx, nil, "-host", host, "consume", "some_topic")
+	assert.Nil(t, err)
+	assert.Equal(t, lines, output)
+	output, err = runCLI(ctx, nil,
+		"-host", host, "consume", "some_topic", "-from", "2")
+	assert.Nil(t, err)
+	assert.Equal(t, "second line\nthird line\n", output)
+	output, err = runCLI(ctx, nil,
+		"-host", host, "consume", "-from", "3", "some_topic")
+	assert.Nil(t, err)
+	assert.Equal(t, "third line\n", output)
+
+	output, err = runCLI(ctx, nil, "-host", host, "topics")
+	assert.Nil(t, err)
+	assert.Equal(t, "other_topic\nsome_topic\n", output)
+	_, err = runCLI(ctx, nil, "-host", host, "rm", "other_topic")
+	assert.Nil(t, err)
+	output, err = runCLI(ctx, nil, "-host", host, "topics")
+	assert.Nil(t, err)
+	assert.Equal(t, "some_topic\n", output)
+
+	_, err = runCLI(ctx, nil, "-host", host, "nosuchsubcommand")
+	assert.NotNil(t, err)
+	_, err = runCLI(ctx, nil, "-host", host, "consume")
+	assert.NotNil(t, err)
+	_, err = runCLI(ctx, nil, "consume", "some_topic")
+	assert.NotNil(t, err)
+}
+
+// TestConsumeFollow makes sure that consume with -follow prints the lines
+// already produced, and then those produced afterwards, until it is
+// interrupted.
+func TestConsumeFollow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	host, stop := startServer(t)
+	defer stop()
+
+	_, err := runCLI(ctx, strings.NewReader("before\n"),
+		"-host", host, "produce", "some_topic")
+	assert.Nil(t, err)
+
+	reader, writer := io.Pipe()
+	done := make(chan error)
+	go func() {
+		args := []string{"-host", host, "consume", "some_topic", "-follow"}
+		err := run(ctx, args, nil, writer)
+		writer.Close()
+		done <- err
+	}()
+	received := bufio.NewScanner(reader)
+	assert.True(t, received.Scan())
+	assert.Equal(t, "before", received.Text())
+	_, err = runCLI(context.Background(), strings.NewReader("after\n"),
+		"-host", host, "produce", "some_topic")
+	assert.Nil(t, err)
+	assert.True(t, received.Scan())
+	assert.Equal(t, "after", received.Text())
+
+	cancel()
+	for received.Scan() {
+		// Drain the pipe, so that the goroutine can finish.
+	}
+	assert.Nil(t, <-done)
+}
+
+// runCLI runs the program with the given arguments, and stdin, and provides
+// what it printed on stdout.
+func runCLI(ctx context.Context, stdin io.Reader, args ...string) (
+	string, error) {
+	var stdout bytes.Buffer
+	err := run(ctx, args, stdin, &stdout)
+	return stdout.String(), err
+}
+
+// startServer launches a server, backed by a MemStore, and provides the host
+// it is listening on, and a function to stop it.
+func startServer(t *testing.T) (host string, stop func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		msg := fmt.Sprintf("net.Listen(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	grpcServer := grpc.NewServer()
+	pb.RegisterMiniKafkaServer(grpcServer,
+		svr.NewServer(memstore.NewMemStore()))
+	go grpcServer.Serve(listener)
+	return listener.Addr().String(), grpcServer.Stop
+}
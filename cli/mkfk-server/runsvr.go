@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/memstore"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/walstore"
 
 	"github.com/peterhoward42/minikafka/svr/backends/contract"
 
@@ -18,18 +20,35 @@ import (
 // mandates it to start serving.
 func main() {
 
-	host, retentionTime, rootDir := readEnvironmentVariables()
+	host, retentionTime, rootDir, storeKind := readEnvironmentVariables()
 
-	// Create an in-memory, or file-based backing store according
-	// to the environment variables.
+	// Create an in-memory, file-based, or write-ahead-log backing store
+	// according to the environment variables.
 	var err error
 	var backingStore contract.BackingStore
 	var storeMessage string
 	if rootDir == "" {
 		backingStore = memstore.NewMemStore()
 		storeMessage = "In-memory (volatile) store"
+	} else if storeKind == "wal" {
+		backingStore, err = walstore.NewWALStore(rootDir)
+		if err != nil {
+			log.Fatalf("walstore.NewWALStore(): %v", err)
+		}
+		storeMessage = fmt.Sprintf("Write-ahead-log store rooted at: %s",
+			rootDir)
 	} else {
 		backingStore, err = filestore.NewFileStore(rootDir)
+		if errors.Is(err, contract.ErrCorruptIndex) {
+			log.Printf("Rebuilding the index, since: %v", err)
+			// Records dropped by the rebuild are reported, but do not stop
+			// the store opening.
+			err = filestore.RebuildIndex(rootDir)
+			if err != nil {
+				log.Printf("filestore.RebuildIndex(): %v", err)
+			}
+			backingStore, err = filestore.NewFileStore(rootDir)
+		}
 		if err != nil {
 			log.Fatalf("filestore.NewFileStore(): %v", err)
 		}
@@ -53,16 +72,18 @@ func main() {
 
 // readEnvironmentVariables fetches the configuration parameters parameterise
 // the operation of the server from environment variables.
-func readEnvironmentVariables() (
-	host string, retentionTime time.Duration, rootDir string) {
+func readEnvironmentVariables() (host string, retentionTime time.Duration,
+	rootDir string, storeKind string) {
 
 	const hostEnvVar string = "MINIKAFKA_HOST"
 	const retentionEnvVar string = "MINIKAFKA_RETENTIONTIME"
 	const rootDirEnvVar string = "MINIKAFKA_ROOT_DIR"
+	const storeKindEnvVar string = "MINIKAFKA_STORE"
 
 	host = os.Getenv(hostEnvVar)
 	rt := os.Getenv(retentionEnvVar)
 	rootDir = os.Getenv(rootDirEnvVar)
+	storeKind = os.Getenv(storeKindEnvVar)
 
 	// Host and retention time (unlike root directory) are obligatory.
 	if host == "" {
@@ -74,10 +95,16 @@ func readEnvironmentVariables() (
 			"E.g. 3s or 10m", retentionEnvVar)
 	}
 
+	// The store kind is optional, and only matters with a root directory.
+	if storeKind != "" && storeKind != "file" && storeKind != "wal" {
+		log.Fatalf("The %s environment variable must be file or wal",
+			storeKindEnvVar)
+	}
+
 	retentionTime, err := time.ParseDuration(rt)
 	if err != nil {
 		log.Fatalf("Error parsing this retention time (%s) from \n"+
 			"the %s environment variable: %s", rt, retentionEnvVar, err)
 	}
-	return host, retentionTime, rootDir
+	return host, retentionTime, rootDir, storeKind
 }
@@ -34,7 +34,7 @@ func (m *Topic) Reset()         { *m = Topic{} }
 func (m *Topic) String() string { return proto.CompactTextString(m) }
 func (*Topic) ProtoMessage()    {}
 func (*Topic) Descriptor() ([]byte, []int) {
-	return fileDescriptor_minikafka_07f0578420994a20, []int{0}
+	return fileDescriptor_minikafka_a958b38b35d78997, []int{0}
 }
 func (m *Topic) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Topic.Unmarshal(m, b)
@@ -72,7 +72,7 @@ func (m *Payload) Reset()         { *m = Payload{} }
 func (m *Payload) String() string { return proto.CompactTextString(m) }
 func (*Payload) ProtoMessage()    {}
 func (*Payload) Descriptor() ([]byte, []int) {
-	return fileDescriptor_minikafka_07f0578420994a20, []int{1}
+	return fileDescriptor_minikafka_a958b38b35d78997, []int{1}
 }
 func (m *Payload) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Payload.Unmarshal(m, b)
@@ -111,7 +111,7 @@ func (m *ProduceRequest) Reset()         { *m = ProduceRequest{} }
 func (m *ProduceRequest) String() string { return proto.CompactTextString(m) }
 func (*ProduceRequest) ProtoMessage()    {}
 func (*ProduceRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_minikafka_07f0578420994a20, []int{2}
+	return fileDescriptor_minikafka_a958b38b35d78997, []int{2}
 }
 func (m *ProduceRequest) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ProduceRequest.Unmarshal(m, b)
@@ -156,7 +156,7 @@ func (m *MsgNumber) Reset()         { *m = MsgNumber{} }
 func (m *MsgNumber) String() string { return proto.CompactTextString(m) }
 func (*MsgNumber) ProtoMessage()    {}
 func (*MsgNumber) Descriptor() ([]byte, []int) {
-	return fileDescriptor_minikafka_07f0578420994a20, []int{3}
+	return fileDescriptor_minikafka_a958b38b35d78997, []int{3}
 }
 func (m *MsgNumber) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_MsgNumber.Unmarshal(m, b)
@@ -195,7 +195,7 @@ func (m *PollRequest) Reset()         { *m = PollRequest{} }
 func (m *PollRequest) String() string { return proto.CompactTextString(m) }
 func (*PollRequest) ProtoMessage()    {}
 func (*PollRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_minikafka_07f0578420994a20, []int{4}
+	return fileDescriptor_minikafka_a958b38b35d78997, []int{4}
 }
 func (m *PollRequest) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_PollRequest.Unmarshal(m, b)
@@ -244,7 +244,7 @@ func (m *PollResponse) Reset()         { *m = PollResponse{} }
 func (m *PollResponse) String() string { return proto.CompactTextString(m) }
 func (*PollResponse) ProtoMessage()    {}
 func (*PollResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_minikafka_07f0578420994a20, []int{5}
+	return fileDescriptor_minikafka_a958b38b35d78997, []int{5}
 }
 func (m *PollResponse) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_PollResponse.Unmarshal(m, b)
@@ -278,6 +278,105 @@ func (m *PollResponse) GetNewReadFrom() *MsgNumber {
 	return nil
 }
 
+type ListTopicsRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListTopicsRequest) Reset()         { *m = ListTopicsRequest{} }
+func (m *ListTopicsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListTopicsRequest) ProtoMessage()    {}
+func (*ListTopicsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_minikafka_a958b38b35d78997, []int{6}
+}
+func (m *ListTopicsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListTopicsRequest.Unmarshal(m, b)
+}
+func (m *ListTopicsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListTopicsRequest.Marshal(b, m, deterministic)
+}
+func (dst *ListTopicsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListTopicsRequest.Merge(dst, src)
+}
+func (m *ListTopicsRequest) XXX_Size() int {
+	return xxx_messageInfo_ListTopicsRequest.Size(m)
+}
+func (m *ListTopicsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListTopicsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListTopicsRequest proto.InternalMessageInfo
+
+type TopicList struct {
+	// topics holds the names of the topics, sorted alphabetically.
+	Topics               []string `protobuf:"bytes,1,rep,name=topics,proto3" json:"topics,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TopicList) Reset()         { *m = TopicList{} }
+func (m *TopicList) String() string { return proto.CompactTextString(m) }
+func (*TopicList) ProtoMessage()    {}
+func (*TopicList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_minikafka_a958b38b35d78997, []int{7}
+}
+func (m *TopicList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TopicList.Unmarshal(m, b)
+}
+func (m *TopicList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TopicList.Marshal(b, m, deterministic)
+}
+func (dst *TopicList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TopicList.Merge(dst, src)
+}
+func (m *TopicList) XXX_Size() int {
+	return xxx_messageInfo_TopicList.Size(m)
+}
+func (m *TopicList) XXX_DiscardUnknown() {
+	xxx_messageInfo_TopicList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TopicList proto.InternalMessageInfo
+
+func (m *TopicList) GetTopics() []string {
+	if m != nil {
+		return m.Topics
+	}
+	return nil
+}
+
+type DeleteTopicResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteTopicResponse) Reset()         { *m = DeleteTopicResponse{} }
+func (m *DeleteTopicResponse) String() string { return proto.CompactTextString(m) }
+func (*DeleteTopicResponse) ProtoMessage()    {}
+func (*DeleteTopicResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_minikafka_a958b38b35d78997, []int{8}
+}
+func (m *DeleteTopicResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteTopicResponse.Unmarshal(m, b)
+}
+func (m *DeleteTopicResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteTopicResponse.Marshal(b, m, deterministic)
+}
+func (dst *DeleteTopicResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteTopicResponse.Merge(dst, src)
+}
+func (m *DeleteTopicResponse) XXX_Size() int {
+	return xxx_messageInfo_DeleteTopicResponse.Size(m)
+}
+func (m *DeleteTopicResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteTopicResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteTopicResponse proto.InternalMessageInfo
+
 func init() {
 	proto.RegisterType((*Topic)(nil), "protocol.Topic")
 	proto.RegisterType((*Payload)(nil), "protocol.Payload")
@@ -285,6 +384,9 @@ func init() {
 	proto.RegisterType((*MsgNumber)(nil), "protocol.MsgNumber")
 	proto.RegisterType((*PollRequest)(nil), "protocol.PollRequest")
 	proto.RegisterType((*PollResponse)(nil), "protocol.PollResponse")
+	proto.RegisterType((*ListTopicsRequest)(nil), "protocol.ListTopicsRequest")
+	proto.RegisterType((*TopicList)(nil), "protocol.TopicList")
+	proto.RegisterType((*DeleteTopicResponse)(nil), "protocol.DeleteTopicResponse")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -302,6 +404,14 @@ type MiniKafkaClient interface {
 	// Produce returns the message number assigned to the stored message.
 	Produce(ctx context.Context, in *ProduceRequest, opts ...grpc.CallOption) (*MsgNumber, error)
 	Poll(ctx context.Context, in *PollRequest, opts ...grpc.CallOption) (*PollResponse, error)
+	// Consume streams the messages in the topic from read_from onwards, as
+	// they arrive, until the client cancels the call. Each response holds the
+	// messages that arrived together.
+	Consume(ctx context.Context, in *PollRequest, opts ...grpc.CallOption) (MiniKafka_ConsumeClient, error)
+	ListTopics(ctx context.Context, in *ListTopicsRequest, opts ...grpc.CallOption) (*TopicList, error)
+	// DeleteTopic removes the topic and all its messages. Deleting a topic
+	// that does not exist is not an error.
+	DeleteTopic(ctx context.Context, in *Topic, opts ...grpc.CallOption) (*DeleteTopicResponse, error)
 }
 
 type miniKafkaClient struct {
@@ -330,11 +440,69 @@ func (c *miniKafkaClient) Poll(ctx context.Context, in *PollRequest, opts ...grp
 	return out, nil
 }
 
+func (c *miniKafkaClient) Consume(ctx context.Context, in *PollRequest, opts ...grpc.CallOption) (MiniKafka_ConsumeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_MiniKafka_serviceDesc.Streams[0], "/protocol.MiniKafka/Consume", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &miniKafkaConsumeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MiniKafka_ConsumeClient interface {
+	Recv() (*PollResponse, error)
+	grpc.ClientStream
+}
+
+type miniKafkaConsumeClient struct {
+	grpc.ClientStream
+}
+
+func (x *miniKafkaConsumeClient) Recv() (*PollResponse, error) {
+	m := new(PollResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *miniKafkaClient) ListTopics(ctx context.Context, in *ListTopicsRequest, opts ...grpc.CallOption) (*TopicList, error) {
+	out := new(TopicList)
+	err := c.cc.Invoke(ctx, "/protocol.MiniKafka/ListTopics", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *miniKafkaClient) DeleteTopic(ctx context.Context, in *Topic, opts ...grpc.CallOption) (*DeleteTopicResponse, error) {
+	out := new(DeleteTopicResponse)
+	err := c.cc.Invoke(ctx, "/protocol.MiniKafka/DeleteTopic", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MiniKafkaServer is the server API for MiniKafka service.
 type MiniKafkaServer interface {
 	// Produce returns the message number assigned to the stored message.
 	Produce(context.Context, *ProduceRequest) (*MsgNumber, error)
 	Poll(context.Context, *PollRequest) (*PollResponse, error)
+	// Consume streams the messages in the topic from read_from onwards, as
+	// they arrive, until the client cancels the call. Each response holds the
+	// messages that arrived together.
+	Consume(*PollRequest, MiniKafka_ConsumeServer) error
+	ListTopics(context.Context, *ListTopicsRequest) (*TopicList, error)
+	// DeleteTopic removes the topic and all its messages. Deleting a topic
+	// that does not exist is not an error.
+	DeleteTopic(context.Context, *Topic) (*DeleteTopicResponse, error)
 }
 
 func RegisterMiniKafkaServer(s *grpc.Server, srv MiniKafkaServer) {
@@ -377,6 +545,63 @@ func _MiniKafka_Poll_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MiniKafka_Consume_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PollRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MiniKafkaServer).Consume(m, &miniKafkaConsumeServer{stream})
+}
+
+type MiniKafka_ConsumeServer interface {
+	Send(*PollResponse) error
+	grpc.ServerStream
+}
+
+type miniKafkaConsumeServer struct {
+	grpc.ServerStream
+}
+
+func (x *miniKafkaConsumeServer) Send(m *PollResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MiniKafka_ListTopics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTopicsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MiniKafkaServer).ListTopics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.MiniKafka/ListTopics",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MiniKafkaServer).ListTopics(ctx, req.(*ListTopicsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MiniKafka_DeleteTopic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Topic)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MiniKafkaServer).DeleteTopic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.MiniKafka/DeleteTopic",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MiniKafkaServer).DeleteTopic(ctx, req.(*Topic))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _MiniKafka_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protocol.MiniKafka",
 	HandlerType: (*MiniKafkaServer)(nil),
@@ -389,33 +614,52 @@ var _MiniKafka_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Poll",
 			Handler:    _MiniKafka_Poll_Handler,
 		},
+		{
+			MethodName: "ListTopics",
+			Handler:    _MiniKafka_ListTopics_Handler,
+		},
+		{
+			MethodName: "DeleteTopic",
+			Handler:    _MiniKafka_DeleteTopic_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Consume",
+			Handler:       _MiniKafka_Consume_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "minikafka.proto",
 }
 
-func init() { proto.RegisterFile("minikafka.proto", fileDescriptor_minikafka_07f0578420994a20) }
-
-var fileDescriptor_minikafka_07f0578420994a20 = []byte{
-	// 310 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x91, 0x5f, 0x4b, 0xc3, 0x30,
-	0x14, 0xc5, 0x57, 0x75, 0x6e, 0xbd, 0xdb, 0x1c, 0x5e, 0xff, 0x50, 0x06, 0x83, 0x11, 0x11, 0x44,
-	0x71, 0xc8, 0x7c, 0x18, 0xf8, 0x01, 0x7c, 0x91, 0xc9, 0x08, 0xfa, 0x3c, 0xba, 0x35, 0x1b, 0x65,
-	0x4d, 0x52, 0x93, 0xd6, 0xe1, 0x9b, 0x1f, 0x5d, 0x9a, 0xa6, 0x6b, 0x95, 0x3e, 0xf8, 0xd4, 0xdc,
-	0xe6, 0xe4, 0xfc, 0xee, 0xb9, 0x17, 0xfa, 0x3c, 0x14, 0xe1, 0xd6, 0x5f, 0x6f, 0xfd, 0x71, 0xac,
-	0x64, 0x22, 0xb1, 0x6d, 0x3e, 0x2b, 0x19, 0x91, 0x21, 0x34, 0xdf, 0x64, 0x1c, 0xae, 0xf0, 0x1c,
-	0x9a, 0x49, 0x76, 0xf0, 0x9c, 0x91, 0x73, 0xe3, 0xd2, 0xbc, 0x20, 0x57, 0xd0, 0x9a, 0xfb, 0x5f,
-	0x91, 0xf4, 0x03, 0xf4, 0xa0, 0x15, 0xe7, 0x47, 0x23, 0xe9, 0xd2, 0xa2, 0x24, 0x01, 0x9c, 0xcc,
-	0x95, 0x0c, 0xd2, 0x15, 0xa3, 0xec, 0x23, 0x65, 0x3a, 0xc1, 0xeb, 0xaa, 0x59, 0x67, 0xd2, 0x1f,
-	0x17, 0xbc, 0xb1, 0x81, 0x59, 0x77, 0xbc, 0x2b, 0x2d, 0x0f, 0x8c, 0xf0, 0xb4, 0x14, 0x5a, 0x6c,
-	0x49, 0xb9, 0x05, 0x77, 0xa6, 0x37, 0xaf, 0x29, 0x5f, 0x32, 0x85, 0x43, 0x00, 0xae, 0x37, 0x0b,
-	0x61, 0x2a, 0x43, 0xe9, 0x51, 0x97, 0x17, 0xd7, 0xe4, 0x1d, 0x3a, 0x73, 0x19, 0x45, 0x45, 0x3b,
-	0xb5, 0xd9, 0xf0, 0x01, 0x5c, 0xc5, 0xfc, 0x60, 0xb1, 0x56, 0x92, 0x5b, 0xfe, 0x59, 0xc9, 0xdf,
-	0xb3, 0x68, 0x3b, 0x53, 0x3d, 0x2b, 0xc9, 0xc9, 0x27, 0x74, 0x73, 0x5b, 0x1d, 0x4b, 0xa1, 0x19,
-	0xde, 0x43, 0xdb, 0x76, 0xa7, 0x3d, 0x67, 0x74, 0x58, 0x1f, 0x60, 0x2f, 0xc1, 0x29, 0xf4, 0x04,
-	0xdb, 0x2d, 0xfe, 0x05, 0xed, 0x08, 0xb6, 0xa3, 0x96, 0x3b, 0xf9, 0x76, 0xc0, 0x9d, 0x85, 0x22,
-	0x7c, 0xc9, 0x56, 0x88, 0x4f, 0xd0, 0xb2, 0xe3, 0x46, 0xaf, 0x82, 0xfb, 0xb5, 0x81, 0x41, 0x9d,
-	0x29, 0x69, 0xe0, 0x14, 0x8e, 0xb2, 0x04, 0x78, 0x51, 0x79, 0x58, 0x0e, 0x6a, 0x70, 0xf9, 0xf7,
-	0x77, 0x1e, 0x94, 0x34, 0x96, 0xc7, 0xe6, 0xe2, 0xf1, 0x27, 0x00, 0x00, 0xff, 0xff, 0xeb, 0x77,
-	0xfc, 0x23, 0x4b, 0x02, 0x00, 0x00,
+func init() { proto.RegisterFile("minikafka.proto", fileDescriptor_minikafka_a958b38b35d78997) }
+
+var fileDescriptor_minikafka_a958b38b35d78997 = []byte{
+	// 395 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x52, 0x51, 0x8b, 0xda, 0x40,
+	0x10, 0x4e, 0x6c, 0x35, 0x66, 0xa2, 0x15, 0xc7, 0x2a, 0xc1, 0x22, 0xc8, 0x4a, 0xa1, 0xb4, 0x54,
+	0xc4, 0x3e, 0x08, 0xa5, 0xd0, 0x42, 0x4b, 0x5f, 0x5a, 0x8b, 0x84, 0xf6, 0x59, 0xa2, 0x59, 0x25,
+	0x98, 0x64, 0xd3, 0x6c, 0x72, 0x72, 0x3f, 0xef, 0xfe, 0xd9, 0x91, 0xcd, 0xc6, 0xcd, 0x79, 0x79,
+	0xb8, 0x7b, 0xda, 0x9d, 0x99, 0x6f, 0xe7, 0xfb, 0xe6, 0xdb, 0x81, 0x5e, 0xe8, 0x47, 0xfe, 0xc9,
+	0x3d, 0x9c, 0xdc, 0x79, 0x9c, 0xb0, 0x94, 0x61, 0x5b, 0x1c, 0x7b, 0x16, 0x90, 0x09, 0x34, 0xff,
+	0xb2, 0xd8, 0xdf, 0xe3, 0x6b, 0x68, 0xa6, 0xf9, 0xc5, 0xd6, 0xa7, 0xfa, 0x3b, 0xd3, 0x29, 0x02,
+	0x32, 0x03, 0x63, 0xe3, 0xde, 0x06, 0xcc, 0xf5, 0xd0, 0x06, 0x23, 0x2e, 0xae, 0x02, 0xd2, 0x71,
+	0xca, 0x90, 0x78, 0xf0, 0x6a, 0x93, 0x30, 0x2f, 0xdb, 0x53, 0x87, 0xfe, 0xcf, 0x28, 0x4f, 0xf1,
+	0x6d, 0xb5, 0x99, 0xb5, 0xec, 0xcd, 0x4b, 0xbe, 0xb9, 0x20, 0x93, 0xdd, 0xf1, 0x83, 0x6a, 0xd9,
+	0x10, 0xc0, 0xbe, 0x02, 0x4a, 0x5a, 0xc5, 0xf2, 0x1e, 0xcc, 0x35, 0x3f, 0xfe, 0xc9, 0xc2, 0x1d,
+	0x4d, 0x70, 0x02, 0x10, 0xf2, 0xe3, 0x36, 0x12, 0x91, 0x60, 0xe9, 0x3a, 0x66, 0x58, 0x96, 0xc9,
+	0x3f, 0xb0, 0x36, 0x2c, 0x08, 0x4a, 0x39, 0xb5, 0xb3, 0xe1, 0x02, 0xcc, 0x84, 0xba, 0xde, 0xf6,
+	0x90, 0xb0, 0x50, 0xf2, 0x0f, 0x14, 0xff, 0x85, 0xcb, 0x69, 0xe7, 0xa8, 0x9f, 0x09, 0x0b, 0xc9,
+	0x0d, 0x74, 0x8a, 0xb6, 0x3c, 0x66, 0x11, 0xa7, 0xf8, 0x11, 0xda, 0x52, 0x1d, 0xb7, 0xf5, 0xe9,
+	0x8b, 0xfa, 0x01, 0x2e, 0x10, 0x5c, 0x41, 0x37, 0xa2, 0xe7, 0xed, 0x93, 0x48, 0xad, 0x88, 0x9e,
+	0x9d, 0x92, 0x77, 0x00, 0xfd, 0xdf, 0x3e, 0x4f, 0x85, 0x77, 0x5c, 0x0e, 0x45, 0x66, 0x60, 0x8a,
+	0x44, 0x5e, 0xc1, 0x11, 0xb4, 0xc4, 0x50, 0x85, 0x0e, 0xd3, 0x91, 0x11, 0x19, 0xc2, 0xe0, 0x07,
+	0x0d, 0x68, 0x4a, 0x0b, 0xdf, 0xa5, 0xf0, 0xe5, 0x5d, 0x03, 0xcc, 0xb5, 0x1f, 0xf9, 0xbf, 0xf2,
+	0x9d, 0xc0, 0xcf, 0x60, 0xc8, 0xff, 0x43, 0xbb, 0xa2, 0xff, 0xc1, 0x97, 0x8e, 0xeb, 0x54, 0x12,
+	0x0d, 0x57, 0xf0, 0x32, 0xb7, 0x04, 0x87, 0x95, 0x87, 0xca, 0xf9, 0xf1, 0xe8, 0x3a, 0x5d, 0x08,
+	0x20, 0x1a, 0x7e, 0x01, 0xe3, 0x3b, 0x8b, 0x78, 0x16, 0xd2, 0x67, 0xbf, 0x5d, 0xe8, 0xf8, 0x0d,
+	0x40, 0x39, 0x82, 0x6f, 0x14, 0xf2, 0x91, 0x4f, 0x55, 0xe1, 0x17, 0xbf, 0x88, 0x86, 0x5f, 0xc1,
+	0xaa, 0x38, 0x83, 0xd7, 0x2b, 0x3a, 0x9e, 0xa8, 0x44, 0x8d, 0x83, 0x44, 0xdb, 0xb5, 0x44, 0xfd,
+	0xd3, 0xfd, 0x00, 0x6e, 0xe6, 0x83, 0x01, 0x5d, 0x03, 0x00, 0x00,
 }
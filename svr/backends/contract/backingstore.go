@@ -4,6 +4,7 @@
 package contract
 
 import (
+	"context"
 	"time"
 
 	minikafka "github.com/peterhoward42/minikafka"
@@ -11,27 +12,70 @@ import (
 
 // BackingStore is an interface that offers a core set of CRUD methods
 // on a backing store for messages.
+//
+// Every method takes a context, so that the caller can abandon the operation.
+// When the context is cancelled or times out before the operation completes,
+// the method returns an error that wraps ctx.Err(). An operation that changes
+// the store, leaves it in a consistent state nonetheless.
 type BackingStore interface {
 
 	// Store adds the given message to the sequence of Messages already
 	// held in the store for a Topic, and returns the message number thus
-	// asigned to it.
-	Store(topic string, message minikafka.Message) (
+	// asigned to it. A store that limits the size of messages returns an
+	// error wrapping ErrMessageTooLarge for a message beyond the limit.
+	Store(ctx context.Context, topic string, message minikafka.Message) (
 		messageNumber int, err error)
 
-	// RemoveOldMessages invites the store to remove any messages in the 
-    // store that were stored before the time specified. The store is allowed to
-    // deploy some internal optimisation to **not** remove these messages at
-    // this time.
-	RemoveOldMessages(maxAge time.Time) error
+	// RemoveOldMessages invites the store to remove any messages in the
+	// store that were stored before the time specified. The store is allowed to
+	// deploy some internal optimisation to **not** remove these messages at
+	// this time.
+	RemoveOldMessages(ctx context.Context, maxAge time.Time) error
 
 	// Provide a list of all the messages held for this topic, whose message
 	// number is greater than or equal to the specified read-from message
 	// number. Returns the messages, and also the advised new read-from message
-	// number. (beyond those returned by this invocation).
-	Poll(topic string, readFrom int) (messages []minikafka.Message,
-		newReadFrom int, err error)
+	// number. (beyond those returned by this invocation). When the topic is
+	// unknown - because it has never been stored to, or has been deleted - it
+	// returns an error wrapping ErrTopicNotFound. Whereas a topic that holds
+	// no messages from the read-from message number onwards provides an
+	// empty slice, and the unchanged read-from message number.
+	Poll(ctx context.Context, topic string, readFrom int) (
+		messages []minikafka.Message, newReadFrom int, err error)
+
+	// PollN is like Poll, but returns at most maxMessages messages. The
+	// advised new read-from message number then follows on from the last
+	// message returned, so that the caller can page through the rest. A
+	// maxMessages of zero means no limit. An unknown topic is reported as it
+	// is by Poll.
+	PollN(ctx context.Context, topic string, readFrom int, maxMessages int) (
+		messages []minikafka.Message, newReadFrom int, err error)
+
+	// PollBlocking is like Poll, but when there are no messages to return, it
+	// waits until a message is stored to the topic, and then returns that.
+	// (The topic need not exist yet.) If the context is cancelled or times
+	// out first, it returns ctx.Err() along with an empty slice and the
+	// unchanged read-from message number.
+	PollBlocking(ctx context.Context, topic string, readFrom int) (
+		messages []minikafka.Message, newReadFrom int, err error)
+
+	// Subscribe continuously delivers the topic's messages, in order, from
+	// the read-from message number onwards - including those stored after
+	// the subscription is made. Every subscriber receives every message.
+	// Both channels are closed when the context is done, or after an error
+	// has been delivered.
+	Subscribe(ctx context.Context, topic string, readFrom int) (
+		<-chan minikafka.Message, <-chan error)
+
+	// ListTopics provides the names of all the topics held in the store,
+	// sorted alphabetically.
+	ListTopics(ctx context.Context) (topics []string, err error)
+
+	// DeleteTopic removes the given topic, and all its messages, from the
+	// store. Deleting a topic that does not exist is a no-op, that returns
+	// nil.
+	DeleteTopic(ctx context.Context, topic string) error
 
 	// DeleteContents empties the store of all its contents.
-	DeleteContents() error
+	DeleteContents(ctx context.Context) error
 }
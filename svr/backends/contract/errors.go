@@ -0,0 +1,39 @@
+package contract
+
+import (
+	"errors"
+)
+
+// Sentinel errors that BackingStore implementations return (wrapped), so that
+// callers can distinguish them using errors.Is() - for example to map them to
+// status codes.
+var (
+	// ErrTopicNotFound is returned by Poll and PollN when the store holds
+	// no such topic.
+	ErrTopicNotFound = errors.New("topic not found")
+
+	// ErrMessageTooLarge is returned by Store when the message exceeds the
+	// largest the store can hold. (Not all stores have a limit.)
+	ErrMessageTooLarge = errors.New("message too large")
+
+	// ErrQuotaExceeded is returned by Store when the topic is already at the
+	// limit set for it, so that storing the message would take it beyond.
+	// Unlike retention, which removes messages to make room, a quota makes
+	// overflow an error. (Not all stores have quotas.)
+	ErrQuotaExceeded = errors.New("topic quota exceeded")
+
+	// ErrInvalidTopic is returned by stores that restrict the names a topic
+	// can have, when they are given a topic whose name is not allowed - for
+	// example because it could not safely be used as a file name.
+	ErrInvalidTopic = errors.New("invalid topic name")
+
+	// ErrMessageNotFound is returned by stores that can fetch a single
+	// message by its number, when the topic holds no such message - either
+	// because it has been removed, or because it has never been stored.
+	ErrMessageNotFound = errors.New("message not found")
+
+	// ErrCorruptIndex is returned by stores that persist an index, when they
+	// are opened on an index that cannot be decoded, or does not match its
+	// checksum - so that the caller can have the index rebuilt.
+	ErrCorruptIndex = errors.New("corrupt index")
+)
@@ -0,0 +1,37 @@
+package contract
+
+import (
+	"context"
+	"time"
+
+	minikafka "github.com/peterhoward42/minikafka"
+)
+
+// Record is a message, along with everything a store holds about it.
+type Record struct {
+	Number  int
+	Time    time.Time // When it was stored.
+	Key     []byte    // Nil when the message has no key.
+	Headers map[string]string
+	Message minikafka.Message
+}
+
+// RecordStore is an interface offered, in addition to BackingStore, by the
+// stores that can provide, and accept, the metadata of each message - which
+// lets a topic be copied from one such store to another (see the snapshot
+// package).
+type RecordStore interface {
+
+	// PollRecords is like Poll, except that it provides each message as
+	// a Record.
+	PollRecords(ctx context.Context, topic string, readFrom int) (
+		records []Record, newReadFrom int, err error)
+
+	// StoreRecords stores the given records in order, keeping the time, key
+	// and headers of each. The first is given the next message number in
+	// the topic, and each of the others is numbered so that the difference
+	// between its number and the first's is the same as it is between their
+	// Number(s). Their Number(s) must be in ascending order.
+	StoreRecords(ctx context.Context, topic string, records []Record) (
+		firstNumber int, lastNumber int, err error)
+}
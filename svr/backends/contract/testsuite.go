@@ -1,6 +1,9 @@
 package contract
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
@@ -22,9 +25,21 @@ func RunBackingStoreTests(t *testing.T, implementation BackingStore) {
 	testRemoveWhenAllOldEnough(t, implementation)
 	testRemoveWhenOnlySomeOldEnough(t, implementation)
 	testPollErrorHandlingWhenNoSuchTopic(t, implementation)
+	testPollErrorHandlingWhenTopicDeleted(t, implementation)
 	testPollWhenTopicIsEmpty(t, implementation)
 	testNewReadFromAdvancement(t, implementation)
 	testMessageNumbersIncrementAcrossRemovals(t, implementation)
+	testPagingWithPollN(t, implementation)
+	testPollNWithNoLimit(t, implementation)
+	testPollBlockingWhenDataAlreadyPresent(t, implementation)
+	testPollBlockingWakesOnStore(t, implementation)
+	testPollBlockingTimesOut(t, implementation)
+	testSubscribersEachGetEveryMessage(t, implementation)
+	testListTopics(t, implementation)
+	testListTopicsWhenEmpty(t, implementation)
+	testDeleteTopicLeavesOthersAlone(t, implementation)
+	testDeleteTopicWhenNoSuchTopic(t, implementation)
+	testCancelledContextIsRefused(t, implementation)
 }
 
 //----------------------------------------------------------------------------
@@ -32,176 +47,420 @@ func RunBackingStoreTests(t *testing.T, implementation BackingStore) {
 //----------------------------------------------------------------------------
 
 func testCanStoreToVirginStore(t *testing.T, store BackingStore) {
-	err := store.DeleteContents()
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
 	assert.Nil(t, err)
-	msgNum, err := store.Store("topicA", []byte("hello"))
+	msgNum, err := store.Store(ctx, "topicA", []byte("hello"))
 	assert.Nil(t, err)
 	assert.Equal(t, 1, msgNum)
 }
 
 func testCanStoreToExistingTopic(t *testing.T, store BackingStore) {
-	err := store.DeleteContents()
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
 	assert.Nil(t, err)
-	msgNum, err := store.Store("topicA", []byte("hello"))
+	msgNum, err := store.Store(ctx, "topicA", []byte("hello"))
 	assert.Nil(t, err)
-	msgNum, err = store.Store("topicA", []byte("goodbye"))
+	msgNum, err = store.Store(ctx, "topicA", []byte("goodbye"))
 	assert.Nil(t, err)
 	assert.Equal(t, 2, msgNum)
 }
 
 func testMessageNumberAllocatedPerTopic(t *testing.T, store BackingStore) {
-	err := store.DeleteContents()
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
 	assert.Nil(t, err)
-	msgNum, err := store.Store("topicA", []byte("foo"))
+	msgNum, err := store.Store(ctx, "topicA", []byte("foo"))
 	assert.Nil(t, err)
-	msgNum, err = store.Store("topicA", []byte("bar"))
+	msgNum, err = store.Store(ctx, "topicA", []byte("bar"))
 	assert.Nil(t, err)
-	msgNum, err = store.Store("topicB", []byte("baz"))
+	msgNum, err = store.Store(ctx, "topicB", []byte("baz"))
 	assert.Nil(t, err)
 	assert.Equal(t, 1, msgNum)
 }
 
 func testRemoveMsgOperatesAcrossTopics(t *testing.T, store BackingStore) {
-	err := store.DeleteContents()
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
 	assert.Nil(t, err)
-	_, err = store.Store("topicA", []byte("foo"))
+	_, err = store.Store(ctx, "topicA", []byte("foo"))
 	assert.Nil(t, err)
-	_, err = store.Store("topicB", []byte("bar"))
+	_, err = store.Store(ctx, "topicB", []byte("bar"))
 	assert.Nil(t, err)
 
 	maxAge := time.Now()
-	err = store.RemoveOldMessages(maxAge)
+	err = store.RemoveOldMessages(ctx, maxAge)
 	assert.Nil(t, err)
 }
 
 func testRemoveOnEmptyStore(t *testing.T, store BackingStore) {
-	err := store.DeleteContents()
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
 	assert.Nil(t, err)
 
 	maxAge := time.Now()
-	err = store.RemoveOldMessages(maxAge)
+	err = store.RemoveOldMessages(ctx, maxAge)
 
 	assert.Nil(t, err)
 }
 
 func testRemoveWhenNoneOldEnough(t *testing.T, store BackingStore) {
-	err := store.DeleteContents()
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
 	assert.Nil(t, err)
-	_, err = store.Store("topicA", []byte("foo"))
+	_, err = store.Store(ctx, "topicA", []byte("foo"))
 	assert.Nil(t, err)
 
 	// Remove messages older than one hour ago.
 	maxAge := time.Now().Add(time.Duration(-1 * time.Hour))
-	err = store.RemoveOldMessages(maxAge)
+	err = store.RemoveOldMessages(ctx, maxAge)
 	assert.Nil(t, err)
 }
 
 func testRemoveWhenAllOldEnough(t *testing.T, store BackingStore) {
-	err := store.DeleteContents()
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
 	assert.Nil(t, err)
-	_, err = store.Store("topicA", []byte("foo"))
+	_, err = store.Store(ctx, "topicA", []byte("foo"))
 	assert.Nil(t, err)
 
 	// Remove messages older than one hour's hence.
 	maxAge := time.Now().Add(time.Duration(1 * time.Hour))
-	err = store.RemoveOldMessages(maxAge)
+	err = store.RemoveOldMessages(ctx, maxAge)
 	assert.Nil(t, err)
 }
 
 func testRemoveWhenOnlySomeOldEnough(t *testing.T, store BackingStore) {
-	err := store.DeleteContents()
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
 	assert.Nil(t, err)
 	// Store two messages immediately.
-	_, err = store.Store("topicA", []byte("abc"))
+	_, err = store.Store(ctx, "topicA", []byte("abc"))
 	assert.Nil(t, err)
-	_, err = store.Store("topicA", []byte("def"))
+	_, err = store.Store(ctx, "topicA", []byte("def"))
 	assert.Nil(t, err)
 	// Store two more, after a 500ms delay.
 	time.Sleep(time.Millisecond * 500)
-	_, err = store.Store("topicA", []byte("ghi"))
+	_, err = store.Store(ctx, "topicA", []byte("ghi"))
 	assert.Nil(t, err)
-	_, err = store.Store("topicA", []byte("klm"))
+	_, err = store.Store(ctx, "topicA", []byte("klm"))
 	assert.Nil(t, err)
 	// Remove those older than 250ms.
 	maxAge := time.Now().Add(time.Duration(-250 * time.Microsecond))
-	err = store.RemoveOldMessages(maxAge)
+	err = store.RemoveOldMessages(ctx, maxAge)
 	assert.Nil(t, err)
 }
 
 func testPollErrorHandlingWhenNoSuchTopic(t *testing.T, store BackingStore) {
-	err := store.DeleteContents()
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
 	assert.Nil(t, err)
-	_, _, err = store.Poll("XXX", 1)
+	_, _, err = store.Poll(ctx, "XXX", 1)
 	assert.NotNil(t, err)
 	ok := strings.Contains(err.Error(), "topic")
 	assert.True(t, ok)
+	assert.True(t, errors.Is(err, ErrTopicNotFound))
+}
+
+func testPollErrorHandlingWhenTopicDeleted(t *testing.T,
+	store BackingStore) {
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
+	assert.Nil(t, err)
+	_, err = store.Store(ctx, "topicA", []byte("foo"))
+	assert.Nil(t, err)
+	err = store.DeleteTopic(ctx, "topicA")
+	assert.Nil(t, err)
+	_, _, err = store.Poll(ctx, "topicA", 1)
+	assert.True(t, errors.Is(err, ErrTopicNotFound))
 }
 
 func testPollWhenTopicIsEmpty(t *testing.T, store BackingStore) {
-	err := store.DeleteContents()
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
 	assert.Nil(t, err)
 	// Bring topic into being.
-	_, err = store.Store("topicA", []byte("foo"))
+	_, err = store.Store(ctx, "topicA", []byte("foo"))
 	assert.Nil(t, err)
 	// Remove all messages.
 	maxAge := time.Now().Add(time.Duration(1 * time.Hour))
-	err = store.RemoveOldMessages(maxAge)
+	err = store.RemoveOldMessages(ctx, maxAge)
 	assert.Nil(t, err)
 
-	messages, newReadFrom, err := store.Poll("topicA", 1)
+	messages, newReadFrom, err := store.Poll(ctx, "topicA", 1)
 	assert.Nil(t, err)
 	assert.Equal(t, 0, len(messages))
 	assert.Equal(t, 1, newReadFrom)
 }
 
 func testNewReadFromAdvancement(t *testing.T, store BackingStore) {
-	err := store.DeleteContents()
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
 	assert.Nil(t, err)
 	// Add 3 messages.
-	_, err = store.Store("topicA", []byte("foo"))
+	_, err = store.Store(ctx, "topicA", []byte("foo"))
 	assert.Nil(t, err)
-	_, err = store.Store("topicA", []byte("bar"))
+	_, err = store.Store(ctx, "topicA", []byte("bar"))
 	assert.Nil(t, err)
-	_, err = store.Store("topicA", []byte("baz"))
+	_, err = store.Store(ctx, "topicA", []byte("baz"))
 	assert.Nil(t, err)
 	// Check returned values from a Poll that will empty the topic.
-	messages, newReadFrom, err := store.Poll("topicA", 1)
+	messages, newReadFrom, err := store.Poll(ctx, "topicA", 1)
 	assert.Nil(t, err)
 	assert.Equal(t, 3, len(messages))
 	assert.Equal(t, 4, newReadFrom)
 	// Check returned values when Polling for newever values when there
 	// are none.
-	messages, newReadFrom, err = store.Poll("topicA", newReadFrom)
+	messages, newReadFrom, err = store.Poll(ctx, "topicA", newReadFrom)
 	assert.Nil(t, err)
 	assert.Equal(t, 0, len(messages))
 	assert.Equal(t, 4, newReadFrom)
 
 	// Check returned values when Polling for newever values when there
 	// are some new ones.
-	_, err = store.Store("topicA", []byte("baz"))
+	_, err = store.Store(ctx, "topicA", []byte("baz"))
 	assert.Nil(t, err)
-	messages, newReadFrom, err = store.Poll("topicA", newReadFrom)
+	messages, newReadFrom, err = store.Poll(ctx, "topicA", newReadFrom)
 	assert.Nil(t, err)
 	assert.Equal(t, 1, len(messages))
 	assert.Equal(t, 5, newReadFrom)
 }
 
 func testMessageNumbersIncrementAcrossRemovals(t *testing.T, store BackingStore) {
-	err := store.DeleteContents()
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
 	assert.Nil(t, err)
 
 	// Store a message.
-	_, err = store.Store("topicA", []byte("foo"))
+	_, err = store.Store(ctx, "topicA", []byte("foo"))
 	assert.Nil(t, err)
 
 	// Remove all messages using the RemoveOldMessages API call.
 	maxAge := time.Now().Add(time.Duration(1 * time.Hour))
-	err = store.RemoveOldMessages(maxAge)
+	err = store.RemoveOldMessages(ctx, maxAge)
 	assert.Nil(t, err)
 
 	// Do a fresh storage opertation, and ensure the messgae number
 	// allocated is 2.
-	msgNum, err := store.Store("topicA", []byte("foo"))
+	msgNum, err := store.Store(ctx, "topicA", []byte("foo"))
 	assert.Nil(t, err)
 	assert.Equal(t, 2, msgNum)
 }
+
+func testPagingWithPollN(t *testing.T, store BackingStore) {
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
+	assert.Nil(t, err)
+	// Store 100 distinguishable messages.
+	for i := 1; i <= 100; i++ {
+		_, err = store.Store(ctx, "topicA", []byte(fmt.Sprintf("msg%d", i)))
+		assert.Nil(t, err)
+	}
+	// Page through them in batches of 10, making sure we get back exactly
+	// the original sequence with no gaps or duplicates.
+	readFrom := 1
+	harvested := []string{}
+	for page := 0; page < 10; page++ {
+		messages, newReadFrom, err := store.PollN(ctx, "topicA", readFrom, 10)
+		assert.Nil(t, err)
+		assert.Equal(t, 10, len(messages))
+		assert.Equal(t, readFrom+10, newReadFrom)
+		for _, msg := range messages {
+			harvested = append(harvested, string(msg))
+		}
+		readFrom = newReadFrom
+	}
+	for i := 1; i <= 100; i++ {
+		assert.Equal(t, fmt.Sprintf("msg%d", i), harvested[i-1])
+	}
+	// There should be nothing left.
+	messages, newReadFrom, err := store.PollN(ctx, "topicA", readFrom, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(messages))
+	assert.Equal(t, 101, newReadFrom)
+}
+
+func testPollNWithNoLimit(t *testing.T, store BackingStore) {
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
+	assert.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		_, err = store.Store(ctx, "topicA", []byte("foo"))
+		assert.Nil(t, err)
+	}
+	// A limit of zero means no limit.
+	messages, newReadFrom, err := store.PollN(ctx, "topicA", 2, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(messages))
+	assert.Equal(t, 6, newReadFrom)
+}
+
+func testPollBlockingWhenDataAlreadyPresent(t *testing.T, store BackingStore) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := store.DeleteContents(ctx)
+	assert.Nil(t, err)
+	_, err = store.Store(ctx, "topicA", []byte("foo"))
+	assert.Nil(t, err)
+	messages, newReadFrom, err := store.PollBlocking(ctx, "topicA", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, 2, newReadFrom)
+}
+
+func testPollBlockingWakesOnStore(t *testing.T, store BackingStore) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := store.DeleteContents(ctx)
+	assert.Nil(t, err)
+	// Store a message a little while after starting to wait - to a topic
+	// that does not yet exist.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, err := store.Store(ctx, "topicA", []byte("foo"))
+		assert.Nil(t, err)
+	}()
+	messages, newReadFrom, err := store.PollBlocking(ctx, "topicA", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, "foo", string(messages[0]))
+	assert.Equal(t, 2, newReadFrom)
+}
+
+func testPollBlockingTimesOut(t *testing.T, store BackingStore) {
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
+	assert.Nil(t, err)
+	_, err = store.Store(ctx, "topicA", []byte("foo"))
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 50*time.Millisecond)
+	defer cancel()
+	messages, newReadFrom, err := store.PollBlocking(ctx, "topicA", 2)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 0, len(messages))
+	assert.Equal(t, 2, newReadFrom)
+}
+
+func testSubscribersEachGetEveryMessage(t *testing.T, store BackingStore) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := store.DeleteContents(ctx)
+	assert.Nil(t, err)
+
+	// Start two subscribers, and collect what each receives.
+	const nMessages = 10
+	received := make([]chan []string, 2)
+	for i := range received {
+		received[i] = make(chan []string)
+		messages, errs := store.Subscribe(ctx, "topicA", 1)
+		go func(results chan<- []string) {
+			got := []string{}
+			for len(got) < nMessages {
+				select {
+				case msg := <-messages:
+					got = append(got, string(msg))
+				case err := <-errs:
+					assert.Nil(t, err)
+					results <- got
+					return
+				}
+			}
+			results <- got
+		}(received[i])
+	}
+
+	// Produce the messages.
+	expected := []string{}
+	for i := 1; i <= nMessages; i++ {
+		msg := fmt.Sprintf("msg%d", i)
+		expected = append(expected, msg)
+		_, err = store.Store(ctx, "topicA", []byte(msg))
+		assert.Nil(t, err)
+	}
+
+	for _, results := range received {
+		select {
+		case got := <-results:
+			assert.Equal(t, expected, got)
+		case <-time.After(5 * time.Second):
+			assert.FailNow(t, "Subscriber did not receive every message.")
+		}
+	}
+}
+
+func testListTopics(t *testing.T, store BackingStore) {
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
+	assert.Nil(t, err)
+	for _, topic := range []string{"topicC", "topicA", "topicB", "topicA"} {
+		_, err = store.Store(ctx, topic, []byte("foo"))
+		assert.Nil(t, err)
+	}
+	topics, err := store.ListTopics(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"topicA", "topicB", "topicC"}, topics)
+}
+
+func testListTopicsWhenEmpty(t *testing.T, store BackingStore) {
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
+	assert.Nil(t, err)
+	topics, err := store.ListTopics(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, topics)
+}
+
+func testDeleteTopicLeavesOthersAlone(t *testing.T, store BackingStore) {
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
+	assert.Nil(t, err)
+	_, err = store.Store(ctx, "topicA", []byte("foo"))
+	assert.Nil(t, err)
+	_, err = store.Store(ctx, "topicB", []byte("bar"))
+	assert.Nil(t, err)
+	_, err = store.Store(ctx, "topicB", []byte("baz"))
+	assert.Nil(t, err)
+
+	err = store.DeleteTopic(ctx, "topicA")
+	assert.Nil(t, err)
+
+	topics, err := store.ListTopics(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"topicB"}, topics)
+	_, _, err = store.Poll(ctx, "topicA", 1)
+	assert.NotNil(t, err)
+	messages, newReadFrom, err := store.Poll(ctx, "topicB", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "bar", string(messages[0]))
+	assert.Equal(t, "baz", string(messages[1]))
+	assert.Equal(t, 3, newReadFrom)
+}
+
+func testDeleteTopicWhenNoSuchTopic(t *testing.T, store BackingStore) {
+	ctx := context.Background()
+	err := store.DeleteContents(ctx)
+	assert.Nil(t, err)
+	err = store.DeleteTopic(ctx, "XXX")
+	assert.Nil(t, err)
+}
+
+func testCancelledContextIsRefused(t *testing.T, store BackingStore) {
+	err := store.DeleteContents(context.Background())
+	assert.Nil(t, err)
+	_, err = store.Store(context.Background(), "topicA", []byte("hello"))
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = store.Store(ctx, "topicA", []byte("goodbye"))
+	assert.True(t, errors.Is(err, context.Canceled))
+	_, _, err = store.Poll(ctx, "topicA", 1)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	// The refused store should have left no trace.
+	messages, _, err := store.Poll(context.Background(), "topicA", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+}
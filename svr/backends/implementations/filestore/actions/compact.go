@@ -0,0 +1,156 @@
+package actions
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
+)
+
+// CompactAction encapsulates a single execution of the compact command for
+// one topic.
+type CompactAction struct {
+	Topic   string
+	Index   *indexing.Index
+	RootDir string
+	// How the message records were encoded. Nil means use
+	// records.DefaultSerializer.
+	Serializer records.Serializer
+	// The key with which to decrypt encrypted records. Nil means there is
+	// none.
+	EncryptionKey *records.Key
+	// The naming scheme of the store's files. Nil means use
+	// filenamer.DefaultNamer.
+	Namer filenamer.FileNamer
+}
+
+// Compact is the internal entry point function to discard each keyed message
+// in a topic that has been superseded by a later one with the same key, so
+// that only the most recent message for each key remains. When that is a
+// tombstone (i.e. its message is empty), it is discarded too, removing the
+// key entirely. Messages stored without a key are all retained, as are those
+// whose records cannot be decoded - but a record that cannot be decrypted
+// fails the compaction, with an error that wraps records.ErrDecryptionFailed,
+// before anything is discarded. The surviving messages keep their message
+// numbers. Message files are rewritten without the messages discarded, or
+// removed if none of their messages survive. It provides the numbers of the
+// messages discarded, for each key. It updates the in-memory index, but is
+// not responsible for mutex protection, nor re-saving the index afterwards.
+// These are the responsibility of the caller.
+func (action CompactAction) Compact() (removed map[string][]int, err error) {
+	msgFileList, ok := action.Index.MessageFileLists[action.Topic]
+	if ok == false {
+		return nil, fmt.Errorf("%w: %v", contract.ErrTopicNotFound,
+			action.Topic)
+	}
+
+	// Decide which messages to discard.
+	keyed, err := action.keyedRecords(msgFileList)
+	if err != nil {
+		return nil, fmt.Errorf("keyedRecords(): %w", err)
+	}
+	latest := map[string]int32{}
+	for _, storedMsg := range keyed {
+		latest[string(storedMsg.Key)] = storedMsg.MsgNum
+	}
+	removed = map[string][]int{}
+	discard := map[int32]bool{}
+	for _, storedMsg := range keyed {
+		key := string(storedMsg.Key)
+		isTombstone := len(storedMsg.Message) == 0
+		if storedMsg.MsgNum == latest[key] && isTombstone == false {
+			continue
+		}
+		removed[key] = append(removed[key], int(storedMsg.MsgNum))
+		discard[storedMsg.MsgNum] = true
+	}
+	if len(discard) == 0 {
+		return removed, nil
+	}
+
+	// Rewrite the files affected.
+	emptiedFiles := []string{}
+	for _, fileName := range msgFileList.Names {
+		fileMeta := msgFileList.Meta[fileName]
+		kept := []int32{}
+		keptSeekOffsets := []int64{}
+		oldest, newest := fileMeta.Oldest.MsgNum, fileMeta.Newest.MsgNum
+		for msgNum := oldest; msgNum <= newest; msgNum++ {
+			offset, ok := fileMeta.SeekOffsetForMessageNumber[msgNum]
+			if ok == false || discard[msgNum] {
+				continue
+			}
+			kept = append(kept, msgNum)
+			keptSeekOffsets = append(keptSeekOffsets, offset)
+		}
+		if len(kept) == len(fileMeta.SeekOffsetForMessageNumber) {
+			continue
+		}
+		filePath := messageFilePath(action.Namer,
+			fileName, action.Topic, action.RootDir)
+		if len(kept) == 0 {
+			emptiedFiles = append(emptiedFiles, fileName)
+			continue
+		}
+		contents, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("ioutil.ReadFile(): %v", err)
+		}
+		newSeekOffsets, newSize, err := rewriteKeeping(
+			filePath, contents, keptSeekOffsets)
+		if err != nil {
+			return nil, fmt.Errorf("rewriteKeeping(): %v", err)
+		}
+		fileMeta.KeepOnly(kept, newSeekOffsets, newSize)
+	}
+
+	// Mandate the index to forget about the emptied files, and then
+	// physically remove them.
+	msgFileList.ForgetFiles(emptiedFiles)
+	for _, fileName := range emptiedFiles {
+		filePath := messageFilePath(action.Namer,
+			fileName, action.Topic, action.RootDir)
+		err = os.Remove(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("os.Remove(): %v", err)
+		}
+	}
+	return removed, nil
+}
+
+// keyedRecords provides the records of the messages in the topic that have a
+// key, in message number order. Records that cannot be decoded are omitted.
+func (action CompactAction) keyedRecords(
+	msgFileList *indexing.MessageFileList) ([]records.StoredMessage, error) {
+	keyed := []records.StoredMessage{}
+	for _, fileName := range msgFileList.Names {
+		fileMeta := msgFileList.Meta[fileName]
+		filePath := messageFilePath(action.Namer,
+			fileName, action.Topic, action.RootDir)
+		contents, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("ioutil.ReadFile(): %v", err)
+		}
+		if int64(len(contents)) > fileMeta.Size {
+			contents = contents[:fileMeta.Size]
+		}
+		serializer := serializerForFile(action.Serializer, fileMeta)
+		found, _, _, _, err := records.DecodeSequence(
+			contents, serializer, action.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("records.DecodeSequence(): %w", err)
+		}
+		for _, storedMsg := range found {
+			msgNum := storedMsg.MsgNum
+			_, registered := fileMeta.SeekOffsetForMessageNumber[msgNum]
+			if registered && len(storedMsg.Key) != 0 {
+				keyed = append(keyed, storedMsg)
+			}
+		}
+	}
+	return keyed, nil
+}
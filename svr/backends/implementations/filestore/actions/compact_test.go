@@ -0,0 +1,150 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
+)
+
+// Store messages with keys A,B,A,C,B - two to a file - and make sure that
+// compaction leaves only the latest A, the latest B, and C, in their original
+// order. The first file is left with nothing, so should be removed.
+func TestCompact(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+	topic := "sometopic"
+	storeAction := StoreAction{
+		Topic:       topic,
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 2 * keyedSizeOf("K", "m1"),
+	}
+	for i, key := range []string{"A", "B", "A", "C", "B"} {
+		storeAction.Key = []byte(key)
+		storeAction.Message = minikafka.Message(
+			fmt.Sprintf("%s%d", key, i+1))
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.FailNow(t, msg)
+		}
+	}
+	// Files now hold 1-2, 3-4 and 5.
+
+	compactAction := CompactAction{Topic: topic, Index: index, RootDir: rootDir}
+	removed, err := compactAction.Compact()
+	if err != nil {
+		msg := fmt.Sprintf("compactAction.Compact(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	assert.Equal(t, map[string][]int{"A": {1}, "B": {2}}, removed)
+
+	msgFileList := index.MessageFileLists[topic]
+	assert.Equal(t, 2, len(msgFileList.Names))
+	nFiles, err := ioutils.CountEntitiesInDir(
+		filenamer.DirectoryForTopic(topic, rootDir))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, nFiles)
+
+	pollAction := PollAction{
+		Topic: topic, ReadFrom: 1, Index: index, RootDir: rootDir}
+	stored, newReadFrom, err := pollAction.PollRecords()
+	if err != nil {
+		msg := fmt.Sprintf("pollAction.PollRecords(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	got := []string{}
+	for _, storedMsg := range stored {
+		got = append(got, fmt.Sprintf("%d:%s", storedMsg.MsgNum,
+			storedMsg.Message))
+	}
+	assert.Equal(t, []string{"3:A3", "4:C4", "5:B5"}, got)
+	assert.Equal(t, 6, newReadFrom)
+
+	// Compacting again should be a no-op.
+	removed, err = compactAction.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]int{}, removed)
+}
+
+// Make sure that a tombstone removes its key entirely, that unkeyed messages
+// are left alone, and that the message numbering carries on regardless.
+func TestCompactWithTombstone(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+	topic := "sometopic"
+	storeAction := StoreAction{Topic: topic, Index: index, RootDir: rootDir}
+	toStore := []struct {
+		key     string
+		message string
+	}{
+		{"A", "A1"}, {"", "unkeyed"}, {"A", ""}, {"B", "B1"},
+	}
+	for _, keyed := range toStore {
+		storeAction.Key = []byte(keyed.key)
+		storeAction.Message = minikafka.Message(keyed.message)
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.FailNow(t, msg)
+		}
+	}
+
+	compactAction := CompactAction{Topic: topic, Index: index, RootDir: rootDir}
+	removed, err := compactAction.Compact()
+	if err != nil {
+		msg := fmt.Sprintf("compactAction.Compact(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	assert.Equal(t, map[string][]int{"A": {1, 3}}, removed)
+
+	storeAction.Key = []byte("C")
+	storeAction.Message = minikafka.Message("C1")
+	msgNum, _, err := storeAction.Store()
+	assert.Nil(t, err)
+	assert.Equal(t, 5, msgNum)
+
+	pollAction := PollAction{
+		Topic: topic, ReadFrom: 1, Index: index, RootDir: rootDir}
+	messages, _, err := pollAction.Poll()
+	assert.Nil(t, err)
+	assert.Equal(t, []minikafka.Message{
+		minikafka.Message("unkeyed"), minikafka.Message("B1"),
+		minikafka.Message("C1")}, messages)
+}
+
+// Make sure a topic unknown to the index is reported.
+func TestCompactWhenTopicIsUnknown(t *testing.T) {
+	compactAction := CompactAction{
+		Topic: "nosuchtopic", Index: indexing.NewIndex(), RootDir: "unused"}
+	_, err := compactAction.Compact()
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+}
+
+// keyedSizeOf provides the number of bytes a message stored with the given
+// key occupies in a message file, on the assumption that its message number
+// is small.
+func keyedSizeOf(key string, msg string) int64 {
+	encoded, _ := records.DefaultSerializer.Encode(records.StoredMessage{
+		MsgNum:  1,
+		Created: time.Now(),
+		Key:     []byte(key),
+		Message: minikafka.Message(msg),
+	})
+	return int64(len(records.Frame(encoded)))
+}
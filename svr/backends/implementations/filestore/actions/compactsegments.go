@@ -0,0 +1,159 @@
+package actions
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
+)
+
+// CompactSegmentsAction encapsulates a single execution of the compact
+// segments command for one topic.
+type CompactSegmentsAction struct {
+	Topic string
+	// The size that message files may be merged up to.
+	TargetSize int64
+	Index      *indexing.Index
+	RootDir    string
+	// The naming scheme of the store's files. Nil means use
+	// filenamer.DefaultNamer.
+	Namer filenamer.FileNamer
+}
+
+// CompactSegments is the internal entry point function to merge runs of
+// consecutive message files, which are small enough for their combined size
+// not to exceed the target size, into one file each. The file currently
+// being appended to is left alone, as are files whose records are compressed
+// differently from their neighbours', and runs holding a record that fails
+// its checksum (which RebuildIndexAction deals with). The messages keep
+// their order and their message numbers. Only the messages that the index
+// has registered are carried over, so merging never brings back messages
+// that were removed, whether or not their bytes remain in the files. Each
+// run is merged into its first file, which is replaced in one step, before
+// the rest are removed - so should that be interrupted, rebuilding the index
+// discards the messages left over in them (see RebuildIndexAction). It
+// provides the number of files removed. It updates the in-memory index, but is not
+// responsible for mutex protection, nor re-saving the index afterwards.
+// These are the responsibility of the caller.
+func (action CompactSegmentsAction) CompactSegments() (
+	nFilesRemoved int, err error) {
+	msgFileList, ok := action.Index.MessageFileLists[action.Topic]
+	if ok == false {
+		return 0, fmt.Errorf("%w: %v", contract.ErrTopicNotFound,
+			action.Topic)
+	}
+
+	// Work out the runs to merge, before the list changes.
+	runs := [][]string{}
+	mergeable := msgFileList.Names
+	if len(mergeable) > 0 {
+		mergeable = mergeable[:len(mergeable)-1]
+	}
+	for i := 0; i < len(mergeable); {
+		first := msgFileList.Meta[mergeable[i]]
+		size := first.Size
+		j := i + 1
+		for ; j < len(mergeable); j++ {
+			next := msgFileList.Meta[mergeable[j]]
+			if next.Compressed != first.Compressed ||
+				size+next.Size > action.TargetSize {
+				break
+			}
+			size += next.Size
+		}
+		if j-i > 1 {
+			runs = append(runs, mergeable[i:j])
+		}
+		i = j
+	}
+	for _, run := range runs {
+		merged, err := action.mergeRun(msgFileList, run)
+		if err != nil {
+			return nFilesRemoved, fmt.Errorf("mergeRun(): %v", err)
+		}
+		if merged {
+			nFilesRemoved += len(run) - 1
+		}
+	}
+	return nFilesRemoved, nil
+}
+
+// mergeRun merges the given run of message files into the first of them, and
+// removes the rest - unless one of them holds a corrupt record, in which case
+// it leaves them alone, and merged is false.
+func (action CompactSegmentsAction) mergeRun(
+	msgFileList *indexing.MessageFileList, run []string) (
+	merged bool, err error) {
+	mergedContents := []byte{}
+	msgNumbers := []int32{}
+	seekOffsets := []int64{}
+	creationTimes := map[int32]time.Time{}
+	for _, fileName := range run {
+		fileMeta := msgFileList.Meta[fileName]
+		filePath := messageFilePath(action.Namer,
+			fileName, action.Topic, action.RootDir)
+		contents, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return false, fmt.Errorf("ioutil.ReadFile(): %v", err)
+		}
+		if int64(len(contents)) > fileMeta.Size {
+			contents = contents[:fileMeta.Size]
+		}
+		registered := []int32{}
+		for msgNum := range fileMeta.SeekOffsetForMessageNumber {
+			registered = append(registered, msgNum)
+		}
+		sort.Slice(registered, func(i, j int) bool {
+			return registered[i] < registered[j]
+		})
+		for _, msgNum := range registered {
+			offset := fileMeta.SeekOffsetForMessageNumber[msgNum]
+			if offset >= int64(len(contents)) {
+				return false, nil
+			}
+			_, frameLength, err := records.Unframe(contents[offset:])
+			if err != nil {
+				return false, nil
+			}
+			msgNumbers = append(msgNumbers, msgNum)
+			seekOffsets = append(seekOffsets, int64(len(mergedContents)))
+			mergedContents = append(mergedContents,
+				contents[offset:offset+frameLength]...)
+			creationTimes[msgNum] = fileMeta.CreationTimeOf(msgNum)
+		}
+	}
+	if len(msgNumbers) == 0 {
+		return false, nil
+	}
+
+	firstPath := messageFilePath(action.Namer,
+		run[0], action.Topic, action.RootDir)
+	err = ioutils.ReplaceFile(firstPath, mergedContents)
+	if err != nil {
+		return false, fmt.Errorf("ioutils.ReplaceFile(): %v", err)
+	}
+	// KeepOnly takes the creation times from the first file's metadata.
+	firstMeta := msgFileList.Meta[run[0]]
+	firstMeta.CreationTimeForMessageNumber = creationTimes
+	firstMeta.KeepOnly(msgNumbers, seekOffsets, int64(len(mergedContents)))
+
+	// Mandate the index to forget about the files merged, and then
+	// physically remove them.
+	msgFileList.ForgetFiles(run[1:])
+	for _, fileName := range run[1:] {
+		filePath := messageFilePath(action.Namer,
+			fileName, action.Topic, action.RootDir)
+		err = os.Remove(filePath)
+		if err != nil {
+			return true, fmt.Errorf("os.Remove(): %v", err)
+		}
+	}
+	return true, nil
+}
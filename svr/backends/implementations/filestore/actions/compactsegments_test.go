@@ -0,0 +1,92 @@
+package actions
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+)
+
+// Store messages two to a file, and make sure that merging leaves all but the
+// file being stored to as one file, holding the same messages, in the same
+// order. Then put back one of the files merged, as if the merge had been
+// interrupted, and make sure that rebuilding the index discards it, rather
+// than registering its messages twice.
+func TestCompactSegments(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+	topic := "sometopic"
+	storeAction := StoreAction{
+		Topic:       topic,
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 2 * encodedSizeOf([]byte("message_N")),
+	}
+	for i := 1; i <= 7; i++ {
+		storeAction.Message = minikafka.Message(fmt.Sprintf("message_%d", i))
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.FailNow(t, msg)
+		}
+	}
+	// Files now hold 1-2, 3-4, 5-6 and 7.
+	names := append([]string{}, index.MessageFileLists[topic].Names...)
+	assert.Equal(t, 4, len(names))
+	leftoverPath := filenamer.MessageFilePath(names[1], topic, rootDir)
+	leftover, err := ioutil.ReadFile(leftoverPath)
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.ReadFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+
+	compactAction := CompactSegmentsAction{
+		Topic: topic, TargetSize: 1 << 20, Index: index, RootDir: rootDir}
+	nFilesRemoved, err := compactAction.CompactSegments()
+	if err != nil {
+		msg := fmt.Sprintf("compactAction.CompactSegments(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	assert.Equal(t, 2, nFilesRemoved)
+	assert.Equal(t, []string{names[0], names[3]},
+		index.MessageFileLists[topic].Names)
+	assertMessages := func(index *indexing.Index) {
+		pollAction := PollAction{
+			Topic: topic, ReadFrom: 1, Index: index, RootDir: rootDir}
+		stored, newReadFrom, err := pollAction.PollRecords()
+		assert.Nil(t, err)
+		got := []int32{}
+		for _, storedMsg := range stored {
+			got = append(got, storedMsg.MsgNum)
+		}
+		assert.Equal(t, []int32{1, 2, 3, 4, 5, 6, 7}, got)
+		assert.Equal(t, 8, newReadFrom)
+	}
+	assertMessages(index)
+
+	err = ioutil.WriteFile(leftoverPath, leftover, 0644)
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.WriteFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	rebuildAction := RebuildIndexAction{RootDir: rootDir}
+	rebuilt, problems, err := rebuildAction.RebuildIndex()
+	if err != nil {
+		msg := fmt.Sprintf("rebuildAction.RebuildIndex(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	assert.Equal(t, 1, len(problems))
+	assert.Equal(t, []string{names[0], names[3]},
+		rebuilt.MessageFileLists[topic].Names)
+	assert.False(t, ioutils.Exists(leftoverPath))
+	assertMessages(rebuilt)
+}
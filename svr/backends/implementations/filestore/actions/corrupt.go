@@ -0,0 +1,29 @@
+package actions
+
+import (
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
+)
+
+// CorruptRecord describes a record that could not be read, as it is passed
+// to the OnCorrupt function of the actions that decode records.
+type CorruptRecord struct {
+	Topic string
+	// The message number the index holds for the record. Zero when it is not
+	// known, as is the case for those found by a rebuild of the index.
+	MessageNumber int
+	FileName      string
+	Offset        int64 // Where the record starts in the message file.
+	Raw           []byte
+	Problem       string
+}
+
+// rawRecord provides a copy of the bytes of the record at the start of the
+// given bytes. When not even the record's frame can be made sense of, all
+// the bytes given are provided.
+func rawRecord(contents []byte) []byte {
+	_, frameLength, _ := records.Unframe(contents)
+	if frameLength == 0 || frameLength > int64(len(contents)) {
+		frameLength = int64(len(contents))
+	}
+	return append([]byte{}, contents[:frameLength]...)
+}
@@ -0,0 +1,36 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+)
+
+// DeleteTopicAction encapsulates a single execution of the delete-topic
+// command.
+type DeleteTopicAction struct {
+	Topic   string
+	Index   *indexing.Index
+	RootDir string
+	// The naming scheme of the store's files. Nil means use
+	// filenamer.DefaultNamer.
+	Namer filenamer.FileNamer
+}
+
+// DeleteTopic is the internal entry point function to remove a topic and all
+// its messages from the filestore. Its responsibility is to remove the topic's
+// directory and to update the in-memory index. It is not responsible for mutex
+// protection, nor re-saving the index afterwards. These are the responsibility
+// of the caller. Deleting a topic that does not exist is a benign no-op.
+func (action DeleteTopicAction) DeleteTopic() error {
+	dirPath := namerOrDefault(action.Namer).DirectoryForTopic(
+		action.Topic, action.RootDir)
+	err := os.RemoveAll(dirPath)
+	if err != nil {
+		return fmt.Errorf("os.RemoveAll(): %v", err)
+	}
+	action.Index.ForgetTopic(action.Topic)
+	return nil
+}
@@ -0,0 +1,58 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+)
+
+// TestDeleteTopic makes sure that the DeleteTopicAction removes the topic's
+// directory and forgets the topic in the index, while leaving other topics
+// alone.
+func TestDeleteTopic(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+	for _, topic := range []string{"topicA", "topicB"} {
+		storeAction := StoreAction{
+			Topic:   topic,
+			Message: []byte("some message"),
+			Index:   index,
+			RootDir: rootDir,
+		}
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.FailNow(t, msg)
+		}
+	}
+
+	action := DeleteTopicAction{Topic: "topicA", Index: index,
+		RootDir: rootDir}
+	err := action.DeleteTopic()
+	assert.Nil(t, err)
+
+	assert.False(t, ioutils.Exists(filenamer.DirectoryForTopic("topicA", rootDir)))
+	assert.True(t, ioutils.Exists(filenamer.DirectoryForTopic("topicB", rootDir)))
+	assert.Equal(t, []string{"topicB"}, index.Topics())
+}
+
+// TestDeleteUnknownTopic makes sure that deleting a topic that does not
+// exist is a benign no-op.
+func TestDeleteUnknownTopic(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+	action := DeleteTopicAction{Topic: "nosuchtopic", Index: index,
+		RootDir: rootDir}
+	err := action.DeleteTopic()
+	assert.Nil(t, err)
+}
@@ -0,0 +1,132 @@
+package actions
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
+)
+
+// GetMessageAction encapsulates a single execution of the get message
+// command, which fetches one message by its number.
+type GetMessageAction struct {
+	Topic         string
+	MessageNumber int
+	Index         *indexing.Index
+	RootDir       string
+	// How the message records were encoded. Nil means use
+	// records.DefaultSerializer.
+	Serializer records.Serializer
+	// The key with which to decrypt encrypted records. Nil means there is
+	// none.
+	EncryptionKey *records.Key
+	// The cache of memory-mapped message files to read from. Nil means
+	// read the file in the regular way.
+	Mappings *ioutils.ReadMappings
+	// The naming scheme of the store's files. Nil means use
+	// filenamer.DefaultNamer.
+	Namer filenamer.FileNamer
+}
+
+// GetMessage is the internal entry point function to fetch a single message.
+// It uses the index to find the file that holds the message, and where in the
+// file it starts, and reads only that record. It returns an error that wraps
+// contract.ErrTopicNotFound if the topic is unknown, one that wraps
+// contract.ErrMessageNotFound if no file holds the message, and one that
+// wraps records.ErrCorruptRecord if the record is corrupt (or
+// records.ErrDecryptionFailed if it cannot be decrypted). It is not
+// responsible for mutex protection.
+func (action GetMessageAction) GetMessage() (
+	storedMsg records.StoredMessage, err error) {
+
+	msgFileList, ok := action.Index.MessageFileLists[action.Topic]
+	if ok == false {
+		return records.StoredMessage{}, fmt.Errorf("%w: %v",
+			contract.ErrTopicNotFound, action.Topic)
+	}
+	fileName, err := action.Index.FileContaining(
+		action.Topic, action.MessageNumber)
+	if err != nil {
+		return records.StoredMessage{}, fmt.Errorf("%w: %v",
+			contract.ErrMessageNotFound, err)
+	}
+	// Numbers can be missing from a file's range, where compaction, or a
+	// rebuild of the index, dropped records.
+	fileMeta := msgFileList.Meta[fileName]
+	msgNum := int32(action.MessageNumber)
+	start, ok := fileMeta.SeekOffsetForMessageNumber[msgNum]
+	if ok == false {
+		return records.StoredMessage{}, fmt.Errorf(
+			"%w: message %d in topic: %v", contract.ErrMessageNotFound,
+			action.MessageNumber, action.Topic)
+	}
+
+	// The record ends where the next one in the file starts.
+	end := fileMeta.Size
+	for next := msgNum + 1; next <= fileMeta.Newest.MsgNum; next++ {
+		offset, ok := fileMeta.SeekOffsetForMessageNumber[next]
+		if ok {
+			end = offset
+			break
+		}
+	}
+
+	// Read just that part of the file.
+	framed, release, err := action.readRecord(fileName, start, end)
+	if err != nil {
+		return records.StoredMessage{}, fmt.Errorf(
+			"action.readRecord(): %v", err)
+	}
+	defer release()
+	storedMsg, err = records.DecodeFramed(framed,
+		serializerForFile(action.Serializer, fileMeta), action.EncryptionKey)
+	if err != nil {
+		return records.StoredMessage{}, fmt.Errorf(
+			"records.DecodeFramed(): %w", err)
+	}
+	if storedMsg.MsgNum != msgNum {
+		return records.StoredMessage{}, fmt.Errorf(
+			"%w: found message %d in place of message %d in topic: %v",
+			records.ErrCorruptRecord, storedMsg.MsgNum, msgNum, action.Topic)
+	}
+	return storedMsg, nil
+}
+
+// readRecord provides the bytes between the given offsets in the message
+// file, along with a function to call when finished with them. When they come
+// from the action's memory mappings, they must not be used after calling it.
+func (action GetMessageAction) readRecord(fileName string, start int64,
+	end int64) (framed []byte, release func(), err error) {
+	if action.Mappings != nil {
+		contents, release, err := messageFileContents(action.Mappings,
+			action.Namer, action.Index, action.Topic, fileName,
+			action.RootDir, end)
+		if err != nil {
+			return nil, nil, fmt.Errorf("messageFileContents(): %v", err)
+		}
+		if end > int64(len(contents)) {
+			release()
+			return nil, nil, fmt.Errorf("%w: the file ends at %d, before %d",
+				io.ErrUnexpectedEOF, len(contents), end)
+		}
+		return contents[start:end], release, nil
+	}
+	filePath := messageFilePath(action.Namer, fileName, action.Topic,
+		action.RootDir)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("os.Open(): %v", err)
+	}
+	defer file.Close()
+	framed = make([]byte, end-start)
+	_, err = file.ReadAt(framed, start)
+	if err != nil {
+		return nil, nil, fmt.Errorf("file.ReadAt(): %v", err)
+	}
+	return framed, func() {}, nil
+}
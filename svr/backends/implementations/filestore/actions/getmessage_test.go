@@ -0,0 +1,73 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+)
+
+func TestGetMessage(t *testing.T) {
+	// Store messages spread over several files, trim away the oldest, and
+	// make sure that each retained message can be fetched on its own, while
+	// the trimmed ones, and those never stored, are reported as not found.
+
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+
+	// Size the files so that exactly 3 messages fit in each.
+	topic := "sometopic"
+	storeAction := StoreAction{
+		Topic:       topic,
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 3 * encodedSizeOf(minikafka.Message("message_1")),
+	}
+	for i := 1; i <= 8; i++ {
+		storeAction.Message = minikafka.Message(fmt.Sprintf("message_%d", i))
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.FailNow(t, msg)
+		}
+	}
+	trimAction := TrimToCountAction{
+		Topic: topic, MaxMessages: 6, Index: index, RootDir: rootDir}
+	_, err := trimAction.TrimToCount()
+	if err != nil {
+		msg := fmt.Sprintf("trimAction.TrimToCount(): %v", err)
+		assert.FailNow(t, msg)
+	}
+
+	for i := 3; i <= 8; i++ {
+		action := GetMessageAction{
+			Topic: topic, MessageNumber: i, Index: index, RootDir: rootDir}
+		storedMsg, err := action.GetMessage()
+		assert.Nil(t, err)
+		assert.Equal(t, int32(i), storedMsg.MsgNum)
+		assert.Equal(t, fmt.Sprintf("message_%d", i),
+			string(storedMsg.Message))
+	}
+	for _, msgNum := range []int{1, 2, 9, 0, -1} {
+		action := GetMessageAction{
+			Topic: topic, MessageNumber: msgNum, Index: index,
+			RootDir: rootDir}
+		_, err := action.GetMessage()
+		assert.True(t, errors.Is(err, contract.ErrMessageNotFound))
+	}
+
+	action := GetMessageAction{
+		Topic: "nosuchtopic", MessageNumber: 1, Index: index,
+		RootDir: rootDir}
+	_, err = action.GetMessage()
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+}
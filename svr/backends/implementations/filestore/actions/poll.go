@@ -3,21 +3,48 @@
 package actions
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 
 	"github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
 )
 
 // PollAction encapsulates a single execution of the Poll command.
 type PollAction struct {
+	// The poll is abandoned when this is cancelled. It is checked before
+	// each message file is read. Nil means the poll cannot be cancelled.
+	Ctx      context.Context
 	Topic    string
 	ReadFrom int
 	Index    *indexing.Index
 	RootDir  string
+	// The most messages to return. Zero means no limit.
+	MaxMessages int
+	// The highest message number to return. Zero means no limit.
+	ReadTo int
+	// How the message records were encoded. Nil means use
+	// records.DefaultSerializer.
+	Serializer records.Serializer
+	// The key with which to decrypt encrypted records. Nil means there is
+	// none.
+	EncryptionKey *records.Key
+	// Called with each record found to be corrupt, in addition to it being
+	// reported in the error returned. Nil means there is nothing to call.
+	OnCorrupt func(CorruptRecord)
+	// The cache of memory-mapped message files to read from. Nil means
+	// read the files in the regular way.
+	Mappings *ioutils.ReadMappings
+	// The naming scheme of the store's files. Nil means use
+	// filenamer.DefaultNamer.
+	Namer filenamer.FileNamer
 }
 
 // Poll is the internal entry point function to poll for messages beyond a given
@@ -26,10 +53,35 @@ type PollAction struct {
 func (action PollAction) Poll() (
 	foundMessages []minikafka.Message, newReadFrom int, err error) {
 
+	// Errors are passed on unwrapped, because Poll is no more than a view
+	// of PollRecords.
+	stored, newReadFrom, err := action.PollRecords()
+	if err != nil && errors.Is(err, records.ErrCorruptRecord) == false {
+		return nil, -1, err
+	}
+	foundMessages = make([]minikafka.Message, len(stored))
+	for i, storedMsg := range stored {
+		foundMessages[i] = storedMsg.Message
+	}
+	return foundMessages, newReadFrom, err
+}
+
+// PollRecords is like Poll, except that it provides the stored
+// representation of each message, which includes its key and creation time.
+//
+// Records found to be corrupt are skipped, rather than failing the poll. When
+// there are any, the records that could be read are nonetheless returned,
+// along with an error that wraps records.ErrCorruptRecord, and which
+// identifies the message numbers skipped. Should the action's context be
+// cancelled, it returns ctx.Err() instead.
+func (action PollAction) PollRecords() (
+	found []records.StoredMessage, newReadFrom int, err error) {
+
 	// Access the topic-specific indexing information.
 	msgFileList, ok := action.Index.MessageFileLists[action.Topic]
 	if ok == false {
-		return nil, -1, fmt.Errorf("Unknown topic: %v", action.Topic)
+		return nil, -1, fmt.Errorf("%w: %v", contract.ErrTopicNotFound,
+			action.Topic)
 	}
 
 	// Which message storage files must we look in?
@@ -39,59 +91,154 @@ func (action PollAction) Poll() (
 
 	// If there are none, return benign data.
 	if len(fileNames) == 0 {
-		return []minikafka.Message{}, int(action.ReadFrom), nil
+		return []records.StoredMessage{}, int(action.ReadFrom), nil
 	}
 
 	// Harvest the messages from this list of files.
-	messages := []minikafka.Message{}
+	found = []records.StoredMessage{}
+	corrupt := []int32{}
+	var lastHarvested int32
+	newReadFrom = int(action.Index.NextMessageNumbers[action.Topic])
 	for _, fileName := range fileNames {
-		messages, err = action.addMessagesFromFile(
-			messages, fileName, int32(messageNumberToReadFrom))
+		if action.Ctx != nil {
+			err = action.Ctx.Err()
+			if err != nil {
+				return nil, -1, err
+			}
+		}
+		found, corrupt, lastHarvested, err = action.addRecordsFromFile(
+			found, corrupt, fileName, int32(messageNumberToReadFrom))
 		if err != nil {
-			return nil, -1, fmt.Errorf("action.AddMessagesFromFile(): %v", err)
+			return nil, -1, fmt.Errorf("action.addRecordsFromFile(): %w", err)
+		}
+		if action.limitReached(len(found)) ||
+			(action.ReadTo > 0 && int(lastHarvested) >= action.ReadTo) {
+			newReadFrom = int(lastHarvested) + 1
+			break
 		}
 	}
 
-	newReadFrom = int(action.Index.NextMessageNumbers[action.Topic])
-
-	return messages, newReadFrom, nil
+	if len(corrupt) != 0 {
+		return found, newReadFrom, fmt.Errorf(
+			"%w: skipped message(s) %v in topic: %v",
+			records.ErrCorruptRecord, corrupt, action.Topic)
+	}
+	return found, newReadFrom, nil
 }
 
-// addMessagesFromFile appends all the messages in the file beyond (incl.)
-// messageNumberToReadFrom, to the addTo slice, and returns it.
-func (action PollAction) addMessagesFromFile(
-	addTo []minikafka.Message, fileName string, messageNumberToReadFrom int32) (
-	[]minikafka.Message, error) {
+// limitReached evaluates whether the number of messages harvested so far has
+// reached the maximum number the action is allowed to return.
+func (action PollAction) limitReached(harvested int) bool {
+	return action.MaxMessages > 0 && harvested >= action.MaxMessages
+}
 
-	// Read the file contents into memory.
-	filePath := filenamer.MessageFilePath(fileName, action.Topic, action.RootDir)
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("os.Open(): %v", err)
-	}
-	defer file.Close()
-	fileContents, err := ioutil.ReadAll(file)
+// addRecordsFromFile appends all the stored messages in the file beyond
+// (incl.) messageNumberToReadFrom, to the addTo slice, and returns it, along
+// with the message number of the last one harvested. The numbers of those
+// that are corrupt are appended to the corrupt slice instead. It stops early
+// if the action's message limit is reached.
+func (action PollAction) addRecordsFromFile(
+	addTo []records.StoredMessage, corrupt []int32, fileName string,
+	messageNumberToReadFrom int32) (
+	[]records.StoredMessage, []int32, int32, error) {
 
 	// Which message numbers should we harvest?
 	msgFileList, _ := action.Index.MessageFileLists[action.Topic]
 	fileMeta := msgFileList.Meta[fileName]
+
+	// Read the file contents into memory (or find them mapped there).
+	fileContents, release, err := messageFileContents(action.Mappings,
+		action.Namer, action.Index, action.Topic, fileName, action.RootDir,
+		fileMeta.Size)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("messageFileContents(): %v", err)
+	}
+	defer release()
 	startMsgNum := messageNumberToReadFrom
 	if fileMeta.Oldest.MsgNum > startMsgNum {
 		startMsgNum = fileMeta.Oldest.MsgNum
 	}
 	endMsgNum := fileMeta.Newest.MsgNum
+	if action.ReadTo > 0 && endMsgNum > int32(action.ReadTo) {
+		endMsgNum = int32(action.ReadTo)
+	}
 
-	// For each targeted message number, harvest the slice of bytes in the
+	// For each targeted message number, decode the slice of bytes in the
 	// file that represents it.
+	serializer := serializerForFile(action.Serializer, fileMeta)
+	lastHarvested := startMsgNum - 1
 	for msgNum := startMsgNum; msgNum <= endMsgNum; msgNum++ {
-		start := fileMeta.SeekOffsetForMessageNumber[msgNum]
-		end, ok := fileMeta.SeekOffsetForMessageNumber[msgNum+1]
+		if action.limitReached(len(addTo)) {
+			break
+		}
+		lastHarvested = msgNum
+		// Numbers can be missing, where a rebuild of the index dropped
+		// corrupt records.
+		start, ok := fileMeta.SeekOffsetForMessageNumber[msgNum]
 		if ok == false {
+			continue
+		}
+		// The file may extend beyond the messages registered, if one is
+		// being appended concurrently. Nor should a file that has lost bytes
+		// cause a panic.
+		end := fileMeta.Size
+		if end > int64(len(fileContents)) {
 			end = int64(len(fileContents))
 		}
-		msgBytes := fileContents[start:end]
-		addTo = append(addTo, msgBytes)
+		if start > end {
+			start = end
+		}
+		storedMsg, err := records.DecodeFramed(
+			fileContents[start:end], serializer, action.EncryptionKey)
+		// A record that cannot be decrypted is intact, so rather than
+		// skipping it, the poll fails.
+		if errors.Is(err, records.ErrDecryptionFailed) {
+			return nil, nil, 0, fmt.Errorf("message %d: %w", msgNum, err)
+		}
+		if err != nil {
+			corrupt = append(corrupt, msgNum)
+			if action.OnCorrupt != nil {
+				action.OnCorrupt(CorruptRecord{Topic: action.Topic,
+					MessageNumber: int(msgNum), FileName: fileName,
+					Offset: start, Raw: rawRecord(fileContents[start:end]),
+					Problem: err.Error()})
+			}
+			continue
+		}
+		addTo = append(addTo, storedMsg)
 	}
 
-	return addTo, nil
+	return addTo, corrupt, lastHarvested, nil
+}
+
+// messageFileContents provides the contents of a topic's message file, along
+// with a function to call when finished with them. They come from the given
+// cache of memory mappings, when there is one, in which case they must not be
+// used after calling the function - and are otherwise read from the file.
+// The size is how much of the file the index has registered, and the namer
+// says where the file is (with nil meaning filenamer.DefaultNamer).
+func messageFileContents(mappings *ioutils.ReadMappings,
+	namer filenamer.FileNamer, index *indexing.Index, topic string,
+	fileName string, rootDir string, size int64) (
+	contents []byte, release func(), err error) {
+	filePath := messageFilePath(namer, fileName, topic, rootDir)
+	if mappings != nil {
+		current := fileName == index.CurrentMsgFileNameFor(topic)
+		contents, release, err = mappings.Contents(
+			topic, filePath, size, current)
+		if err != nil {
+			return nil, nil, fmt.Errorf("mappings.Contents(): %v", err)
+		}
+		return contents, release, nil
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("os.Open(): %v", err)
+	}
+	defer file.Close()
+	contents, err = ioutil.ReadAll(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ioutil.ReadAll(): %v", err)
+	}
+	return contents, func() {}, nil
 }
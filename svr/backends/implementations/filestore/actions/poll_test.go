@@ -1,7 +1,9 @@
 package actions
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
@@ -9,8 +11,11 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
 )
 
 func TestSimplestCase(t *testing.T) {
@@ -43,7 +48,8 @@ func TestSimplestCase(t *testing.T) {
 		}
 	}
 	readFrom := 1
-	action := PollAction{topic, readFrom, index, rootDir}
+	action := PollAction{
+		Topic: topic, ReadFrom: readFrom, Index: index, RootDir: rootDir}
 	messages, newReadFrom, err := action.Poll()
 	if err != nil {
 		msg := fmt.Sprintf("action.Poll(): %v", err)
@@ -85,7 +91,8 @@ func TestSeekOffsetsCalculatedRight(t *testing.T) {
 		}
 	}
 	readFrom := 1
-	action := PollAction{topic, readFrom, index, rootDir}
+	action := PollAction{
+		Topic: topic, ReadFrom: readFrom, Index: index, RootDir: rootDir}
 	messages, newReadFrom, err := action.Poll()
 	if err != nil {
 		msg := fmt.Sprintf("action.Poll(): %v", err)
@@ -113,7 +120,8 @@ func TestOnStoreWithNoMessagesRegistered(t *testing.T) {
 	index.GetMessageFileListFor(topic)
 
 	readFrom := 1
-	action := PollAction{topic, readFrom, index, rootDir}
+	action := PollAction{
+		Topic: topic, ReadFrom: readFrom, Index: index, RootDir: rootDir}
 	messages, newReadFrom, err := action.Poll()
 	if err != nil {
 		msg := fmt.Sprintf("action.Poll(): %v", err)
@@ -132,9 +140,11 @@ func TestWhenTopicIsUnknown(t *testing.T) {
 	index := indexing.NewIndex()
 
 	readFrom := 1
-	action := PollAction{"nosuchtopic", readFrom, index, rootDir}
+	action := PollAction{
+		Topic: "nosuchtopic", ReadFrom: readFrom, Index: index, RootDir: rootDir}
 	_, _, err := action.Poll()
-	assert.EqualError(t, err, "Unknown topic: nosuchtopic")
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+	assert.EqualError(t, err, "topic not found: nosuchtopic")
 }
 
 func TestWhenReadFromIsEarlierThanAllFiles(t *testing.T) {
@@ -163,7 +173,8 @@ func TestWhenReadFromIsEarlierThanAllFiles(t *testing.T) {
 		}
 	}
 	readFrom := -999
-	action := PollAction{topic, readFrom, index, rootDir}
+	action := PollAction{
+		Topic: topic, ReadFrom: readFrom, Index: index, RootDir: rootDir}
 	messages, newReadFrom, err := action.Poll()
 	if err != nil {
 		msg := fmt.Sprintf("action.Poll(): %v", err)
@@ -199,7 +210,8 @@ func TestWhenReadFromIsLaterThanAllFiles(t *testing.T) {
 		}
 	}
 	readFrom := 999
-	action := PollAction{topic, readFrom, index, rootDir}
+	action := PollAction{
+		Topic: topic, ReadFrom: readFrom, Index: index, RootDir: rootDir}
 	messages, newReadFrom, err := action.Poll()
 	if err != nil {
 		msg := fmt.Sprintf("action.Poll(): %v", err)
@@ -236,7 +248,8 @@ func TestWhenReadFromIsInMiddleOfFile(t *testing.T) {
 		}
 	}
 	readFrom := 3
-	action := PollAction{topic, readFrom, index, rootDir}
+	action := PollAction{
+		Topic: topic, ReadFrom: readFrom, Index: index, RootDir: rootDir}
 	messages, newReadFrom, err := action.Poll()
 	if err != nil {
 		msg := fmt.Sprintf("action.Poll(): %v", err)
@@ -274,7 +287,8 @@ func TestResultsWhenFromMultipleFiles(t *testing.T) {
 		}
 	}
 	readFrom := 1
-	action := PollAction{topic, readFrom, index, rootDir}
+	action := PollAction{
+		Topic: topic, ReadFrom: readFrom, Index: index, RootDir: rootDir}
 	messages, newReadFrom, err := action.Poll()
 	if err != nil {
 		msg := fmt.Sprintf("action.Poll(): %v", err)
@@ -283,3 +297,136 @@ func TestResultsWhenFromMultipleFiles(t *testing.T) {
 	assert.Equal(t, 20, len(messages))
 	assert.Equal(t, 21, newReadFrom)
 }
+
+func TestMaxMessagesLimitsResultsAcrossFiles(t *testing.T) {
+	// Store messages that force several files to be created, and make sure
+	// that a Poll with a message limit stops at the limit, even when that
+	// falls part way through a file, and advises the right read-from.
+
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+
+	topic := "sometopic"
+	storeAction := StoreAction{
+		Topic:       topic,
+		Message:     []byte("0123456789"),
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 3 * encodedSizeOf([]byte("0123456789")),
+	}
+	for i := 0; i < 10; i++ {
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.Fail(t, msg)
+		}
+	}
+	action := PollAction{
+		Topic: topic, ReadFrom: 2, Index: index, RootDir: rootDir,
+		MaxMessages: 4}
+	messages, newReadFrom, err := action.Poll()
+	if err != nil {
+		msg := fmt.Sprintf("action.Poll(): %v", err)
+		assert.Fail(t, msg)
+	}
+	assert.Equal(t, 4, len(messages))
+	assert.Equal(t, 6, newReadFrom)
+}
+
+func TestReadToLimitsResultsAcrossFiles(t *testing.T) {
+	// Store messages that force several files to be created, and make sure
+	// that a Poll with an upper message number stops there, even when that
+	// falls part way through a file, and advises the right read-from.
+
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+
+	topic := "sometopic"
+	storeAction := StoreAction{
+		Topic:       topic,
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 3 * encodedSizeOf([]byte("message_1")),
+	}
+	for i := 1; i <= 10; i++ {
+		storeAction.Message = []byte(fmt.Sprintf("message_%d", i))
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.Fail(t, msg)
+		}
+	}
+	action := PollAction{
+		Topic: topic, ReadFrom: 2, Index: index, RootDir: rootDir,
+		ReadTo: 5}
+	messages, newReadFrom, err := action.Poll()
+	if err != nil {
+		msg := fmt.Sprintf("action.Poll(): %v", err)
+		assert.Fail(t, msg)
+	}
+	assert.Equal(t, []minikafka.Message{[]byte("message_2"),
+		[]byte("message_3"), []byte("message_4"), []byte("message_5")},
+		messages)
+	assert.Equal(t, 6, newReadFrom)
+}
+
+func TestVariableLengthMessagesInOneFile(t *testing.T) {
+	// Store messages of widely differing lengths into a single message file,
+	// with each serializer, and make sure that they are all read back in
+	// order - both by a Poll, and by stepping through the file's framed
+	// records directly.
+
+	for name, serializer := range map[string]records.Serializer{
+		"gob": records.GobSerializer{}, "json": records.JSONSerializer{}} {
+		t.Run(name, func(t *testing.T) {
+			rootDir := ioutils.TmpRootDir(t)
+			defer os.RemoveAll(rootDir)
+
+			index := indexing.NewIndex()
+			topic := "sometopic"
+			lengths := []int{5, 0, 1000, 1, 70000, 42}
+			for _, length := range lengths {
+				storeAction := StoreAction{
+					Topic:      topic,
+					Message:    []byte(strings.Repeat("X", length)),
+					Index:      index,
+					RootDir:    rootDir,
+					Serializer: serializer,
+				}
+				_, _, err := storeAction.Store()
+				if err != nil {
+					msg := fmt.Sprintf("storeAction.Store(): %v", err)
+					assert.FailNow(t, msg)
+				}
+			}
+			msgFileList := index.MessageFileLists[topic]
+			assert.Equal(t, 1, len(msgFileList.Names))
+
+			action := PollAction{Topic: topic, ReadFrom: 1, Index: index,
+				RootDir: rootDir, Serializer: serializer}
+			messages, _, err := action.Poll()
+			assert.Nil(t, err)
+			assert.Equal(t, len(lengths), len(messages))
+			for i, message := range messages {
+				assert.Equal(t, lengths[i], len(message))
+			}
+
+			contents, err := ioutil.ReadFile(filenamer.MessageFilePath(
+				msgFileList.Names[0], topic, rootDir))
+			assert.Nil(t, err)
+			found, _, skipped, _, err := records.DecodeSequence(
+				contents, serializer, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, 0, len(skipped))
+			assert.Equal(t, len(lengths), len(found))
+			for i, storedMsg := range found {
+				assert.Equal(t, int32(i+1), storedMsg.MsgNum)
+				assert.Equal(t, lengths[i], len(storedMsg.Message))
+			}
+		})
+	}
+}
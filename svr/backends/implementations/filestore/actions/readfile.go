@@ -0,0 +1,110 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
+)
+
+// ReadFileAction encapsulates a single execution of reading the records in
+// one message file, as of a snapshot of the file's FileMeta taken earlier -
+// so that the file can be read without the store's mutex being held.
+type ReadFileAction struct {
+	Topic    string
+	FileName string
+	// The file's FileMeta, as it was when the snapshot was taken. Only the
+	// records it registers are read, so not those appended since.
+	FileMeta *indexing.FileMeta
+	ReadFrom int
+	RootDir  string
+	// How the message records were encoded. Nil means use
+	// records.DefaultSerializer.
+	Serializer records.Serializer
+	// The key with which to decrypt encrypted records. Nil means there is
+	// none.
+	EncryptionKey *records.Key
+	// The naming scheme of the store's files. Nil means use
+	// filenamer.DefaultNamer.
+	Namer filenamer.FileNamer
+}
+
+// ReadFile provides the records that the action's FileMeta registers, from
+// the message number ReadFrom onwards, in order, along with the numbers of
+// those that were found to be corrupt instead.
+//
+// Since the store's mutex is not held, the file may have changed since the
+// snapshot was taken: it may have been removed, or rewritten without its
+// oldest messages - by retention, or compaction. When the records still to
+// be read are no longer all there, complete is false, and the records
+// provided are those before the first that is missing (and none, when the
+// file has gone). A record that is missing from a file in which corrupt
+// records were skipped is taken to be one of those. A record that cannot be
+// decrypted fails the read, with an error that wraps
+// records.ErrDecryptionFailed.
+func (action ReadFileAction) ReadFile() (found []records.StoredMessage,
+	corrupt []int32, complete bool, err error) {
+	filePath := messageFilePath(action.Namer, action.FileName, action.Topic,
+		action.RootDir)
+	file, err := os.Open(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return []records.StoredMessage{}, []int32{}, false, nil
+	}
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("os.Open(): %v", err)
+	}
+	defer file.Close()
+	contents, err := ioutil.ReadAll(io.LimitReader(file, action.FileMeta.Size))
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("ioutil.ReadAll(): %v", err)
+	}
+
+	// The file is decoded from the start, rather than at the seek offsets
+	// registered, since they no longer hold once it has been rewritten.
+	serializer := serializerForFile(action.Serializer, action.FileMeta)
+	decoded, _, skipped, _, err := records.DecodeSequence(
+		contents, serializer, action.EncryptionKey)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("records.DecodeSequence(): %w",
+			err)
+	}
+	byNumber := map[int32]records.StoredMessage{}
+	for _, storedMsg := range decoded {
+		byNumber[storedMsg.MsgNum] = storedMsg
+	}
+	found = []records.StoredMessage{}
+	corrupt = []int32{}
+	for _, msgNum := range action.messageNumbers() {
+		storedMsg, ok := byNumber[msgNum]
+		switch {
+		case ok:
+			found = append(found, storedMsg)
+		case len(skipped) != 0:
+			corrupt = append(corrupt, msgNum)
+		default:
+			return found, corrupt, false, nil
+		}
+	}
+	return found, corrupt, true, nil
+}
+
+// messageNumbers provides the message numbers the action's FileMeta
+// registers, from ReadFrom onwards, in ascending order.
+func (action ReadFileAction) messageNumbers() []int32 {
+	msgNums := []int32{}
+	for msgNum := range action.FileMeta.SeekOffsetForMessageNumber {
+		if int(msgNum) >= action.ReadFrom {
+			msgNums = append(msgNums, msgNum)
+		}
+	}
+	sort.Slice(msgNums, func(i, j int) bool {
+		return msgNums[i] < msgNums[j]
+	})
+	return msgNums
+}
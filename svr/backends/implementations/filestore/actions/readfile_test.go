@@ -0,0 +1,106 @@
+package actions
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
+)
+
+// Make sure that reading a file as of a snapshot of its FileMeta provides
+// the records the snapshot registers, and not those appended since, and that
+// it says the read is incomplete once the file has been rewritten without
+// records still to be read, or removed.
+func TestReadFileAsOfSnapshot(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+
+	topic := "sometopic"
+	storeAction := StoreAction{
+		Topic:       topic,
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 3 * encodedSizeOf([]byte("message_NN")),
+	}
+	store := func(from int, to int) {
+		for i := from; i <= to; i++ {
+			storeAction.Message = minikafka.Message(
+				fmt.Sprintf("message_%02d", i))
+			_, _, err := storeAction.Store()
+			if err != nil {
+				msg := fmt.Sprintf("storeAction.Store(): %v", err)
+				assert.FailNow(t, msg)
+			}
+		}
+	}
+	store(1, 7)
+	// Files now hold 1-3, 4-6 and 7.
+	msgFileList := index.MessageFileLists[topic]
+	snapshot := msgFileList.Clone()
+	store(8, 8)
+	readAction := func(fileIndex int, readFrom int) ReadFileAction {
+		fileName := snapshot.Names[fileIndex]
+		return ReadFileAction{Topic: topic, FileName: fileName,
+			FileMeta: snapshot.Meta[fileName], ReadFrom: readFrom,
+			RootDir: rootDir}
+	}
+	messagesOf := func(found []records.StoredMessage) []string {
+		messages := []string{}
+		for _, storedMsg := range found {
+			messages = append(messages, string(storedMsg.Message))
+		}
+		return messages
+	}
+
+	found, corrupt, complete, err := readAction(0, 2).ReadFile()
+	assert.Nil(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, []string{"message_02", "message_03"}, messagesOf(found))
+	assert.Equal(t, []int32{}, corrupt)
+
+	// Message 8 was appended to the last file after the snapshot.
+	found, _, complete, err = readAction(2, 1).ReadFile()
+	assert.Nil(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, []string{"message_07"}, messagesOf(found))
+
+	truncateAction := TruncateBeforeAction{
+		Topic: topic, MessageNumber: 5, Index: index, RootDir: rootDir}
+	_, err = truncateAction.TruncateBefore()
+	assert.Nil(t, err)
+	found, _, complete, err = readAction(1, 4).ReadFile()
+	assert.Nil(t, err)
+	assert.False(t, complete)
+	assert.Equal(t, []string{}, messagesOf(found))
+	found, _, complete, err = readAction(1, 5).ReadFile()
+	assert.Nil(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, []string{"message_05", "message_06"}, messagesOf(found))
+	found, _, complete, err = readAction(0, 1).ReadFile()
+	assert.Nil(t, err)
+	assert.False(t, complete)
+	assert.Equal(t, []string{}, messagesOf(found))
+
+	// A corrupt record is reported, rather than ending the read.
+	fileName := snapshot.Names[2]
+	filePath := messageFilePath(nil, fileName, topic, rootDir)
+	contents, err := ioutil.ReadFile(filePath)
+	assert.Nil(t, err)
+	contents[len(contents)/4] ^= 0xff
+	err = ioutil.WriteFile(filePath, contents, 0644)
+	assert.Nil(t, err)
+	found, corrupt, complete, err = readAction(2, 1).ReadFile()
+	assert.Nil(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, []string{}, messagesOf(found))
+	assert.Equal(t, []int32{7}, corrupt)
+}
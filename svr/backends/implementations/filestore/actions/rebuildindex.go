@@ -0,0 +1,250 @@
+// Package actions is where the private implementation code lives for each
+// of the main BackingStore actions. I.e. store/removeold/poll etc.
+package actions
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
+)
+
+// RebuildIndexAction encapsulates a single execution of the rebuild-index
+// command.
+type RebuildIndexAction struct {
+	RootDir string
+	// How the message records were encoded. Nil means use
+	// records.DefaultSerializer.
+	Serializer records.Serializer
+	// The key with which to decrypt encrypted records. Nil means there is
+	// none.
+	EncryptionKey *records.Key
+	// Called with each record that is dropped, in addition to it being
+	// reported in the problems returned. Nil means there is nothing to call.
+	OnCorrupt func(CorruptRecord)
+	// The naming scheme of the store's files. Nil means use
+	// filenamer.DefaultNamer.
+	Namer filenamer.FileNamer
+}
+
+// RebuildIndex is the internal entry point function to reconstruct an index
+// from the message files alone, by decoding the records in each of them. It
+// does not save the index, nor is it responsible for mutex protection. These
+// are the responsibility of the caller.
+//
+// When a message file ends part way through a record, the file is truncated
+// to drop it. (Otherwise subsequent appends would be misplaced.) Records that
+// fail their checksum, or cannot be decoded, are dropped by rewriting the
+// file without them. These are reported in the problems returned, which does
+// not stop the rebuild. Message files holding no decodable records at all are
+// removed. So are records whose message numbers an earlier message file
+// holds already, as those merged by an interrupted CompactSegmentsAction do.
+// A record that cannot be decrypted is not dropped, since it is intact;
+// instead the rebuild fails, with an error that wraps
+// records.ErrDecryptionFailed.
+func (action RebuildIndexAction) RebuildIndex() (
+	index *indexing.Index, problems []string, err error) {
+	index = indexing.NewIndex()
+	problems = []string{}
+	topics, err := ioutils.SubDirectories(action.RootDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ioutils.SubDirectories(): %v", err)
+	}
+	for _, dirName := range topics {
+		// Directories the naming scheme does not recognise are not for
+		// topics.
+		topic, ok := namerOrDefault(action.Namer).TopicForDirectory(dirName)
+		if ok == false {
+			continue
+		}
+		topicProblems, err := action.rebuildTopic(index, topic)
+		if err != nil {
+			return nil, nil, fmt.Errorf("rebuildTopic(): %w", err)
+		}
+		problems = append(problems, topicProblems...)
+	}
+	return index, problems, nil
+}
+
+// rebuildTopic registers everything found in the given topic's message files
+// with the index.
+func (action RebuildIndexAction) rebuildTopic(
+	index *indexing.Index, topic string) (problems []string, err error) {
+	namer := namerOrDefault(action.Namer)
+	dirPath := namer.DirectoryForTopic(topic, action.RootDir)
+	entities, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadDir(): %v", err)
+	}
+
+	// Decode each message file in turn.
+	type decodedFile struct {
+		name        string
+		found       []records.StoredMessage
+		seekOffsets []int64
+		size        int64
+		compressed  bool
+	}
+	decodedFiles := []decodedFile{}
+	for _, entity := range entities {
+		fileName := entity.Name()
+		if entity.IsDir() || namer.IsMessageFileName(fileName) == false {
+			continue
+		}
+		filePath := filenamer.MessageFilePathFor(
+			namer, fileName, topic, action.RootDir)
+		contents, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("ioutil.ReadFile(): %v", err)
+		}
+		// The index no longer says whether the file's records are
+		// compressed, so the records must.
+		serializer := serializerOrDefault(action.Serializer)
+		compressed := records.SequenceIsCompressed(
+			contents, action.EncryptionKey)
+		if compressed {
+			serializer = records.Compressed(serializer)
+		}
+		found, seekOffsets, skipped, decodedLength, err :=
+			records.DecodeSequence(contents, serializer, action.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+		incomplete := decodedLength < int64(len(contents))
+		if incomplete {
+			problem := fmt.Sprintf(
+				"%s: dropped %d bytes of an incomplete final record",
+				filePath, int64(len(contents))-decodedLength)
+			problems = append(problems, problem)
+			action.reportCorrupt(topic, fileName, contents, decodedLength,
+				problem)
+		}
+		for _, offset := range skipped {
+			problem := fmt.Sprintf(
+				"%s: dropped corrupt record at offset %d", filePath, offset)
+			problems = append(problems, problem)
+			action.reportCorrupt(topic, fileName, contents, offset, problem)
+		}
+		if len(found) == 0 {
+			err = os.Remove(filePath)
+			if err != nil {
+				return nil, fmt.Errorf("os.Remove(): %v", err)
+			}
+			continue
+		}
+		if len(skipped) != 0 {
+			seekOffsets, decodedLength, err = rewriteKeeping(
+				filePath, contents, seekOffsets)
+			if err != nil {
+				return nil, fmt.Errorf("rewriteKeeping(): %v", err)
+			}
+		} else if incomplete {
+			err = os.Truncate(filePath, decodedLength)
+			if err != nil {
+				return nil, fmt.Errorf("os.Truncate(): %v", err)
+			}
+		}
+		decodedFiles = append(decodedFiles, decodedFile{
+			fileName, found, seekOffsets, decodedLength, compressed})
+	}
+
+	// Register the files in message number order.
+	sort.Slice(decodedFiles, func(i, j int) bool {
+		return decodedFiles[i].found[0].MsgNum < decodedFiles[j].found[0].MsgNum
+	})
+	msgFileList := index.GetMessageFileListFor(topic)
+	for _, decoded := range decodedFiles {
+		filePath := filenamer.MessageFilePathFor(
+			namer, decoded.name, topic, action.RootDir)
+		next := index.NextMessageNumberFor(topic)
+		kept := []int{}
+		for i, storedMsg := range decoded.found {
+			if storedMsg.MsgNum >= next {
+				kept = append(kept, i)
+			}
+		}
+		if len(kept) < len(decoded.found) {
+			problem := fmt.Sprintf(
+				"%s: dropped %d records held by an earlier message file",
+				filePath, len(decoded.found)-len(kept))
+			problems = append(problems, problem)
+		}
+		if len(kept) == 0 {
+			err = os.Remove(filePath)
+			if err != nil {
+				return nil, fmt.Errorf("os.Remove(): %v", err)
+			}
+			continue
+		}
+		if len(kept) < len(decoded.found) {
+			contents, err := ioutil.ReadFile(filePath)
+			if err != nil {
+				return nil, fmt.Errorf("ioutil.ReadFile(): %v", err)
+			}
+			found := []records.StoredMessage{}
+			seekOffsets := []int64{}
+			for _, i := range kept {
+				found = append(found, decoded.found[i])
+				seekOffsets = append(seekOffsets, decoded.seekOffsets[i])
+			}
+			decoded.found = found
+			decoded.seekOffsets, decoded.size, err = rewriteKeeping(
+				filePath, contents[:decoded.size], seekOffsets)
+			if err != nil {
+				return nil, fmt.Errorf("rewriteKeeping(): %v", err)
+			}
+		}
+		msgFileList.RegisterNewFile(decoded.name)
+		fileMeta := msgFileList.Meta[decoded.name]
+		fileMeta.Compressed = decoded.compressed
+		// When the file was started is not recorded in it, but the first
+		// message was stored then.
+		fileMeta.Opened = decoded.found[0].Created
+		for i, storedMsg := range decoded.found {
+			end := decoded.size
+			if i+1 < len(decoded.seekOffsets) {
+				end = decoded.seekOffsets[i+1]
+			}
+			fileMeta.RegisterNewMessage(storedMsg.MsgNum,
+				end-decoded.seekOffsets[i], storedMsg.Created)
+		}
+		index.NextMessageNumbers[topic] = fileMeta.Newest.MsgNum + 1
+	}
+	return problems, nil
+}
+
+// reportCorrupt passes the record that starts at the given offset in the
+// message file's contents to the action's OnCorrupt function, if it has one.
+func (action RebuildIndexAction) reportCorrupt(topic string, fileName string,
+	contents []byte, offset int64, problem string) {
+	if action.OnCorrupt == nil {
+		return
+	}
+	action.OnCorrupt(CorruptRecord{Topic: topic, FileName: fileName,
+		Offset: offset, Raw: rawRecord(contents[offset:]), Problem: problem})
+}
+
+// rewriteKeeping rewrites the message file, whose contents are given, so that
+// it holds only the (framed) records that start at the given offsets. It
+// provides the offsets at which they start in the rewritten file, and its
+// length.
+func rewriteKeeping(filePath string, contents []byte, seekOffsets []int64) (
+	newSeekOffsets []int64, newLength int64, err error) {
+	kept := []byte{}
+	newSeekOffsets = []int64{}
+	for _, offset := range seekOffsets {
+		_, frameLength, _ := records.Unframe(contents[offset:])
+		newSeekOffsets = append(newSeekOffsets, int64(len(kept)))
+		kept = append(kept, contents[offset:offset+frameLength]...)
+	}
+	err = ioutils.ReplaceFile(filePath, kept)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ioutils.ReplaceFile(): %v", err)
+	}
+	return newSeekOffsets, int64(len(kept)), nil
+}
@@ -0,0 +1,57 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+)
+
+// Store messages across several files, and make sure that the index rebuilt
+// from the files matches the one maintained as they were stored.
+func TestRebuiltIndexMatchesOriginal(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+
+	topic := "sometopic"
+	storeAction := StoreAction{
+		Topic:       topic,
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 3 * encodedSizeOf([]byte("message_N")),
+	}
+	for i := 1; i <= 7; i++ {
+		storeAction.Message = minikafka.Message(fmt.Sprintf("message_%d", i))
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.Fail(t, msg)
+		}
+	}
+
+	rebuildAction := RebuildIndexAction{RootDir: rootDir}
+	rebuilt, problems, err := rebuildAction.RebuildIndex()
+	if err != nil {
+		msg := fmt.Sprintf("rebuildAction.RebuildIndex(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	assert.Equal(t, 0, len(problems))
+	original := index.MessageFileLists[topic]
+	restored := rebuilt.MessageFileLists[topic]
+	assert.Equal(t, original.Names, restored.Names)
+	for _, name := range original.Names {
+		assert.Equal(t, original.Meta[name].Size, restored.Meta[name].Size)
+		assert.Equal(t, original.Meta[name].SeekOffsetForMessageNumber,
+			restored.Meta[name].SeekOffsetForMessageNumber)
+		assert.True(t, original.Meta[name].Newest.Created.Equal(
+			restored.Meta[name].Newest.Created))
+	}
+	assert.Equal(t, int32(8), rebuilt.NextMessageNumbers[topic])
+}
@@ -4,7 +4,6 @@ package actions
 
 import (
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
@@ -17,41 +16,71 @@ type RemoveOldMessagesAction struct {
 	MaxAge  time.Time
 	Index   *indexing.Index
 	RootDir string
+	// The naming scheme of the store's files. Nil means use
+	// filenamer.DefaultNamer.
+	Namer filenamer.FileNamer
 }
 
 // RemoveOldMessages is the internal entry point function to remove expired
-// messages from the filestore. Its responsibility to perform the removal
-// operation and to update the in-memory index. It is not responsible for mutex
-// protection, nor re-saving the index afterwards. These are the responsibility
-// of the caller.  The function contains an optimisation as allowed by the
-// interface, whereby it does not neccesarily remove all of the messages it is
-// invited to.  The optimisation is to only remove whole message files that are
-// eligible rather than crack any of them open. 
+// messages - those created before MaxAge - from the filestore. Its
+// responsibility to perform the removal operation and to update the
+// in-memory index. It is not responsible for mutex protection, nor re-saving
+// the index afterwards. These are the responsibility of the caller. Message
+// files whose newest message has expired are removed whole, without being
+// opened, since the index records when each message was created. Only the
+// file of each topic that straddles MaxAge is rewritten, without the messages
+// that precede the first that has not expired. It provides the names of the
+// files removed, and the numbers of the messages removed from each topic, in
+// ascending order, for those topics that lost any.
 func (action RemoveOldMessagesAction) RemoveOldMessages() (
-	filesRemoved []string, nMessagesRemoved int, err error) {
+	filesRemoved []string, removed map[string][]int, err error) {
 	filesRemoved = []string{}
-	nMessagesRemoved = 0
+	removed = map[string][]int{}
 	// Handle the action on a per-topic basis.
 	for topic, msgFileList := range action.Index.MessageFileLists {
-		// Capture the files to delete and how many messages they had
-		// in them.
-		oldFiles := msgFileList.SpentFiles(action.MaxAge)
-		for _, fileName := range oldFiles {
-			nMessages := msgFileList.NumMessagesInFile(fileName)
-			nMessagesRemoved += nMessages
+		oldFiles, topicRemoved, err := action.removeFromTopic(
+			topic, msgFileList)
+		if len(topicRemoved) != 0 {
+			removed[topic] = topicRemoved
 		}
 		filesRemoved = append(filesRemoved, oldFiles...)
-		// Mandate the index to forget about these files.
-		msgFileList.ForgetFiles(oldFiles)
-		// Physically remove the files.
-		for _, fileName := range oldFiles {
-			filePath := filenamer.MessageFilePath(
-				fileName, topic, action.RootDir)
-			err = os.Remove(filePath)
-			if err != nil {
-				return nil, -1, fmt.Errorf("os.Remove(): %v", err)
-			}
+		if err != nil {
+			return filesRemoved, removed, err
 		}
 	}
-	return filesRemoved, nMessagesRemoved, nil
+	return filesRemoved, removed, nil
+}
+
+// removeFromTopic removes the expired messages from one topic, as
+// RemoveOldMessages does, by delegating to a TruncateBeforeAction that cuts
+// the topic at the first message that has not expired.
+func (action RemoveOldMessagesAction) removeFromTopic(topic string,
+	msgFileList *indexing.MessageFileList) (
+	oldFiles []string, removed []int, err error) {
+	keepFrom, ok := msgFileList.FirstMessageNumberSince(action.MaxAge)
+	if ok == false {
+		// Every message has expired.
+		keepFrom = action.Index.NextMessageNumbers[topic]
+	}
+	namesBefore := append([]string{}, msgFileList.Names...)
+	truncateAction := TruncateBeforeAction{Topic: topic,
+		MessageNumber: int(keepFrom), Index: action.Index,
+		RootDir: action.RootDir, Namer: action.Namer}
+	removed, err = truncateAction.TruncateBefore()
+
+	// The files removed are those the index no longer lists.
+	kept := map[string]bool{}
+	for _, fileName := range msgFileList.Names {
+		kept[fileName] = true
+	}
+	for _, fileName := range namesBefore {
+		if kept[fileName] == false {
+			oldFiles = append(oldFiles, fileName)
+		}
+	}
+	if err != nil {
+		return oldFiles, removed, fmt.Errorf(
+			"truncateAction.TruncateBefore(): %w", err)
+	}
+	return oldFiles, removed, nil
 }
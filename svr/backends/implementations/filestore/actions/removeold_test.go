@@ -2,11 +2,14 @@ package actions
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
 	"time"
 
+	minikafka "github.com/peterhoward42/minikafka"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
 
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
 
@@ -57,7 +60,8 @@ func TestRemoveOld(t *testing.T) {
 	}
 	// Set maxAge to target the first two files for deletion.
 	maxAge := newestInFile2.Add(time.Duration(10 * time.Millisecond))
-	removeAction := RemoveOldMessagesAction{maxAge, index, rootDir}
+	removeAction := RemoveOldMessagesAction{MaxAge: maxAge, Index: index,
+		RootDir: rootDir}
 	filesRemoved, _, err := removeAction.RemoveOldMessages()
 	if err != nil {
 		msg := fmt.Sprintf("removeAction.RemoveOldMessages(): %v", err)
@@ -77,3 +81,181 @@ func TestRemoveOld(t *testing.T) {
 	expected = 3
 	assert.Equal(t, expected, nFilesRemaining)
 }
+
+// TestRemoveOldRewritesBoundaryFile makes sure that RemoveOldMessagesAction
+// removes the expired messages from the file that straddles MaxAge, as well
+// as the files that have expired entirely, and reports the numbers of all of
+// them.
+func TestRemoveOldRewritesBoundaryFile(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	// Store 10 messages, a minute apart, three to a file.
+	index := indexing.NewIndex()
+	const topic string = "sometopic"
+	storeAction := StoreAction{
+		Topic:       topic,
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 3 * encodedSizeOf([]byte("message_1")),
+	}
+	start := time.Now().Add(-time.Hour)
+	for i := 1; i <= 10; i++ {
+		storeAction.Message = []byte(fmt.Sprintf("message_%d", i))
+		storeAction.Created = start.Add(time.Duration(i) * time.Minute)
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.FailNow(t, msg)
+		}
+	}
+	msgFileList := index.GetMessageFileListFor(topic)
+	assert.Equal(t, 4, len(msgFileList.Names))
+	boundaryFile := msgFileList.Names[1]
+
+	// Expire messages 1 to 4, which leaves message 5 as the oldest in the
+	// second file.
+	removeAction := RemoveOldMessagesAction{
+		MaxAge: start.Add(5 * time.Minute), Index: index, RootDir: rootDir}
+	filesRemoved, removed, err := removeAction.RemoveOldMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(filesRemoved))
+	assert.Equal(t, map[string][]int{topic: {1, 2, 3, 4}}, removed)
+
+	assert.Equal(t, 3, len(msgFileList.Names))
+	assert.Equal(t, boundaryFile, msgFileList.Names[0])
+	fileMeta := msgFileList.Meta[boundaryFile]
+	assert.Equal(t, int32(5), fileMeta.Oldest.MsgNum)
+	filePath := messageFilePath(nil, boundaryFile, topic, rootDir)
+	contents, err := ioutil.ReadFile(filePath)
+	assert.Nil(t, err)
+	assert.Equal(t, fileMeta.Size, int64(len(contents)))
+
+	pollAction := PollAction{
+		Topic: topic, ReadFrom: 1, Index: index, RootDir: rootDir}
+	messages, _, err := pollAction.Poll()
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(messages))
+	assert.Equal(t, minikafka.Message("message_5"), messages[0])
+
+	// Removing again finds nothing more to remove.
+	filesRemoved, removed, err = removeAction.RemoveOldMessages()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(filesRemoved))
+	assert.Equal(t, 0, len(removed))
+}
+
+// BenchmarkRemoveOldMessagesAction compares RemoveOldMessagesAction, which
+// removes the files that have expired entirely without opening them, with a
+// naive removal that decodes every message, on a topic with many such files.
+func BenchmarkRemoveOldMessagesAction(b *testing.B) {
+	cases := []struct {
+		name   string
+		remove func(index *indexing.Index, rootDir string,
+			maxAge time.Time) error
+	}{
+		{"SkipExpiredFiles", func(index *indexing.Index, rootDir string,
+			maxAge time.Time) error {
+			removeAction := RemoveOldMessagesAction{
+				MaxAge: maxAge, Index: index, RootDir: rootDir}
+			_, _, err := removeAction.RemoveOldMessages()
+			return err
+		}},
+		{"NaiveFullScan", naiveRemoveOld},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				index, rootDir, maxAge := prepareExpiredTopic(b)
+				b.StartTimer()
+				err := c.remove(index, rootDir, maxAge)
+				if err != nil {
+					b.Fatalf("remove(): %v", err)
+				}
+				b.StopTimer()
+				os.RemoveAll(rootDir)
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// prepareExpiredTopic stores a topic of 50 message files, of 100 messages
+// each, and provides an expiry time that falls part way through the last of
+// them.
+func prepareExpiredTopic(b *testing.B) (
+	index *indexing.Index, rootDir string, maxAge time.Time) {
+	rootDir, err := os.MkdirTemp("", "filestore")
+	if err != nil {
+		b.Fatalf("os.MkdirTemp(): %v", err)
+	}
+	index = indexing.NewIndex()
+	message := minikafka.Message("benchmark message")
+	storeAction := StoreAction{
+		Topic:       "topic",
+		Message:     message,
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 100 * encodedSizeOf(message),
+	}
+	start := time.Now().Add(-time.Hour)
+	for i := 1; i <= 5000; i++ {
+		storeAction.Created = start.Add(time.Duration(i) * time.Millisecond)
+		_, _, err := storeAction.Store()
+		if err != nil {
+			b.Fatalf("storeAction.Store(): %v", err)
+		}
+	}
+	return index, rootDir, start.Add(4950 * time.Millisecond)
+}
+
+// naiveRemoveOld removes the messages created before maxAge by decoding every
+// message file, and rewriting it without them - or removing it, when it holds
+// nothing else.
+func naiveRemoveOld(index *indexing.Index, rootDir string,
+	maxAge time.Time) error {
+	for topic, msgFileList := range index.MessageFileLists {
+		emptied := []string{}
+		for _, fileName := range msgFileList.Names {
+			filePath := messageFilePath(nil, fileName, topic, rootDir)
+			contents, err := ioutil.ReadFile(filePath)
+			if err != nil {
+				return fmt.Errorf("ioutil.ReadFile(): %v", err)
+			}
+			found, seekOffsets, _, _, err := records.DecodeSequence(
+				contents, records.DefaultSerializer, nil)
+			if err != nil {
+				return fmt.Errorf("records.DecodeSequence(): %v", err)
+			}
+			keptNumbers := []int32{}
+			keptOffsets := []int64{}
+			for i, storedMsg := range found {
+				if storedMsg.Created.Before(maxAge) == false {
+					keptNumbers = append(keptNumbers, storedMsg.MsgNum)
+					keptOffsets = append(keptOffsets, seekOffsets[i])
+				}
+			}
+			if len(keptNumbers) == 0 {
+				emptied = append(emptied, fileName)
+				err = os.Remove(filePath)
+				if err != nil {
+					return fmt.Errorf("os.Remove(): %v", err)
+				}
+				continue
+			}
+			if len(keptNumbers) == len(found) {
+				continue
+			}
+			newOffsets, newLength, err := rewriteKeeping(
+				filePath, contents, keptOffsets)
+			if err != nil {
+				return fmt.Errorf("rewriteKeeping(): %v", err)
+			}
+			msgFileList.Meta[fileName].KeepOnly(
+				keptNumbers, newOffsets, newLength)
+		}
+		msgFileList.ForgetFiles(emptied)
+	}
+	return nil
+}
@@ -5,14 +5,27 @@ package actions
 import (
 	"fmt"
 	"os"
+	"time"
 
 	minikafka "github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
 )
 
-const maximumFileSize = 1048576 // 1 MiB
+// DefaultMaximumFileSize is the size beyond which a message file will not be
+// allowed to grow, unless a StoreAction specifies otherwise.
+const DefaultMaximumFileSize = 1048576 // 1 MiB
+
+// DefaultDirPerm and DefaultFilePerm are the permissions (before the umask)
+// with which topic directories and message files are created, unless a
+// StoreAction specifies otherwise.
+const (
+	DefaultDirPerm  os.FileMode = 0755
+	DefaultFilePerm os.FileMode = 0644
+)
 
 // StoreAction encapsulates a single execution of the store (message) command.
 type StoreAction struct {
@@ -20,20 +33,142 @@ type StoreAction struct {
 	Message minikafka.Message
 	Index   *indexing.Index
 	RootDir string
+	// The size at which message files are rolled over. Zero means use
+	// DefaultMaximumFileSize.
+	MaxFileSize int64
+	// The age at which message files are rolled over, regardless of their
+	// size. Zero means they are not rolled over by age.
+	MaxFileAge time.Duration
+	// The size of the largest message (framed record) to accept. Zero means
+	// the maximum file size is the only limit.
+	MaxMessageSize int64
+	// The size beyond which a message (framed record) is split into chunk
+	// records, each holding at most this much of it (see records.Chunk).
+	// A chunked message is written whole to one message file, which it can
+	// make larger than the maximum file size. Zero means messages are not
+	// chunked, so those larger than the maximum file size are refused.
+	ChunkSize int64
+	// The most messages, and bytes (of message files), the topic is allowed
+	// to hold, beyond which the message is refused. Zero means no limit.
+	MaxTopicMessages int
+	MaxTopicBytes    int64
+	// An optional key to store with the message.
+	Key []byte
+	// Optional headers to store with the message.
+	Headers map[string]string
+	// The creation time to give the message. Zero means now.
+	Created time.Time
+	// How many message numbers to leave unallocated before the message's -
+	// so as to reproduce the gaps in a topic copied from elsewhere.
+	SkipMessageNumbers int
+	// Whether to commit the message file to stable storage after appending
+	// the message to it.
+	SyncOnWrite bool
+	// How to encode the message record. Nil means use
+	// records.DefaultSerializer.
+	Serializer records.Serializer
+	// Whether to compress the message record. A message file holds either
+	// compressed records, or uncompressed ones, so a new file is started
+	// when this differs from the current file.
+	Compress bool
+	// The key with which to encrypt the message record. Nil means it is
+	// not encrypted.
+	EncryptionKey *records.Key
+	// The permissions with which to create the topic directory and message
+	// files. Zero means use DefaultDirPerm and DefaultFilePerm.
+	DirPerm  os.FileMode
+	FilePerm os.FileMode
+	// Optional cache of open message files to append to, keyed on topic.
+	// Nil means open and close the message file for each message.
+	Handles *ioutils.AppendHandles
+	// The naming scheme of the store's files. Nil means use
+	// filenamer.DefaultNamer.
+	Namer filenamer.FileNamer
+	// Provides the time now, by which the message is given its creation
+	// time (unless Created says otherwise), and message files are started
+	// and rolled over by age. Nil means use time.Now.
+	Now func() time.Time
+}
+
+// StagedMessage is a message that a StoreAction has prepared for storage,
+// by encoding it and allocating it to a message file, but which has not yet
+// been written to that file.
+type StagedMessage struct {
+	MsgFileName string
+	msgToStore  records.StoredMessage
+	encoded     []byte
+}
+
+// Created provides the creation time given to the staged message.
+func (staged StagedMessage) Created() time.Time {
+	return staged.msgToStore.Created
 }
 
 // Store is the internal entry point function to store a new message in the
 // filestore. Its responsibility to perform the storage operation and to update
 // the in-memory index. It is not responsible for mutex protection, nor re-saving
 // the index afterwards. These are the responsibility of the caller.
+//
+// Store is the combination of the Prepare, Append and Register phases, which
+// can instead be called individually, so that the caller need not hold its
+// index lock while the message file is written.
 func (action StoreAction) Store() (
 	messageNumber int, msgFileUsed string, err error) {
+	staged, err := action.Prepare()
+	if err != nil {
+		return -1, "", err
+	}
+	err = action.Append(staged)
+	if err != nil {
+		return -1, "", fmt.Errorf("Append(): %v", err)
+	}
+	messageNumber = action.Register(staged)
+	return messageNumber, staged.MsgFileName, nil
+}
 
-	// Special case when the store has never stored a message for this
-	// this topic before.
-	err = action.createTopicDirIfNotExists()
+// Prepare is the first phase of Store. It encodes the message and
+// establishes which message file it should go in, starting a new file if
+// necessary. The index is only changed when a new file is started. Nothing
+// else must store to the same topic until the Register phase is complete.
+func (action StoreAction) Prepare() (staged StagedMessage, err error) {
+
+	// Make the representation of the message that will go in the file -
+	// which is framed, so that its integrity can be checked when read.
+	msgToStore := action.makeMsgToStore()
+	serializer := serializerOrDefault(action.Serializer)
+	if action.Compress {
+		serializer = records.Compressed(serializer)
+	}
+	encoded, err := serializer.Encode(msgToStore)
 	if err != nil {
-		return -1, "", fmt.Errorf("createTopicDirIfNotExists(): %v", err)
+		return StagedMessage{}, fmt.Errorf("Encode(): %v", err)
+	}
+	if action.EncryptionKey != nil {
+		encoded, err = records.FrameEncrypted(encoded, action.EncryptionKey)
+		if err != nil {
+			return StagedMessage{}, fmt.Errorf(
+				"records.FrameEncrypted(): %v", err)
+		}
+	} else {
+		encoded = records.Frame(encoded)
+	}
+	chunked := action.ChunkSize > 0 && int64(len(encoded)) > action.ChunkSize
+	if chunked {
+		encoded = records.Chunk(encoded, msgToStore.MsgNum, action.ChunkSize)
+	}
+
+	// Refuse a message that is too large, or could never fit in a message
+	// file - before anything is written. (When it is compressed, encrypted,
+	// or chunked, it is the size it then has that counts, as it is for the
+	// rolling over of files.)
+	msgSize := int64(len(encoded))
+	err = action.checkMessageSize(msgSize, chunked)
+	if err != nil {
+		return StagedMessage{}, err
+	}
+	err = action.checkQuota(msgSize)
+	if err != nil {
+		return StagedMessage{}, err
 	}
 
 	// Establish which storage file to use - including the case for needing to
@@ -44,50 +179,243 @@ func (action StoreAction) Store() (
 	if msgFileName == "" {
 		needNewFile = true
 	} else {
-		needNewFile = action.fileHasInsufficentRoom(msgFileName)
+		needNewFile = action.fileHasInsufficentRoom(msgFileName, msgSize) ||
+			action.fileCompressionDiffers(msgFileName) ||
+			action.fileIsTooOld(msgFileName)
 	}
 	if needNewFile {
+		// The topic's directory is only looked for when a file is to be
+		// started in it, since the current file is known to be there - so
+		// that appending to that costs no more than the write.
+		err = action.createTopicDirIfNotExists()
+		if err != nil {
+			return StagedMessage{}, fmt.Errorf(
+				"createTopicDirIfNotExists(): %v", err)
+		}
 		msgFileName, err = action.setupNewFileForTopic()
 		if err != nil {
-			return -1, "", fmt.Errorf("setupNewFileForTopic(): %v", err)
+			return StagedMessage{}, fmt.Errorf(
+				"setupNewFileForTopic(): %v", err)
 		}
 	}
-	// Append the message bytes to the storage file, and mandate the
-	// index to update itself with this new info.
-	messageNumber, err = action.saveAndRegisterMessage(msgFileName)
+	return StagedMessage{msgFileName, msgToStore, encoded}, nil
+}
+
+// Append is the second phase of Store. It appends the staged message to its
+// message file. It neither reads nor changes the index.
+func (action StoreAction) Append(staged StagedMessage) error {
+	filepath := messageFilePath(action.Namer,
+		staged.MsgFileName, action.Topic, action.RootDir)
+	var err error
+	if action.Handles != nil {
+		err = action.Handles.Append(
+			action.Topic, filepath, staged.encoded, action.SyncOnWrite)
+	} else if action.SyncOnWrite {
+		err = ioutils.AppendToFileAndSync(filepath, staged.encoded)
+	} else {
+		err = ioutils.AppendToFile(filepath, staged.encoded)
+	}
 	if err != nil {
-		return -1, "", fmt.Errorf("saveAndRegisterMessage(): %v", err)
+		return fmt.Errorf("ioutils.AppendToFile(): %v", err)
 	}
-	return messageNumber, msgFileName, nil
+	return nil
+}
+
+// Register is the final phase of Store. It updates the index with the
+// message that has been appended, and provides the message's number.
+func (action StoreAction) Register(staged StagedMessage) (messageNumber int) {
+	action.Index.NextMessageNumbers[action.Topic] +=
+		int32(action.SkipMessageNumbers)
+	msgNumber := action.Index.GetAndIncrementMessageNumberFor(action.Topic)
+	msgFileList := action.Index.GetMessageFileListFor(action.Topic)
+	fileMeta := msgFileList.Meta[staged.MsgFileName]
+	fileMeta.RegisterNewMessage(
+		msgNumber, int64(len(staged.encoded)), staged.msgToStore.Created)
+	return int(msgNumber)
 }
 
 // createTopicDirIfNotExists looks to see if a directory already exists
 // for the given topic, and when not so, it creates one. It seeks the help of
 // the filenamer module about file-naming rules.
 func (action *StoreAction) createTopicDirIfNotExists() error {
-	dirPath := filenamer.DirectoryForTopic(action.Topic, action.RootDir)
-	err := ioutils.CreateDirIfDoesntExist(dirPath)
+	dirPath := namerOrDefault(action.Namer).DirectoryForTopic(
+		action.Topic, action.RootDir)
+	err := ioutils.CreateDirIfDoesntExist(
+		dirPath, permOrDefault(action.DirPerm, DefaultDirPerm))
 	if err != nil {
 		return fmt.Errorf("os.Mkdir(): %v", err)
 	}
 	return nil
 }
 
-func (action *StoreAction) fileHasInsufficentRoom(msgFileName string) bool {
+// now provides the time now, taking into account the default.
+func (action *StoreAction) now() time.Time {
+	if action.Now == nil {
+		return time.Now()
+	}
+	return action.Now()
+}
+
+// maxFileSize provides the size at which message files should be rolled over,
+// taking into account the default.
+func (action *StoreAction) maxFileSize() int64 {
+	if action.MaxFileSize == 0 {
+		return DefaultMaximumFileSize
+	}
+	return action.MaxFileSize
+}
+
+// checkMessageSize makes sure that a message (framed record) of the given
+// size, which has been chunked, or not, can be accepted, and when not,
+// returns an error that wraps contract.ErrMessageTooLarge.
+func (action *StoreAction) checkMessageSize(msgSize int64,
+	chunked bool) error {
+	if action.MaxMessageSize > 0 && msgSize > action.MaxMessageSize {
+		return fmt.Errorf(
+			"%w: message size (%d) exceeds the maximum message size (%d)",
+			contract.ErrMessageTooLarge, msgSize, action.MaxMessageSize)
+	}
+	if msgSize > action.maxFileSize() && chunked == false {
+		return fmt.Errorf(
+			"%w: message size (%d) exceeds the maximum file size (%d)",
+			contract.ErrMessageTooLarge, msgSize, action.maxFileSize())
+	}
+	if msgSize > records.MaxFramedSize {
+		return fmt.Errorf(
+			"%w: message size (%d) exceeds the largest record (%d)",
+			contract.ErrMessageTooLarge, msgSize, records.MaxFramedSize)
+	}
+	return nil
+}
+
+// checkQuota makes sure that the topic has room for a message (framed
+// record) of the given size within its quota, and when not, returns an error
+// that wraps contract.ErrQuotaExceeded.
+func (action *StoreAction) checkQuota(msgSize int64) error {
+	if action.MaxTopicMessages == 0 && action.MaxTopicBytes == 0 {
+		return nil
+	}
+	nMessages, nBytes := 0, int64(0)
+	msgFileList, ok := action.Index.MessageFileLists[action.Topic]
+	if ok {
+		nMessages, nBytes = msgFileList.NumMessages(), msgFileList.TotalSize()
+	}
+	if action.MaxTopicMessages > 0 && nMessages >= action.MaxTopicMessages {
+		return fmt.Errorf(
+			"%w: topic %v holds %d message(s), which is its limit",
+			contract.ErrQuotaExceeded, action.Topic, action.MaxTopicMessages)
+	}
+	if action.MaxTopicBytes > 0 && nBytes+msgSize > action.MaxTopicBytes {
+		return fmt.Errorf(
+			"%w: topic %v holds %d bytes, which leaves no room for %d more "+
+				"within its limit (%d)", contract.ErrQuotaExceeded,
+			action.Topic, nBytes, msgSize, action.MaxTopicBytes)
+	}
+	return nil
+}
+
+// serializerForFile provides the Serializer with which to decode the records
+// in a message file - being the given one, or the default when it is nil, and
+// wrapped to decompress the records when the file's are compressed.
+func serializerForFile(serializer records.Serializer,
+	fileMeta *indexing.FileMeta) records.Serializer {
+	serializer = serializerOrDefault(serializer)
+	if fileMeta.Compressed {
+		return records.Compressed(serializer)
+	}
+	return serializer
+}
+
+// permOrDefault provides the given permissions, or the default ones when they
+// are zero.
+func permOrDefault(perm os.FileMode, defaultPerm os.FileMode) os.FileMode {
+	if perm == 0 {
+		return defaultPerm
+	}
+	return perm
+}
+
+// serializerOrDefault provides the given serializer, or the default one when
+// it is nil.
+func serializerOrDefault(serializer records.Serializer) records.Serializer {
+	if serializer == nil {
+		return records.DefaultSerializer
+	}
+	return serializer
+}
+
+// namerOrDefault provides the given FileNamer, or the default one when it is
+// nil.
+func namerOrDefault(namer filenamer.FileNamer) filenamer.FileNamer {
+	if namer == nil {
+		return filenamer.DefaultNamer{}
+	}
+	return namer
+}
+
+// messageFilePath provides the full path of the topic's message file with the
+// given base name, according to the given FileNamer, or the default one when
+// it is nil.
+func messageFilePath(namer filenamer.FileNamer, msgFileName, topic,
+	rootDir string) string {
+	return filenamer.MessageFilePathFor(
+		namerOrDefault(namer), msgFileName, topic, rootDir)
+}
+
+// makeMsgToStore wraps the action's message into the representation that
+// gets written to the message file - including the message number it will be
+// allocated and its creation time.
+func (action *StoreAction) makeMsgToStore() records.StoredMessage {
+	msgNumber := action.Index.NextMessageNumberFor(action.Topic) +
+		int32(action.SkipMessageNumbers)
+	created := action.Created
+	if created.IsZero() {
+		created = action.now()
+	}
+	return records.StoredMessage{
+		MsgNum:  msgNumber,
+		Created: created,
+		Key:     action.Key,
+		Headers: action.Headers,
+		Message: action.Message,
+	}
+}
+
+func (action *StoreAction) fileHasInsufficentRoom(
+	msgFileName string, msgSize int64) bool {
+	msgFileList := action.Index.MessageFileLists[action.Topic]
+	return msgFileList.Meta[msgFileName].Size+msgSize > action.maxFileSize()
+}
+
+// fileCompressionDiffers evaluates whether the given message file's records
+// are compressed, or not, contrary to what the action requires.
+func (action *StoreAction) fileCompressionDiffers(msgFileName string) bool {
+	msgFileList := action.Index.MessageFileLists[action.Topic]
+	return msgFileList.Meta[msgFileName].Compressed != action.Compress
+}
+
+// fileIsTooOld evaluates whether the given message file was started longer
+// ago than the action's maximum file age.
+func (action *StoreAction) fileIsTooOld(msgFileName string) bool {
+	if action.MaxFileAge == 0 {
+		return false
+	}
 	msgFileList := action.Index.MessageFileLists[action.Topic]
-	msgSize := int64(len(action.Message))
-	return msgFileList.Meta[msgFileName].Size+msgSize > maximumFileSize
+	opened := msgFileList.Meta[msgFileName].Opened
+	return action.now().Sub(opened) >= action.MaxFileAge
 }
 
 // setupNewFileForTopic works out what the new file should be called, creates it,
 // and then registers this new information with the index.
 func (action *StoreAction) setupNewFileForTopic() (msgFileName string, err error) {
-	fileName := filenamer.NewMsgFilenameFor(action.Topic, action.Index)
-	filePath := filenamer.MessageFilePath(
+	fileName := namerOrDefault(action.Namer).NewMsgFilenameFor(
+		action.Topic, action.Index)
+	filePath := messageFilePath(action.Namer,
 		fileName, action.Topic, action.RootDir)
-	file, err := os.Create(filePath)
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC,
+		permOrDefault(action.FilePerm, DefaultFilePerm))
 	if err != nil {
-		return "", fmt.Errorf("os.Create(): %v", err)
+		return "", fmt.Errorf("os.OpenFile(): %v", err)
 	}
 	err = file.Close()
 	if err != nil {
@@ -95,22 +423,7 @@ func (action *StoreAction) setupNewFileForTopic() (msgFileName string, err error
 	}
 	msgFileList := action.Index.GetMessageFileListFor(action.Topic)
 	msgFileList.RegisterNewFile(fileName)
+	msgFileList.Meta[fileName].Opened = action.now()
+	msgFileList.Meta[fileName].Compressed = action.Compress
 	return fileName, nil
 }
-
-// saveAndRegisteMessage appends the message to the specified file and updates
-// the index with this new info.
-func (action *StoreAction) saveAndRegisterMessage(
-	msgFileName string) (msgNumber int, err error) {
-	filepath := filenamer.MessageFilePath(
-		msgFileName, action.Topic, action.RootDir)
-	err = ioutils.AppendToFile(filepath, action.Message)
-	if err != nil {
-		return 0, fmt.Errorf("ioutils.AppendToFile(): %v", err)
-	}
-	msgNumber = int(action.Index.GetAndIncrementMessageNumberFor(action.Topic))
-	msgFileList := action.Index.GetMessageFileListFor(action.Topic)
-	fileMeta := msgFileList.Meta[msgFileName]
-	fileMeta.RegisterNewMessage(int32(msgNumber), int64(len(action.Message)))
-	return msgNumber, nil
-}
@@ -1,16 +1,21 @@
 package actions
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
 )
 
 // Operate the StoreAction in a context where it is obliged to make a new
@@ -108,7 +113,7 @@ func TestTwoLargeMessagesGetPutInDifferentFiles(t *testing.T) {
 	index := indexing.NewIndex()
 
 	// Create a store-action with a large payload that we can use twice.
-	largeMsg := make([]byte, 0.75*maximumFileSize)
+	largeMsg := make([]byte, 0.75*DefaultMaximumFileSize)
 	storeAction := StoreAction{
 		Topic:   "neverheardof",
 		Message: largeMsg,
@@ -165,7 +170,7 @@ func TestIndexIsUpdated(t *testing.T) {
 
 	// Check the index has tracked the sizes of the message files
 	// as they've grown.
-	const msgSize int64 = 12
+	msgSize := encodedSizeOf(msg)
 	assert.Equal(t, 2*msgSize, msgFileList.Meta[msgFileUsed].Size)
 
 	// Check has tracked Oldest and Newest message numbers.
@@ -189,3 +194,291 @@ func TestIndexIsUpdated(t *testing.T) {
 	expected = msgSize
 	assert.Equal(t, expected, seek)
 }
+
+// Operate the StoreAction with a small configured maximum file size, and make
+// sure that a new storage file is started exactly when the next message would
+// take the current one beyond the limit.
+func TestConfiguredMaxFileSizeIsRespected(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+
+	// Size the files so that exactly 3 messages fit in each.
+	msg := minikafka.Message("0123456789")
+	storeAction := StoreAction{
+		Topic:       "neverheardof",
+		Message:     msg,
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 3 * encodedSizeOf(msg),
+	}
+	msgFilesUsed := make([]string, 7)
+	var err error
+	for i := 0; i < 7; i++ {
+		_, msgFilesUsed[i], err = storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.Fail(t, msg)
+		}
+	}
+	assert.Equal(t, msgFilesUsed[0], msgFilesUsed[1])
+	assert.Equal(t, msgFilesUsed[0], msgFilesUsed[2])
+	assert.NotEqual(t, msgFilesUsed[2], msgFilesUsed[3])
+	assert.Equal(t, msgFilesUsed[3], msgFilesUsed[5])
+	assert.NotEqual(t, msgFilesUsed[5], msgFilesUsed[6])
+
+	msgFileList := index.MessageFileLists["neverheardof"]
+	assert.Equal(t, 3*encodedSizeOf(msg), msgFileList.Meta[msgFilesUsed[0]].Size)
+}
+
+// Make sure that a message which is larger than the configured maximum file
+// size is refused, and that nothing is stored.
+func TestMessageLargerThanMaxFileSizeIsRefused(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+
+	msg := minikafka.Message("0123456789")
+	msgSize := encodedSizeOf(msg)
+	storeAction := StoreAction{
+		Topic:       "neverheardof",
+		Message:     msg,
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: msgSize - 1,
+	}
+	_, _, err := storeAction.Store()
+	assert.True(t, errors.Is(err, contract.ErrMessageTooLarge))
+	assert.EqualError(t, err, fmt.Sprintf(
+		"message too large: message size (%d) exceeds the maximum file "+
+			"size (%d)", msgSize, msgSize-1))
+	assert.Equal(t, "", index.CurrentMsgFileNameFor("neverheardof"))
+}
+
+// Make sure that a message which is larger than the configured maximum file
+// size is accepted when it is chunked, that its chunks are written together
+// to a message file of their own, and that it is read back whole.
+func TestChunkedMessageCanExceedMaxFileSize(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+
+	small := minikafka.Message("0123456789")
+	large := minikafka.Message(strings.Repeat("0123456789", 100))
+	storeAction := StoreAction{
+		Topic:       "neverheardof",
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 3 * encodedSizeOf(small),
+		ChunkSize:   encodedSizeOf(small),
+	}
+	msgFilesUsed := []string{}
+	for _, msg := range []minikafka.Message{small, large, small} {
+		storeAction.Message = msg
+		_, msgFileUsed, err := storeAction.Store()
+		assert.Nil(t, err)
+		msgFilesUsed = append(msgFilesUsed, msgFileUsed)
+	}
+	assert.NotEqual(t, msgFilesUsed[0], msgFilesUsed[1])
+	assert.NotEqual(t, msgFilesUsed[1], msgFilesUsed[2])
+	msgFileList := index.MessageFileLists["neverheardof"]
+	assert.True(t, msgFileList.Meta[msgFilesUsed[1]].Size >
+		encodedSizeOf(large))
+
+	pollAction := PollAction{
+		Topic: "neverheardof", ReadFrom: 1, Index: index, RootDir: rootDir}
+	messages, _, err := pollAction.Poll()
+	assert.Nil(t, err)
+	assert.Equal(t, []minikafka.Message{small, large, small}, messages)
+}
+
+// Make sure that a message which is just larger than the configured maximum
+// message size is refused, without anything being written, and that one just
+// within it is accepted.
+func TestMaxMessageSizeIsRespected(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+
+	msg := minikafka.Message("0123456789")
+	msgSize := encodedSizeOf(msg)
+	storeAction := StoreAction{
+		Topic:          "neverheardof",
+		Message:        msg,
+		Index:          index,
+		RootDir:        rootDir,
+		MaxMessageSize: msgSize - 1,
+	}
+	_, _, err := storeAction.Store()
+	assert.True(t, errors.Is(err, contract.ErrMessageTooLarge))
+	assert.EqualError(t, err, fmt.Sprintf(
+		"message too large: message size (%d) exceeds the maximum message "+
+			"size (%d)", msgSize, msgSize-1))
+	assert.False(t, ioutils.Exists(
+		filenamer.DirectoryForTopic("neverheardof", rootDir)))
+
+	storeAction.MaxMessageSize = msgSize
+	_, _, err = storeAction.Store()
+	assert.Nil(t, err)
+}
+
+// Make sure that a key given to the StoreAction is stored alongside the
+// message, and that it is recovered by a subsequent poll.
+func TestKeyIsStoredWithMessage(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+
+	topic := "justforthistest"
+	keyed := StoreAction{
+		Topic:   topic,
+		Message: minikafka.Message("keyed message"),
+		Key:     []byte("some key"),
+		Index:   index,
+		RootDir: rootDir,
+	}
+	unkeyed := keyed
+	unkeyed.Message = minikafka.Message("unkeyed message")
+	unkeyed.Key = nil
+	for _, storeAction := range []StoreAction{keyed, unkeyed} {
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.Fail(t, msg)
+		}
+	}
+
+	pollAction := PollAction{
+		Topic:    topic,
+		ReadFrom: 1,
+		Index:    index,
+		RootDir:  rootDir,
+	}
+	stored, _, err := pollAction.PollRecords()
+	if err != nil {
+		msg := fmt.Sprintf("pollAction.PollRecords(): %v", err)
+		assert.Fail(t, msg)
+	}
+	assert.Equal(t, 2, len(stored))
+	assert.Equal(t, int32(1), stored[0].MsgNum)
+	assert.Equal(t, "some key", string(stored[0].Key))
+	assert.Equal(t, "keyed message", string(stored[0].Message))
+	assert.Equal(t, int32(2), stored[1].MsgNum)
+	assert.Nil(t, stored[1].Key)
+	assert.Equal(t, "unkeyed message", string(stored[1].Message))
+}
+
+// Operate the StoreAction with a cache of open message files, and a small
+// maximum file size, and make sure that each message lands in the file the
+// index says it did, so that it can be polled back - despite the files
+// rolling over. Only one file should need opening per roll.
+func TestCachedHandlesFollowFileRoll(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+	var handles ioutils.AppendHandles
+	defer handles.CloseAll()
+
+	// Size the files so that exactly 3 messages fit in each.
+	topic := "justforthistest"
+	msg := minikafka.Message("0123456789")
+	storeAction := StoreAction{
+		Topic:       topic,
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 3 * encodedSizeOf(msg),
+		Handles:     &handles,
+	}
+	for i := 0; i < 7; i++ {
+		storeAction.Message = minikafka.Message(fmt.Sprintf("012345678%d", i))
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.FailNow(t, msg)
+		}
+	}
+	assert.Equal(t, 3, handles.Opens())
+
+	msgFileList := index.MessageFileLists[topic]
+	assert.Equal(t, 3, len(msgFileList.Names))
+	for _, name := range msgFileList.Names {
+		info, err := os.Stat(filenamer.MessageFilePath(name, topic, rootDir))
+		assert.Nil(t, err)
+		assert.Equal(t, msgFileList.Meta[name].Size, info.Size())
+	}
+
+	pollAction := PollAction{
+		Topic: topic, ReadFrom: 1, Index: index, RootDir: rootDir}
+	messages, _, err := pollAction.Poll()
+	assert.Nil(t, err)
+	assert.Equal(t, 7, len(messages))
+	for i, message := range messages {
+		assert.Equal(t, fmt.Sprintf("012345678%d", i), string(message))
+	}
+}
+
+// BenchmarkStoreAction stores 1000 messages by way of the StoreAction,
+// opening the message file for each of them, and alternatively using a cache
+// of open message files - which it reports the number of opens per message
+// for.
+func BenchmarkStoreAction(b *testing.B) {
+	cases := []struct {
+		name   string
+		cached bool
+	}{
+		{"OpenPerMessage", false},
+		{"CachedHandles", true},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			rootDir, err := os.MkdirTemp("", "filestore")
+			if err != nil {
+				b.Fatalf("os.MkdirTemp(): %v", err)
+			}
+			defer os.RemoveAll(rootDir)
+			var handles *ioutils.AppendHandles
+			if c.cached {
+				handles = &ioutils.AppendHandles{}
+				defer handles.CloseAll()
+			}
+			storeAction := StoreAction{
+				Topic:   "topic",
+				Message: minikafka.Message("benchmark message"),
+				Index:   indexing.NewIndex(),
+				RootDir: rootDir,
+				Handles: handles,
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < 1000; j++ {
+					_, _, err := storeAction.Store()
+					if err != nil {
+						b.Fatalf("storeAction.Store(): %v", err)
+					}
+				}
+			}
+			if handles != nil {
+				b.ReportMetric(
+					float64(handles.Opens())/float64(b.N*1000), "opens/msg")
+			}
+		})
+	}
+}
+
+// encodedSizeOf provides the number of bytes the given (unkeyed) message
+// occupies in a message file, on the assumption that its message number is
+// small.
+func encodedSizeOf(msg minikafka.Message) int64 {
+	encoded, _ := records.DefaultSerializer.Encode(records.StoredMessage{
+		MsgNum:  1,
+		Created: time.Now(),
+		Message: msg,
+	})
+	return int64(len(records.Frame(encoded)))
+}
@@ -0,0 +1,66 @@
+// Package actions is where the private implementation code lives for each
+// of the main BackingStore actions. I.e. store/removeold/poll etc.
+package actions
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+)
+
+// TrimToCountAction encapsulates a single execution of the trim-to-count
+// command for one topic.
+type TrimToCountAction struct {
+	Topic       string
+	MaxMessages int
+	Index       *indexing.Index
+	RootDir     string
+	// The naming scheme of the store's files. Nil means use
+	// filenamer.DefaultNamer.
+	Namer filenamer.FileNamer
+}
+
+// TrimToCount is the internal entry point function to remove the oldest
+// messages from a topic, so that no more than MaxMessages remain. Whole
+// message files are deleted where possible, and the file that straddles the
+// boundary is rewritten without its excess messages. It updates the
+// in-memory index, but is not responsible for mutex protection, nor re-saving
+// the index afterwards. These are the responsibility of the caller.
+func (action TrimToCountAction) TrimToCount() (nMessagesRemoved int, err error) {
+	msgFileList, ok := action.Index.MessageFileLists[action.Topic]
+	if ok == false {
+		return 0, nil
+	}
+	nBefore := msgFileList.NumMessages()
+	if nBefore <= action.MaxMessages {
+		return 0, nil
+	}
+	// The oldest message to keep is found among those registered, rather
+	// than counted back from the newest, since the numbering need not be
+	// contiguous - once the topic has been compacted, or a rebuild of the
+	// index has dropped corrupt records.
+	msgNums := []int{}
+	for _, fileName := range msgFileList.Names {
+		fileMeta := msgFileList.Meta[fileName]
+		for msgNum := range fileMeta.SeekOffsetForMessageNumber {
+			msgNums = append(msgNums, int(msgNum))
+		}
+	}
+	sort.Ints(msgNums)
+	keepFrom := msgNums[len(msgNums)-1] + 1
+	if action.MaxMessages > 0 {
+		keepFrom = msgNums[len(msgNums)-action.MaxMessages]
+	}
+
+	// Remove everything older than the oldest message to keep.
+	truncateAction := TruncateBeforeAction{Topic: action.Topic,
+		MessageNumber: keepFrom, Index: action.Index,
+		RootDir: action.RootDir, Namer: action.Namer}
+	_, err = truncateAction.TruncateBefore()
+	if err != nil {
+		return -1, fmt.Errorf("truncateAction.TruncateBefore(): %w", err)
+	}
+	return nBefore - msgFileList.NumMessages(), nil
+}
@@ -0,0 +1,86 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+)
+
+// Store enough messages to spawn several files, and make sure that trimming
+// to a count that falls part way through a file removes the older files
+// entirely, rewrites the boundary file, and leaves the newest messages
+// pollable.
+func TestTrimToCount(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+
+	topic := "sometopic"
+	storeAction := StoreAction{
+		Topic:       topic,
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 3 * encodedSizeOf([]byte("message_NN")),
+	}
+	for i := 1; i <= 10; i++ {
+		storeAction.Message = minikafka.Message(fmt.Sprintf("message_%02d", i))
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.Fail(t, msg)
+		}
+	}
+	// Files now hold 1-3, 4-6, 7-9 and 10.
+
+	trimAction := TrimToCountAction{
+		Topic: topic, MaxMessages: 5, Index: index, RootDir: rootDir}
+	nRemoved, err := trimAction.TrimToCount()
+	if err != nil {
+		msg := fmt.Sprintf("trimAction.TrimToCount(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	assert.Equal(t, 5, nRemoved)
+
+	msgFileList := index.MessageFileLists[topic]
+	assert.Equal(t, 3, len(msgFileList.Names))
+	nFiles, err := ioutils.CountEntitiesInDir(
+		filenamer.DirectoryForTopic(topic, rootDir))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, nFiles)
+	boundaryFile := msgFileList.Names[0]
+	assert.Equal(t, int32(6), msgFileList.Meta[boundaryFile].Oldest.MsgNum)
+
+	pollAction := PollAction{
+		Topic: topic, ReadFrom: 1, Index: index, RootDir: rootDir}
+	messages, newReadFrom, err := pollAction.Poll()
+	if err != nil {
+		msg := fmt.Sprintf("pollAction.Poll(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	assert.Equal(t, 5, len(messages))
+	assert.Equal(t, "message_06", string(messages[0]))
+	assert.Equal(t, "message_10", string(messages[4]))
+	assert.Equal(t, 11, newReadFrom)
+
+	// Trimming again should be a no-op.
+	nRemoved, err = trimAction.TrimToCount()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, nRemoved)
+}
+
+// Make sure a topic unknown to the index is tolerated.
+func TestTrimToCountWhenTopicIsUnknown(t *testing.T) {
+	trimAction := TrimToCountAction{
+		Topic: "nosuchtopic", MaxMessages: 5, Index: indexing.NewIndex()}
+	nRemoved, err := trimAction.TrimToCount()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, nRemoved)
+}
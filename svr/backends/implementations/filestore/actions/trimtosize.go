@@ -0,0 +1,67 @@
+// Package actions is where the private implementation code lives for each
+// of the main BackingStore actions. I.e. store/removeold/poll etc.
+package actions
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+)
+
+// TrimToSizeAction encapsulates a single execution of the trim-to-size
+// command for one topic.
+type TrimToSizeAction struct {
+	Topic    string
+	MaxBytes int64
+	Index    *indexing.Index
+	RootDir  string
+	// The naming scheme of the store's files. Nil means use
+	// filenamer.DefaultNamer.
+	Namer filenamer.FileNamer
+}
+
+// TrimToSize is the internal entry point function to remove the oldest message
+// files from a topic until the total size of those remaining is within
+// MaxBytes. Only whole files are removed, and the newest file is always kept,
+// even when it alone exceeds the budget, so that the topic is never emptied.
+// It updates the in-memory index, but is not responsible for mutex protection,
+// nor re-saving the index afterwards. These are the responsibility of the
+// caller.
+func (action TrimToSizeAction) TrimToSize() (
+	filesRemoved []string, nMessagesRemoved int, err error) {
+	filesRemoved = []string{}
+	msgFileList, ok := action.Index.MessageFileLists[action.Topic]
+	if ok == false {
+		return filesRemoved, 0, nil
+	}
+
+	// Capture the files to delete, oldest first, and how many messages
+	// they had in them.
+	remainingSize := msgFileList.TotalSize()
+	for _, fileName := range msgFileList.Names {
+		if remainingSize <= action.MaxBytes {
+			break
+		}
+		if fileName == action.Index.CurrentMsgFileNameFor(action.Topic) {
+			break
+		}
+		filesRemoved = append(filesRemoved, fileName)
+		nMessagesRemoved += msgFileList.NumMessagesInFile(fileName)
+		remainingSize -= msgFileList.Meta[fileName].Size
+	}
+
+	// Mandate the index to forget about these files, and then physically
+	// remove them.
+	msgFileList.ForgetFiles(filesRemoved)
+	for _, fileName := range filesRemoved {
+		filePath := messageFilePath(action.Namer,
+			fileName, action.Topic, action.RootDir)
+		err = os.Remove(filePath)
+		if err != nil {
+			return nil, -1, fmt.Errorf("os.Remove(): %v", err)
+		}
+	}
+	return filesRemoved, nMessagesRemoved, nil
+}
@@ -0,0 +1,85 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+)
+
+// Store enough messages to spawn several files, and make sure that trimming
+// to a byte budget removes the oldest files until the remainder fits.
+func TestTrimToSize(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+
+	topic := "sometopic"
+	msgSize := encodedSizeOf([]byte("message_NN"))
+	storeAction := StoreAction{
+		Topic:       topic,
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 3 * msgSize,
+	}
+	for i := 1; i <= 10; i++ {
+		storeAction.Message = minikafka.Message(fmt.Sprintf("message_%02d", i))
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.Fail(t, msg)
+		}
+	}
+	// Files now hold 1-3, 4-6, 7-9 and 10.
+
+	// A budget of 5 messages' worth can only keep the newest 2 files.
+	trimAction := TrimToSizeAction{
+		Topic: topic, MaxBytes: 5 * msgSize, Index: index, RootDir: rootDir}
+	filesRemoved, nMessagesRemoved, err := trimAction.TrimToSize()
+	if err != nil {
+		msg := fmt.Sprintf("trimAction.TrimToSize(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	assert.Equal(t, 2, len(filesRemoved))
+	assert.Equal(t, 6, nMessagesRemoved)
+	oldest, newest := index.MessageFileLists[topic].Bounds()
+	assert.Equal(t, 7, oldest)
+	assert.Equal(t, 10, newest)
+}
+
+// Make sure that the newest file is kept, even when it alone exceeds the
+// budget.
+func TestTrimToSizeKeepsNewestFile(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+
+	topic := "sometopic"
+	storeAction := StoreAction{
+		Topic:       topic,
+		Message:     []byte("0123456789"),
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 3 * encodedSizeOf([]byte("0123456789")),
+	}
+	for i := 0; i < 5; i++ {
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.Fail(t, msg)
+		}
+	}
+	trimAction := TrimToSizeAction{
+		Topic: topic, MaxBytes: 1, Index: index, RootDir: rootDir}
+	_, nMessagesRemoved, err := trimAction.TrimToSize()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, nMessagesRemoved)
+	assert.Equal(t, 2, index.MessageFileLists[topic].NumMessages())
+}
@@ -0,0 +1,111 @@
+package actions
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+)
+
+// TruncateBeforeAction encapsulates a single execution of the
+// truncate-before command for one topic.
+type TruncateBeforeAction struct {
+	Topic         string
+	MessageNumber int
+	Index         *indexing.Index
+	RootDir       string
+	// The naming scheme of the store's files. Nil means use
+	// filenamer.DefaultNamer.
+	Namer filenamer.FileNamer
+}
+
+// TruncateBefore is the internal entry point function to remove the messages
+// in a topic whose numbers are below MessageNumber. Whole message files are
+// deleted where possible, and the file that straddles the boundary is
+// rewritten without the messages that precede it. It provides the numbers of
+// the messages removed, in ascending order. It updates the in-memory index,
+// but is not responsible for mutex protection, nor re-saving the index
+// afterwards. These are the responsibility of the caller.
+func (action TruncateBeforeAction) TruncateBefore() (
+	removed []int, err error) {
+	msgFileList, ok := action.Index.MessageFileLists[action.Topic]
+	if ok == false {
+		return nil, fmt.Errorf("%w: %v", contract.ErrTopicNotFound,
+			action.Topic)
+	}
+	cutAt := int32(action.MessageNumber)
+
+	// Work from the oldest file forwards, until the boundary is reached.
+	removed = []int{}
+	spentFiles := []string{}
+	for _, fileName := range msgFileList.Names {
+		fileMeta := msgFileList.Meta[fileName]
+		if fileMeta.Oldest.MsgNum == 0 {
+			continue
+		}
+		if fileMeta.Oldest.MsgNum >= cutAt {
+			break
+		}
+		// The file's messages need not be contiguous, once it has been
+		// compacted.
+		keepFrom := int32(0)
+		oldest, newest := fileMeta.Oldest.MsgNum, fileMeta.Newest.MsgNum
+		for msgNum := oldest; msgNum <= newest; msgNum++ {
+			_, ok := fileMeta.SeekOffsetForMessageNumber[msgNum]
+			if ok == false {
+				continue
+			}
+			if msgNum >= cutAt {
+				keepFrom = msgNum
+				break
+			}
+			removed = append(removed, int(msgNum))
+		}
+		if keepFrom == 0 {
+			spentFiles = append(spentFiles, fileName)
+			continue
+		}
+		err = action.rewriteBoundaryFile(fileName, fileMeta, keepFrom)
+		if err != nil {
+			return nil, fmt.Errorf("rewriteBoundaryFile(): %v", err)
+		}
+		break
+	}
+
+	// Mandate the index to forget about the spent files, and then
+	// physically remove them.
+	msgFileList.ForgetFiles(spentFiles)
+	for _, fileName := range spentFiles {
+		filePath := messageFilePath(action.Namer,
+			fileName, action.Topic, action.RootDir)
+		err = os.Remove(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("os.Remove(): %v", err)
+		}
+	}
+	return removed, nil
+}
+
+// rewriteBoundaryFile replaces the given message file with one that holds
+// only the messages from keepFrom onwards, and updates the file's index
+// metadata to match. A failure part way through leaves the original intact.
+func (action TruncateBeforeAction) rewriteBoundaryFile(fileName string,
+	fileMeta *indexing.FileMeta, keepFrom int32) error {
+	filePath := messageFilePath(action.Namer, fileName, action.Topic,
+		action.RootDir)
+	fileContents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("ioutil.ReadFile(): %v", err)
+	}
+	cutAt := fileMeta.SeekOffsetForMessageNumber[keepFrom]
+	err = ioutils.ReplaceFile(filePath, fileContents[cutAt:])
+	if err != nil {
+		return fmt.Errorf("ioutils.ReplaceFile(): %v", err)
+	}
+	fileMeta.DropMessagesBefore(keepFrom)
+	return nil
+}
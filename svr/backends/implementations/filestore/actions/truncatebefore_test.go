@@ -0,0 +1,91 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+)
+
+// Store enough messages to spawn several files, and make sure that
+// truncating before a message part way through a file removes the older files
+// entirely, rewrites the boundary file, and reports the messages removed.
+func TestTruncateBefore(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+
+	topic := "sometopic"
+	storeAction := StoreAction{
+		Topic:       topic,
+		Index:       index,
+		RootDir:     rootDir,
+		MaxFileSize: 3 * encodedSizeOf([]byte("message_NN")),
+	}
+	for i := 1; i <= 10; i++ {
+		storeAction.Message = minikafka.Message(fmt.Sprintf("message_%02d", i))
+		_, _, err := storeAction.Store()
+		if err != nil {
+			msg := fmt.Sprintf("storeAction.Store(): %v", err)
+			assert.Fail(t, msg)
+		}
+	}
+	// Files now hold 1-3, 4-6, 7-9 and 10.
+
+	truncateAction := TruncateBeforeAction{
+		Topic: topic, MessageNumber: 5, Index: index, RootDir: rootDir}
+	removed, err := truncateAction.TruncateBefore()
+	if err != nil {
+		msg := fmt.Sprintf("truncateAction.TruncateBefore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	assert.Equal(t, []int{1, 2, 3, 4}, removed)
+
+	msgFileList := index.MessageFileLists[topic]
+	assert.Equal(t, 3, len(msgFileList.Names))
+	nFiles, err := ioutils.CountEntitiesInDir(
+		filenamer.DirectoryForTopic(topic, rootDir))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, nFiles)
+	oldest, newest := msgFileList.Bounds()
+	assert.Equal(t, 5, oldest)
+	assert.Equal(t, 10, newest)
+
+	pollAction := PollAction{
+		Topic: topic, ReadFrom: 5, Index: index, RootDir: rootDir}
+	messages, _, err := pollAction.Poll()
+	if err != nil {
+		msg := fmt.Sprintf("pollAction.Poll(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	assert.Equal(t, 6, len(messages))
+	assert.Equal(t, "message_05", string(messages[0]))
+
+	// Truncating at, or before the oldest should be a no-op.
+	removed, err = truncateAction.TruncateBefore()
+	assert.Nil(t, err)
+	assert.Equal(t, []int{}, removed)
+	truncateAction.MessageNumber = 2
+	removed, err = truncateAction.TruncateBefore()
+	assert.Nil(t, err)
+	assert.Equal(t, []int{}, removed)
+	oldest, _ = msgFileList.Bounds()
+	assert.Equal(t, 5, oldest)
+}
+
+// Make sure a topic unknown to the index is reported.
+func TestTruncateBeforeWhenTopicIsUnknown(t *testing.T) {
+	truncateAction := TruncateBeforeAction{
+		Topic: "nosuchtopic", MessageNumber: 5, Index: indexing.NewIndex()}
+	_, err := truncateAction.TruncateBefore()
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+}
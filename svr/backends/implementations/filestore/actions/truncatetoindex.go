@@ -0,0 +1,57 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+)
+
+// TruncateToIndexAction encapsulates a single execution of the
+// truncate-to-index command.
+type TruncateToIndexAction struct {
+	Index   *indexing.Index
+	RootDir string
+	// The naming scheme of the store's files. Nil means use
+	// filenamer.DefaultNamer.
+	Namer filenamer.FileNamer
+}
+
+// TruncateToIndex is the internal entry point function to discard any bytes
+// at the end of each topic's current message file that the index does not
+// account for. These are left behind when an append is interrupted, or when
+// the store stops before the index has been saved. Were they left in place,
+// subsequent appends would be misplaced. It provides the paths of the files
+// it truncated. It does not change the index, and is not responsible for
+// mutex protection.
+func (action TruncateToIndexAction) TruncateToIndex() (
+	truncated []string, err error) {
+	truncated = []string{}
+	for _, topic := range action.Index.Topics() {
+		msgFileName := action.Index.CurrentMsgFileNameFor(topic)
+		if msgFileName == "" {
+			continue
+		}
+		fileMeta := action.Index.MessageFileLists[topic].Meta[msgFileName]
+		filePath := messageFilePath(action.Namer,
+			msgFileName, topic, action.RootDir)
+		info, err := os.Stat(filePath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("os.Stat(): %v", err)
+		}
+		if info.Size() <= fileMeta.Size {
+			continue
+		}
+		err = os.Truncate(filePath, fileMeta.Size)
+		if err != nil {
+			return nil, fmt.Errorf("os.Truncate(): %v", err)
+		}
+		truncated = append(truncated, filePath)
+	}
+	return truncated, nil
+}
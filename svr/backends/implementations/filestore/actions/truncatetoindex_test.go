@@ -0,0 +1,73 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+)
+
+// Store a message, then append part of a second one to the file behind the
+// index's back - as an interrupted append would - and make sure the partial
+// bytes are discarded, leaving the first message readable, and subsequent
+// appends aligned.
+func TestTruncateToIndex(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	index := indexing.NewIndex()
+	topic := "sometopic"
+	storeAction := StoreAction{
+		Topic:   topic,
+		Message: []byte("message_1"),
+		Index:   index,
+		RootDir: rootDir,
+	}
+	_, msgFileName, err := storeAction.Store()
+	if err != nil {
+		msg := fmt.Sprintf("storeAction.Store(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	filePath := filenamer.MessageFilePath(msgFileName, topic, rootDir)
+	staged, err := storeAction.Prepare()
+	if err != nil {
+		msg := fmt.Sprintf("storeAction.Prepare(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	err = ioutils.AppendToFile(filePath, staged.encoded[:10])
+	if err != nil {
+		msg := fmt.Sprintf("ioutils.AppendToFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+
+	// Even before the truncation, a Poll should return just the first
+	// message, cleanly.
+	pollAction := PollAction{
+		Topic: topic, ReadFrom: 1, Index: index, RootDir: rootDir}
+	messages, _, err := pollAction.Poll()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+
+	truncateAction := TruncateToIndexAction{Index: index, RootDir: rootDir}
+	truncated, err := truncateAction.TruncateToIndex()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filePath}, truncated)
+
+	storeAction.Message = []byte("message_2")
+	_, _, err = storeAction.Store()
+	assert.Nil(t, err)
+	messages, _, err = pollAction.Poll()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "message_2", string(messages[1]))
+
+	// Nothing more to discard.
+	truncated, err = truncateAction.TruncateToIndex()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, truncated)
+}
@@ -0,0 +1,231 @@
+package filestore
+
+import (
+	"context"
+	"fmt"
+
+	minikafka "github.com/peterhoward42/minikafka"
+)
+
+// DefaultAsyncQueueSize is how many messages StoreAsync queues, unless
+// WithAsyncQueueSize says otherwise.
+const DefaultAsyncQueueSize = 1024
+
+// WithAsyncQueueSize sets how many messages StoreAsync queues for storage,
+// beyond which it blocks, or refuses them (see WithAsyncQueueFullError). It
+// is also the most messages stored in one go. The default is
+// DefaultAsyncQueueSize.
+func WithAsyncQueueSize(size int) Option {
+	return func(s *FileStore) {
+		s.asyncQueueSize = size
+	}
+}
+
+// WithAsyncQueueFullError sets whether StoreAsync refuses a message when its
+// queue is full, with a result whose error wraps ErrAsyncQueueFull, rather
+// than waiting until there is room. The default is to wait.
+func WithAsyncQueueFullError(enabled bool) Option {
+	return func(s *FileStore) {
+		s.asyncQueueFullError = enabled
+	}
+}
+
+// StoreResult is the outcome of a StoreAsync call: the message number given
+// to the message, or the error that prevented it being stored.
+type StoreResult struct {
+	MessageNumber int
+	Err           error
+}
+
+// asyncStore is a message queued by StoreAsync, along with where to send
+// its result.
+type asyncStore struct {
+	topic   string
+	message minikafka.Message
+	result  chan StoreResult
+}
+
+// StoreAsync is like Store, except that it returns straight away, with a
+// channel from which the result can be collected once the message has been
+// stored. (Exactly one result is sent, and the channel is buffered, so the
+// result need not be collected.) The message is queued for a goroutine that
+// stores the messages queued in batches - one per topic, as StoreBatch
+// would, so with one index save each - which is started by the first call.
+// Messages stored to the same topic by StoreAsync are given message numbers
+// in the order they were queued, and it can be used alongside the other
+// Store methods. When the queue is full (see WithAsyncQueueSize), it waits
+// for room, unless WithAsyncQueueFullError says otherwise. Close stores the
+// messages still queued before closing the store, and Shutdown waits for
+// them, as for stores under way. Once either has been called, the results of
+// later calls report ErrStoreClosed. The rate limit (see WithRateLimit)
+// applies to each batch. The function set by SetOnStore is called by the
+// goroutine for the messages it stores, so must not wait for the results of
+// StoreAsync calls.
+func (s *FileStore) StoreAsync(topic string,
+	message minikafka.Message) <-chan StoreResult {
+	result := make(chan StoreResult, 1)
+	queued := asyncStore{topic: topic, message: message, result: result}
+
+	// Held, while the message is queued, so that stopAsyncStores can wait
+	// for it to be.
+	s.asyncMutex.RLock()
+	defer s.asyncMutex.RUnlock()
+	queue := s.asyncQueue()
+	s.mutex.RLock()
+	closed := queue == nil || s.closed || s.shuttingDown
+	if closed == false {
+		s.storesInFlight.Add(1)
+	}
+	s.mutex.RUnlock()
+	if closed {
+		result <- StoreResult{MessageNumber: -1, Err: ErrStoreClosed}
+		return result
+	}
+	if s.asyncQueueFullError {
+		select {
+		case queue <- queued:
+		default:
+			s.deliverAsync(queued, -1, fmt.Errorf(
+				"%w: %d message(s) are queued", ErrAsyncQueueFull, cap(queue)))
+		}
+		return result
+	}
+	queue <- queued
+	return result
+}
+
+// asyncQueue provides the queue StoreAsync sends messages to, starting the
+// goroutine that stores them, if it is not running already - unless
+// stopAsyncStores has been called, in which case it provides nil.
+func (s *FileStore) asyncQueue() chan asyncStore {
+	s.asyncStartMutex.Lock()
+	defer s.asyncStartMutex.Unlock()
+	if s.asyncStopped {
+		return nil
+	}
+	if s.asyncStores == nil {
+		s.asyncStores = make(chan asyncStore, s.asyncQueueSize)
+		s.asyncDone = make(chan struct{})
+		go s.runAsyncStores(s.asyncStores, s.asyncDone)
+	}
+	return s.asyncStores
+}
+
+// stopAsyncStores stops StoreAsync queueing messages, and waits for those
+// already queued to be stored, and for the goroutine that stores them to
+// finish. It does nothing if that is not running.
+func (s *FileStore) stopAsyncStores() {
+	s.asyncStartMutex.Lock()
+	s.asyncStopped = true
+	queue, done := s.asyncStores, s.asyncDone
+	s.asyncStores, s.asyncDone = nil, nil
+	s.asyncStartMutex.Unlock()
+	if queue == nil {
+		return
+	}
+	// Wait for the calls that are queueing a message.
+	s.asyncMutex.Lock()
+	s.asyncMutex.Unlock()
+	close(queue)
+	<-done
+}
+
+// resumeAsyncStores lets StoreAsync queue messages again, once
+// stopAsyncStores has been called - for when the store turns out not to be
+// closing after all.
+func (s *FileStore) resumeAsyncStores() {
+	s.asyncStartMutex.Lock()
+	defer s.asyncStartMutex.Unlock()
+	s.asyncStopped = false
+}
+
+// runAsyncStores is the goroutine that stores the messages queued by
+// StoreAsync. Each time, it takes all of those queued - up to the queue's
+// size - and stores them, until the queue is closed and emptied.
+func (s *FileStore) runAsyncStores(queue <-chan asyncStore,
+	done chan<- struct{}) {
+	defer close(done)
+	for first := range queue {
+		batch := []asyncStore{first}
+		for len(batch) < cap(queue) {
+			next, ok := takeQueued(queue)
+			if ok == false {
+				break
+			}
+			batch = append(batch, next)
+		}
+		// Group the messages by topic, keeping their order.
+		topics := []string{}
+		byTopic := map[string][]asyncStore{}
+		for _, queued := range batch {
+			_, ok := byTopic[queued.topic]
+			if ok == false {
+				topics = append(topics, queued.topic)
+			}
+			byTopic[queued.topic] = append(byTopic[queued.topic], queued)
+		}
+		for _, topic := range topics {
+			s.storeQueued(topic, byTopic[topic])
+		}
+	}
+}
+
+// takeQueued provides the next message in the queue, if there is one to
+// hand.
+func takeQueued(queue <-chan asyncStore) (queued asyncStore, ok bool) {
+	select {
+	case queued, ok = <-queue:
+		return queued, ok
+	default:
+		return asyncStore{}, false
+	}
+}
+
+// storeQueued stores the given messages queued by StoreAsync to the topic,
+// as a batch, and sends each its result. The messages are independent of
+// each other, so should one fail, those after it are stored regardless, as
+// a batch of their own.
+func (s *FileStore) storeQueued(topic string, queued []asyncStore) {
+	ctx := context.Background()
+	for len(queued) != 0 {
+		batch := make([]pendingMessage, len(queued))
+		for i, q := range queued {
+			batch[i] = pendingMessage{
+				KeyedMessage: KeyedMessage{Message: q.message}, queued: true}
+		}
+		err := s.admit(ctx, batch)
+		if err != nil {
+			for _, q := range queued {
+				s.deliverAsync(q, -1, err)
+			}
+			return
+		}
+		_, _, stored, err := s.appendBatch(ctx, topic, batch)
+		s.fireOnStore(topic, stored)
+		// When every message was stored, the error can only be that the
+		// index was not saved, which concerns them all.
+		var storedErr error
+		if len(stored) == len(batch) {
+			storedErr = err
+		}
+		for i, event := range stored {
+			s.deliverAsync(queued[i], event.messageNumber, storedErr)
+		}
+		queued = queued[len(stored):]
+		if len(queued) != 0 {
+			s.deliverAsync(queued[0], -1, err)
+			queued = queued[1:]
+		}
+	}
+}
+
+// deliverAsync sends the result of a message queued by StoreAsync, which is
+// then no longer in flight.
+func (s *FileStore) deliverAsync(queued asyncStore, messageNumber int,
+	err error) {
+	if err != nil {
+		messageNumber = -1
+	}
+	queued.result <- StoreResult{MessageNumber: messageNumber, Err: err}
+	s.storesInFlight.Done()
+}
@@ -0,0 +1,183 @@
+package filestore
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+)
+
+// Backup writes the entire store - the index, the committed offsets, and
+// every topic's message files - to the given writer, as a tar stream, from
+// which Restore can recreate it. The store's locks are held throughout, and
+// the index is persisted first, so that the backup is consistent. This holds
+// up everything else the store does, for as long as it takes.
+func (s *FileStore) Backup(w io.Writer) error {
+	s.maintenanceMutex.Lock()
+	defer s.maintenanceMutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	err := s.flush()
+	if err != nil {
+		return fmt.Errorf("flush(): %v", err)
+	}
+
+	archive := tar.NewWriter(w)
+	err = filepath.Walk(s.RootDir,
+		func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if filePath == s.RootDir {
+				return nil
+			}
+			return addToArchive(archive, s.RootDir, filePath, info)
+		})
+	if err != nil {
+		return fmt.Errorf("filepath.Walk(): %v", err)
+	}
+	err = archive.Close()
+	if err != nil {
+		return fmt.Errorf("archive.Close(): %v", err)
+	}
+	return nil
+}
+
+// Restore recreates, in this store, the store backed up by Backup to the
+// given tar stream - which replaces the store's contents in their entirety.
+// It refuses to restore into a store that holds any topics. The directories
+// and files are created with the store's permissions, and a stream that
+// holds anything other than those, or which would write outside the store's
+// root directory, is rejected. Should it fail part way through, the store is
+// left empty.
+func (s *FileStore) Restore(r io.Reader) error {
+	s.maintenanceMutex.Lock()
+	defer s.maintenanceMutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	if len(s.index.Topics()) != 0 {
+		return fmt.Errorf("cannot restore into a store that holds topics")
+	}
+	err := s.deleteContents()
+	if err != nil {
+		return fmt.Errorf("deleteContents(): %v", err)
+	}
+	restoreErr := s.extractArchive(tar.NewReader(r))
+	if restoreErr != nil {
+		err = s.deleteContents()
+		if err != nil {
+			return fmt.Errorf("deleteContents(): %v (after: %v)",
+				err, restoreErr)
+		}
+		return restoreErr
+	}
+	err = s.loadIndex()
+	if err != nil {
+		return fmt.Errorf("loadIndex(): %w", err)
+	}
+	return nil
+}
+
+// addToArchive writes the given directory, or file, found in the root
+// directory, to the archive - under its path relative to the root.
+func addToArchive(archive *tar.Writer, rootDir string, filePath string,
+	info os.FileInfo) error {
+	name, err := filepath.Rel(rootDir, filePath)
+	if err != nil {
+		return fmt.Errorf("filepath.Rel(): %v", err)
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return fmt.Errorf("tar.FileInfoHeader(): %v", err)
+	}
+	header.Name = filepath.ToSlash(name)
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	err = archive.WriteHeader(header)
+	if err != nil {
+		return fmt.Errorf("archive.WriteHeader(): %v", err)
+	}
+	if info.IsDir() {
+		return nil
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("os.Open(): %v", err)
+	}
+	defer file.Close()
+	_, err = io.Copy(archive, file)
+	if err != nil {
+		return fmt.Errorf("io.Copy(): %v", err)
+	}
+	return nil
+}
+
+// extractArchive recreates, in the store's root directory, the directories
+// and files in the archive.
+func (s *FileStore) extractArchive(archive *tar.Reader) error {
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("archive.Next(): %v", err)
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." ||
+			strings.HasPrefix(name, "../") {
+			return fmt.Errorf("backup holds a path outside the store: %q",
+				header.Name)
+		}
+		targetPath := filepath.Join(s.RootDir, filepath.FromSlash(name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = ioutils.CreateDirIfDoesntExist(targetPath, s.dirPerm)
+			if err != nil {
+				return fmt.Errorf("ioutils.CreateDirIfDoesntExist(): %v", err)
+			}
+		case tar.TypeReg:
+			err = s.extractFile(archive, targetPath)
+			if err != nil {
+				return fmt.Errorf("extractFile(): %v", err)
+			}
+		default:
+			return fmt.Errorf("backup holds something other than a file: %q",
+				header.Name)
+		}
+	}
+}
+
+// extractFile writes the contents of the archive's current file to the given
+// path, and commits it to stable storage.
+func (s *FileStore) extractFile(archive *tar.Reader, filePath string) error {
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+		s.filePerm)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile(): %v", err)
+	}
+	_, err = io.Copy(file, archive)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return fmt.Errorf("io.Copy(): %v", err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("file.Close(): %v", closeErr)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package filestore
+
+import "time"
+
+// Clock tells the time, for a FileStore (see WithClock).
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock a store uses unless it is given another.
+type systemClock struct{}
+
+// Now is defined by, and documented in the Clock interface.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// WithClock sets the clock by which the store tells the time. It governs the
+// creation times given to messages, when message files are started, and so
+// rolled over by age (see WithMaxSegmentAge), which messages the janitor
+// finds have expired, when the index is next due to be saved (see
+// WithIndexFlushInterval), and how the rate limit is replenished (see
+// WithRateLimit). So tests can give it a clock they move forward themselves,
+// rather than wait for time to pass. (The durations in Counters are measured
+// by the system clock regardless.) The default, or nil, is the system clock.
+func WithClock(clock Clock) Option {
+	return func(s *FileStore) {
+		s.clock = clock
+	}
+}
@@ -0,0 +1,105 @@
+package filestore
+
+import (
+	"time"
+
+	minikafka "github.com/peterhoward42/minikafka"
+)
+
+// The names of the operations tallied in Counters.Operations. Every variety
+// of store (Store, StoreBatch, StoreTransaction and so on) counts as a
+// StoreOperation, and every variety of poll, once per topic polled, as a
+// PollOperation.
+const (
+	StoreOperation = "store"
+	PollOperation  = "poll"
+)
+
+// Counters is a tally of what a FileStore has done, as provided by its
+// Counters method, for reporting purposes. Unlike Stats, it is held only in
+// memory, so it starts from zero each time the store is opened. It is plain
+// data, like Stats.
+type Counters struct {
+	// The messages stored, by topic. (Keyed on topic.) A topic's figures
+	// are kept when it is deleted.
+	PerTopic map[string]TopicCounters
+
+	// The operations carried out, keyed on the operation names above.
+	Operations map[string]OperationCounters
+
+	// How many polls (once per topic polled), and calls of GetMessage, were
+	// served from the record cache (see WithRecordCache), and how many had
+	// to read the message files instead.
+	RecordCacheHits   int64
+	RecordCacheMisses int64
+}
+
+// TopicCounters is the part of Counters that concerns a single topic.
+type TopicCounters struct {
+	MessagesStored int64
+	BytesStored    int64 // The total size of the messages, as given.
+}
+
+// OperationCounters is the part of Counters that concerns a single kind of
+// operation.
+type OperationCounters struct {
+	Count    int64
+	Errors   int64         // How many of them failed.
+	Duration time.Duration // The total time they took.
+}
+
+// Counters provides the tally of what the store has done since it was
+// opened. It can be called after the store has been closed.
+func (s *FileStore) Counters() Counters {
+	s.countersMutex.Lock()
+	defer s.countersMutex.Unlock()
+	counters := Counters{PerTopic: map[string]TopicCounters{},
+		Operations: map[string]OperationCounters{}}
+	for topic, topicCounters := range s.counters.PerTopic {
+		counters.PerTopic[topic] = topicCounters
+	}
+	for operation, opCounters := range s.counters.Operations {
+		counters.Operations[operation] = opCounters
+	}
+	counters.RecordCacheHits = s.counters.RecordCacheHits
+	counters.RecordCacheMisses = s.counters.RecordCacheMisses
+	return counters
+}
+
+// countOperation adds an operation that began at the given time, and has
+// just finished with the given error, to the tally.
+func (s *FileStore) countOperation(operation string, began time.Time,
+	err error) {
+	elapsed := time.Since(began)
+	s.countersMutex.Lock()
+	defer s.countersMutex.Unlock()
+	if s.counters.Operations == nil {
+		s.counters.Operations = map[string]OperationCounters{}
+	}
+	opCounters := s.counters.Operations[operation]
+	opCounters.Count++
+	if err != nil {
+		opCounters.Errors++
+	}
+	opCounters.Duration += elapsed
+	s.counters.Operations[operation] = opCounters
+}
+
+// countStored adds the given messages, which have been stored in the topic,
+// to the tally.
+func (s *FileStore) countStored(topic string, messages []minikafka.Message) {
+	if len(messages) == 0 {
+		return
+	}
+	s.countersMutex.Lock()
+	defer s.countersMutex.Unlock()
+	if s.counters.PerTopic == nil {
+		s.counters.PerTopic = map[string]TopicCounters{}
+	}
+	topicCounters := s.counters.PerTopic[topic]
+	for _, message := range messages {
+		topicCounters.MessagesStored++
+		topicCounters.BytesStored += int64(len(message))
+	}
+	s.counters.PerTopic[topic] = topicCounters
+}
@@ -0,0 +1,175 @@
+package filestore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/actions"
+)
+
+// The headers stored with each message in the dead-letter topic. They say
+// where the unreadable record was found, and what was wrong with it.
+const (
+	DeadLetterTopicHeader         = "dead-letter-topic"
+	DeadLetterMessageNumberHeader = "dead-letter-message-number"
+	DeadLetterFileHeader          = "dead-letter-file"
+	DeadLetterOffsetHeader        = "dead-letter-offset"
+	DeadLetterProblemHeader       = "dead-letter-problem"
+)
+
+// DeadLetter is a record that the store could not read, as it is written to
+// the dead-letter writer (see WithDeadLetterWriter), one JSON object per
+// line.
+type DeadLetter struct {
+	Topic string `json:"topic"`
+	// The message number the record was stored with. Zero when it is not
+	// known, as is the case for those found by RebuildIndex.
+	MessageNumber int    `json:"messageNumber"`
+	File          string `json:"file"`
+	Offset        int64  `json:"offset"` // Where the record starts in File.
+	Raw           []byte `json:"raw"`
+	Problem       string `json:"problem"`
+}
+
+// WithDeadLetterTopic sets a topic to which the raw bytes of each record
+// that cannot be read are stored, so that they can be inspected, and perhaps
+// repaired, rather than being lost. The records are those the Poll methods
+// skip, and those RebuildIndex drops - including when the index is rebuilt
+// on opening. Each is stored once, with headers that say where it was found
+// and what was wrong with it (see DeadLetterTopicHeader etc.), before the
+// method that found it returns. The records of the dead-letter topic itself
+// are never dead-lettered. The default is no dead-letter topic.
+func WithDeadLetterTopic(topic string) Option {
+	return func(s *FileStore) {
+		s.deadLetterTopic = topic
+	}
+}
+
+// WithDeadLetterWriter is like WithDeadLetterTopic, except that each
+// unreadable record is written to the given writer, as a DeadLetter encoded
+// as a line of JSON. It can be used alongside a dead-letter topic. The
+// default is no writer.
+func WithDeadLetterWriter(w io.Writer) Option {
+	return func(s *FileStore) {
+		s.deadLetterWriter = w
+	}
+}
+
+// deadLettersWanted evaluates whether the store has anywhere to send dead
+// letters.
+func (s *FileStore) deadLettersWanted() bool {
+	return s.deadLetterTopic != "" || s.deadLetterWriter != nil
+}
+
+// onCorrupt provides the OnCorrupt function to give to the actions that
+// decode records, which is nil when dead letters are not wanted.
+func (s *FileStore) onCorrupt() func(actions.CorruptRecord) {
+	if s.deadLettersWanted() == false {
+		return nil
+	}
+	return s.queueDeadLetter
+}
+
+// recordPlace is where a record is found in a topic's message files.
+type recordPlace struct {
+	fileName string
+	offset   int64
+}
+
+// queueDeadLetter is the OnCorrupt function given to the actions that decode
+// records. It is called with the store's locks held, so it only queues the
+// record, for deliverDeadLetters to send on. A record is queued only the
+// first time it is met, so that polling it again - or rebuilding the index
+// that drops it - does not repeat it.
+func (s *FileStore) queueDeadLetter(corrupt actions.CorruptRecord) {
+	if corrupt.Topic == s.deadLetterTopic {
+		return
+	}
+	s.deadLettersMutex.Lock()
+	defer s.deadLettersMutex.Unlock()
+	seen := s.deadLettersSeen[corrupt.Topic]
+	if seen == nil {
+		seen = map[recordPlace]bool{}
+		s.deadLettersSeen[corrupt.Topic] = seen
+	}
+	place := recordPlace{corrupt.FileName, corrupt.Offset}
+	if seen[place] {
+		return
+	}
+	seen[place] = true
+	s.deadLetters = append(s.deadLetters, DeadLetter{Topic: corrupt.Topic,
+		MessageNumber: corrupt.MessageNumber, File: corrupt.FileName,
+		Offset: corrupt.Offset, Raw: corrupt.Raw, Problem: corrupt.Problem})
+}
+
+// forgetDeadLetters discards which of the given topics' records have been
+// dead-lettered, or of every topic when none are given - for when the
+// message files they were found in have been removed or rewritten.
+func (s *FileStore) forgetDeadLetters(topics ...string) {
+	s.deadLettersMutex.Lock()
+	defer s.deadLettersMutex.Unlock()
+	if len(topics) == 0 {
+		s.deadLettersSeen = map[string]map[recordPlace]bool{}
+	}
+	for _, topic := range topics {
+		delete(s.deadLettersSeen, topic)
+	}
+}
+
+// deliverDeadLetters sends the dead letters queued by queueDeadLetter to the
+// dead-letter topic and writer. It must be called without holding any of the
+// store's other locks, since storing to the dead-letter topic acquires them.
+// Failures are logged, because they are not the failure of the operation
+// that found the records.
+func (s *FileStore) deliverDeadLetters() {
+	if s.deadLettersWanted() == false {
+		return
+	}
+	// Deliveries are serialized, so that the letters arrive in the order
+	// they were queued.
+	s.deadLetterDeliveryMutex.Lock()
+	defer s.deadLetterDeliveryMutex.Unlock()
+	s.deadLettersMutex.Lock()
+	letters := s.deadLetters
+	s.deadLetters = nil
+	s.deadLettersMutex.Unlock()
+
+	for _, letter := range letters {
+		err := s.deliverDeadLetter(letter)
+		if err != nil {
+			log.Printf("filestore dead letters: %v", err)
+		}
+	}
+}
+
+// deliverDeadLetter sends one dead letter to the dead-letter topic and
+// writer.
+func (s *FileStore) deliverDeadLetter(letter DeadLetter) error {
+	if s.deadLetterTopic != "" {
+		headers := map[string]string{
+			DeadLetterTopicHeader:         letter.Topic,
+			DeadLetterMessageNumberHeader: strconv.Itoa(letter.MessageNumber),
+			DeadLetterFileHeader:          letter.File,
+			DeadLetterOffsetHeader:        strconv.FormatInt(letter.Offset, 10),
+			DeadLetterProblemHeader:       letter.Problem}
+		// The rate limit is for producers, so it is bypassed.
+		_, _, stored, err := s.appendBatch(context.Background(),
+			s.deadLetterTopic, []pendingMessage{{KeyedMessage: KeyedMessage{
+				Headers: headers, Message: letter.Raw}}})
+		s.fireOnStore(s.deadLetterTopic, stored)
+		if err != nil {
+			return fmt.Errorf("appendBatch(): %v", err)
+		}
+	}
+	if s.deadLetterWriter != nil {
+		err := json.NewEncoder(s.deadLetterWriter).Encode(letter)
+		if err != nil {
+			return fmt.Errorf("json.Encoder.Encode(): %v", err)
+		}
+	}
+	return nil
+}
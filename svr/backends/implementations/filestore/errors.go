@@ -0,0 +1,79 @@
+package filestore
+
+import (
+	"errors"
+
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
+)
+
+// Sentinel errors that the FileStore returns (wrapped), so that callers can
+// distinguish them using errors.Is(). In addition to these, the Poll methods
+// (and HasNew) return contract.ErrTopicNotFound for an unknown topic, and the
+// Store methods return contract.ErrMessageTooLarge for a message that will
+// not fit in a message file, or exceeds the maximum message size. (See
+// WithMaxFileSize and WithMaxMessageSize). And contract.ErrQuotaExceeded for
+// a message that would take its topic beyond the quota set by SetQuota. And
+// NewFileStore (and Restore) return contract.ErrCorruptIndex for an index
+// file that cannot be decoded (see WithRebuildCorruptIndex).
+var (
+	// ErrRootDirIsFile is returned by NewFileStore when the root directory
+	// path provided exists, but is a file rather than a directory.
+	ErrRootDirIsFile = errors.New("root directory path is not a directory")
+
+	// ErrRootDirNotWritable is returned by NewFileStore when the root
+	// directory exists, but the store cannot create files in it.
+	ErrRootDirNotWritable = errors.New("root directory is not writable")
+
+	// ErrInvalidTopic is returned by the methods that store to, poll, or
+	// delete a topic, when the topic name could not safely be used as the
+	// name of the topic's directory - or does not match the pattern set by
+	// WithTopicPattern. It is contract.ErrInvalidTopic, so that callers that
+	// know only the contract can recognise it.
+	ErrInvalidTopic = contract.ErrInvalidTopic
+
+	// ErrUnreadableRecords is returned by RebuildIndex when it had to drop
+	// records it could not decode from some message files. The rebuild
+	// nonetheless completes.
+	ErrUnreadableRecords = errors.New("unreadable records were dropped")
+
+	// ErrCorruptRecords is returned by the Poll methods when they had to
+	// skip records that failed their checksum, or could not be decoded. The
+	// messages that could be read are returned nonetheless.
+	ErrCorruptRecords = errors.New("corrupt records were skipped")
+
+	// ErrRateLimited is returned by the Store methods when storing the
+	// message(s) would exceed the rate limit set by WithRateLimit - unless
+	// WithRateLimitBlocking says to wait instead.
+	ErrRateLimited = errors.New("rate limit exceeded")
+
+	// ErrInvalidToken is returned by PollToken when the continuation token
+	// it is given is not one the store provided.
+	ErrInvalidToken = errors.New("invalid continuation token")
+
+	// ErrOffsetOutOfRange is returned by ConsumerLag and AllLag, along with
+	// the lag they work out regardless, when a consumer group's committed
+	// offset lies outside the topic - because the messages the group had
+	// yet to read have been removed, or because the topic has been deleted
+	// and stored to afresh since it was committed.
+	ErrOffsetOutOfRange = errors.New("committed offset is out of range")
+
+	// ErrDecryptionFailed is returned by the methods that read records - and
+	// by NewFileStore and RebuildIndex, should the index need rebuilding -
+	// when a record is encrypted with a different key from the store's (see
+	// WithEncryptionKey), or the store has none. It is records'
+	// ErrDecryptionFailed, so either can be tested for with errors.Is().
+	ErrDecryptionFailed = records.ErrDecryptionFailed
+
+	// ErrAsyncQueueFull is reported by StoreAsync, when its queue is full,
+	// and WithAsyncQueueFullError says to refuse messages rather than wait.
+	ErrAsyncQueueFull = errors.New("async store queue is full")
+
+	// ErrUnhealthy is returned by HealthCheck when one of its checks fails.
+	// The error that wraps it says which.
+	ErrUnhealthy = errors.New("store is unhealthy")
+
+	// ErrStoreClosed is returned by every method of a FileStore that has
+	// been closed - and by the Store methods once Shutdown has been called.
+	ErrStoreClosed = errors.New("store is closed")
+)
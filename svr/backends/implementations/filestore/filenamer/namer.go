@@ -5,16 +5,47 @@ package filenamer
 import (
 	"math/rand"
 	"path"
+	"strings"
 	"time"
 )
 
 const indexName = "index"
+const offsetsName = "offsets"
+
+// Message file names are made from this palette of characters. (Deliberate
+// avoidance of mixed case for Windows suitability.)
+const msgFilenamePalette = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+const msgFilenameLength = 8
 
 // IndexFile provides the full path of the index file.
 func IndexFile(rootDir string) string {
 	return path.Join(rootDir, indexName)
 }
 
+// IndexDirtyMarkerFile provides the full path of the file whose presence
+// signifies that the index file may be out of date.
+func IndexDirtyMarkerFile(rootDir string) string {
+	return path.Join(rootDir, indexName+".dirty")
+}
+
+// OffsetsFile provides the full path of the file in which consumer-group
+// offsets are kept.
+func OffsetsFile(rootDir string) string {
+	return path.Join(rootDir, offsetsName)
+}
+
+// IsReserved evaluates whether the given name is, or might be, that of one of
+// the files kept in the root directory alongside the topic directories. (So a
+// topic must not be given that name.)
+func IsReserved(name string) bool {
+	for _, reserved := range []string{indexName, offsetsName} {
+		if name == reserved || strings.HasPrefix(name, reserved+".") {
+			return true
+		}
+	}
+	return false
+}
+
 // DirectoryForTopic provides the directory that should be used for the
 // given topic.
 func DirectoryForTopic(topic, rootDir string) string {
@@ -38,11 +69,9 @@ func NewMsgFilenameFor(
 	topic string, previouslyUsedChecker PreviouslyUsedChecker) string {
 	// Names are randomly generated, 8 characters long, from a specified
 	// palette
-
-	// Deliberate avoidance of mixed case for Windows suitability.
-	pickFrom := []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+	pickFrom := []rune(msgFilenamePalette)
 	nChoices := len(pickFrom)
-	const requiredLength = 8
+	const requiredLength = msgFilenameLength
 	rand.Seed(time.Now().UnixNano())
 	// Keep making them up for as long as the previously-used checker
 	// rejects them.
@@ -61,8 +90,121 @@ func NewMsgFilenameFor(
 	}
 }
 
+// IsMessageFileName evaluates whether the given file base name is of the
+// form that NewMsgFilenameFor provides.
+func IsMessageFileName(name string) bool {
+	if len(name) != msgFilenameLength {
+		return false
+	}
+	for _, c := range name {
+		if strings.ContainsRune(msgFilenamePalette, c) == false {
+			return false
+		}
+	}
+	return true
+}
+
 // PreviouslyUsedChecker is a thing that will check whether a name has
 // already been used before in a given context.
 type PreviouslyUsedChecker interface {
 	PreviouslyUsed(name string, context string) bool
 }
+
+// FileNamer decides the names of the files and directories that make up a
+// file store, so that a store can be given a naming scheme of its own (see
+// filestore.WithFileNamer). DefaultNamer provides the default scheme.
+type FileNamer interface {
+	// IndexFile provides the full path of the index file. The marker file
+	// whose presence signifies that the index may be out of date is named
+	// after it.
+	IndexFile(rootDir string) string
+
+	// OffsetsFile provides the full path of the file in which consumer-group
+	// offsets are kept.
+	OffsetsFile(rootDir string) string
+
+	// DirectoryForTopic provides the directory that holds the given topic's
+	// message files, which must be directly within the root directory.
+	DirectoryForTopic(topic, rootDir string) string
+
+	// TopicForDirectory is the inverse of DirectoryForTopic. It provides the
+	// topic whose directory has the given base name, or false when that is
+	// not the name of a topic's directory.
+	TopicForDirectory(dirName string) (topic string, ok bool)
+
+	// NewMsgFilenameFor provides a base name for a new message file for the
+	// given topic, which the PreviouslyUsedChecker passed in does not reject.
+	NewMsgFilenameFor(
+		topic string, previouslyUsedChecker PreviouslyUsedChecker) string
+
+	// IsMessageFileName evaluates whether the given base name, found in a
+	// topic's directory, is of the form that NewMsgFilenameFor provides.
+	IsMessageFileName(name string) bool
+}
+
+// DefaultNamer is the FileNamer a store uses unless it is given another. It
+// names everything as the functions above do, so message files are given
+// random names.
+type DefaultNamer struct{}
+
+// IndexFile is defined by, and documented in the FileNamer interface.
+func (DefaultNamer) IndexFile(rootDir string) string {
+	return IndexFile(rootDir)
+}
+
+// OffsetsFile is defined by, and documented in the FileNamer interface.
+func (DefaultNamer) OffsetsFile(rootDir string) string {
+	return OffsetsFile(rootDir)
+}
+
+// DirectoryForTopic is defined by, and documented in the FileNamer
+// interface.
+func (DefaultNamer) DirectoryForTopic(topic, rootDir string) string {
+	return DirectoryForTopic(topic, rootDir)
+}
+
+// TopicForDirectory is defined by, and documented in the FileNamer
+// interface.
+func (DefaultNamer) TopicForDirectory(dirName string) (string, bool) {
+	return dirName, true
+}
+
+// NewMsgFilenameFor is defined by, and documented in the FileNamer
+// interface.
+func (DefaultNamer) NewMsgFilenameFor(
+	topic string, previouslyUsedChecker PreviouslyUsedChecker) string {
+	return NewMsgFilenameFor(topic, previouslyUsedChecker)
+}
+
+// IsMessageFileName is defined by, and documented in the FileNamer
+// interface.
+func (DefaultNamer) IsMessageFileName(name string) bool {
+	return IsMessageFileName(name)
+}
+
+// MessageFilePathFor is like MessageFilePath, but for the naming scheme of
+// the given FileNamer.
+func MessageFilePathFor(namer FileNamer, msgFileName, topic,
+	rootDir string) string {
+	return path.Join(namer.DirectoryForTopic(topic, rootDir), msgFileName)
+}
+
+// IndexDirtyMarkerFileFor is like IndexDirtyMarkerFile, but for the naming
+// scheme of the given FileNamer.
+func IndexDirtyMarkerFileFor(namer FileNamer, rootDir string) string {
+	return namer.IndexFile(rootDir) + ".dirty"
+}
+
+// IsReservedFor is like IsReserved, but for the naming scheme of the given
+// FileNamer: it evaluates whether the given topic's directory would be, or
+// might be, one of the files kept in the root directory.
+func IsReservedFor(namer FileNamer, topic, rootDir string) bool {
+	dirPath := namer.DirectoryForTopic(topic, rootDir)
+	for _, reserved := range []string{namer.IndexFile(rootDir),
+		namer.OffsetsFile(rootDir)} {
+		if dirPath == reserved || strings.HasPrefix(dirPath, reserved+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -58,3 +58,11 @@ func TestRejectionCallbackWorking(t *testing.T) {
 		}
 	}
 }
+
+func TestIsMessageFileName(t *testing.T) {
+	name := NewMsgFilenameFor("topicA", AlwaysFalse{})
+	assert.True(t, IsMessageFileName(name))
+	assert.False(t, IsMessageFileName(name+".tmp"))
+	assert.False(t, IsMessageFileName("abcdefgh"))
+	assert.False(t, IsMessageFileName("ABC"))
+}
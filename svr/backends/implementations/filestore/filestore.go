@@ -3,171 +3,2587 @@
 package filestore
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
+
+	"go.opentelemetry.io/otel/trace"
 
 	minikafka "github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/actions"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/offsets"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
+	"github.com/peterhoward42/minikafka/svr/backends/notify"
+	"github.com/peterhoward42/minikafka/svr/backends/snapshot"
 )
 
-var mutex = &sync.Mutex{} // Guards concurrent access of the FileStore.
-
 // FileStore encapsulates the store.
 type FileStore struct {
 	RootDir string
+	// Guards the index, and the store's other mutable state. Operations
+	// that only read them share it.
+	mutex sync.RWMutex
+
+	// Each topic has its own lock, held throughout the storage of messages
+	// to it, so that only writes to the same topic are serialized against
+	// each other. The map of them is guarded by topicLocksMutex.
+	topicLocks      map[string]*sync.Mutex
+	topicLocksMutex sync.Mutex
+
+	// Shared by storage operations, but held exclusively by operations that
+	// remove or rewrite message files, so that these never coincide with a
+	// message being written. (Acquire it before a topic lock, and a topic
+	// lock before mutex.)
+	maintenanceMutex sync.RWMutex
+
+	// The size at which message files are rolled over. Zero means use
+	// the default.
+	maxFileSize int64
+
+	// The age at which message files are rolled over. Zero means they are
+	// not rolled over by age.
+	maxSegmentAge time.Duration
+
+	// The size of the largest message to accept. Zero means the maximum
+	// file size is the only limit.
+	maxMessageSize int64
+
+	// The size beyond which messages are split into chunk records. Zero
+	// means they are not.
+	chunkSize int64
+
+	// Wakes up blocking polls when messages are stored.
+	notifier notify.Notifier
+
+	// The topics that have come into being since the store was opened, in
+	// order, for WatchTopics. And what wakes up the watchers when one does.
+	createdTopics []string
+	topicNotifier notify.Notifier
+
+	// The most messages TrimToCount should retain, for those topics that
+	// have a limit.
+	retentionCounts map[string]int
+
+	// The most bytes TrimToSize should retain, for those topics that have a
+	// limit.
+	retentionBytes map[string]int64
+
+	// The quotas set by SetQuota, keyed on topic.
+	quotas map[string]quota
+
+	// Whether to commit each write to stable storage as it is made.
+	syncOnWrite bool
+
+	// How message records are encoded in the message files.
+	serializer records.Serializer
+
+	// Whether to compress the records in the message files started from now
+	// on.
+	compress bool
+
+	// The secret given to WithEncryptionKey, and the key made from it, with
+	// which records are encrypted. Nil means they are not.
+	encryptionSecret []byte
+	encryptionKey    *records.Key
+
+	// The permissions (before the umask) with which the store creates
+	// directories and files.
+	dirPerm  os.FileMode
+	filePerm os.FileMode
+
+	// The naming scheme of the store's files and directories.
+	namer filenamer.FileNamer
+
+	// The pattern topic names must match, in addition to being safe to use
+	// as a directory name. Nil means any safe name is allowed.
+	topicPattern *regexp.Regexp
+
+	// The message files written to since the last Flush, when syncOnWrite
+	// is off. (Keyed on file path.)
+	unsynced map[string]bool
+
+	// The current message file of each topic stored to, held open. They
+	// are closed by the operations that remove or rewrite message files.
+	handles ioutils.AppendHandles
+
+	// Whether Poll and GetMessage read the message files through memory
+	// mappings, and the cache of those mappings. They are unmapped by the
+	// operations that truncate, remove or rewrite message files.
+	mmapReads bool
+	mappings  ioutils.ReadMappings
+
+	// Whether an index file that cannot be decoded is rebuilt when it is
+	// loaded, rather than being reported (see WithRebuildCorruptIndex). Or
+	// is set aside for a virgin index, because the RebuildIndex function is
+	// to rebuild it straight away.
+	rebuildCorruptIndex  bool
+	setAsideCorruptIndex bool
+
+	// The index, held in memory, and mutated in place.
+	index *indexing.Index
+
+	// How long the in-memory index is allowed to go unpersisted after
+	// being changed. Zero means persist it after every change.
+	indexFlushInterval time.Duration
+
+	// Whether the in-memory index has changes that have not been persisted,
+	// and when it was last persisted.
+	indexDirty     bool
+	indexPersisted time.Time
+
+	// How many of each topic's most recent records to cache, and the
+	// caches, keyed on topic. (Guarded by mutex.)
+	recordCacheSize int
+	recordCaches    map[string]*recordCache
+
+	// Whether Close has been called. And whether Shutdown has, along with
+	// the stores that were under way when it was, for it to wait for.
+	closed         bool
+	shuttingDown   bool
+	storesInFlight sync.WaitGroup
+
+	// The size of StoreAsync's queue, and whether it refuses messages when
+	// the queue is full. The queue, and the goroutine's done channel, are
+	// nil when it is not running, and whether stopAsyncStores has been
+	// called. These are guarded by asyncStartMutex. And asyncMutex, which
+	// is held (shared) by StoreAsync while it queues a message, so the
+	// queue is not closed under it. (Neither is held while acquiring the
+	// store's other locks.)
+	asyncQueueSize      int
+	asyncQueueFullError bool
+	asyncStores         chan asyncStore
+	asyncDone           chan struct{}
+	asyncStopped        bool
+	asyncStartMutex     sync.Mutex
+	asyncMutex          sync.RWMutex
+
+	// The janitor goroutine's stop and done channels, which are nil when it
+	// is not running, and are guarded by janitorMutex. (Which is never
+	// acquired while holding the store's other locks.) And where it reports
+	// errors.
+	janitorStop         chan struct{}
+	janitorDone         chan struct{}
+	janitorMutex        sync.Mutex
+	janitorErrorHandler func(error)
+
+	// The function to call for each message stored, or nil.
+	onStore func(topic string, messageNumber int, created time.Time)
+
+	// How many idempotency keys StoreIdempotent remembers for each topic,
+	// and the windows of those it has seen, keyed on topic. (A window's
+	// own mutex is acquired before any of the store's other locks.)
+	idempotencyWindow int
+	keyWindows        map[string]*keyWindow
+	keyWindowsMutex   sync.Mutex
+
+	// Creates the spans described by WithTracer.
+	tracer trace.Tracer
+
+	// The tally provided by Counters. It is guarded by countersMutex, which
+	// is never held while acquiring the store's other locks.
+	counters      Counters
+	countersMutex sync.Mutex
+
+	// Where records that cannot be read are sent, if anywhere. Those found
+	// are queued in deadLetters until they can be delivered, and where
+	// those that have been were found is held in deadLettersSeen, keyed on
+	// topic. Both are guarded by deadLettersMutex, which is never
+	// held while acquiring the store's other locks. Deliveries are
+	// serialized by deadLetterDeliveryMutex, which is acquired before any
+	// of the store's other locks.
+	deadLetterTopic         string
+	deadLetterWriter        io.Writer
+	deadLetters             []DeadLetter
+	deadLettersSeen         map[string]map[recordPlace]bool
+	deadLettersMutex        sync.Mutex
+	deadLetterDeliveryMutex sync.Mutex
+
+	// The token buckets that enforce WithRateLimit, which are nil when
+	// there is no limit, and whether to wait for them rather than refuse a
+	// store. The buckets are guarded by rateMutex, which is never held while
+	// acquiring the store's other locks.
+	messageRate       *tokenBucket
+	byteRate          *tokenBucket
+	rateLimitBlocking bool
+	rateMutex         sync.Mutex
+
+	// Serializes HealthCheck calls, which share the topic they probe. It is
+	// acquired after maintenanceMutex, and before mutex.
+	healthMutex sync.Mutex
+
+	// Tells the time (see WithClock). And waits for the rate limit, which
+	// is replaced by tests.
+	clock Clock
+	sleep func(ctx context.Context, d time.Duration) error
+
+	// Renames the root directory, for Migrate. It is replaced by tests.
+	rename func(oldPath, newPath string) error
+}
+
+// KeyedMessage is a message, along with the (optional) key and headers that
+// were stored with it.
+type KeyedMessage struct {
+	Key     []byte
+	Headers map[string]string
+	Message minikafka.Message
+}
+
+// Option is a functional option that can be passed to NewFileStore to
+// override one of the FileStore's default settings.
+type Option func(*FileStore)
+
+// WithMaxFileSize sets the size (in bytes) beyond which a message file will
+// not be allowed to grow, and a new one will be started instead. It thus
+// also limits the size of the largest message the store will accept (see
+// WithMaxMessageSize), unless messages are chunked (see WithChunkSize). The
+// default is 1 MiB.
+func WithMaxFileSize(size int64) Option {
+	return func(s *FileStore) {
+		s.maxFileSize = size
+	}
+}
+
+// WithMaxMessageSize sets the size (in bytes) of the largest message the
+// store will accept, as it is stored - that is encoded, along with its
+// message number, creation time and key, and framed. Larger messages are
+// refused with an error that wraps contract.ErrMessageTooLarge, before
+// anything is written. The limit cannot be raised beyond the maximum file
+// size (see WithMaxFileSize), which is the default - unless messages are
+// chunked (see WithChunkSize) - nor beyond records.MaxFramedSize, which the
+// store refuses.
+func WithMaxMessageSize(size int64) Option {
+	return func(s *FileStore) {
+		s.maxMessageSize = size
+	}
+}
+
+// WithChunkSize sets the size (in bytes) beyond which a message, as it is
+// stored (see WithMaxMessageSize), is split into chunk records, each holding
+// at most this much of it. Poll, GetMessage and the like reassemble them
+// transparently. This lets the store accept messages larger than the maximum
+// file size, since the chunks of a message are written together to a message
+// file of their own, which is allowed to exceed it. Keeping them together
+// means that retention, which removes whole files, or cuts files only where a
+// message starts, treats them as a unit. A message whose chunks were not all
+// written, as when a store was interrupted, is dropped whole by RebuildIndex.
+// The chunk size cannot exceed the maximum file size. The default of zero
+// means messages are not chunked.
+func WithChunkSize(size int64) Option {
+	return func(s *FileStore) {
+		s.chunkSize = size
+	}
+}
+
+// WithMaxSegmentAge sets the age beyond which a message file will not be
+// stored to, and a new one will be started instead - regardless of its size.
+// Since RemoveOldMessages rewrites the one file of each topic that straddles
+// the expiry time, this bounds how much of a file it must rewrite, in a topic
+// that is stored to too slowly for its files to fill. The default of zero
+// means files are rolled over only by size. Files whose start time is unknown
+// to the index, having been started before this was introduced, are rolled
+// over straight away.
+func WithMaxSegmentAge(age time.Duration) Option {
+	return func(s *FileStore) {
+		s.maxSegmentAge = age
+	}
+}
+
+// WithSyncOnWrite sets whether the store commits the message file to stable
+// storage after appending each message, and likewise the index after saving
+// it. This makes every stored message durable before Store returns, so an
+// acknowledged message cannot be lost in a crash, but it costs a disk flush
+// per message, so throughput falls markedly - especially on spinning disks.
+// The default is off, in which case callers can use Flush to force
+// durability at points of their choosing, such as after a batch.
+func WithSyncOnWrite(enabled bool) Option {
+	return func(s *FileStore) {
+		s.syncOnWrite = enabled
+	}
+}
+
+// WithSerializer sets how the store encodes the record it writes to a message
+// file for each message. The default is records.GobSerializer, which is
+// compact, but Go-specific. Message files are not marked with the serializer
+// that wrote them, so a store must be opened with the same serializer every
+// time.
+func WithSerializer(serializer records.Serializer) Option {
+	return func(s *FileStore) {
+		s.serializer = serializer
+	}
+}
+
+// WithDirPerm sets the permissions with which the store creates directories -
+// the root directory (when it does not exist), and one per topic. The default
+// is 0755. (The process's umask is applied, as usual.)
+func WithDirPerm(perm os.FileMode) Option {
+	return func(s *FileStore) {
+		s.dirPerm = perm
+	}
+}
+
+// WithFilePerm sets the permissions with which the store creates files -
+// the message files, the index, and the other files it keeps in the root
+// directory. The default is 0644. (The process's umask is applied, as usual.)
+// Files that are rewritten keep their existing permissions.
+func WithFilePerm(perm os.FileMode) Option {
+	return func(s *FileStore) {
+		s.filePerm = perm
+	}
+}
+
+// WithFileNamer sets the naming scheme of the store's files and directories -
+// for example one that gives message files sequential names, which sort in
+// the order they were created. The default is filenamer.DefaultNamer, which
+// gives them random names. A store must be opened with the same naming scheme
+// every time, since it finds its files by their names.
+func WithFileNamer(namer filenamer.FileNamer) Option {
+	return func(s *FileStore) {
+		s.namer = namer
+	}
+}
+
+// WithTopicPattern restricts the topic names the store accepts to those that
+// match the given pattern - for example `^[a-z0-9_-]+$`. (Anchor it, to
+// constrain the whole name.) It is applied in addition to the checks that
+// make sure a topic name is safe to use as a directory name, so it cannot be
+// used to relax them.
+func WithTopicPattern(pattern *regexp.Regexp) Option {
+	return func(s *FileStore) {
+		s.topicPattern = pattern
+	}
+}
+
+// WithCompression sets whether the store compresses the records it writes to
+// message files, using gzip. Each record is compressed on its own, so this
+// pays off for large messages of text, rather than for small ones. The
+// maximum file size (see WithMaxFileSize) then limits the compressed size of
+// the file, and of a message. Whether a file's records are compressed is
+// recorded in the index, so a store can switch compression on or off at any
+// time - new messages then go in a new file, and those in the existing files
+// remain readable. The default is off.
+func WithCompression(enabled bool) Option {
+	return func(s *FileStore) {
+		s.compress = enabled
+	}
+}
+
+// WithEncryptionKey sets the key with which the store encrypts the records it
+// writes to message files, using AES-GCM - so that their contents cannot be
+// read by anyone without it, and so that tampering with them is detected.
+// The key must be 16, 24, or 32 bytes long, to select AES-128, AES-192, or
+// AES-256. Each record is encrypted on its own, with a nonce of its own, so
+// records can still be appended, and read from the middle of a file. The
+// records are marked as encrypted, so a store can switch encryption on at
+// any time, and those written before then remain readable. One that cannot
+// be decrypted, because the store was opened with a different key, or none,
+// is reported with an error that wraps ErrDecryptionFailed, and is never
+// discarded as corrupt. Records are compressed (see WithCompression) before
+// they are encrypted. The index and the committed offsets, which hold no
+// messages, are not encrypted. The default is no encryption.
+func WithEncryptionKey(key []byte) Option {
+	return func(s *FileStore) {
+		s.encryptionSecret = key
+	}
+}
+
+// WithIndexFlushInterval sets how long the index, which the store holds in
+// memory, may go without being persisted to disk after it changes. It is then
+// persisted by the next operation that changes it, or by Flush or Close. The
+// default of zero persists it after every change, which costs a rewrite of
+// the whole index per Store. Should the store not be closed cleanly, the
+// index persisted may lag behind the message files, in which case it is
+// rebuilt from them when the store is next opened.
+func WithIndexFlushInterval(interval time.Duration) Option {
+	return func(s *FileStore) {
+		s.indexFlushInterval = interval
+	}
+}
+
+// WithMmapReads sets whether Poll, and its variants, and GetMessage read the
+// message files through memory mappings, rather than opening and reading
+// them each time - which pays off when the same files are read repeatedly,
+// as when consumers replay a topic. For each topic, the current message file
+// and the one read most recently besides are kept mapped, until the topic
+// rolls over to a new file, or its files are rewritten, or the store is
+// closed. Where memory mapping is not available, the files are read in the
+// regular way regardless. A Serializer (see WithSerializer) must not retain
+// the bytes it is given to decode, since they may be unmapped afterwards. The
+// default is off.
+func WithMmapReads(enabled bool) Option {
+	return func(s *FileStore) {
+		s.mmapReads = enabled
+	}
+}
+
+// WithRebuildCorruptIndex sets whether NewFileStore (and Restore) rebuild an
+// index file that cannot be decoded from the message files, as RebuildIndex
+// does, rather than failing with an error that wraps
+// contract.ErrCorruptIndex. The default is to fail, so that the caller learns
+// of the corruption, and chooses to rebuild. (An index left stale by a store
+// that was not closed cleanly is rebuilt regardless.)
+func WithRebuildCorruptIndex(enabled bool) Option {
+	return func(s *FileStore) {
+		s.rebuildCorruptIndex = enabled
+	}
 }
 
 // NewFileStore provides an intialised FileStore object based on the root
 // directory provided. It either consumes the file store that is already
-// persisted there, or sets up a new one if there isn't one there.
-func NewFileStore(rootDir string) (*FileStore, error) {
+// persisted there, or sets up a new one if there isn't one there. Only one
+// FileStore at a time should use a given root directory. It returns
+// ErrRootDirIsFile if the root directory path is occupied by a file, and
+// ErrRootDirNotWritable if the store would be unable to write to it - and an
+// error that wraps contract.ErrCorruptIndex when the index file there cannot
+// be decoded (unless WithRebuildCorruptIndex says to rebuild it, as the
+// RebuildIndex function can). The store's default settings can be
+// overridden by passing in Options.
+func NewFileStore(rootDir string, options ...Option) (*FileStore, error) {
+	store := &FileStore{RootDir: rootDir,
+		clock:             systemClock{},
+		sleep:             sleepContext,
+		rename:            os.Rename,
+		serializer:        records.DefaultSerializer,
+		dirPerm:           actions.DefaultDirPerm,
+		filePerm:          actions.DefaultFilePerm,
+		namer:             filenamer.DefaultNamer{},
+		idempotencyWindow: DefaultIdempotencyWindow,
+		asyncQueueSize:    DefaultAsyncQueueSize,
+		tracer:            defaultTracer(),
+		deadLettersSeen:   map[string]map[recordPlace]bool{}}
+	for _, option := range options {
+		option(store)
+	}
+	if store.tracer == nil {
+		store.tracer = defaultTracer()
+	}
+	if store.clock == nil {
+		store.clock = systemClock{}
+	}
+	if store.namer == nil {
+		store.namer = filenamer.DefaultNamer{}
+	}
+	if store.maxFileSize < 0 {
+		return nil, fmt.Errorf("maximum file size must not be negative: %d",
+			store.maxFileSize)
+	}
+	if store.maxMessageSize < 0 ||
+		store.maxMessageSize > records.MaxFramedSize {
+		return nil, fmt.Errorf(
+			"maximum message size must be from 0 to %d: %d",
+			records.MaxFramedSize, store.maxMessageSize)
+	}
+	maxChunkSize := store.maxFileSize
+	if maxChunkSize == 0 {
+		maxChunkSize = actions.DefaultMaximumFileSize
+	}
+	if maxChunkSize > records.MaxChunkSize {
+		maxChunkSize = records.MaxChunkSize
+	}
+	if store.chunkSize < 0 || store.chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("chunk size must be from 0 to %d: %d",
+			maxChunkSize, store.chunkSize)
+	}
+	if store.maxSegmentAge < 0 {
+		return nil, fmt.Errorf("maximum segment age must not be negative: %v",
+			store.maxSegmentAge)
+	}
+	if store.idempotencyWindow < 1 {
+		return nil, fmt.Errorf("idempotency window must be positive: %d",
+			store.idempotencyWindow)
+	}
+	if store.asyncQueueSize < 1 {
+		return nil, fmt.Errorf("async queue size must be positive: %d",
+			store.asyncQueueSize)
+	}
+	if store.indexFlushInterval < 0 {
+		return nil, fmt.Errorf("index flush interval must not be negative: %v",
+			store.indexFlushInterval)
+	}
+	if (store.messageRate != nil && store.messageRate.rate < 0) ||
+		(store.byteRate != nil && store.byteRate.rate < 0) {
+		return nil, fmt.Errorf("rate limits must not be negative")
+	}
+	if store.encryptionSecret != nil {
+		// Key ids are reserved for when keys come to be rotated.
+		key, err := records.NewKey(0, store.encryptionSecret)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key: %v", err)
+		}
+		store.encryptionKey = key
+	}
+	if store.deadLetterTopic != "" {
+		err := store.validateTopic(store.deadLetterTopic)
+		if err != nil {
+			return nil, fmt.Errorf("dead-letter topic: %w", err)
+		}
+	}
+	// Refuse a path that is occupied by something other than a directory.
+	info, err := os.Stat(rootDir)
+	if err == nil && info.IsDir() == false {
+		return nil, fmt.Errorf("%w: %s", ErrRootDirIsFile, rootDir)
+	}
 	// Create the root directory if it does not exist.
-	err := ioutils.CreateDirIfDoesntExist(rootDir)
+	err = ioutils.CreateDirIfDoesntExist(rootDir, store.dirPerm)
 	if err != nil {
 		return nil, fmt.Errorf("ioutils.CreateDirIfDoesntExist(): %v", err)
 	}
+	// Make sure we can write to it.
+	err = ioutils.CheckDirIsWritable(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRootDirNotWritable, err)
+	}
 	// Create and persist a blank index file if doesn't exist.
-	indexFilePath := filenamer.IndexFile(rootDir)
+	indexFilePath := store.namer.IndexFile(rootDir)
 	if ioutils.Exists(indexFilePath) == false {
 		index := indexing.NewIndex()
-		err := index.Save(indexFilePath)
+		err := index.Save(indexFilePath, store.filePerm)
 		if err != nil {
 			return nil, fmt.Errorf("index.Save(): %v", err)
 		}
 	}
-	return &FileStore{rootDir}, nil
+	err = store.loadIndex()
+	if err != nil {
+		return nil, fmt.Errorf("loadIndex(): %w", err)
+	}
+	store.deliverDeadLetters()
+	return store, nil
 }
 
 // ------------------------------------------------------------------------
 // METHODS TO SATISFY THE BackingStore INTERFACE.
 //
 // Most of these methods delegate to a helper function, but wrap it the
-// call in a mutex().
+// call in the store's mutex.
 // ------------------------------------------------------------------------
 
 // DeleteContents removes all contents from the store.
-func (s FileStore) DeleteContents() error {
-	mutex.Lock()
-	defer mutex.Unlock()
+func (s *FileStore) DeleteContents(ctx context.Context) error {
+	s.maintenanceMutex.Lock()
+	defer s.maintenanceMutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	return s.deleteContents()
 }
 
+// DeleteTopic is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (s *FileStore) DeleteTopic(ctx context.Context, topic string) error {
+	err := s.validateTopic(topic)
+	if err != nil {
+		return err
+	}
+
+	s.maintenanceMutex.Lock()
+	defer s.maintenanceMutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	index := s.index
+	err = s.prepareToChangeIndex()
+	if err != nil {
+		return fmt.Errorf("prepareToChangeIndex(): %v", err)
+	}
+	err = s.closeFiles()
+	if err != nil {
+		return fmt.Errorf("closeFiles(): %v", err)
+	}
+	defer s.pruneCachedRecords()
+
+	// Delegate to a DeleteTopicAction instance.
+	deleteTopicAction := actions.DeleteTopicAction{
+		Topic: topic, Index: index, RootDir: s.RootDir, Namer: s.namer}
+	err = deleteTopicAction.DeleteTopic()
+	if err != nil {
+		return fmt.Errorf("deleteTopicAction.DeleteTopic(): %v", err)
+	}
+	s.forgetKeyWindows(topic)
+	s.forgetDeadLetters(topic)
+
+	err = s.saveIndex(index)
+	if err != nil {
+		return fmt.Errorf("SaveIndex(): %v", err)
+	}
+
+	// Committed offsets would be meaningless for a topic recreated later.
+	committed, err := s.retrieveOffsets()
+	if err != nil {
+		return fmt.Errorf("retrieveOffsets(): %v", err)
+	}
+	committed.ForgetTopic(topic)
+	err = committed.Save(s.namer.OffsetsFile(s.RootDir), s.filePerm)
+	if err != nil {
+		return fmt.Errorf("committed.Save(): %v", err)
+	}
+	return nil
+}
+
 // Store is defined by, and documented in the backends/contract/BackingStore
 // interface.
-func (s FileStore) Store(topic string, message minikafka.Message) (
-	messageNumber int, err error) {
+func (s *FileStore) Store(ctx context.Context, topic string,
+	message minikafka.Message) (messageNumber int, err error) {
+	ctx, span := s.startSpan(ctx, "Store", topicAttribute.String(topic))
+	span.SetAttributes(messageAttributes([]minikafka.Message{message})...)
+	defer func() { endSpan(span, err) }()
+
+	messageNumber, _, err = s.storeBatch(ctx, topic,
+		[]pendingMessage{{KeyedMessage: KeyedMessage{Message: message}}})
+	if err != nil {
+		return -1, err
+	}
+	return messageNumber, nil
+}
 
-	mutex.Lock()
-	defer mutex.Unlock()
+// RemoveOldMessages is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (s *FileStore) RemoveOldMessages(
+	ctx context.Context, maxAge time.Time) (err error) {
+	ctx, span := s.startSpan(ctx, "RemoveOldMessages")
+	defer func() { endSpan(span, err) }()
 
-	// Establish the index, - either virgin, or deserialised from disk.
-	index := indexing.NewIndex()
-	indexPath := filenamer.IndexFile(s.RootDir)
-	if ioutils.Exists(indexPath) {
-		err = index.PopulateFromDisk(filenamer.IndexFile(s.RootDir))
+	return s.changeIndex(func(index *indexing.Index) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Delegate to a RemoveOldMessagesAction instance.
+		rmOldAction := actions.RemoveOldMessagesAction{
+			MaxAge: maxAge, Index: index, RootDir: s.RootDir, Namer: s.namer}
+		_, removed, err := rmOldAction.RemoveOldMessages()
+		nRemoved := 0
+		for _, numbers := range removed {
+			nRemoved += len(numbers)
+		}
+		span.SetAttributes(messageCountAttribute.Int(nRemoved))
 		if err != nil {
-			return -1, fmt.Errorf("index.PopulateFromDisk(): %v", err)
+			return fmt.Errorf("rmOldAction.RemoveOldMessages(): %w", err)
 		}
+		return nil
+	})
+}
+
+// Poll is defined by, and documented in the backends/contract/BackingStore
+// interface. See PollN about corrupt records.
+func (s *FileStore) Poll(ctx context.Context, topic string, readFrom int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	ctx, span := s.startSpan(ctx, "Poll", topicAttribute.String(topic))
+	defer func() {
+		span.SetAttributes(messageAttributes(foundMessages)...)
+		endSpan(span, err)
+	}()
+	return s.pollN(ctx, topic, readFrom, 0)
+}
+
+// PollN is defined by, and documented in the backends/contract/BackingStore
+// interface. Records that are found to be corrupt are skipped, rather than
+// failing the poll. The messages that could be read are nonetheless
+// returned, along with an error that wraps ErrCorruptRecords. The context is
+// checked before each message file is read.
+func (s *FileStore) PollN(ctx context.Context, topic string, readFrom int,
+	maxMessages int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	ctx, span := s.startSpan(ctx, "PollN", topicAttribute.String(topic))
+	defer func() {
+		span.SetAttributes(messageAttributes(foundMessages)...)
+		endSpan(span, err)
+	}()
+	return s.pollN(ctx, topic, readFrom, maxMessages)
+}
+
+// pollN is the implementation of PollN, which is shared with Poll.
+func (s *FileStore) pollN(ctx context.Context, topic string, readFrom int,
+	maxMessages int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	defer s.deliverDeadLetters()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, -1, ErrStoreClosed
 	}
 
-	// Delegate to a StoreAction instance.
-	storeAction := actions.StoreAction{
-		Topic: topic, Message: message, Index: index, RootDir: s.RootDir}
-	messageNumber, _, err = storeAction.Store()
+	index := s.index
 
-	// Finish up by mandating the index to re-save itself to disk, ready
-	// for the next API operation to pick up.
-	err = index.Save(filenamer.IndexFile(s.RootDir))
+	foundMessages, newReadFrom, err = s.poll(
+		ctx, index, topic, readFrom, maxMessages)
+	if errors.Is(err, ErrCorruptRecords) {
+		return foundMessages, newReadFrom, err
+	}
 	if err != nil {
-		return -1, fmt.Errorf("SaveIndex(): %v", err)
+		return nil, -1, fmt.Errorf("poll(): %w", err)
 	}
+	return foundMessages, newReadFrom, nil
+}
 
-	return messageNumber, nil
+// PollBlocking is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (s *FileStore) PollBlocking(
+	ctx context.Context, topic string, readFrom int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	err = s.validateTopic(topic)
+	if err != nil {
+		return nil, -1, err
+	}
+	for {
+		// Obtain the wake-up channel before looking, so that a message stored
+		// in between cannot be missed.
+		wakeUp := s.notifier.Wait(topic)
+		foundMessages, newReadFrom, err = s.pollIfTopicKnown(
+			ctx, topic, readFrom)
+		if err != nil {
+			return nil, -1, fmt.Errorf("pollIfTopicKnown(): %w", err)
+		}
+		if len(foundMessages) > 0 {
+			return foundMessages, newReadFrom, nil
+		}
+		select {
+		case <-ctx.Done():
+			return []minikafka.Message{}, readFrom, ctx.Err()
+		case <-wakeUp:
+		}
+	}
 }
 
-// RemoveOldMessages is defined by, and documented in the
+// Subscribe is defined by, and documented in the
 // backends/contract/BackingStore interface.
-func (s FileStore) RemoveOldMessages(maxAge time.Time) error {
+func (s *FileStore) Subscribe(ctx context.Context, topic string, readFrom int) (
+	<-chan minikafka.Message, <-chan error) {
+	return notify.Subscribe(ctx, s.PollBlocking, topic, readFrom)
+}
 
-	mutex.Lock()
-	defer mutex.Unlock()
+// ListTopics is defined by, and documented in the
+// backends/contract/BackingStore interface. It takes the topics from the
+// index.
+func (s *FileStore) ListTopics(ctx context.Context) (
+	topics []string, err error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+	return s.index.Topics(), nil
+}
 
-	// Establish the index, - either virgin, or deserialised from disk.
-	index := indexing.NewIndex()
-	indexPath := filenamer.IndexFile(s.RootDir)
-	if ioutils.Exists(indexPath) {
-		err := index.PopulateFromDisk(filenamer.IndexFile(s.RootDir))
-		if err != nil {
-			return fmt.Errorf("index.PopulateFromDisk(): %v", err)
+// ------------------------------------------------------------------------
+// ADDITIONAL METHODS, NOT PART OF THE BackingStore INTERFACE.
+// ------------------------------------------------------------------------
+
+// StoreBatch is like Store, except that it stores a sequence of messages to
+// the topic, assigning them consecutive message numbers, and reports the
+// first and last of these. It is much faster than the equivalent Store calls,
+// because the index is saved only once. Should it fail part way
+// through, the index is nonetheless saved, so that it remains consistent with
+// the messages that were written. The same is true when the context is
+// cancelled part way through, which is checked before each message is stored.
+// Either way, the error is returned along with the first and last numbers of
+// the messages that were stored, which are those at the start of the batch -
+// or -1 for both, when none were - so that a retry can carry on with the
+// rest.
+func (s *FileStore) StoreBatch(ctx context.Context, topic string,
+	messages []minikafka.Message) (
+	firstNumber int, lastNumber int, err error) {
+	ctx, span := s.startSpan(ctx, "StoreBatch", topicAttribute.String(topic))
+	span.SetAttributes(messageAttributes(messages)...)
+	defer func() { endSpan(span, err) }()
+
+	batch := make([]pendingMessage, len(messages))
+	for i, message := range messages {
+		batch[i].Message = message
+	}
+	return s.storeBatch(ctx, topic, batch)
+}
+
+// StoreKeyed is like Store, except that it stores the given key alongside the
+// message. The key can be recovered with PollKeyed.
+func (s *FileStore) StoreKeyed(ctx context.Context, topic string, key []byte,
+	message minikafka.Message) (int, error) {
+	msgNumber, _, err := s.storeBatch(ctx, topic, []pendingMessage{
+		{KeyedMessage: KeyedMessage{Key: key, Message: message}}})
+	if err != nil {
+		return -1, fmt.Errorf("storeBatch(): %w", err)
+	}
+	return msgNumber, nil
+}
+
+// StoreWithHeaders is like Store, except that it stores the given headers
+// alongside the message - such as those used for tracing, or routing. They
+// can be recovered with PollKeyed.
+func (s *FileStore) StoreWithHeaders(ctx context.Context, topic string,
+	message minikafka.Message, headers map[string]string) (int, error) {
+	msgNumber, _, err := s.storeBatch(ctx, topic, []pendingMessage{
+		{KeyedMessage: KeyedMessage{Headers: headers, Message: message}}})
+	if err != nil {
+		return -1, fmt.Errorf("storeBatch(): %w", err)
+	}
+	return msgNumber, nil
+}
+
+// StoreRecords is defined by, and documented in the
+// backends/contract/RecordStore interface. Should it fail part way through,
+// the records already stored remain, and their first and last numbers are
+// returned along with the error, as they are by StoreBatch.
+func (s *FileStore) StoreRecords(ctx context.Context, topic string,
+	toStore []contract.Record) (firstNumber int, lastNumber int, err error) {
+	batch := make([]pendingMessage, len(toStore))
+	for i, record := range toStore {
+		if i > 0 && record.Number <= toStore[i-1].Number {
+			return -1, -1, fmt.Errorf(
+				"record numbers are not ascending: %d follows %d",
+				record.Number, toStore[i-1].Number)
+		}
+		batch[i].KeyedMessage = KeyedMessage{Key: record.Key,
+			Headers: record.Headers, Message: record.Message}
+		batch[i].created = record.Time
+		if i > 0 {
+			batch[i].skip = record.Number - toStore[i-1].Number - 1
 		}
 	}
+	return s.storeBatch(ctx, topic, batch)
+}
+
+// ExportTopic writes all the messages the store retains for the topic to the
+// given writer, along with their metadata, in a self-describing format that
+// ImportTopic - of this, or any other store that offers RecordStore - can
+// read. See snapshot.ExportTopic.
+func (s *FileStore) ExportTopic(ctx context.Context, topic string,
+	w io.Writer) error {
+	return snapshot.ExportTopic(ctx, s, topic, w)
+}
 
-	// Delegate to a RemoveOldMessagesAction instance.
-	rmOldAction := actions.RemoveOldMessagesAction{
-		MaxAge: maxAge, Index: index, RootDir: s.RootDir}
-	_, _, err := rmOldAction.RemoveOldMessages()
+// ImportTopic stores the messages in a snapshot written by ExportTopic in the
+// given topic. See snapshot.ImportTopic.
+func (s *FileStore) ImportTopic(ctx context.Context, topic string,
+	r io.Reader) error {
+	return snapshot.ImportTopic(ctx, s, topic, r)
+}
 
-	// Finish up by mandating the index to re-save itself to disk, ready
-	// for the next API operation to pick up.
-	err = index.Save(filenamer.IndexFile(s.RootDir))
+// StoreTransaction is like StoreBatch, except that it stores either all of
+// the messages, or none of them. Should storing any of them fail - or the
+// context be cancelled part way through - those already appended are
+// truncated away, any message files started for them are removed, and the
+// index is left exactly as it was, so that the message numbers are not
+// advanced either. The store's mutex is held throughout, so that what has
+// been stored cannot be seen until the transaction is committed. This holds
+// up polls, and stores to other topics, for as long as it takes.
+func (s *FileStore) StoreTransaction(ctx context.Context, topic string,
+	messages []minikafka.Message) (
+	firstNumber int, lastNumber int, err error) {
+	stored, err := s.storeTransaction(ctx, topic, messages)
 	if err != nil {
-		return fmt.Errorf("SaveIndex(): %v", err)
+		return -1, -1, err
 	}
+	s.fireOnStore(topic, stored)
+	return stored[0].messageNumber, stored[len(stored)-1].messageNumber, nil
+}
 
-	return nil
+// PollKeyed is like Poll, except that it provides the key and headers stored
+// with each message too. Messages stored without a key have a nil Key, and
+// those stored without headers have nil Headers. Corrupt records are treated
+// as they are by PollN.
+func (s *FileStore) PollKeyed(ctx context.Context, topic string,
+	readFrom int) (foundMessages []KeyedMessage, newReadFrom int, err error) {
+	found, newReadFrom, err := s.PollRecords(ctx, topic, readFrom)
+	if err != nil && errors.Is(err, ErrCorruptRecords) == false {
+		return nil, -1, err
+	}
+	foundMessages = make([]KeyedMessage, len(found))
+	for i, record := range found {
+		foundMessages[i] = KeyedMessage{Key: record.Key,
+			Headers: record.Headers, Message: record.Message}
+	}
+	return foundMessages, newReadFrom, err
 }
 
-// Poll is defined by, and documented in the backends/contract/BackingStore
-// interface.
-func (s FileStore) Poll(topic string, readFrom int) (
-	foundMessages []minikafka.Message, newReadFrom int, err error) {
+// PollRecords is defined by, and documented in the
+// backends/contract/RecordStore interface. Each Record carries the message
+// number, creation time, key and headers stored with the message - so that a
+// consumer can, for example, commit the offset of precisely the message it
+// has processed. Corrupt records are treated as they are by PollN.
+func (s *FileStore) PollRecords(ctx context.Context, topic string,
+	readFrom int) (found []contract.Record, newReadFrom int, err error) {
+	defer s.deliverDeadLetters()
 
-	mutex.Lock()
-	defer mutex.Unlock()
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, -1, ErrStoreClosed
+	}
 
-	// Establish the index, - either virgin, or deserialised from disk.
-	index := indexing.NewIndex()
-	indexPath := filenamer.IndexFile(s.RootDir)
-	if ioutils.Exists(indexPath) {
-		err = index.PopulateFromDisk(filenamer.IndexFile(s.RootDir))
-		if err != nil {
-			return nil, -1, fmt.Errorf("index.PopulateFromDisk(): %v", err)
+	index := s.index
+
+	found, newReadFrom, err = s.pollRecords(ctx, index, topic, readFrom, 0)
+	if errors.Is(err, ErrCorruptRecords) {
+		return found, newReadFrom, err
+	}
+	if err != nil {
+		return nil, -1, fmt.Errorf("pollRecords(): %w", err)
+	}
+	return found, newReadFrom, nil
+}
+
+// PollMulti is like PollRecords, except that it polls several topics at once,
+// from the same snapshot of the index - for a consumer that is subscribed to
+// more than one. It provides the records found for each topic, and each
+// topic's advised new read-from message number, keyed on topic. A topic's
+// read-from is taken from the readFrom map given, and a topic missing from
+// that is read from its start. A topic that does not exist (yet) is treated
+// as having no messages, so that its read-from comes back unchanged. When
+// corrupt records are met, every topic is polled nonetheless, and the error
+// returned wraps ErrCorruptRecords.
+func (s *FileStore) PollMulti(ctx context.Context, topics []string,
+	readFrom map[string]int) (found map[string][]contract.Record,
+	newReadFrom map[string]int, err error) {
+	defer s.deliverDeadLetters()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, nil, ErrStoreClosed
+	}
+
+	index := s.index
+
+	found = map[string][]contract.Record{}
+	newReadFrom = map[string]int{}
+	var corruptErr error
+	for _, topic := range topics {
+		if _, ok := index.MessageFileLists[topic]; ok == false {
+			err = s.validateTopic(topic)
+			if err != nil {
+				return nil, nil, err
+			}
+			found[topic] = []contract.Record{}
+			newReadFrom[topic] = readFrom[topic]
+			continue
+		}
+		topicFound, topicReadFrom, err := s.pollRecords(
+			ctx, index, topic, readFrom[topic], 0)
+		if errors.Is(err, ErrCorruptRecords) {
+			corruptErr = err
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("pollRecords(): %w", err)
 		}
+		found[topic] = topicFound
+		newReadFrom[topic] = topicReadFrom
 	}
+	return found, newReadFrom, corruptErr
+}
 
-	// Delegate to a PollAction instance.
-	pollAction := actions.PollAction{
-		Topic:    topic,
-		ReadFrom: readFrom,
-		Index:    index,
-		RootDir:  s.RootDir}
-	foundMessages, newReadFrom, err = pollAction.Poll()
-	if err != nil {
-		return nil, -1, fmt.Errorf("possAction.Poll(): %v", err)
+// MessageCount provides how many messages are currently retained for the
+// given topic. It is derived from the index, without looking inside any
+// message files. An unknown topic has a count of zero.
+func (s *FileStore) MessageCount(topic string) (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return 0, ErrStoreClosed
+	}
+
+	index := s.index
+	msgFileList, ok := index.MessageFileLists[topic]
+	if ok == false {
+		return 0, nil
+	}
+	return msgFileList.NumMessages(), nil
+}
+
+// HighWaterMark provides the highest message number stored in the given
+// topic that is still retained. This is distinct from the number that will be
+// assigned to the next message stored, and is what a consumer should compare
+// its read position with to measure its lag. It is derived from the index,
+// without looking inside any message files. When the topic holds no messages
+// (or is unknown), it is -1.
+func (s *FileStore) HighWaterMark(topic string) (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return -1, ErrStoreClosed
+	}
+
+	index := s.index
+	msgFileList, ok := index.MessageFileLists[topic]
+	if ok == false {
+		return -1, nil
+	}
+	return msgFileList.HighWaterMark(), nil
+}
+
+// Bounds provides the lowest message number still retained for the given
+// topic, and the highest - its HighWaterMark. They are derived from the index,
+// without looking inside any message files. When the topic holds no
+// messages (or is unknown), both are returned as -1.
+func (s *FileStore) Bounds(topic string) (oldest int, newest int, err error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return -1, -1, ErrStoreClosed
+	}
+
+	index := s.index
+	msgFileList, ok := index.MessageFileLists[topic]
+	if ok == false {
+		return -1, -1, nil
+	}
+	oldest, newest = msgFileList.Bounds()
+	return oldest, newest, nil
+}
+
+// HasNew says whether the given topic holds any message whose number is at
+// least readFrom, that is still retained - so whether Poll would find
+// anything. It is derived from the index, without looking inside any message
+// files, so it is much cheaper than polling and discarding the messages
+// found. An unknown topic is reported as it is by Poll.
+func (s *FileStore) HasNew(topic string, readFrom int) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return false, ErrStoreClosed
+	}
+
+	msgFileList, ok := s.index.MessageFileLists[topic]
+	if ok == false {
+		return false, fmt.Errorf("%w: %v", contract.ErrTopicNotFound, topic)
 	}
+	// When the oldest messages have been removed, a read position before
+	// those retained is nonetheless caught up with them.
+	highWaterMark := msgFileList.HighWaterMark()
+	return highWaterMark != -1 && highWaterMark >= readFrom, nil
+}
 
-	// Finish up by mandating the index to re-save itself to disk, ready
-	// for the next API operation to pick up.
-	err = index.Save(filenamer.IndexFile(s.RootDir))
+// PollFromTime is like Poll, except that it provides the messages for the
+// topic that were stored at, or after the given time. The advised new
+// read-from message number can be used to carry on with Poll. Errors,
+// including those for corrupt records, are as for PollN.
+func (s *FileStore) PollFromTime(ctx context.Context, topic string,
+	since time.Time) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	err = s.validateTopic(topic)
 	if err != nil {
-		return nil, -1, fmt.Errorf("SaveIndex(): %v", err)
+		return nil, -1, err
+	}
+	defer s.deliverDeadLetters()
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, -1, ErrStoreClosed
 	}
 
+	index := s.index
+	// Use the index to convert the time into a message number to read from.
+	readFrom := int(index.NextMessageNumbers[topic])
+	msgFileList, ok := index.MessageFileLists[topic]
+	if ok {
+		msgNum, found := msgFileList.FirstMessageNumberSince(since)
+		if found {
+			readFrom = int(msgNum)
+		}
+	}
+	foundMessages, newReadFrom, err = s.poll(ctx, index, topic, readFrom, 0)
+	if errors.Is(err, ErrCorruptRecords) {
+		return foundMessages, newReadFrom, err
+	}
+	if err != nil {
+		return nil, -1, fmt.Errorf("poll(): %w", err)
+	}
 	return foundMessages, newReadFrom, nil
 }
 
-// ------------------------------------------------------------------------
-// Miscellaneous Implementation functions.
-// ------------------------------------------------------------------------
-
-func (s FileStore) deleteContents() error {
-	err := ioutils.DeleteDirectoryContents(s.RootDir)
+// GetRange provides the messages in the topic whose numbers fall between from
+// and to (inclusive), in order. It is like Poll, but with an upper bound,
+// which can be beyond the newest message - in which case the range stops at
+// that. Messages that are no longer retained are skipped, so the range can
+// hold fewer messages than it spans, or none. It reports an error when from
+// is greater than to. Otherwise errors, including those for corrupt records,
+// are as for PollN.
+func (s *FileStore) GetRange(ctx context.Context, topic string, from int,
+	to int) ([]minikafka.Message, error) {
+	err := s.validateTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if from > to {
+		return nil, fmt.Errorf("range start (%d) is beyond its end (%d)",
+			from, to)
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+	// Message numbers start at 1, and a ReadTo of zero would mean no limit.
+	if to < 1 {
+		return []minikafka.Message{}, nil
+	}
+
+	pollAction := actions.PollAction{
+		Ctx:           ctx,
+		Topic:         topic,
+		ReadFrom:      from,
+		ReadTo:        to,
+		Index:         s.index,
+		RootDir:       s.RootDir,
+		Serializer:    s.serializer,
+		EncryptionKey: s.encryptionKey,
+		Mappings:      s.readMappings(),
+		Namer:         s.namer}
+	foundMessages, _, err := pollAction.Poll()
+	if errors.Is(err, records.ErrCorruptRecord) {
+		return foundMessages, fmt.Errorf("%w: %v", ErrCorruptRecords, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pollAction.Poll(): %w", err)
+	}
+	return foundMessages, nil
+}
+
+// GetMessage provides the message with the given number in the topic, along
+// with the time it was stored, reading only that message's record. Should
+// the topic hold no such message - because it has been removed by one of the
+// retention methods, or by Compact, or because it was never stored - it
+// returns an error that wraps contract.ErrMessageNotFound. An unknown topic
+// is reported as it is by Poll, and a corrupt record with an error that wraps
+// ErrCorruptRecords.
+func (s *FileStore) GetMessage(topic string, messageNumber int) (
+	message minikafka.Message, created time.Time, err error) {
+	err = s.validateTopic(topic)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, time.Time{}, ErrStoreClosed
+	}
+
+	record, ok := s.cachedRecord(topic, messageNumber)
+	if ok {
+		return record.Message, record.Time, nil
+	}
+	getAction := actions.GetMessageAction{
+		Topic: topic, MessageNumber: messageNumber, Index: s.index,
+		RootDir: s.RootDir, Serializer: s.serializer,
+		EncryptionKey: s.encryptionKey, Mappings: s.readMappings(),
+		Namer: s.namer}
+	storedMsg, err := getAction.GetMessage()
+	if errors.Is(err, records.ErrCorruptRecord) {
+		return nil, time.Time{}, fmt.Errorf("%w: %v", ErrCorruptRecords, err)
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf(
+			"getAction.GetMessage(): %w", err)
+	}
+	return storedMsg.Message, storedMsg.Created, nil
+}
+
+// CommitOffset records the offset (i.e. the next message number to read) that
+// the consumer group has reached for the topic, so that the group can resume
+// from there later - including after the store is reopened.
+func (s *FileStore) CommitOffset(group string, topic string, offset int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	return s.commitOffset(group, topic, offset)
+}
+
+// OffsetPosition says where ResetOffset moves a consumer group's offset to.
+type OffsetPosition int
+
+const (
+	// Earliest is the topic's oldest message still retained, so that the
+	// group reads everything the topic holds.
+	Earliest OffsetPosition = iota
+	// Latest is the message number the topic's next message will be given,
+	// so that the group reads only the messages stored from then on.
+	Latest
+)
+
+// ResetOffset commits, for the consumer group and topic, the offset at the
+// given position in the topic, as it stands - which is where a group that is
+// to skip a backlog, or to reread the topic, should carry on from. When the
+// topic holds no messages, both positions are the message number its next
+// message will be given. An unknown topic is reported as it is by Poll.
+func (s *FileStore) ResetOffset(group string, topic string,
+	position OffsetPosition) error {
+	if position != Earliest && position != Latest {
+		return fmt.Errorf("unknown offset position: %d", position)
+	}
+	return s.resetOffset(group, topic,
+		func(msgFileList *indexing.MessageFileList) (int32, bool) {
+			oldest, _ := msgFileList.Bounds()
+			if position == Latest || oldest == -1 {
+				return 0, false
+			}
+			return int32(oldest), true
+		})
+}
+
+// ResetOffsetToTime is like ResetOffset, except that the offset it commits is
+// that of the topic's first message stored at, or after the given time - as
+// found by PollFromTime. When there is none, it is the message number the
+// topic's next message will be given.
+func (s *FileStore) ResetOffsetToTime(group string, topic string,
+	t time.Time) error {
+	return s.resetOffset(group, topic,
+		func(msgFileList *indexing.MessageFileList) (int32, bool) {
+			return msgFileList.FirstMessageNumberSince(t)
+		})
+}
+
+// resetOffset commits, for the consumer group and topic, the offset that the
+// given function finds in the topic's message files - or the topic's next
+// message number, when it finds none.
+func (s *FileStore) resetOffset(group string, topic string,
+	find func(msgFileList *indexing.MessageFileList) (int32, bool)) error {
+	err := s.validateTopic(topic)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	index := s.index
+	msgFileList, ok := index.MessageFileLists[topic]
+	if ok == false {
+		return fmt.Errorf("%w: %v", contract.ErrTopicNotFound, topic)
+	}
+	offset, found := find(msgFileList)
+	if found == false {
+		offset = index.NextMessageNumberFor(topic)
+	}
+	return s.commitOffset(group, topic, int(offset))
+}
+
+// commitOffset is CommitOffset, for callers that hold the store's mutex for
+// writing.
+func (s *FileStore) commitOffset(group string, topic string,
+	offset int) error {
+	committed, err := s.retrieveOffsets()
+	if err != nil {
+		return fmt.Errorf("retrieveOffsets(): %v", err)
+	}
+	committed.Commit(group, topic, offset)
+	err = committed.Save(s.namer.OffsetsFile(s.RootDir), s.filePerm)
+	if err != nil {
+		return fmt.Errorf("committed.Save(): %v", err)
+	}
+	return nil
+}
+
+// FetchOffset provides the offset most recently committed by the consumer
+// group for the topic. When there is none, it provides the topic's oldest
+// available message number instead, so that a fresh group reads from the
+// beginning.
+func (s *FileStore) FetchOffset(group string, topic string) (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return -1, ErrStoreClosed
+	}
+
+	committed, err := s.retrieveOffsets()
+	if err != nil {
+		return -1, fmt.Errorf("retrieveOffsets(): %v", err)
+	}
+	offset, ok := committed.Fetch(group, topic)
+	if ok {
+		return offset, nil
+	}
+
+	// Fall back to the oldest message retained. When there are none, that
+	// is the next one to be stored.
+	index := s.index
+	msgFileList, ok := index.MessageFileLists[topic]
+	if ok == false {
+		return 1, nil
+	}
+	oldest, _ := msgFileList.Bounds()
+	if oldest == -1 {
+		return int(index.NextMessageNumberFor(topic)), nil
+	}
+	return oldest, nil
+}
+
+// ConsumerLag provides how far the consumer group lags behind the topic: the
+// number of messages from its committed offset (see FetchOffset) up to the
+// topic's HighWaterMark. So it is zero for a group that has read everything.
+// When the offset is before the topic's oldest message still retained, the
+// lag counts only the messages retained, and when it is beyond the message
+// number the topic's next message will be given, the lag is zero - and
+// either way the error returned wraps ErrOffsetOutOfRange. An unknown topic
+// is reported as it is by Poll.
+func (s *FileStore) ConsumerLag(group string, topic string) (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return -1, ErrStoreClosed
+	}
+
+	msgFileList, ok := s.index.MessageFileLists[topic]
+	if ok == false {
+		return -1, fmt.Errorf("%w: %v", contract.ErrTopicNotFound, topic)
+	}
+	committed, err := s.retrieveOffsets()
+	if err != nil {
+		return -1, fmt.Errorf("retrieveOffsets(): %v", err)
+	}
+	offset, ok := committed.Fetch(group, topic)
+	if ok == false {
+		offset, _ = msgFileList.Bounds()
+	}
+	lag, err := s.lagFrom(offset, topic, msgFileList)
+	if err != nil {
+		return lag, fmt.Errorf("group %s: %w", group, err)
+	}
+	return lag, nil
+}
+
+// AllLag is like ConsumerLag, except that it provides the consumer group's
+// lag for each topic it has committed an offset for. A group that has
+// committed none gets an empty map. The error returned wraps
+// ErrOffsetOutOfRange when any of the offsets is out of range.
+func (s *FileStore) AllLag(group string) (map[string]int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+
+	committed, err := s.retrieveOffsets()
+	if err != nil {
+		return nil, fmt.Errorf("retrieveOffsets(): %v", err)
+	}
+	lags := map[string]int{}
+	var outOfRange error
+	for topic, offset := range committed.Committed[group] {
+		msgFileList, ok := s.index.MessageFileLists[topic]
+		if ok == false {
+			continue
+		}
+		lag, err := s.lagFrom(offset, topic, msgFileList)
+		if err != nil && outOfRange == nil {
+			outOfRange = err
+		}
+		lags[topic] = lag
+	}
+	if outOfRange != nil {
+		return lags, fmt.Errorf("group %s: %w", group, outOfRange)
+	}
+	return lags, nil
+}
+
+// lagFrom provides the number of messages in the topic from the given offset
+// up to its high-water mark, as ConsumerLag does, along with an error that
+// wraps ErrOffsetOutOfRange if the offset is out of range. The caller must
+// hold the store's mutex.
+func (s *FileStore) lagFrom(offset int, topic string,
+	msgFileList *indexing.MessageFileList) (int, error) {
+	next := int(s.index.NextMessageNumberFor(topic))
+	oldest, newest := msgFileList.Bounds()
+	if oldest == -1 {
+		oldest = next
+	}
+	var err error
+	if offset < oldest || offset > next {
+		err = fmt.Errorf("%w: %d, when topic %s holds %d to %d",
+			ErrOffsetOutOfRange, offset, topic, oldest, next-1)
+	}
+	if offset < oldest {
+		offset = oldest
+	}
+	if newest == -1 || offset > newest {
+		return 0, err
+	}
+	return newest - offset + 1, err
+}
+
+// SetRetentionCount sets the most messages that TrimToCount will leave in
+// the given topic. A limit of zero removes the topic's limit. Like the other
+// settings, limits are not persisted; they apply only to this FileStore.
+func (s *FileStore) SetRetentionCount(topic string, maxMessages int) error {
+	if maxMessages < 0 {
+		return fmt.Errorf("retention count must not be negative: %d",
+			maxMessages)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	if s.retentionCounts == nil {
+		s.retentionCounts = map[string]int{}
+	}
+	if maxMessages == 0 {
+		delete(s.retentionCounts, topic)
+		return nil
+	}
+	s.retentionCounts[topic] = maxMessages
+	return nil
+}
+
+// TrimToCount removes the oldest messages from each topic that has a
+// retention count set, so that it holds no more than that many. Message files
+// that fall entirely outside the limit are deleted, and the one that
+// straddles it is rewritten. It provides how many messages were removed.
+func (s *FileStore) TrimToCount() (nMessagesRemoved int, err error) {
+	_, span := s.startSpan(context.Background(), "TrimToCount")
+	defer func() {
+		span.SetAttributes(messageCountAttribute.Int(nMessagesRemoved))
+		endSpan(span, err)
+	}()
+
+	err = s.changeIndex(func(index *indexing.Index) error {
+		// Delegate each topic to a TrimToCountAction instance.
+		for topic, maxMessages := range s.retentionCounts {
+			trimAction := actions.TrimToCountAction{
+				Topic: topic, MaxMessages: maxMessages, Index: index,
+				RootDir: s.RootDir, Namer: s.namer}
+			nRemoved, err := trimAction.TrimToCount()
+			if err != nil {
+				return fmt.Errorf("trimAction.TrimToCount(): %w", err)
+			}
+			nMessagesRemoved += nRemoved
+		}
+		return nil
+	})
+	if err != nil {
+		return -1, err
+	}
+	return nMessagesRemoved, nil
+}
+
+// SetRetentionBytes sets the total size of message files that TrimToSize
+// will leave in the given topic. A limit of zero removes the topic's limit.
+// Like the other settings, limits are not persisted; they apply only to this
+// FileStore.
+func (s *FileStore) SetRetentionBytes(topic string, maxBytes int64) error {
+	if maxBytes < 0 {
+		return fmt.Errorf("retention bytes must not be negative: %d", maxBytes)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	if s.retentionBytes == nil {
+		s.retentionBytes = map[string]int64{}
+	}
+	if maxBytes == 0 {
+		delete(s.retentionBytes, topic)
+		return nil
+	}
+	s.retentionBytes[topic] = maxBytes
+	return nil
+}
+
+// TrimToSize removes the oldest message files from each topic that has a
+// retention size set, until the topic's message files are within it. The
+// newest message file is always kept, so a topic is never emptied, even when
+// that file alone exceeds the limit. It provides how many messages were
+// removed.
+func (s *FileStore) TrimToSize() (nMessagesRemoved int, err error) {
+	_, span := s.startSpan(context.Background(), "TrimToSize")
+	defer func() {
+		span.SetAttributes(messageCountAttribute.Int(nMessagesRemoved))
+		endSpan(span, err)
+	}()
+
+	err = s.changeIndex(func(index *indexing.Index) error {
+		// Delegate each topic to a TrimToSizeAction instance.
+		for topic, maxBytes := range s.retentionBytes {
+			trimAction := actions.TrimToSizeAction{
+				Topic: topic, MaxBytes: maxBytes, Index: index,
+				RootDir: s.RootDir, Namer: s.namer}
+			_, nRemoved, err := trimAction.TrimToSize()
+			if err != nil {
+				return fmt.Errorf("trimAction.TrimToSize(): %w", err)
+			}
+			nMessagesRemoved += nRemoved
+		}
+		return nil
+	})
+	if err != nil {
+		return -1, err
+	}
+	return nMessagesRemoved, nil
+}
+
+// quota is the most a topic is allowed to hold. Zero means no limit.
+type quota struct {
+	maxBytes    int64
+	maxMessages int
+}
+
+// SetQuota sets a hard limit on how much the given topic can hold, so that
+// one topic cannot take over the whole disk. Once the topic holds maxMessages
+// messages, or a message would take its message files beyond maxBytes, the
+// Store methods refuse to store to it, with an error that wraps
+// contract.ErrQuotaExceeded. (As opposed to retention, which removes the
+// oldest messages to make room.) Messages already stored are not affected,
+// and removing messages makes room again. Either limit can be zero, to mean
+// no limit, and setting both to zero removes the topic's quota. Like the
+// other settings, quotas are not persisted; they apply only to this
+// FileStore.
+func (s *FileStore) SetQuota(topic string, maxBytes int64,
+	maxMessages int) error {
+	if maxBytes < 0 || maxMessages < 0 {
+		return fmt.Errorf(
+			"quota must not be negative: %d bytes, %d messages",
+			maxBytes, maxMessages)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	if s.quotas == nil {
+		s.quotas = map[string]quota{}
+	}
+	if maxBytes == 0 && maxMessages == 0 {
+		delete(s.quotas, topic)
+		return nil
+	}
+	s.quotas[topic] = quota{maxBytes: maxBytes, maxMessages: maxMessages}
+	return nil
+}
+
+// TruncateBefore removes the messages in the topic whose numbers are below
+// the given one - such as those that every consumer has acknowledged - so
+// that it becomes the topic's oldest. Message files that fall entirely before
+// it are deleted, and the one that straddles it is rewritten. It does nothing
+// when the message number is at, or below the oldest. It provides the numbers
+// of the messages removed. An unknown topic is reported as it is by Poll.
+func (s *FileStore) TruncateBefore(topic string, messageNumber int) (
+	removed []int, err error) {
+	_, span := s.startSpan(context.Background(), "TruncateBefore",
+		topicAttribute.String(topic))
+	defer func() {
+		span.SetAttributes(messageCountAttribute.Int(len(removed)))
+		endSpan(span, err)
+	}()
+
+	err = s.validateTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.changeIndex(func(index *indexing.Index) error {
+		// Delegate to a TruncateBeforeAction instance.
+		truncateAction := actions.TruncateBeforeAction{
+			Topic: topic, MessageNumber: messageNumber, Index: index,
+			RootDir: s.RootDir, Namer: s.namer}
+		removed, err = truncateAction.TruncateBefore()
+		if err != nil {
+			return fmt.Errorf("truncateAction.TruncateBefore(): %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// Compact discards each keyed message in the topic that has been superseded
+// by a later one with the same key, so that only the most recent message for
+// each key remains - which suits topics that hold the latest state of each
+// key. When that is a tombstone (a message stored with a key, but an empty
+// payload), it is discarded too, so that the key is removed entirely.
+// Messages stored without a key are all retained, and the survivors keep
+// their message numbers. The message files affected are rewritten. It
+// provides the numbers of the messages discarded, for each key. An unknown
+// topic is reported as it is by Poll.
+func (s *FileStore) Compact(topic string) (
+	removed map[string][]int, err error) {
+	_, span := s.startSpan(context.Background(), "Compact",
+		topicAttribute.String(topic))
+	defer func() {
+		nRemoved := 0
+		for _, numbers := range removed {
+			nRemoved += len(numbers)
+		}
+		span.SetAttributes(messageCountAttribute.Int(nRemoved))
+		endSpan(span, err)
+	}()
+
+	err = s.validateTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.changeIndex(func(index *indexing.Index) error {
+		// Delegate to a CompactAction instance.
+		compactAction := actions.CompactAction{
+			Topic: topic, Index: index, RootDir: s.RootDir,
+			Serializer: s.serializer, EncryptionKey: s.encryptionKey,
+			Namer: s.namer}
+		removed, err = compactAction.Compact()
+		if err != nil {
+			return fmt.Errorf("compactAction.Compact(): %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// CompactSegments merges the topic's message files into fewer, larger ones -
+// for topics that accumulate many small files, because they are rolled over
+// by age (see WithMaxSegmentAge), which costs reads, and inodes. Each run of
+// consecutive files whose combined size does not exceed the target size is
+// merged into one file, with the messages keeping their order and their
+// message numbers. The file currently being stored to is left alone. Only
+// the messages the store retains are merged, so that none that have been
+// removed come back, and a merged file is removed by RemoveOldMessages only
+// once all of its messages have expired. Should it be interrupted, the store
+// is left consistent, once it has rebuilt its index on being reopened. An
+// unknown topic is reported as it is by Poll.
+func (s *FileStore) CompactSegments(topic string, targetSize int64) (
+	err error) {
+	_, span := s.startSpan(context.Background(), "CompactSegments",
+		topicAttribute.String(topic))
+	defer func() { endSpan(span, err) }()
+
+	err = s.validateTopic(topic)
+	if err != nil {
+		return err
+	}
+	if targetSize <= 0 {
+		return fmt.Errorf("target size must be positive: %d", targetSize)
+	}
+
+	return s.changeIndex(func(index *indexing.Index) error {
+		// Delegate to a CompactSegmentsAction instance.
+		compactAction := actions.CompactSegmentsAction{
+			Topic: topic, TargetSize: targetSize, Index: index,
+			RootDir: s.RootDir, Namer: s.namer}
+		_, err := compactAction.CompactSegments()
+		if err != nil {
+			return fmt.Errorf("compactAction.CompactSegments(): %w", err)
+		}
+		return nil
+	})
+}
+
+// Flush persists the in-memory index if it has unpersisted changes, and
+// commits to stable storage every message file written to since the last
+// Flush, along with the index.
+func (s *FileStore) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	return s.flush()
+}
+
+// Close flushes the store (see Flush), so that everything it holds only in
+// memory is persisted, and then releases it - closing the message files it
+// holds open, and unmapping those it holds mapped. Any method called
+// afterwards, including Close, returns ErrStoreClosed - which is also what any
+// blocked PollBlocking calls, and Subscriptions, report, having been woken up.
+// The janitor, if running, is stopped first, and the messages queued by
+// StoreAsync are stored.
+func (s *FileStore) Close() error {
+	s.StopJanitor()
+	s.stopAsyncStores()
+	s.maintenanceMutex.Lock()
+	defer s.maintenanceMutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	err := s.flush()
+	if err != nil {
+		s.resumeAsyncStores()
+		return fmt.Errorf("flush(): %v", err)
+	}
+	err = s.closeFiles()
+	if err != nil {
+		s.resumeAsyncStores()
+		return fmt.Errorf("closeFiles(): %v", err)
+	}
+	s.closed = true
+	s.notifier.NotifyAll()
+	s.topicNotifier.NotifyAll()
+	return nil
+}
+
+// Shutdown closes the store gracefully, as a server that embeds it should
+// when it is asked to stop. It stops the store accepting messages - so the
+// Store methods return ErrStoreClosed from then on - and waits for the stores
+// already under way to finish, before closing the store as Close does, which
+// persists the index and commits everything written to stable storage. Should
+// the context be done before the stores under way have finished, it persists
+// and commits what has been stored so far nonetheless, and returns
+// ctx.Err(). The store is then left refusing messages, but not closed: Close
+// can be called to finish the job, and waits for the stragglers.
+func (s *FileStore) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrStoreClosed
+	}
+	s.shuttingDown = true
+	s.mutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.storesInFlight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return s.Close()
+	case <-ctx.Done():
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ctx.Err()
+	}
+	err := s.flush()
+	if err != nil {
+		return fmt.Errorf("flush(): %v", err)
+	}
+	return ctx.Err()
+}
+
+// SetOnStore sets a function to be called for each message stored, with its
+// topic, message number, and creation time - or removes it, given nil. It is
+// called once the message has been registered in the index, so the message
+// can be polled, and after the store has released its locks, so it can call
+// the store's methods. When a batch is stored, it is called for each message
+// in turn, once the whole batch has been. Should it panic, the panic is
+// recovered and logged, and the store is unaffected.
+func (s *FileStore) SetOnStore(
+	onStore func(topic string, messageNumber int, created time.Time)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onStore = onStore
+}
+
+// RebuildIndex reconstructs the index from the message files alone, and saves
+// it in place of the existing one. It is the remedy for an index file that has
+// been lost or corrupted. Records it cannot decode, including those that fail
+// their checksum, are dropped from the message files rather than aborting the
+// rebuild, and this is reported by returning the (saved) index along with an
+// error that wraps ErrUnreadableRecords. (They are also sent to any
+// dead-letter topic or writer - see WithDeadLetterTopic.) Message numbers
+// that were issued, but which are no longer retained in any file, cannot be
+// recovered - so should every message of a topic have been removed, its
+// numbering will start afresh.
+func (s *FileStore) RebuildIndex() (*indexing.Index, error) {
+	defer s.deliverDeadLetters()
+	s.maintenanceMutex.Lock()
+	defer s.maintenanceMutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+
+	err := s.closeFiles()
+	if err != nil {
+		return nil, fmt.Errorf("closeFiles(): %v", err)
+	}
+	defer s.forgetCachedRecords()
+	rebuildAction := actions.RebuildIndexAction{RootDir: s.RootDir,
+		Serializer: s.serializer, EncryptionKey: s.encryptionKey,
+		OnCorrupt: s.onCorrupt(), Namer: s.namer}
+	index, problems, err := rebuildAction.RebuildIndex()
+	if err != nil {
+		return nil, fmt.Errorf("rebuildAction.RebuildIndex(): %w", err)
+	}
+	// The files holding the records dropped have been rewritten.
+	s.forgetDeadLetters()
+	s.index = index
+	err = s.persistIndex()
+	if err != nil {
+		return nil, fmt.Errorf("SaveIndex(): %v", err)
+	}
+	if len(problems) != 0 {
+		return index, fmt.Errorf("%w: %s", ErrUnreadableRecords,
+			strings.Join(problems, "; "))
+	}
+	return index, nil
+}
+
+// RebuildIndex is the remedy for a store that NewFileStore will not open,
+// because its index file is corrupt (see contract.ErrCorruptIndex). It opens
+// the store in the given root directory, with the given options, rebuilding
+// the index from the message files - as the RebuildIndex method does, and
+// with the same error when records had to be dropped - and closes it again,
+// so that NewFileStore can then open it.
+func RebuildIndex(rootDir string, options ...Option) error {
+	options = append(options[:len(options):len(options)],
+		func(s *FileStore) { s.setAsideCorruptIndex = true })
+	store, err := NewFileStore(rootDir, options...)
+	if err != nil {
+		return fmt.Errorf("NewFileStore(): %w", err)
+	}
+	_, err = store.RebuildIndex()
+	closeErr := store.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return fmt.Errorf("store.Close(): %v", closeErr)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------
+// Miscellaneous Implementation functions.
+// ------------------------------------------------------------------------
+
+// storeEvent records a message that has been stored, for the function set
+// by SetOnStore.
+type storeEvent struct {
+	messageNumber int
+	created       time.Time
+}
+
+// pendingMessage is a message that is to be stored, along with how.
+type pendingMessage struct {
+	KeyedMessage
+	created time.Time // Zero means now.
+	skip    int       // Message numbers to leave unallocated before it.
+	queued  bool      // Whether StoreAsync queued it.
+}
+
+// storeBatch is the implementation common to StoreBatch, StoreKeyed and the
+// like. It applies the rate limit set by WithRateLimit before anything else.
+// Having released the locks that appendBatch takes, it calls the function set
+// by SetOnStore for each message stored - including when only some were.
+func (s *FileStore) storeBatch(ctx context.Context, topic string,
+	batch []pendingMessage) (firstNumber int, lastNumber int, err error) {
+	err = s.admit(ctx, batch)
+	if err != nil {
+		return -1, -1, err
+	}
+	firstNumber, lastNumber, stored, err := s.appendBatch(ctx, topic, batch)
+	s.fireOnStore(topic, stored)
+	return firstNumber, lastNumber, err
+}
+
+// appendBatch does the work of storeBatch, taking the locks it needs, and
+// provides the message numbers and creation times of the messages stored -
+// and the first and last of those numbers (or -1 when none were), even when
+// it fails part way through.
+func (s *FileStore) appendBatch(ctx context.Context, topic string,
+	batch []pendingMessage) (firstNumber int, lastNumber int,
+	stored []storeEvent, err error) {
+	defer func(began time.Time) {
+		s.countOperation(StoreOperation, began, err)
+	}(time.Now())
+
+	err = s.validateTopic(topic)
+	if err != nil {
+		return -1, -1, nil, err
+	}
+	if len(batch) == 0 {
+		return -1, -1, nil, fmt.Errorf("no messages to store")
+	}
+
+	// Stores to other topics can proceed alongside this one.
+	s.maintenanceMutex.RLock()
+	defer s.maintenanceMutex.RUnlock()
+	topicLock := s.topicLock(topic)
+	topicLock.Lock()
+	defer topicLock.Unlock()
+	s.mutex.RLock()
+	// Messages queued by StoreAsync before Shutdown was called are already
+	// counted as under way.
+	closed := s.closed || (s.shuttingDown && batch[0].queued == false)
+	_, existed := s.index.MessageFileLists[topic]
+	if closed == false {
+		s.storesInFlight.Add(1)
+	}
+	s.mutex.RUnlock()
+	if closed {
+		return -1, -1, nil, ErrStoreClosed
+	}
+	defer s.storesInFlight.Done()
+
+	// Delegate each message to a StoreAction instance.
+	firstNumber, lastNumber = -1, -1
+	var storeErr error
+	storedMessages := []minikafka.Message{}
+	for _, pending := range batch {
+		storeErr = ctx.Err()
+		if storeErr != nil {
+			break
+		}
+		var messageNumber int
+		var created time.Time
+		messageNumber, created, err = s.storeOne(topic, pending)
+		if err != nil {
+			storeErr = fmt.Errorf("storeOne(): %w", err)
+			break
+		}
+		stored = append(stored, storeEvent{messageNumber, created})
+		storedMessages = append(storedMessages, pending.Message)
+		if firstNumber == -1 {
+			firstNumber = messageNumber
+		}
+		lastNumber = messageNumber
+	}
+
+	// Finish up by mandating the index to be saved to disk, subject to the
+	// index flush interval.
+	s.mutex.Lock()
+	err = s.saveIndex(s.index)
+	if firstNumber != -1 && existed == false {
+		s.noteNewTopic(topic)
+	}
+	s.mutex.Unlock()
+	s.countStored(topic, storedMessages)
+	// The messages stored are in the index held in memory, so they can be
+	// polled, even when it could not be saved.
+	if err != nil {
+		return firstNumber, lastNumber, stored,
+			fmt.Errorf("SaveIndex(): %w", err)
+	}
+	if firstNumber != -1 {
+		s.notifier.Notify(topic)
+	}
+	if storeErr != nil {
+		return firstNumber, lastNumber, stored, storeErr
+	}
+
+	return firstNumber, lastNumber, stored, nil
+}
+
+// fireOnStore calls the function set by SetOnStore, if any, for each of the
+// stored messages given. The caller must not hold any of the store's locks.
+func (s *FileStore) fireOnStore(topic string, stored []storeEvent) {
+	s.mutex.RLock()
+	onStore := s.onStore
+	s.mutex.RUnlock()
+	if onStore == nil {
+		return
+	}
+	for _, event := range stored {
+		callOnStore(onStore, topic, event)
+	}
+}
+
+// callOnStore calls the given function for the stored message, recovering
+// from any panic it raises.
+func callOnStore(
+	onStore func(topic string, messageNumber int, created time.Time),
+	topic string, event storeEvent) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			log.Printf("filestore: on-store function panicked: %v", r)
+		}
+	}()
+	onStore(topic, event.messageNumber, event.created)
+}
+
+// storeOne stores a single message by way of the phases of a StoreAction. It
+// holds the store's mutex while the index is consulted and updated, but not
+// while the message file is written. The caller must hold the topic's lock.
+func (s *FileStore) storeOne(topic string, pending pendingMessage) (
+	messageNumber int, created time.Time, err error) {
+	s.mutex.Lock()
+	storeAction := s.storeAction(topic, pending)
+	err = s.prepareToChangeIndex()
+	if err != nil {
+		s.mutex.Unlock()
+		return -1, time.Time{}, fmt.Errorf(
+			"prepareToChangeIndex(): %v", err)
+	}
+	staged, err := storeAction.Prepare()
+	s.mutex.Unlock()
+	if err != nil {
+		return -1, time.Time{}, fmt.Errorf("storeAction.Prepare(): %w", err)
+	}
+
+	err = storeAction.Append(staged)
+	if err != nil {
+		return -1, time.Time{}, fmt.Errorf("storeAction.Append(): %v", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	messageNumber = storeAction.Register(staged)
+	s.cacheRecord(topic, messageNumber, staged.Created(), pending)
+	s.noteUnsynced(filenamer.MessageFilePathFor(s.namer,
+		staged.MsgFileName, topic, s.RootDir))
+	return messageNumber, staged.Created(), nil
+}
+
+// storeAction provides a StoreAction for the message, configured according
+// to the store's settings. The caller must hold the store's mutex.
+func (s *FileStore) storeAction(topic string,
+	pending pendingMessage) actions.StoreAction {
+	q := s.quotas[topic]
+	return actions.StoreAction{
+		Topic: topic, Message: pending.Message, Key: pending.Key,
+		Headers: pending.Headers, Created: pending.created,
+		SkipMessageNumbers: pending.skip, Index: s.index, RootDir: s.RootDir,
+		MaxFileSize: s.maxFileSize, MaxFileAge: s.maxSegmentAge,
+		MaxMessageSize: s.maxMessageSize, ChunkSize: s.chunkSize,
+		SyncOnWrite: s.syncOnWrite, Serializer: s.serializer,
+		Compress: s.compress, EncryptionKey: s.encryptionKey,
+		DirPerm: s.dirPerm, FilePerm: s.filePerm, Handles: &s.handles,
+		Namer: s.namer, MaxTopicMessages: q.maxMessages,
+		MaxTopicBytes: q.maxBytes, Now: s.clock.Now}
+}
+
+// storeTransaction does the work of StoreTransaction, taking the locks it
+// needs, and provides the message numbers and creation times of the messages
+// stored.
+func (s *FileStore) storeTransaction(ctx context.Context, topic string,
+	messages []minikafka.Message) (stored []storeEvent, err error) {
+	defer func(began time.Time) {
+		s.countOperation(StoreOperation, began, err)
+	}(time.Now())
+
+	err = s.validateTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("no messages to store")
+	}
+
+	// Nothing else may change the topic's files, nor look at the index,
+	// until the transaction is committed, or rolled back.
+	s.maintenanceMutex.RLock()
+	defer s.maintenanceMutex.RUnlock()
+	topicLock := s.topicLock(topic)
+	topicLock.Lock()
+	defer topicLock.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+	err = s.prepareToChangeIndex()
+	if err != nil {
+		return nil, fmt.Errorf("prepareToChangeIndex(): %v", err)
+	}
+
+	// Keep what is needed to roll the topic back.
+	savedFileList, existed := s.index.MessageFileLists[topic]
+	if existed {
+		savedFileList = savedFileList.Clone()
+	}
+	savedNextNumber := s.index.NextMessageNumberFor(topic)
+
+	// Delegate each message to a StoreAction instance.
+	var storeErr error
+	for _, message := range messages {
+		storeErr = ctx.Err()
+		if storeErr != nil {
+			break
+		}
+		storeAction := s.storeAction(
+			topic, pendingMessage{KeyedMessage: KeyedMessage{Message: message}})
+		staged, err := storeAction.Prepare()
+		if err != nil {
+			storeErr = fmt.Errorf("storeAction.Prepare(): %w", err)
+			break
+		}
+		err = storeAction.Append(staged)
+		if err != nil {
+			storeErr = fmt.Errorf("storeAction.Append(): %v", err)
+			break
+		}
+		messageNumber := storeAction.Register(staged)
+		s.noteUnsynced(filenamer.MessageFilePathFor(s.namer,
+			staged.MsgFileName, topic, s.RootDir))
+		stored = append(stored, storeEvent{messageNumber, staged.Created()})
+	}
+
+	if storeErr != nil {
+		err = s.rollBack(topic, savedFileList, existed, savedNextNumber)
+		if err != nil {
+			return nil, fmt.Errorf("rollBack(): %v (after: %v)", err, storeErr)
+		}
+		stored = nil
+	}
+
+	// The index is saved regardless, since it was marked as changing.
+	err = s.saveIndex(s.index)
+	if err != nil {
+		return nil, fmt.Errorf("SaveIndex(): %v", err)
+	}
+	if storeErr != nil {
+		return nil, storeErr
+	}
+	if existed == false {
+		s.noteNewTopic(topic)
+	}
+	s.countStored(topic, messages)
+	s.notifier.Notify(topic)
+	return stored, nil
+}
+
+// closeFiles closes the message files the store holds open for appending,
+// and unmaps those it holds mapped for reading. It must be called before any
+// message file is truncated, removed or replaced.
+func (s *FileStore) closeFiles() error {
+	err := s.handles.CloseAll()
+	if err != nil {
+		return fmt.Errorf("handles.CloseAll(): %v", err)
+	}
+	err = s.mappings.UnmapAll()
+	if err != nil {
+		return fmt.Errorf("mappings.UnmapAll(): %v", err)
+	}
+	return nil
+}
+
+// closeTopicFiles is like closeFiles, but only for the topic's files.
+func (s *FileStore) closeTopicFiles(topic string) error {
+	err := s.handles.Close(topic)
+	if err != nil {
+		return fmt.Errorf("handles.Close(): %v", err)
+	}
+	err = s.mappings.Unmap(topic)
+	if err != nil {
+		return fmt.Errorf("mappings.Unmap(): %v", err)
+	}
+	return nil
+}
+
+// readMappings provides the cache of memory mappings the read actions should
+// use, which is nil when WithMmapReads is off.
+func (s *FileStore) readMappings() *ioutils.ReadMappings {
+	if s.mmapReads == false {
+		return nil
+	}
+	return &s.mappings
+}
+
+// rollBack returns the topic to the state described by the given message
+// file list and next message number, which were taken before messages were
+// appended to its files. New files are removed, and existing files are
+// truncated to their previous size. The topic is forgotten altogether when
+// it did not exist before. The caller must hold the topic's lock, and the
+// store's mutex.
+func (s *FileStore) rollBack(topic string,
+	savedFileList *indexing.MessageFileList, existed bool,
+	savedNextNumber int32) error {
+	err := s.closeTopicFiles(topic)
+	if err != nil {
+		return fmt.Errorf("closeTopicFiles(): %v", err)
+	}
+	s.forgetCachedRecords(topic)
+	msgFileList := s.index.MessageFileLists[topic]
+	if msgFileList != nil {
+		for _, name := range msgFileList.Names {
+			filePath := filenamer.MessageFilePathFor(
+				s.namer, name, topic, s.RootDir)
+			if existed == false || savedFileList.Meta[name] == nil {
+				err = os.Remove(filePath)
+				if err != nil {
+					return fmt.Errorf("os.Remove(): %v", err)
+				}
+				continue
+			}
+			err = os.Truncate(filePath, savedFileList.Meta[name].Size)
+			if err != nil {
+				return fmt.Errorf("os.Truncate(): %v", err)
+			}
+		}
+	}
+	if existed == false {
+		s.index.ForgetTopic(topic)
+		// Leave no empty topic directory behind. (Removal fails harmlessly
+		// if it is somehow not empty.)
+		os.Remove(s.namer.DirectoryForTopic(topic, s.RootDir))
+		return nil
+	}
+	s.index.MessageFileLists[topic] = savedFileList
+	s.index.NextMessageNumbers[topic] = savedNextNumber
+	return nil
+}
+
+// validateTopic makes sure that the given topic name is safe to use as the
+// name of the topic's directory, inside the root directory, and that it
+// matches the store's topic pattern (when it has one). It rejects empty
+// names, the names "." and "..", names that contain a path separator or a
+// control character, and the names of the store's own files. The error it
+// returns wraps ErrInvalidTopic.
+func (s *FileStore) validateTopic(topic string) error {
+	if topic == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidTopic)
+	}
+	if topic == "." || topic == ".." {
+		return fmt.Errorf("%w: %q", ErrInvalidTopic, topic)
+	}
+	for _, r := range topic {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return fmt.Errorf("%w: %q contains %q", ErrInvalidTopic, topic, r)
+		}
+	}
+	if filenamer.IsReservedFor(s.namer, topic, s.RootDir) ||
+		topic == healthTopic {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidTopic, topic)
+	}
+	if s.topicPattern != nil && s.topicPattern.MatchString(topic) == false {
+		return fmt.Errorf("%w: %q does not match %v",
+			ErrInvalidTopic, topic, s.topicPattern)
+	}
+	return nil
+}
+
+// topicLock provides the mutex that serializes stores to the given topic,
+// creating it on first use.
+func (s *FileStore) topicLock(topic string) *sync.Mutex {
+	s.topicLocksMutex.Lock()
+	defer s.topicLocksMutex.Unlock()
+	if s.topicLocks == nil {
+		s.topicLocks = map[string]*sync.Mutex{}
+	}
+	lock, ok := s.topicLocks[topic]
+	if ok == false {
+		lock = &sync.Mutex{}
+		s.topicLocks[topic] = lock
+	}
+	return lock
+}
+
+// poll is a view of pollRecords, that provides only the messages. It is not
+// responsible for mutex protection. Should records be skipped as corrupt, the
+// messages found are returned along with an error that wraps
+// ErrCorruptRecords.
+func (s *FileStore) poll(ctx context.Context, index *indexing.Index,
+	topic string, readFrom int, maxMessages int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	found, newReadFrom, err := s.pollRecords(
+		ctx, index, topic, readFrom, maxMessages)
+	if err != nil && errors.Is(err, ErrCorruptRecords) == false {
+		return nil, -1, err
+	}
+	foundMessages = make([]minikafka.Message, len(found))
+	for i, record := range found {
+		foundMessages[i] = record.Message
+	}
+	return foundMessages, newReadFrom, err
+}
+
+// pollRecords delegates a poll operation to a PollAction instance, using the
+// given index, and provides each message found as a Record. Corrupt records
+// are treated as they are by poll.
+func (s *FileStore) pollRecords(ctx context.Context, index *indexing.Index,
+	topic string, readFrom int, maxMessages int) (
+	found []contract.Record, newReadFrom int, err error) {
+	defer func(began time.Time) {
+		s.countOperation(PollOperation, began, err)
+	}(time.Now())
+
+	err = s.validateTopic(topic)
+	if err != nil {
+		return nil, -1, err
+	}
+	found, newReadFrom, ok := s.cachedRecords(
+		index, topic, readFrom, maxMessages)
+	if ok {
+		return found, newReadFrom, nil
+	}
+	pollAction := actions.PollAction{
+		Ctx:           ctx,
+		Topic:         topic,
+		ReadFrom:      readFrom,
+		Index:         index,
+		RootDir:       s.RootDir,
+		MaxMessages:   maxMessages,
+		Serializer:    s.serializer,
+		EncryptionKey: s.encryptionKey,
+		OnCorrupt:     s.onCorrupt(),
+		Mappings:      s.readMappings(),
+		Namer:         s.namer}
+	stored, newReadFrom, err := pollAction.PollRecords()
+	if err != nil && errors.Is(err, records.ErrCorruptRecord) == false {
+		return nil, -1, fmt.Errorf("pollAction.PollRecords(): %w", err)
+	}
+	found = make([]contract.Record, len(stored))
+	for i, storedMsg := range stored {
+		found[i] = contract.Record{Number: int(storedMsg.MsgNum),
+			Time: storedMsg.Created, Key: storedMsg.Key,
+			Headers: storedMsg.Headers, Message: storedMsg.Message}
+	}
+	if err != nil {
+		return found, newReadFrom, fmt.Errorf("%w: %v",
+			ErrCorruptRecords, err)
+	}
+	return found, newReadFrom, nil
+}
+
+// pollIfTopicKnown is like Poll, except that it treats a topic the index
+// does not know about as simply having no messages yet. (The check and the
+// poll are made atomically.)
+func (s *FileStore) pollIfTopicKnown(ctx context.Context, topic string,
+	readFrom int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	defer s.deliverDeadLetters()
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, -1, ErrStoreClosed
+	}
+	index := s.index
+	if _, ok := index.MessageFileLists[topic]; ok == false {
+		return []minikafka.Message{}, readFrom, nil
+	}
+	return s.poll(ctx, index, topic, readFrom, 0)
+}
+
+// changeIndex runs the given change to the in-memory index, for the
+// maintenance operations that remove, or rewrite message files. It holds the
+// maintenance mutex, and then the store's mutex, throughout, and closes the
+// files the store holds open first, since they may be replaced. Afterwards,
+// the index is saved, subject to the index flush interval - regardless of
+// whether the change failed, so that it remains consistent with whatever
+// files were changed before the failure. The change's error is returned,
+// unless saving the index failed too.
+func (s *FileStore) changeIndex(
+	change func(index *indexing.Index) error) error {
+	s.maintenanceMutex.Lock()
+	defer s.maintenanceMutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	index := s.index
+	err := s.prepareToChangeIndex()
+	if err != nil {
+		return fmt.Errorf("prepareToChangeIndex(): %v", err)
+	}
+	err = s.closeFiles()
+	if err != nil {
+		return fmt.Errorf("closeFiles(): %v", err)
+	}
+	defer s.pruneCachedRecords()
+
+	changeErr := change(index)
+	err = s.saveIndex(index)
+	if err != nil {
+		return fmt.Errorf("SaveIndex(): %v", err)
+	}
+	return changeErr
+}
+
+// prepareToChangeIndex is called before an operation changes the in-memory
+// index. When the change might not be persisted straight away, it leaves a
+// marker file to say so, which persistIndex removes. Should the marker be
+// found when the store is next opened, the index persisted cannot be trusted.
+func (s *FileStore) prepareToChangeIndex() error {
+	if s.indexFlushInterval == 0 || s.indexDirty {
+		return nil
+	}
+	file, err := os.OpenFile(
+		filenamer.IndexDirtyMarkerFileFor(s.namer, s.RootDir),
+		os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.filePerm)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile(): %v", err)
+	}
+	return file.Close()
+}
+
+// saveIndex is called after the in-memory index has been changed. It persists
+// the index, unless a flush interval is configured and has not yet elapsed
+// since it was last persisted.
+func (s *FileStore) saveIndex(index *indexing.Index) error {
+	s.index = index
+	s.indexDirty = true
+	if s.clock.Now().Sub(s.indexPersisted) < s.indexFlushInterval {
+		return nil
+	}
+	return s.persistIndex()
+}
+
+// persistIndex serializes the in-memory index to disk, committing it to
+// stable storage if the store is configured to sync on write.
+func (s *FileStore) persistIndex() error {
+	var err error
+	if s.syncOnWrite {
+		err = s.index.SaveAndSync(s.namer.IndexFile(s.RootDir), s.filePerm)
+	} else {
+		err = s.index.Save(s.namer.IndexFile(s.RootDir), s.filePerm)
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filenamer.IndexDirtyMarkerFileFor(s.namer, s.RootDir))
+	if err != nil && errors.Is(err, os.ErrNotExist) == false {
+		return fmt.Errorf("os.Remove(): %v", err)
+	}
+	s.indexDirty = false
+	s.indexPersisted = s.clock.Now()
+	return nil
+}
+
+// noteUnsynced records that the given message file has been written to, and
+// so needs including in the next Flush. It is not needed when the store
+// syncs on write.
+func (s *FileStore) noteUnsynced(filePath string) {
+	if s.syncOnWrite {
+		return
+	}
+	if s.unsynced == nil {
+		s.unsynced = map[string]bool{}
+	}
+	s.unsynced[filePath] = true
+}
+
+// flush is the implementation of Flush, which is shared with Close. The
+// caller must hold the store's mutex.
+func (s *FileStore) flush() error {
+	if s.indexDirty {
+		err := s.persistIndex()
+		if err != nil {
+			return fmt.Errorf("persistIndex(): %v", err)
+		}
+	}
+	for filePath := range s.unsynced {
+		err := ioutils.SyncFile(filePath)
+		// Tolerate files removed since they were written to.
+		if err != nil && errors.Is(err, os.ErrNotExist) == false {
+			return fmt.Errorf("ioutils.SyncFile(): %v", err)
+		}
+		delete(s.unsynced, filePath)
+	}
+	err := ioutils.SyncFile(s.namer.IndexFile(s.RootDir))
+	if err != nil {
+		return fmt.Errorf("ioutils.SyncFile(): %v", err)
+	}
+	return nil
+}
+
+// loadIndex initialises the in-memory index from the index file. When there
+// is no index file there yet, it starts with a virgin index. When the marker
+// left by prepareToChangeIndex is present (a sign the store was not closed
+// cleanly) - or the index file cannot be decoded, and the store is to rebuild
+// it (see WithRebuildCorruptIndex) - the index is rebuilt from the message
+// files instead. A corrupt index file is otherwise reported with an error that
+// wraps contract.ErrCorruptIndex. When the index is not rebuilt, any bytes it
+// does not account for, such as those of an interrupted append, are
+// discarded.
+func (s *FileStore) loadIndex() error {
+	index := indexing.NewIndex()
+	err := index.PopulateFromDisk(s.namer.IndexFile(s.RootDir))
+	if errors.Is(err, os.ErrNotExist) {
+		index, err = indexing.NewIndex(), nil
+	}
+	corrupt := errors.Is(err, contract.ErrCorruptIndex)
+	if corrupt && s.setAsideCorruptIndex {
+		s.index = indexing.NewIndex()
+		s.indexDirty = false
+		s.forgetCachedRecords()
+		return nil
+	}
+	if err != nil && (corrupt == false || s.rebuildCorruptIndex == false) {
+		return fmt.Errorf("index.PopulateFromDisk(): %w", err)
+	}
+	dirtyMarker := filenamer.IndexDirtyMarkerFileFor(s.namer, s.RootDir)
+	stale := err != nil || ioutils.Exists(dirtyMarker)
+	if stale {
+		rebuildAction := actions.RebuildIndexAction{RootDir: s.RootDir,
+			Serializer: s.serializer, EncryptionKey: s.encryptionKey,
+			OnCorrupt: s.onCorrupt(), Namer: s.namer}
+		index, _, err = rebuildAction.RebuildIndex()
+		if err != nil {
+			return fmt.Errorf("rebuildAction.RebuildIndex(): %w", err)
+		}
+		s.forgetDeadLetters()
+	}
+	s.index = index
+	s.indexDirty = false
+	s.forgetCachedRecords()
+	if stale {
+		err = s.persistIndex()
+		if err != nil {
+			return fmt.Errorf("persistIndex(): %v", err)
+		}
+	} else {
+		truncateAction := actions.TruncateToIndexAction{
+			Index: index, RootDir: s.RootDir, Namer: s.namer}
+		_, err = truncateAction.TruncateToIndex()
+		if err != nil {
+			return fmt.Errorf("truncateAction.TruncateToIndex(): %v", err)
+		}
+	}
+	s.indexPersisted = s.clock.Now()
+	return nil
+}
+
+// retrieveOffsets deserializes the committed offsets from disk. When there is
+// no offsets file there yet, it provides an empty Offsets instead of reporting
+// an error.
+func (s *FileStore) retrieveOffsets() (*offsets.Offsets, error) {
+	committed := offsets.NewOffsets()
+	err := committed.PopulateFromDisk(s.namer.OffsetsFile(s.RootDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return offsets.NewOffsets(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("committed.PopulateFromDisk(): %v", err)
+	}
+	return committed, nil
+}
+
+func (s *FileStore) deleteContents() error {
+	err := s.closeFiles()
+	if err != nil {
+		return fmt.Errorf("closeFiles(): %v", err)
+	}
+	err = ioutils.DeleteDirectoryContents(s.RootDir)
 	if err != nil {
 		return fmt.Errorf("ioutils.DeleteDirectoryContents(): %v", err)
 	}
+	// Start afresh, as if the store were newly opened.
+	s.unsynced = nil
+	s.forgetKeyWindows()
+	s.forgetDeadLetters()
+	err = s.loadIndex()
+	if err != nil {
+		return fmt.Errorf("loadIndex(): %w", err)
+	}
 	return nil
 }
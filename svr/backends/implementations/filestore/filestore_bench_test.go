@@ -0,0 +1,243 @@
+package filestore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	minikafka "github.com/peterhoward42/minikafka"
+)
+
+// prepareBenchmarkStore provides a FileStore in a fresh temporary directory,
+// along with a function to close the store, and remove that directory,
+// afterwards - so that the files the store holds open, or mapped, are not
+// leaked from one benchmark to the next.
+func prepareBenchmarkStore(b *testing.B, options ...Option) (
+	*FileStore, func()) {
+	rootDir, err := os.MkdirTemp("", "filestore")
+	if err != nil {
+		b.Fatalf("os.MkdirTemp(): %v", err)
+	}
+	store, err := NewFileStore(rootDir, options...)
+	if err != nil {
+		b.Fatalf("NewFileStore(): %v", err)
+	}
+	return store, func() {
+		err := store.Close()
+		if err != nil {
+			b.Errorf("store.Close(): %v", err)
+		}
+		os.RemoveAll(rootDir)
+	}
+}
+
+// makeBenchmarkMessages provides n small, distinct messages.
+func makeBenchmarkMessages(n int) []minikafka.Message {
+	messages := make([]minikafka.Message, n)
+	for i := range messages {
+		messages[i] = []byte(fmt.Sprintf("benchmark message %d", i))
+	}
+	return messages
+}
+
+// BenchmarkStoreIndividually stores 1000 messages, one Store call at a time.
+func BenchmarkStoreIndividually(b *testing.B) {
+	ctx := context.Background()
+	store, cleanUp := prepareBenchmarkStore(b)
+	defer cleanUp()
+	messages := makeBenchmarkMessages(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, msg := range messages {
+			_, err := store.Store(ctx, "topic", msg)
+			if err != nil {
+				b.Fatalf("store.Store(): %v", err)
+			}
+		}
+	}
+}
+
+// BenchmarkWritePath stores 1000 messages, one at a time, as a store that
+// holds nothing in memory, nor open, from one store to the next would (see
+// storeNaively), and with the store itself - which holds the message file
+// open, and knows its size - persisting its index after every Store, and
+// alternatively only on Flush. It reports how many messages are stored a
+// second.
+func BenchmarkWritePath(b *testing.B) {
+	ctx := context.Background()
+	messages := makeBenchmarkMessages(1000)
+	cases := []struct {
+		name    string
+		options []Option
+	}{
+		{"PersistEveryStore", nil},
+		{"PersistOnFlush", []Option{WithIndexFlushInterval(time.Hour)}},
+	}
+	b.Run("PerMessageOpen", func(b *testing.B) {
+		rootDir, err := os.MkdirTemp("", "filestore")
+		if err != nil {
+			b.Fatalf("os.MkdirTemp(): %v", err)
+		}
+		defer os.RemoveAll(rootDir)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, msg := range messages {
+				err = storeNaively(rootDir, "topic", msg, 0, nil)
+				if err != nil {
+					b.Fatalf("storeNaively(): %v", err)
+				}
+			}
+		}
+		b.ReportMetric(
+			float64(b.N*len(messages))/b.Elapsed().Seconds(), "msgs/s")
+	})
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			store, cleanUp := prepareBenchmarkStore(b, c.options...)
+			defer cleanUp()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, msg := range messages {
+					_, err := store.Store(ctx, "topic", msg)
+					if err != nil {
+						b.Fatalf("store.Store(): %v", err)
+					}
+				}
+				err := store.Flush()
+				if err != nil {
+					b.Fatalf("store.Flush(): %v", err)
+				}
+			}
+			b.ReportMetric(
+				float64(b.N*len(messages))/b.Elapsed().Seconds(), "msgs/s")
+		})
+	}
+}
+
+// BenchmarkStoreBatch stores 1000 messages in a single StoreBatch call.
+func BenchmarkStoreBatch(b *testing.B) {
+	ctx := context.Background()
+	store, cleanUp := prepareBenchmarkStore(b)
+	defer cleanUp()
+	messages := makeBenchmarkMessages(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, err := store.StoreBatch(ctx, "topic", messages)
+		if err != nil {
+			b.Fatalf("store.StoreBatch(): %v", err)
+		}
+	}
+}
+
+// BenchmarkStoreIntoLargeIndex stores 100 messages to a store whose index
+// already knows about 200 topics, persisting the index after every Store, and
+// alternatively only on Flush.
+func BenchmarkStoreIntoLargeIndex(b *testing.B) {
+	ctx := context.Background()
+	cases := []struct {
+		name    string
+		options []Option
+	}{
+		{"PersistEveryStore", nil},
+		{"PersistOnFlush", []Option{WithIndexFlushInterval(time.Hour)}},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			store, cleanUp := prepareBenchmarkStore(b, c.options...)
+			defer cleanUp()
+			for i := 0; i < 200; i++ {
+				_, _, err := store.StoreBatch(ctx,
+					fmt.Sprintf("topic_%d", i), makeBenchmarkMessages(10))
+				if err != nil {
+					b.Fatalf("store.StoreBatch(): %v", err)
+				}
+			}
+			messages := makeBenchmarkMessages(100)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, msg := range messages {
+					_, err := store.Store(ctx, "topic_0", msg)
+					if err != nil {
+						b.Fatalf("store.Store(): %v", err)
+					}
+				}
+				err := store.Flush()
+				if err != nil {
+					b.Fatalf("store.Flush(): %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRepeatedPolls polls the last 10 of the 1000 messages in a message
+// file, as a consumer at the tail of a topic would, with and without
+// memory-mapped reads. (Polling all of them would measure the decoding of
+// the messages, rather than the reading of the file.)
+func BenchmarkRepeatedPolls(b *testing.B) {
+	ctx := context.Background()
+	cases := []struct {
+		name    string
+		options []Option
+	}{
+		{"BufferedReads", nil},
+		{"MmapReads", []Option{WithMmapReads(true)}},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			store, cleanUp := prepareBenchmarkStore(b, c.options...)
+			defer cleanUp()
+			_, _, err := store.StoreBatch(ctx, "topic",
+				makeBenchmarkMessages(1000))
+			if err != nil {
+				b.Fatalf("store.StoreBatch(): %v", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _, err := store.Poll(ctx, "topic", 991)
+				if err != nil {
+					b.Fatalf("store.Poll(): %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkTailPolls polls the last 10 of a topic's 1000 messages, as a
+// dashboard refreshing would, with and without the record cache - reporting
+// how many of the polls read the message files.
+func BenchmarkTailPolls(b *testing.B) {
+	ctx := context.Background()
+	cases := []struct {
+		name    string
+		options []Option
+	}{
+		{"NoCache", nil},
+		{"RecordCache", []Option{WithRecordCache(100)}},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			store, cleanUp := prepareBenchmarkStore(b, c.options...)
+			defer cleanUp()
+			_, _, err := store.StoreBatch(ctx, "topic",
+				makeBenchmarkMessages(1000))
+			if err != nil {
+				b.Fatalf("store.StoreBatch(): %v", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _, err := store.Poll(ctx, "topic", 991)
+				if err != nil {
+					b.Fatalf("store.Poll(): %v", err)
+				}
+			}
+			b.StopTimer()
+			counters := store.Counters()
+			fileReads := counters.Operations[PollOperation].Count -
+				counters.RecordCacheHits
+			b.ReportMetric(float64(fileReads)/float64(b.N), "filereads/op")
+		})
+	}
+}
@@ -1,15 +1,36 @@
 package filestore
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io/ioutil"
 	"os"
 	"path"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	minikafka "github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/actions"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/filenamer"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
 	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
 	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/memstore"
 )
 
 //---------------------------------------------------------------------------
@@ -44,6 +65,7 @@ func TestConstructionOnADirectoryThatDoesntExist(t *testing.T) {
 	// This makes sure that the root directory is created when it doesn't
 	// already exist.
 
+	ctx := context.Background()
 	// Use our utility to make a root directory.
 	rootDir := ioutils.TmpRootDir(t)
 	defer os.RemoveAll(rootDir)
@@ -55,7 +77,7 @@ func TestConstructionOnADirectoryThatDoesntExist(t *testing.T) {
 		assert.Fail(t, msg)
 	}
 	// Make sure we can store something in it without error.
-	_, err = filestore.Store("some_topic", []byte("a message"))
+	_, err = filestore.Store(ctx, "some_topic", []byte("a message"))
 	if err != nil {
 		msg := fmt.Sprintf("filestore.Store(): %v", err)
 		assert.Fail(t, msg)
@@ -68,6 +90,7 @@ func TestPersistence(t *testing.T) {
 	// the same root directory - it picks up the stored message and index
 	// left behind by the first instance as it should.
 
+	ctx := context.Background()
 	rootDir := ioutils.TmpRootDir(t)
 	defer os.RemoveAll(rootDir)
 
@@ -78,7 +101,7 @@ func TestPersistence(t *testing.T) {
 		assert.Fail(t, msg)
 	}
 	topic := "some topic"
-	msgNumber, err := filestore.Store(topic, []byte("a message"))
+	msgNumber, err := filestore.Store(ctx, topic, []byte("a message"))
 	if err != nil {
 		msg := fmt.Sprintf("filestore.Store(): %v", err)
 		assert.Fail(t, msg)
@@ -92,7 +115,7 @@ func TestPersistence(t *testing.T) {
 		msg := fmt.Sprintf("NewFileStore(): %v", err)
 		assert.Fail(t, msg)
 	}
-	msgNumber, err = newFileStore.Store(topic, []byte("a message"))
+	msgNumber, err = newFileStore.Store(ctx, topic, []byte("a message"))
 	if err != nil {
 		msg := fmt.Sprintf("filestore.Store(): %v", err)
 		assert.Fail(t, msg)
@@ -100,7 +123,7 @@ func TestPersistence(t *testing.T) {
 	assert.Equal(t, 2, msgNumber)
 
 	readFrom := 1
-	messages, newReadFrom, err := newFileStore.Poll(topic, readFrom)
+	messages, newReadFrom, err := newFileStore.Poll(ctx, topic, readFrom)
 	if err != nil {
 		msg := fmt.Sprintf("newFileStore.Poll(): %v", err)
 		assert.Fail(t, msg)
@@ -108,3 +131,4694 @@ func TestPersistence(t *testing.T) {
 	assert.Equal(t, 2, len(messages))
 	assert.Equal(t, 3, newReadFrom)
 }
+
+func TestPersistedIndexTracksEveryMessageInAFile(t *testing.T) {
+	// This test makes sure that when two messages are stored into the same
+	// message file, the index that gets persisted to disk records both of
+	// their message numbers and creation times.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.Fail(t, msg)
+	}
+	topic := "some_topic"
+	before := time.Now()
+	for i := 0; i < 2; i++ {
+		_, err = filestore.Store(ctx, topic, []byte("a message"))
+		if err != nil {
+			msg := fmt.Sprintf("filestore.Store(): %v", err)
+			assert.Fail(t, msg)
+		}
+	}
+	after := time.Now()
+
+	// Inspect the index as it was left on disk.
+	index := indexing.NewIndex()
+	err = index.PopulateFromDisk(filenamer.IndexFile(rootDir))
+	if err != nil {
+		msg := fmt.Sprintf("index.PopulateFromDisk(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	msgFileList := index.MessageFileLists[topic]
+	assert.Equal(t, 1, len(msgFileList.Names))
+	fileMeta := msgFileList.Meta[msgFileList.Names[0]]
+	assert.Equal(t, int32(1), fileMeta.Oldest.MsgNum)
+	assert.Equal(t, int32(2), fileMeta.Newest.MsgNum)
+	assert.False(t, fileMeta.Oldest.Created.Before(before))
+	assert.False(t, fileMeta.Newest.Created.After(after))
+	assert.False(t, fileMeta.Newest.Created.Before(fileMeta.Oldest.Created))
+	assert.Equal(t, 2, len(fileMeta.SeekOffsetForMessageNumber))
+}
+
+func TestStoreWhenIndexFileIsMissing(t *testing.T) {
+	// This test makes sure that a store whose root directory has no index
+	// file in it bootstraps a virgin index on its first Store call, and that
+	// the index thus persisted is picked up by a subsequent instance.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.Fail(t, msg)
+	}
+	// Take away the index file that the constructor set up.
+	err = os.Remove(filenamer.IndexFile(rootDir))
+	if err != nil {
+		msg := fmt.Sprintf("os.Remove(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	msgNumber, err := filestore.Store(ctx, topic, []byte("a message"))
+	if err != nil {
+		msg := fmt.Sprintf("filestore.Store(): %v", err)
+		assert.Fail(t, msg)
+	}
+	assert.Equal(t, 1, msgNumber)
+
+	// Reopen the store and make sure the persisted index is read back.
+	newFileStore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.Fail(t, msg)
+	}
+	messages, newReadFrom, err := newFileStore.Poll(ctx, topic, 1)
+	if err != nil {
+		msg := fmt.Sprintf("newFileStore.Poll(): %v", err)
+		assert.Fail(t, msg)
+	}
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, "a message", string(messages[0]))
+	assert.Equal(t, 2, newReadFrom)
+}
+
+func TestIndependentStoresDoNotBlockEachOther(t *testing.T) {
+	// This test makes sure that two FileStores rooted at different
+	// directories do not serialize against each other. The first store's
+	// lock is held throughout, while the second must nevertheless be able
+	// to complete a batch of concurrent writes.
+
+	ctx := context.Background()
+	rootDirA := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDirA)
+	rootDirB := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDirB)
+
+	storeA, err := NewFileStore(rootDirA)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	storeB, err := NewFileStore(rootDirB)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+
+	storeA.mutex.Lock()
+	defer storeA.mutex.Unlock()
+
+	const nWriters = 5
+	done := make(chan error, nWriters)
+	for i := 0; i < nWriters; i++ {
+		go func() {
+			_, err := storeB.Store(ctx, "some_topic", []byte("a message"))
+			done <- err
+		}()
+	}
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < nWriters; i++ {
+		select {
+		case err := <-done:
+			assert.Nil(t, err)
+		case <-timeout:
+			assert.FailNow(t, "Store on one FileStore blocked by another.")
+		}
+	}
+	messages, _, err := storeB.Poll(ctx, "some_topic", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, nWriters, len(messages))
+}
+
+func TestConcurrentStoresToDifferentTopics(t *testing.T) {
+	// Make sure that when several goroutines each store a sequence of
+	// messages to their own topic at the same time, every topic ends up
+	// with exactly its own messages, in order.
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+	filestore, err := NewFileStore(rootDir, WithMaxFileSize(
+		10*storedSizeOf("topic_0 message 00")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+
+	const nTopics = 10
+	const nMessages = 50
+	done := make(chan error, nTopics)
+	for i := 0; i < nTopics; i++ {
+		topic := fmt.Sprintf("topic_%d", i)
+		go func() {
+			for j := 0; j < nMessages; j++ {
+				_, err := filestore.Store(ctx, topic,
+					[]byte(fmt.Sprintf("%s message %02d", topic, j)))
+				if err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+	}
+	for i := 0; i < nTopics; i++ {
+		assert.Nil(t, <-done)
+	}
+
+	for i := 0; i < nTopics; i++ {
+		topic := fmt.Sprintf("topic_%d", i)
+		count, err := filestore.MessageCount(topic)
+		assert.Nil(t, err)
+		assert.Equal(t, nMessages, count)
+		messages, _, err := filestore.Poll(ctx, topic, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, nMessages, len(messages))
+		for j, message := range messages {
+			expected := fmt.Sprintf("%s message %02d", topic, j)
+			assert.Equal(t, expected, string(message))
+		}
+	}
+}
+
+func TestConstructionWhenRootDirIsAFile(t *testing.T) {
+	// Make sure the constructor refuses a root directory path that is in fact
+	// a file, with the corresponding typed error.
+
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+	filePath := path.Join(rootDir, "afile")
+	err := ioutil.WriteFile(filePath, []byte("something"), 0666)
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.WriteFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, err = NewFileStore(filePath)
+	assert.True(t, errors.Is(err, ErrRootDirIsFile))
+}
+
+func TestConstructionWhenRootDirIsNotWritable(t *testing.T) {
+	// Make sure the constructor refuses a root directory it cannot write to,
+	// with the corresponding typed error.
+
+	if os.Geteuid() == 0 {
+		t.Skip("Directory permissions are not enforced for root.")
+	}
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+	err := os.Chmod(rootDir, 0500)
+	if err != nil {
+		msg := fmt.Sprintf("os.Chmod(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	defer os.Chmod(rootDir, 0700)
+	_, err = NewFileStore(rootDir)
+	assert.True(t, errors.Is(err, ErrRootDirNotWritable))
+}
+
+func TestConfiguredMaxFileSize(t *testing.T) {
+	// Make sure that a maximum file size passed to the constructor governs
+	// when message files are rolled over, and that messages too big to fit
+	// in a file at all are refused.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	// Room for two messages per file.
+	maxFileSize := 2 * storedSizeOf("0123456789")
+	filestore, err := NewFileStore(rootDir, WithMaxFileSize(maxFileSize))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 0; i < 3; i++ {
+		_, err = filestore.Store(ctx, topic, []byte("0123456789"))
+		if err != nil {
+			msg := fmt.Sprintf("filestore.Store(): %v", err)
+			assert.Fail(t, msg)
+		}
+	}
+	nFiles, err := ioutils.CountEntitiesInDir(
+		filenamer.DirectoryForTopic(topic, rootDir))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, nFiles)
+
+	_, err = filestore.Store(ctx, topic, make([]byte, maxFileSize))
+	assert.NotNil(t, err)
+	messages, _, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(messages))
+}
+
+func TestListTopicsWhenIndexIsUnreadable(t *testing.T) {
+	// Make sure that ListTopics falls back to scanning the topic directories
+	// when the index file cannot be decoded.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	for _, topic := range []string{"topicB", "topicA"} {
+		_, err = filestore.Store(ctx, topic, []byte("a message"))
+		assert.Nil(t, err)
+	}
+	err = ioutil.WriteFile(filenamer.IndexFile(rootDir), []byte("junk"), 0666)
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.WriteFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topics, err := filestore.ListTopics(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"topicA", "topicB"}, topics)
+}
+
+func TestMessageCount(t *testing.T) {
+	// Make sure the message count for a topic reflects both storage and
+	// removal by RemoveOldMessages. (Each message is sized to occupy a file
+	// of its own, so that the removal of each is possible.)
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(
+		rootDir, WithMaxFileSize(storedSizeOf("0123456789")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	var cutOff time.Time
+	for i := 0; i < 5; i++ {
+		if i == 2 {
+			time.Sleep(20 * time.Millisecond)
+			cutOff = time.Now()
+			time.Sleep(20 * time.Millisecond)
+		}
+		_, err = filestore.Store(ctx, topic, []byte("0123456789"))
+		assert.Nil(t, err)
+	}
+	count, err := filestore.MessageCount(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, count)
+
+	err = filestore.RemoveOldMessages(ctx, cutOff)
+	assert.Nil(t, err)
+	count, err = filestore.MessageCount(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+
+	// An unknown topic has a count of zero.
+	count, err = filestore.MessageCount("nosuchtopic")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestBounds(t *testing.T) {
+	// Make sure the bounds reported for a topic are right for a normal topic,
+	// a topic whose oldest messages have been removed, and a topic from which
+	// all messages have been removed. (Each message is sized to occupy a file
+	// of its own, so that the removal of each is possible.)
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(
+		rootDir, WithMaxFileSize(storedSizeOf("0123456789")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	var cutOff time.Time
+	for i := 0; i < 5; i++ {
+		if i == 2 {
+			time.Sleep(20 * time.Millisecond)
+			cutOff = time.Now()
+			time.Sleep(20 * time.Millisecond)
+		}
+		_, err = filestore.Store(ctx, topic, []byte("0123456789"))
+		assert.Nil(t, err)
+	}
+
+	// Normal topic.
+	oldest, newest, err := filestore.Bounds(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, oldest)
+	assert.Equal(t, 5, newest)
+
+	// Trimmed front.
+	err = filestore.RemoveOldMessages(ctx, cutOff)
+	assert.Nil(t, err)
+	oldest, newest, err = filestore.Bounds(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, oldest)
+	assert.Equal(t, 5, newest)
+
+	// Empty topic.
+	err = filestore.RemoveOldMessages(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	oldest, newest, err = filestore.Bounds(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, -1, oldest)
+	assert.Equal(t, -1, newest)
+}
+
+func TestPollMulti(t *testing.T) {
+	// Make sure that polling several topics at once, each from a different
+	// read-from, provides each topic's new records and new read-from - and
+	// that a topic that does not exist is treated as having no messages.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topics := []string{"topic_a", "topic_b", "topic_c"}
+	for i, topic := range topics {
+		for j := 0; j < 3+i; j++ {
+			message := fmt.Sprintf("%s_%d", topic, j+1)
+			_, err = filestore.Store(ctx, topic, []byte(message))
+			assert.Nil(t, err)
+		}
+	}
+
+	readFrom := map[string]int{"topic_a": 1, "topic_b": 3, "topic_c": 6,
+		"nosuchtopic": 7}
+	found, newReadFrom, err := filestore.PollMulti(ctx,
+		append(topics, "nosuchtopic"), readFrom)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"topic_a": 4, "topic_b": 5, "topic_c": 6,
+		"nosuchtopic": 7}, newReadFrom)
+	messagesOf := func(topic string) []string {
+		messages := []string{}
+		for _, record := range found[topic] {
+			messages = append(messages, string(record.Message))
+		}
+		return messages
+	}
+	assert.Equal(t, []string{"topic_a_1", "topic_a_2", "topic_a_3"},
+		messagesOf("topic_a"))
+	assert.Equal(t, []string{"topic_b_3", "topic_b_4"}, messagesOf("topic_b"))
+	assert.Equal(t, []string{}, messagesOf("topic_c"))
+	assert.Equal(t, []string{}, messagesOf("nosuchtopic"))
+	assert.Equal(t, 3, found["topic_b"][0].Number)
+
+	// Carrying on from the new read-froms finds only what is stored since.
+	_, err = filestore.Store(ctx, "topic_c", []byte("topic_c_6"))
+	assert.Nil(t, err)
+	found, _, err = filestore.PollMulti(ctx, topics, newReadFrom)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, messagesOf("topic_a"))
+	assert.Equal(t, []string{}, messagesOf("topic_b"))
+	assert.Equal(t, []string{"topic_c_6"}, messagesOf("topic_c"))
+
+	_, _, err = filestore.PollMulti(ctx, []string{"topic_a", "bad/topic"},
+		readFrom)
+	assert.True(t, errors.Is(err, ErrInvalidTopic))
+}
+
+func TestHighWaterMark(t *testing.T) {
+	// Make sure the high-water mark is the number of the newest message
+	// stored, not that of the next one to be stored, and that it is
+	// reported as -1 for an empty or unknown topic.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	var third int
+	for i := 0; i < 3; i++ {
+		third, err = filestore.Store(ctx, topic, []byte("0123456789"))
+		assert.Nil(t, err)
+	}
+	highWaterMark, err := filestore.HighWaterMark(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, third, highWaterMark)
+	assert.Equal(t, 3, highWaterMark)
+	_, newest, err := filestore.Bounds(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, highWaterMark, newest)
+
+	highWaterMark, err = filestore.HighWaterMark("nosuchtopic")
+	assert.Nil(t, err)
+	assert.Equal(t, -1, highWaterMark)
+	err = filestore.RemoveOldMessages(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	highWaterMark, err = filestore.HighWaterMark(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, -1, highWaterMark)
+}
+
+func TestHasNew(t *testing.T) {
+	// Make sure HasNew says there is something to poll for only when there
+	// is a message at, or beyond the read position, that it refuses an
+	// unknown topic as Poll does, and that it agrees with Poll once the
+	// oldest messages have been removed. (Each message is sized to occupy a
+	// file of its own, so that the removal of each is possible.)
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	clock := newFakeClock()
+	filestore, err := NewFileStore(rootDir, WithClock(clock),
+		WithMaxFileSize(storedSizeOf("0123456789")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 0; i < 3; i++ {
+		_, err = filestore.Store(ctx, topic, []byte("0123456789"))
+		assert.Nil(t, err)
+		clock.advance(time.Minute)
+	}
+
+	// Has new, for any read position up to the newest message.
+	for _, readFrom := range []int{0, 1, 3} {
+		hasNew, err := filestore.HasNew(topic, readFrom)
+		assert.Nil(t, err)
+		assert.True(t, hasNew)
+	}
+	// Caught up.
+	hasNew, err := filestore.HasNew(topic, 4)
+	assert.Nil(t, err)
+	assert.False(t, hasNew)
+	_, err = filestore.Store(ctx, topic, []byte("0123456789"))
+	assert.Nil(t, err)
+	hasNew, err = filestore.HasNew(topic, 4)
+	assert.Nil(t, err)
+	assert.True(t, hasNew)
+
+	// Unknown topic.
+	_, err = filestore.HasNew("nosuchtopic", 1)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+	_, _, err = filestore.Poll(ctx, "nosuchtopic", 1)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+
+	// A read position before the oldest message retained still has new
+	// messages, as Poll finds, until they have all been removed.
+	err = filestore.RemoveOldMessages(ctx, clock.Now().Add(-time.Minute))
+	assert.Nil(t, err)
+	oldest, _, err := filestore.Bounds(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, oldest)
+	hasNew, err = filestore.HasNew(topic, 1)
+	assert.Nil(t, err)
+	assert.True(t, hasNew)
+	messages, _, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+
+	err = filestore.RemoveOldMessages(ctx, clock.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	for _, readFrom := range []int{1, 4, 5} {
+		hasNew, err = filestore.HasNew(topic, readFrom)
+		assert.Nil(t, err)
+		assert.False(t, hasNew)
+		messages, _, err = filestore.Poll(ctx, topic, readFrom)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(messages))
+	}
+	_, err = filestore.Store(ctx, topic, []byte("0123456789"))
+	assert.Nil(t, err)
+	hasNew, err = filestore.HasNew(topic, 5)
+	assert.Nil(t, err)
+	assert.True(t, hasNew)
+
+	err = filestore.Close()
+	assert.Nil(t, err)
+	_, err = filestore.HasNew(topic, 1)
+	assert.True(t, errors.Is(err, ErrStoreClosed))
+}
+
+func TestIterator(t *testing.T) {
+	// Make sure an iterator provides the records from the message number it
+	// was made for to the newest when it was made, and not those stored
+	// later, and that an unknown topic, and a closed store are reported.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir,
+		WithMaxFileSize(3*storedSizeOf("message_N")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 7; i++ {
+		_, err = filestore.StoreKeyed(ctx, topic, []byte("key"),
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	iterator, err := filestore.NewIterator(topic, 2)
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, topic, []byte("message_8"))
+	assert.Nil(t, err)
+
+	for i := 2; i <= 7; i++ {
+		record, ok, err := iterator.Next()
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, i, record.Number)
+		assert.Equal(t, []byte("key"), record.Key)
+		assert.Equal(t, minikafka.Message(fmt.Sprintf("message_%d", i)),
+			record.Message)
+	}
+	_, ok, err := iterator.Next()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, ok, err = iterator.Next()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// Beyond the newest.
+	iterator, err = filestore.NewIterator(topic, 9)
+	assert.Nil(t, err)
+	_, ok, err = iterator.Next()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, err = filestore.NewIterator("nosuchtopic", 1)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+
+	iterator, err = filestore.NewIterator(topic, 1)
+	assert.Nil(t, err)
+	err = filestore.Close()
+	assert.Nil(t, err)
+	_, _, err = iterator.Next()
+	assert.True(t, errors.Is(err, ErrStoreClosed))
+	_, err = filestore.NewIterator(topic, 1)
+	assert.True(t, errors.Is(err, ErrStoreClosed))
+}
+
+func TestIteratorWhileStoring(t *testing.T) {
+	// Make sure that iterating through a topic, while messages are stored to
+	// it concurrently, provides every message from the start, in order, up to
+	// at least the newest when the iterator was made.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir,
+		WithMaxFileSize(10*storedSizeOf("message_NNN")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, err = filestore.Store(ctx, topic, []byte("message_1"))
+	assert.Nil(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 2; i <= 500; i++ {
+			_, err := filestore.Store(ctx, topic,
+				[]byte(fmt.Sprintf("message_%d", i)))
+			assert.Nil(t, err)
+		}
+	}()
+
+	iterations := 0
+	for finished := false; finished == false; iterations++ {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		newest, err := filestore.HighWaterMark(topic)
+		assert.Nil(t, err)
+		iterator, err := filestore.NewIterator(topic, 1)
+		assert.Nil(t, err)
+		expected := 1
+		for {
+			record, ok, err := iterator.Next()
+			assert.Nil(t, err)
+			if ok == false {
+				break
+			}
+			assert.Equal(t, expected, record.Number)
+			assert.Equal(t,
+				minikafka.Message(fmt.Sprintf("message_%d", expected)),
+				record.Message)
+			expected++
+		}
+		assert.True(t, expected-1 >= newest)
+		if finished {
+			assert.Equal(t, 500, expected-1)
+		}
+	}
+	assert.True(t, iterations > 1)
+}
+
+func TestIteratorStopsWhenTrimmed(t *testing.T) {
+	// Make sure that when the messages an iterator has yet to provide are
+	// removed part way through, it provides those before them, and then
+	// stops, rather than skipping over the gap.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir,
+		WithMaxFileSize(3*storedSizeOf("message_N")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 9; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	// Files now hold 1-3, 4-6 and 7-9.
+
+	// Having read the first file, the second is rewritten without message
+	// 4.
+	iterator, err := filestore.NewIterator(topic, 1)
+	assert.Nil(t, err)
+	record, ok, err := iterator.Next()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, record.Number)
+	_, err = filestore.TruncateBefore(topic, 5)
+	assert.Nil(t, err)
+	numbers := []int{}
+	for {
+		record, ok, err = iterator.Next()
+		assert.Nil(t, err)
+		if ok == false {
+			break
+		}
+		numbers = append(numbers, record.Number)
+	}
+	assert.Equal(t, []int{2, 3}, numbers)
+
+	// Or the files still to be read are removed altogether.
+	iterator, err = filestore.NewIterator(topic, 5)
+	assert.Nil(t, err)
+	record, _, err = iterator.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, 5, record.Number)
+	_, err = filestore.TruncateBefore(topic, 9)
+	assert.Nil(t, err)
+	numbers = []int{}
+	for {
+		record, ok, err = iterator.Next()
+		assert.Nil(t, err)
+		if ok == false {
+			break
+		}
+		numbers = append(numbers, record.Number)
+	}
+	assert.Equal(t, []int{6}, numbers)
+}
+
+func TestPollFromTime(t *testing.T) {
+	// Store messages across some time gaps, spread over several files, and
+	// make sure that polling from a time provides the right subset.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(
+		rootDir, WithMaxFileSize(2*storedSizeOf("message_N")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	timeBefore := map[int]time.Time{}
+	for i := 1; i <= 6; i++ {
+		time.Sleep(10 * time.Millisecond)
+		timeBefore[i] = time.Now()
+		time.Sleep(10 * time.Millisecond)
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+
+	// A time in the middle of the second file.
+	messages, newReadFrom, err := filestore.PollFromTime(
+		ctx, topic, timeBefore[4])
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(messages))
+	assert.Equal(t, "message_4", string(messages[0]))
+	assert.Equal(t, "message_6", string(messages[2]))
+	assert.Equal(t, 7, newReadFrom)
+
+	// A time earlier than all the messages.
+	messages, _, err = filestore.PollFromTime(ctx, topic, timeBefore[1])
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(messages))
+
+	// A time later than all the messages.
+	messages, newReadFrom, err = filestore.PollFromTime(ctx, topic, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(messages))
+	assert.Equal(t, 7, newReadFrom)
+
+	// A topic name that is not safe to use.
+	_, _, err = filestore.PollFromTime(ctx, "../"+topic, timeBefore[1])
+	assert.True(t, errors.Is(err, ErrInvalidTopic))
+}
+
+func TestStoreBatch(t *testing.T) {
+	// Make sure a batch of messages is given consecutive message numbers
+	// that follow on from those already stored, and that they can be polled
+	// back in order.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(
+		rootDir, WithMaxFileSize(2*storedSizeOf("message_N")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, err = filestore.Store(ctx, topic, []byte("message_0"))
+	assert.Nil(t, err)
+	batch := []minikafka.Message{}
+	for i := 1; i <= 5; i++ {
+		batch = append(batch, []byte(fmt.Sprintf("message_%d", i)))
+	}
+	first, last, err := filestore.StoreBatch(ctx, topic, batch)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, first)
+	assert.Equal(t, 6, last)
+
+	messages, _, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(messages))
+	for i, msg := range messages {
+		assert.Equal(t, fmt.Sprintf("message_%d", i), string(msg))
+	}
+}
+
+func TestStoreBatchFailingPartWay(t *testing.T) {
+	// Make sure that when a batch fails part way through, the index is left
+	// consistent with the messages that were written, and that the numbers
+	// of those are returned with the error.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(
+		rootDir, WithMaxFileSize(2*storedSizeOf("message_N")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	batch := []minikafka.Message{
+		[]byte("message_1"),
+		[]byte("message_2"),
+		make([]byte, 2*storedSizeOf("message_N")),
+		[]byte("message_4"),
+	}
+	first, last, err := filestore.StoreBatch(ctx, topic, batch)
+	assert.True(t, errors.Is(err, contract.ErrMessageTooLarge))
+	assert.Equal(t, 1, first)
+	assert.Equal(t, 2, last)
+
+	messages, newReadFrom, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "message_2", string(messages[1]))
+	assert.Equal(t, last+1, newReadFrom)
+
+	// A batch that fails at its first message stores none.
+	first, last, err = filestore.StoreBatch(ctx, topic, batch[2:])
+	assert.True(t, errors.Is(err, contract.ErrMessageTooLarge))
+	assert.Equal(t, -1, first)
+	assert.Equal(t, -1, last)
+}
+
+func TestStoreBatchCanBeRetriedFromWhereItFailed(t *testing.T) {
+	// Make sure that when a batch is refused part way through by the topic's
+	// quota, the numbers returned with the error say which of its messages
+	// were stored, so that retrying the rest, once there is room, stores
+	// each message exactly once.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, err = filestore.Store(ctx, topic, []byte("message_0"))
+	assert.Nil(t, err)
+	err = filestore.SetQuota(topic, 0, 4)
+	assert.Nil(t, err)
+	batch := []minikafka.Message{}
+	for i := 1; i <= 5; i++ {
+		batch = append(batch, []byte(fmt.Sprintf("message_%d", i)))
+	}
+	first, last, err := filestore.StoreBatch(ctx, topic, batch)
+	assert.True(t, errors.Is(err, contract.ErrQuotaExceeded))
+	assert.Equal(t, 2, first)
+	assert.Equal(t, 4, last)
+	messages, _, err := filestore.Poll(ctx, topic, first)
+	assert.Nil(t, err)
+	assert.Equal(t, batch[:last-first+1], messages)
+
+	err = filestore.SetQuota(topic, 0, 0)
+	assert.Nil(t, err)
+	first, last, err = filestore.StoreBatch(ctx, topic,
+		batch[last-first+1:])
+	assert.Nil(t, err)
+	assert.Equal(t, 5, first)
+	assert.Equal(t, 6, last)
+	messages, _, err = filestore.Poll(ctx, topic, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, batch, messages)
+}
+
+func TestStoreAsync(t *testing.T) {
+	// Make sure that 1000 messages stored asynchronously, while others are
+	// stored synchronously to the same topic, are given increasing message
+	// numbers without error, and can be polled back. And that Shutdown waits
+	// for those still queued, after which StoreAsync reports ErrStoreClosed.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			_, err := filestore.Store(ctx, topic, []byte("synchronous"))
+			assert.Nil(t, err)
+		}
+	}()
+	results := []<-chan StoreResult{}
+	for i := 0; i < 1000; i++ {
+		results = append(results,
+			filestore.StoreAsync(topic, []byte(fmt.Sprintf("message_%d", i))))
+	}
+	previous := 0
+	for _, result := range results {
+		stored := <-result
+		assert.Nil(t, stored.Err)
+		assert.True(t, stored.MessageNumber > previous)
+		previous = stored.MessageNumber
+	}
+	wg.Wait()
+	messages, _, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1050, len(messages))
+
+	results = results[:0]
+	for i := 0; i < 100; i++ {
+		results = append(results,
+			filestore.StoreAsync(topic, []byte("queued")))
+	}
+	err = filestore.Shutdown(ctx)
+	assert.Nil(t, err)
+	for _, result := range results {
+		stored := <-result
+		assert.Nil(t, stored.Err)
+	}
+	stored := <-filestore.StoreAsync(topic, []byte("too late"))
+	assert.True(t, errors.Is(stored.Err, ErrStoreClosed))
+}
+
+func TestStoreAsyncQueueFull(t *testing.T) {
+	// Make sure that with WithAsyncQueueFullError, a message is refused
+	// while the queue is full, and that Close stores those still queued.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir,
+		WithAsyncQueueSize(1), WithAsyncQueueFullError(true))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+
+	// Hold up the goroutine storing the messages, once it has taken the
+	// first from the queue.
+	filestore.maintenanceMutex.Lock()
+	first := filestore.StoreAsync(topic, []byte("message_1"))
+	for len(filestore.asyncStores) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	second := filestore.StoreAsync(topic, []byte("message_2"))
+	refused := <-filestore.StoreAsync(topic, []byte("message_3"))
+	assert.True(t, errors.Is(refused.Err, ErrAsyncQueueFull))
+	assert.Equal(t, -1, refused.MessageNumber)
+	filestore.maintenanceMutex.Unlock()
+	assert.Equal(t, StoreResult{MessageNumber: 1}, <-first)
+	assert.Equal(t, StoreResult{MessageNumber: 2}, <-second)
+
+	third := filestore.StoreAsync(topic, []byte("message_3"))
+	err = filestore.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, StoreResult{MessageNumber: 3}, <-third)
+	reopened, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	messages, _, err := reopened.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(messages))
+}
+
+func TestStoreKeyedAndPollKeyed(t *testing.T) {
+	// Make sure that keyed and keyless messages can be stored in the same
+	// topic, and that PollKeyed provides each with its key, while Poll
+	// provides the plain messages.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	msgNumber, err := filestore.StoreKeyed(ctx,
+		topic, []byte("key_1"), []byte("message_1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, msgNumber)
+	_, err = filestore.Store(ctx, topic, []byte("message_2"))
+	assert.Nil(t, err)
+
+	keyed, newReadFrom, err := filestore.PollKeyed(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, newReadFrom)
+	assert.Equal(t, 2, len(keyed))
+	assert.Equal(t, "key_1", string(keyed[0].Key))
+	assert.Equal(t, "message_1", string(keyed[0].Message))
+	assert.Nil(t, keyed[1].Key)
+	assert.Equal(t, "message_2", string(keyed[1].Message))
+
+	messages, _, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "message_1", string(messages[0]))
+	assert.Equal(t, "message_2", string(messages[1]))
+}
+
+func TestStoreWithHeaders(t *testing.T) {
+	// Make sure that PollKeyed provides the headers stored with each message
+	// (and nil ones for a message stored without), and that they survive the
+	// message files being rewritten by retention and compaction.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(
+		rootDir, WithMaxFileSize(3*storedSizeOf("message_N")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, err = filestore.Store(ctx, topic, []byte("message_1"))
+	assert.Nil(t, err)
+	headers := map[string]string{"trace-id": "abc123", "route": "eu"}
+	msgNumber, err := filestore.StoreWithHeaders(
+		ctx, topic, []byte("message_2"), headers)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, msgNumber)
+	_, err = filestore.StoreKeyed(
+		ctx, topic, []byte("key_3"), []byte("message_3"))
+	assert.Nil(t, err)
+
+	keyed, _, err := filestore.PollKeyed(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(keyed))
+	assert.Nil(t, keyed[0].Headers)
+	assert.Equal(t, headers, keyed[1].Headers)
+	assert.Equal(t, "message_2", string(keyed[1].Message))
+	assert.Nil(t, keyed[1].Key)
+	assert.Nil(t, keyed[2].Headers)
+	assert.Equal(t, "key_3", string(keyed[2].Key))
+
+	// Trim, so as to rewrite the file that holds the message with headers.
+	err = filestore.SetRetentionCount(topic, 2)
+	assert.Nil(t, err)
+	_, err = filestore.TrimToCount()
+	assert.Nil(t, err)
+	keyed, _, err = filestore.PollKeyed(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(keyed))
+	assert.Equal(t, headers, keyed[0].Headers)
+
+	// Compact, so as to rewrite it again.
+	_, err = filestore.StoreKeyed(
+		ctx, topic, []byte("key_3"), []byte("message_4"))
+	assert.Nil(t, err)
+	_, err = filestore.Compact(topic)
+	assert.Nil(t, err)
+	keyed, _, err = filestore.PollKeyed(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(keyed))
+	assert.Equal(t, headers, keyed[0].Headers)
+	assert.Equal(t, "message_4", string(keyed[1].Message))
+}
+
+func TestPollRecords(t *testing.T) {
+	// Make sure that each record carries the number, creation time, key and
+	// headers of the message it was stored as, and that the records agree
+	// with Poll.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	before := time.Now()
+	_, err = filestore.Store(ctx, topic, []byte("message_1"))
+	assert.Nil(t, err)
+	_, err = filestore.StoreKeyed(
+		ctx, topic, []byte("key_2"), []byte("message_2"))
+	assert.Nil(t, err)
+	headers := map[string]string{"trace-id": "abc123"}
+	_, err = filestore.StoreWithHeaders(
+		ctx, topic, []byte("message_3"), headers)
+	assert.Nil(t, err)
+	after := time.Now()
+
+	found, newReadFrom, err := filestore.PollRecords(ctx, topic, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, newReadFrom)
+	assert.Equal(t, 2, len(found))
+	assert.Equal(t, 2, found[0].Number)
+	assert.Equal(t, "key_2", string(found[0].Key))
+	assert.Nil(t, found[0].Headers)
+	assert.Equal(t, "message_2", string(found[0].Message))
+	assert.Equal(t, 3, found[1].Number)
+	assert.Nil(t, found[1].Key)
+	assert.Equal(t, headers, found[1].Headers)
+	assert.Equal(t, "message_3", string(found[1].Message))
+	for _, record := range found {
+		assert.False(t, record.Time.Before(before))
+		assert.False(t, record.Time.After(after))
+		_, created, err := filestore.GetMessage(topic, record.Number)
+		assert.Nil(t, err)
+		assert.True(t, created.Equal(record.Time))
+	}
+
+	messages, pollReadFrom, err := filestore.Poll(ctx, topic, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, newReadFrom, pollReadFrom)
+	assert.Equal(t, []minikafka.Message{found[0].Message, found[1].Message},
+		messages)
+
+	_, _, err = filestore.PollRecords(ctx, "nosuchtopic", 1)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+}
+
+func TestCommittedOffsetSurvivesReopening(t *testing.T) {
+	// Make sure an offset committed by a consumer group can be fetched back
+	// by a store subsequently opened on the same root directory.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 0; i < 3; i++ {
+		_, err = filestore.Store(ctx, topic, []byte("a message"))
+		assert.Nil(t, err)
+	}
+	err = filestore.CommitOffset("some_group", topic, 3)
+	assert.Nil(t, err)
+
+	reopened, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	offset, err := reopened.FetchOffset("some_group", topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, offset)
+}
+
+func TestFetchOffsetWhenNoneCommitted(t *testing.T) {
+	// Make sure that a group with no committed offset is pointed at the
+	// topic's oldest retained message, so that it reads from the beginning.
+	// (Each message is sized to occupy a file of its own, so that the
+	// removal of each is possible.)
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(
+		rootDir, WithMaxFileSize(storedSizeOf("0123456789")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+
+	// Unknown topic.
+	offset, err := filestore.FetchOffset("some_group", topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, offset)
+
+	var cutOff time.Time
+	for i := 0; i < 4; i++ {
+		if i == 2 {
+			time.Sleep(20 * time.Millisecond)
+			cutOff = time.Now()
+			time.Sleep(20 * time.Millisecond)
+		}
+		_, err = filestore.Store(ctx, topic, []byte("0123456789"))
+		assert.Nil(t, err)
+	}
+	offset, err = filestore.FetchOffset("some_group", topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, offset)
+
+	// With the oldest messages removed.
+	err = filestore.RemoveOldMessages(ctx, cutOff)
+	assert.Nil(t, err)
+	offset, err = filestore.FetchOffset("some_group", topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, offset)
+
+	// With all messages removed.
+	err = filestore.RemoveOldMessages(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	offset, err = filestore.FetchOffset("some_group", topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, offset)
+}
+
+func TestResetOffset(t *testing.T) {
+	// Make sure that resetting a group's offset to the earliest, or latest
+	// position in the topic, or to a time, commits the offset of the
+	// oldest message retained, of the next message to be stored, or of the
+	// first message stored at, or after the time. (Each message is sized to
+	// occupy a file of its own, so that the removal of each is possible.)
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(
+		rootDir, WithMaxFileSize(storedSizeOf("0123456789")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	group := "some_group"
+	err = filestore.ResetOffset(group, topic, Earliest)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+
+	var cutOff time.Time
+	for i := 0; i < 4; i++ {
+		if i == 2 {
+			time.Sleep(20 * time.Millisecond)
+			cutOff = time.Now()
+			time.Sleep(20 * time.Millisecond)
+		}
+		_, err = filestore.Store(ctx, topic, []byte("0123456789"))
+		assert.Nil(t, err)
+	}
+	assertResetTo := func(want int) {
+		assert.Nil(t, err)
+		offset, err := filestore.FetchOffset(group, topic)
+		assert.Nil(t, err)
+		assert.Equal(t, want, offset)
+	}
+	err = filestore.ResetOffset(group, topic, Latest)
+	assertResetTo(5)
+	err = filestore.ResetOffset(group, topic, Earliest)
+	assertResetTo(1)
+	err = filestore.ResetOffsetToTime(group, topic, cutOff)
+	assertResetTo(3)
+	err = filestore.ResetOffsetToTime(group, topic,
+		time.Now().Add(time.Hour))
+	assertResetTo(5)
+	err = filestore.ResetOffsetToTime(group, topic,
+		time.Now().Add(-time.Hour))
+	assertResetTo(1)
+
+	// With the oldest messages removed.
+	err = filestore.RemoveOldMessages(ctx, cutOff)
+	assert.Nil(t, err)
+	err = filestore.ResetOffset(group, topic, Earliest)
+	assertResetTo(3)
+
+	// With all messages removed.
+	err = filestore.RemoveOldMessages(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	err = filestore.ResetOffset(group, topic, Earliest)
+	assertResetTo(5)
+	err = filestore.ResetOffset(group, topic, Latest)
+	assertResetTo(5)
+
+	err = filestore.ResetOffset(group, topic, OffsetPosition(2))
+	assert.NotNil(t, err)
+}
+
+func TestConsumerLag(t *testing.T) {
+	// Make sure that a group's lag is the number of messages from its
+	// offset to the high-water mark - zero once it has caught up - and that
+	// an offset whose messages have been removed, or that is beyond the
+	// topic, is clamped, and reported, both by ConsumerLag and by AllLag.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	otherTopic := "other_topic"
+	_, err = filestore.ConsumerLag("some_group", topic)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+	for i := 0; i < 5; i++ {
+		_, err = filestore.Store(ctx, topic, []byte("a message"))
+		assert.Nil(t, err)
+	}
+	_, err = filestore.Store(ctx, otherTopic, []byte("a message"))
+	assert.Nil(t, err)
+
+	// A group that has committed nothing lags by the whole topic.
+	lag, err := filestore.ConsumerLag("fresh_group", topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, lag)
+
+	// Caught up.
+	err = filestore.CommitOffset("caught_up", topic, 6)
+	assert.Nil(t, err)
+	lag, err = filestore.ConsumerLag("caught_up", topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, lag)
+
+	// Lagging.
+	err = filestore.CommitOffset("lagging", topic, 3)
+	assert.Nil(t, err)
+	err = filestore.CommitOffset("lagging", otherTopic, 1)
+	assert.Nil(t, err)
+	lag, err = filestore.ConsumerLag("lagging", topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, lag)
+	lags, err := filestore.AllLag("lagging")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{topic: 3, otherTopic: 1}, lags)
+
+	// Trimmed away.
+	_, err = filestore.TruncateBefore(topic, 5)
+	assert.Nil(t, err)
+	lag, err = filestore.ConsumerLag("lagging", topic)
+	assert.True(t, errors.Is(err, ErrOffsetOutOfRange))
+	assert.Equal(t, 1, lag)
+	lags, err = filestore.AllLag("lagging")
+	assert.True(t, errors.Is(err, ErrOffsetOutOfRange))
+	assert.Equal(t, map[string]int{topic: 1, otherTopic: 1}, lags)
+
+	// Beyond the high-water mark.
+	err = filestore.CommitOffset("ahead", topic, 9)
+	assert.Nil(t, err)
+	lag, err = filestore.ConsumerLag("ahead", topic)
+	assert.True(t, errors.Is(err, ErrOffsetOutOfRange))
+	assert.Equal(t, 0, lag)
+
+	lags, err = filestore.AllLag("unknown_group")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{}, lags)
+}
+
+func TestDeleteTopicForgetsCommittedOffsets(t *testing.T) {
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, err = filestore.Store(ctx, topic, []byte("a message"))
+	assert.Nil(t, err)
+	err = filestore.CommitOffset("some_group", topic, 2)
+	assert.Nil(t, err)
+
+	err = filestore.DeleteTopic(ctx, topic)
+	assert.Nil(t, err)
+	offset, err := filestore.FetchOffset("some_group", topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, offset)
+}
+
+func TestTrimToCount(t *testing.T) {
+	// Make sure that with a retention count of 5, storing 12 messages and
+	// then trimming leaves only the 5 newest, and that they are pollable.
+	// Other topics should be left alone.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(
+		rootDir, WithMaxFileSize(3*storedSizeOf("message_NN")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	err = filestore.SetRetentionCount(topic, 5)
+	assert.Nil(t, err)
+	for i := 1; i <= 12; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%02d", i)))
+		assert.Nil(t, err)
+		_, err = filestore.Store(ctx, "other_topic", []byte("a message"))
+		assert.Nil(t, err)
+	}
+
+	nRemoved, err := filestore.TrimToCount()
+	assert.Nil(t, err)
+	assert.Equal(t, 7, nRemoved)
+
+	messages, newReadFrom, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(messages))
+	for i, msg := range messages {
+		assert.Equal(t, fmt.Sprintf("message_%02d", i+8), string(msg))
+	}
+	assert.Equal(t, 13, newReadFrom)
+	count, err := filestore.MessageCount("other_topic")
+	assert.Nil(t, err)
+	assert.Equal(t, 12, count)
+
+	// Storage carries on as normal after trimming.
+	msgNumber, err := filestore.Store(ctx, topic, []byte("message_13"))
+	assert.Nil(t, err)
+	assert.Equal(t, 13, msgNumber)
+	oldest, newest, err := filestore.Bounds(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 8, oldest)
+	assert.Equal(t, 13, newest)
+
+	err = filestore.SetRetentionCount(topic, -1)
+	assert.NotNil(t, err)
+}
+
+func TestTrimToCountAfterCompaction(t *testing.T) {
+	// Make sure that trimming a topic whose numbering has gaps in it, left
+	// by compaction, still leaves as many messages as the retention count.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(
+		rootDir, WithMaxFileSize(2*storedSizeOf("message_NN")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i, key := range []string{"x", "b", "a", "c", "a"} {
+		message := fmt.Sprintf("message_%d", i+1)
+		_, err = filestore.StoreKeyed(ctx, topic, []byte(key), []byte(message))
+		assert.Nil(t, err)
+	}
+	_, err = filestore.Compact(topic)
+	assert.Nil(t, err)
+	err = filestore.SetRetentionCount(topic, 3)
+	assert.Nil(t, err)
+
+	nRemoved, err := filestore.TrimToCount()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, nRemoved)
+	found, _, err := filestore.PollRecords(ctx, topic, 1)
+	assert.Nil(t, err)
+	numbers := []int{}
+	for _, record := range found {
+		numbers = append(numbers, record.Number)
+	}
+	assert.Equal(t, []int{2, 4, 5}, numbers)
+}
+
+func TestTrimToSize(t *testing.T) {
+	// Make sure that trimming to a small byte budget reclaims the oldest
+	// message files, that Bounds reflects the new oldest message, and that
+	// the newest file survives even when it alone exceeds the budget.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	msgSize := storedSizeOf("message_NN")
+	filestore, err := NewFileStore(rootDir, WithMaxFileSize(2*msgSize))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 7; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%02d", i)))
+		assert.Nil(t, err)
+	}
+	// Files now hold 1-2, 3-4, 5-6 and 7.
+
+	err = filestore.SetRetentionBytes(topic, 3*msgSize)
+	assert.Nil(t, err)
+	nRemoved, err := filestore.TrimToSize()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, nRemoved)
+	oldest, newest, err := filestore.Bounds(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, oldest)
+	assert.Equal(t, 7, newest)
+
+	// A budget smaller than the newest file.
+	err = filestore.SetRetentionBytes(topic, 1)
+	assert.Nil(t, err)
+	_, err = filestore.TrimToSize()
+	assert.Nil(t, err)
+	oldest, newest, err = filestore.Bounds(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, oldest)
+	assert.Equal(t, 7, newest)
+	messages, _, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+
+	err = filestore.SetRetentionBytes(topic, -1)
+	assert.NotNil(t, err)
+}
+
+func TestReadableAfterReopeningWithAndWithoutSyncOnWrite(t *testing.T) {
+	// Make sure that messages are readable after a simulated reopen, both
+	// when syncing on write, and when relying on an explicit Flush.
+
+	ctx := context.Background()
+	for _, syncOnWrite := range []bool{true, false} {
+		rootDir := ioutils.TmpRootDir(t)
+		defer os.RemoveAll(rootDir)
+
+		filestore, err := NewFileStore(rootDir, WithSyncOnWrite(syncOnWrite))
+		if err != nil {
+			msg := fmt.Sprintf("NewFileStore(): %v", err)
+			assert.FailNow(t, msg)
+		}
+		topic := "some_topic"
+		for i := 1; i <= 3; i++ {
+			_, err = filestore.Store(ctx, topic,
+				[]byte(fmt.Sprintf("message_%d", i)))
+			assert.Nil(t, err)
+		}
+		err = filestore.Flush()
+		assert.Nil(t, err)
+
+		reopened, err := NewFileStore(rootDir)
+		if err != nil {
+			msg := fmt.Sprintf("NewFileStore(): %v", err)
+			assert.FailNow(t, msg)
+		}
+		messages, _, err := reopened.Poll(ctx, topic, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(messages), "syncOnWrite: %v", syncOnWrite)
+		assert.Equal(t, "message_3", string(messages[2]))
+	}
+}
+
+func TestFlushWhenWrittenFileHasBeenRemoved(t *testing.T) {
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, err = filestore.Store(ctx, "some_topic", []byte("a message"))
+	assert.Nil(t, err)
+	err = filestore.DeleteTopic(ctx, "some_topic")
+	assert.Nil(t, err)
+	err = filestore.Flush()
+	assert.Nil(t, err)
+}
+
+func TestRebuildIndexAfterIndexIsDeleted(t *testing.T) {
+	// Store messages across several files, delete the index, and make sure
+	// that after a rebuild, Poll returns the full original sequence, and
+	// storage carries on from the right message number.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(
+		rootDir, WithMaxFileSize(2*storedSizeOf("message_N")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 5; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	err = os.Remove(filenamer.IndexFile(rootDir))
+	if err != nil {
+		msg := fmt.Sprintf("os.Remove(): %v", err)
+		assert.FailNow(t, msg)
+	}
+
+	index, err := filestore.RebuildIndex()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(index.MessageFileLists[topic].Names))
+
+	messages, newReadFrom, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(messages))
+	for i, msg := range messages {
+		assert.Equal(t, fmt.Sprintf("message_%d", i+1), string(msg))
+	}
+	assert.Equal(t, 6, newReadFrom)
+	msgNumber, err := filestore.Store(ctx, topic, []byte("message_6"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, msgNumber)
+}
+
+func TestRebuildIndexWithTruncatedFile(t *testing.T) {
+	// Make sure that a partly written final record is dropped and reported,
+	// without losing the messages that precede it.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 3; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	msgFileList := filestore.index.MessageFileLists[topic]
+	filePath := filenamer.MessageFilePath(msgFileList.Names[0], topic, rootDir)
+	err = os.Truncate(filePath, msgFileList.Meta[msgFileList.Names[0]].Size-5)
+	if err != nil {
+		msg := fmt.Sprintf("os.Truncate(): %v", err)
+		assert.FailNow(t, msg)
+	}
+
+	_, err = filestore.RebuildIndex()
+	assert.True(t, errors.Is(err, ErrUnreadableRecords))
+	messages, _, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "message_2", string(messages[1]))
+
+	// Storage resumes cleanly after the dropped record.
+	_, err = filestore.Store(ctx, topic, []byte("message_X"))
+	assert.Nil(t, err)
+	messages, _, err = filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(messages))
+	assert.Equal(t, "message_X", string(messages[2]))
+}
+
+func TestInterruptedAppendIsDiscardedOnOpening(t *testing.T) {
+	// Simulate a crash part way through appending a second record, and make
+	// sure that a store opened afterwards returns just the first message,
+	// and stores the next one where it can be read back.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, err = filestore.Store(ctx, topic, []byte("message_1"))
+	assert.Nil(t, err)
+	msgFileName := filestore.index.CurrentMsgFileNameFor(topic)
+	encoded, _ := records.DefaultSerializer.Encode(records.StoredMessage{
+		MsgNum: 2, Created: time.Now(), Message: []byte("message_2")})
+	err = ioutils.AppendToFile(
+		filenamer.MessageFilePath(msgFileName, topic, rootDir),
+		records.Frame(encoded)[:12])
+	if err != nil {
+		msg := fmt.Sprintf("ioutils.AppendToFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+
+	reopened, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	messages, newReadFrom, err := reopened.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, 2, newReadFrom)
+
+	_, err = reopened.Store(ctx, topic, []byte("message_X"))
+	assert.Nil(t, err)
+	messages, _, err = reopened.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "message_X", string(messages[1]))
+}
+
+func TestCorruptRecordIsSkippedAndReported(t *testing.T) {
+	// Flip a byte in the middle of the three records in a message file, and
+	// make sure that a Poll still returns the messages either side of it,
+	// while reporting the bad one. Then make sure that rebuilding the index
+	// drops the bad record from the file.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 3; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	msgFileList := filestore.index.MessageFileLists[topic]
+	fileMeta := msgFileList.Meta[msgFileList.Names[0]]
+	filePath := filenamer.MessageFilePath(msgFileList.Names[0], topic, rootDir)
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.ReadFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	contents[fileMeta.SeekOffsetForMessageNumber[2]+20] ^= 0xff
+	err = ioutil.WriteFile(filePath, contents, 0644)
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.WriteFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+
+	messages, newReadFrom, err := filestore.Poll(ctx, topic, 1)
+	assert.True(t, errors.Is(err, ErrCorruptRecords))
+	assert.Contains(t, err.Error(), "[2]")
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "message_1", string(messages[0]))
+	assert.Equal(t, "message_3", string(messages[1]))
+	assert.Equal(t, 4, newReadFrom)
+	messages, newReadFrom, err = filestore.PollFromTime(
+		ctx, topic, time.Time{})
+	assert.True(t, errors.Is(err, ErrCorruptRecords))
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "message_3", string(messages[1]))
+	assert.Equal(t, 4, newReadFrom)
+
+	_, err = filestore.RebuildIndex()
+	assert.True(t, errors.Is(err, ErrUnreadableRecords))
+	messages, _, err = filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "message_3", string(messages[1]))
+	count, err := filestore.MessageCount(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestCorruptRecordIsDeadLettered(t *testing.T) {
+	// Corrupt the middle one of three records, and make sure that polling
+	// still returns the others, while the corrupt record's raw bytes land in
+	// the dead-letter topic, and are written to the dead-letter writer - but
+	// only once, however often it is polled, and when the index is rebuilt
+	// to drop it.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	var written bytes.Buffer
+	filestore, err := NewFileStore(rootDir, WithDeadLetterTopic("dead"),
+		WithDeadLetterWriter(&written))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 3; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	msgFileList := filestore.index.MessageFileLists[topic]
+	fileMeta := msgFileList.Meta[msgFileList.Names[0]]
+	filePath := filenamer.MessageFilePath(msgFileList.Names[0], topic, rootDir)
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.ReadFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	start := fileMeta.SeekOffsetForMessageNumber[2]
+	end := fileMeta.SeekOffsetForMessageNumber[3]
+	contents[start+20] ^= 0xff
+	err = ioutil.WriteFile(filePath, contents, 0644)
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.WriteFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+
+	for i := 0; i < 2; i++ {
+		messages, _, err := filestore.Poll(ctx, topic, 1)
+		assert.True(t, errors.Is(err, ErrCorruptRecords))
+		assert.Equal(t, 2, len(messages))
+		assert.Equal(t, "message_1", string(messages[0]))
+		assert.Equal(t, "message_3", string(messages[1]))
+	}
+	_, err = filestore.RebuildIndex()
+	assert.True(t, errors.Is(err, ErrUnreadableRecords))
+
+	dead, _, err := filestore.PollKeyed(ctx, "dead", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(dead))
+	assert.Equal(t, contents[start:end], []byte(dead[0].Message))
+	assert.Equal(t, topic, dead[0].Headers[DeadLetterTopicHeader])
+	assert.Equal(t, "2", dead[0].Headers[DeadLetterMessageNumberHeader])
+	assert.Contains(t, dead[0].Headers[DeadLetterProblemHeader], "checksum")
+
+	var letter DeadLetter
+	decoder := json.NewDecoder(&written)
+	err = decoder.Decode(&letter)
+	assert.Nil(t, err)
+	assert.Equal(t, topic, letter.Topic)
+	assert.Equal(t, 2, letter.MessageNumber)
+	assert.Equal(t, start, letter.Offset)
+	assert.Equal(t, contents[start:end], letter.Raw)
+	assert.False(t, decoder.More())
+}
+
+func TestIndexFlushInterval(t *testing.T) {
+	// Make sure that with a long flush interval the index file is not
+	// rewritten by each Store, but that it is by Close, and that a reopened
+	// store sees everything.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir, WithIndexFlushInterval(time.Hour))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, err = filestore.Store(ctx, topic, []byte("message_1"))
+	assert.Nil(t, err)
+	persisted := indexing.NewIndex()
+	err = persisted.PopulateFromDisk(filenamer.IndexFile(rootDir))
+	assert.Nil(t, err)
+	_, ok := persisted.MessageFileLists[topic]
+	assert.False(t, ok)
+
+	_, err = filestore.Store(ctx, topic, []byte("message_2"))
+	assert.Nil(t, err)
+	err = filestore.Close()
+	assert.Nil(t, err)
+	err = persisted.PopulateFromDisk(filenamer.IndexFile(rootDir))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, persisted.MessageFileLists[topic].NumMessages())
+
+	reopened, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	messages, _, err := reopened.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+}
+
+func TestClose(t *testing.T) {
+	// Make sure that Close persists what was stored, wakes up a blocked poll,
+	// and that the store refuses to be used afterwards - while a new store
+	// on the same directory finds everything intact.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir,
+		WithIndexFlushInterval(time.Hour), WithCompression(true))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, _, err = filestore.StoreBatch(ctx, topic, []minikafka.Message{
+		[]byte("message_1"), []byte("message_2"), []byte("message_3")})
+	assert.Nil(t, err)
+
+	pollErr := make(chan error)
+	go func() {
+		_, _, err := filestore.PollBlocking(ctx, "other_topic", 1)
+		pollErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	err = filestore.Close()
+	assert.Nil(t, err)
+	select {
+	case err = <-pollErr:
+		assert.True(t, errors.Is(err, ErrStoreClosed))
+	case <-time.After(time.Second):
+		assert.FailNow(t, "PollBlocking was not woken by Close.")
+	}
+	assert.False(t, ioutils.Exists(filenamer.IndexDirtyMarkerFile(rootDir)))
+
+	_, err = filestore.Store(ctx, topic, []byte("message_4"))
+	assert.True(t, errors.Is(err, ErrStoreClosed))
+	_, _, err = filestore.Poll(ctx, topic, 1)
+	assert.True(t, errors.Is(err, ErrStoreClosed))
+	_, err = filestore.MessageCount(topic)
+	assert.True(t, errors.Is(err, ErrStoreClosed))
+	err = filestore.Flush()
+	assert.True(t, errors.Is(err, ErrStoreClosed))
+	err = filestore.Close()
+	assert.True(t, errors.Is(err, ErrStoreClosed))
+
+	reopened, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	messages, newReadFrom, err := reopened.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []minikafka.Message{[]byte("message_1"),
+		[]byte("message_2"), []byte("message_3")}, messages)
+	assert.Equal(t, 4, newReadFrom)
+}
+
+func TestShutdownDrainsStoresUnderWay(t *testing.T) {
+	// Make sure that Shutdown, called while messages are being stored
+	// concurrently, makes every store that it acknowledged durable - and
+	// leaves an index that agrees with the message files, with no sign of
+	// being mid-change - while refusing the stores made after it.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir,
+		WithIndexFlushInterval(time.Hour), WithMaxFileSize(500))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	const nProducers = 8
+	acknowledged := make([][]string, nProducers)
+	refusals := make([]error, nProducers)
+	var wg sync.WaitGroup
+	for p := 0; p < nProducers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			topic := fmt.Sprintf("topic_%d", p%3)
+			for i := 0; ; i++ {
+				message := fmt.Sprintf("producer_%d_message_%d", p, i)
+				_, err := filestore.Store(ctx, topic, []byte(message))
+				if err != nil {
+					refusals[p] = err
+					return
+				}
+				acknowledged[p] = append(acknowledged[p], message)
+			}
+		}(p)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err = filestore.Shutdown(shutdownCtx)
+	assert.Nil(t, err)
+	wg.Wait()
+	for p := 0; p < nProducers; p++ {
+		assert.True(t, errors.Is(refusals[p], ErrStoreClosed))
+	}
+	assert.False(t, ioutils.Exists(filenamer.IndexDirtyMarkerFile(rootDir)))
+	err = filestore.Shutdown(ctx)
+	assert.True(t, errors.Is(err, ErrStoreClosed))
+
+	reopened, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	found := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		messages, _, err := reopened.Poll(ctx, fmt.Sprintf("topic_%d", i), 1)
+		assert.Nil(t, err)
+		for _, message := range messages {
+			found[string(message)] = true
+		}
+	}
+	nAcknowledged := 0
+	for p := 0; p < nProducers; p++ {
+		for _, message := range acknowledged[p] {
+			assert.True(t, found[message], message)
+		}
+		nAcknowledged += len(acknowledged[p])
+	}
+	assert.Equal(t, nAcknowledged, len(found))
+	persisted := reopened.index.NextMessageNumbers
+	rebuilt, err := reopened.RebuildIndex()
+	assert.Nil(t, err)
+	assert.Equal(t, persisted, rebuilt.NextMessageNumbers)
+}
+
+func TestShutdownGivesUpAtItsDeadline(t *testing.T) {
+	// Make sure that Shutdown, when a store under way does not finish in
+	// time, persists what has been stored, returns the context's error, and
+	// leaves the store refusing messages - but open, for Close.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir, WithIndexFlushInterval(time.Hour))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, err = filestore.Store(ctx, topic, []byte("message_1"))
+	assert.Nil(t, err)
+	// Pose as a store that is under way.
+	filestore.storesInFlight.Add(1)
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err = filestore.Shutdown(shutdownCtx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.False(t, ioutils.Exists(filenamer.IndexDirtyMarkerFile(rootDir)))
+	_, err = filestore.Store(ctx, topic, []byte("message_2"))
+	assert.True(t, errors.Is(err, ErrStoreClosed))
+	messages, _, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+
+	filestore.storesInFlight.Done()
+	err = filestore.Close()
+	assert.Nil(t, err)
+}
+
+func TestStats(t *testing.T) {
+	// Make sure that the statistics agree exactly with what was stored, and
+	// with the message files on disk.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir, WithMaxFileSize(200))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	counts := map[string]int{"topic_a": 7, "topic_b": 2, "topic_c": 1}
+	for topic, count := range counts {
+		for i := 0; i < count; i++ {
+			_, err = filestore.Store(ctx, topic, []byte("some_message"))
+			assert.Nil(t, err)
+		}
+	}
+
+	stats, err := filestore.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, stats.Topics)
+	assert.Equal(t, 10, stats.Messages)
+	var totalBytes int64
+	totalFiles := 0
+	for topic, count := range counts {
+		topicStats := stats.PerTopic[topic]
+		assert.Equal(t, count, topicStats.Messages)
+		assert.Equal(t, 1, topicStats.Oldest)
+		assert.Equal(t, count, topicStats.Newest)
+
+		infos, err := ioutil.ReadDir(
+			filenamer.DirectoryForTopic(topic, rootDir))
+		assert.Nil(t, err)
+		var bytes int64
+		for _, info := range infos {
+			bytes += info.Size()
+		}
+		assert.Equal(t, len(infos), topicStats.SegmentFiles)
+		assert.Equal(t, bytes, topicStats.Bytes)
+		totalBytes += bytes
+		totalFiles += len(infos)
+	}
+	assert.True(t, stats.PerTopic["topic_a"].SegmentFiles > 1)
+	assert.Equal(t, totalBytes, stats.Bytes)
+	assert.Equal(t, totalFiles, stats.SegmentFiles)
+
+	encoded, err := json.Marshal(stats)
+	assert.Nil(t, err)
+	var decoded Stats
+	err = json.Unmarshal(encoded, &decoded)
+	assert.Nil(t, err)
+	assert.Equal(t, stats, decoded)
+}
+
+func TestHealthCheck(t *testing.T) {
+	// Make sure that a healthy store passes its health check, with each of
+	// the store's own settings, without its contents being changed, or the
+	// probe topic left behind. And that a store whose index file cannot be
+	// decoded, or whose root directory has gone, fails it, with an error
+	// that says why.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	options := [][]Option{{},
+		{WithCompression(true), WithEncryptionKey(make([]byte, 32))}}
+	for _, storeOptions := range options {
+		filestore, err := NewFileStore(rootDir, storeOptions...)
+		if err != nil {
+			msg := fmt.Sprintf("NewFileStore(): %v", err)
+			assert.FailNow(t, msg)
+		}
+		err = filestore.DeleteContents(ctx)
+		assert.Nil(t, err)
+		_, err = filestore.Store(ctx, "some_topic", []byte("message_1"))
+		assert.Nil(t, err)
+		probeDir := filenamer.DirectoryForTopic(healthTopic, rootDir)
+
+		// Including after an earlier check was interrupted.
+		err = os.Mkdir(probeDir, 0700)
+		assert.Nil(t, err)
+		for i := 0; i < 3; i++ {
+			err = filestore.HealthCheck()
+			assert.Nil(t, err)
+		}
+		assert.False(t, ioutils.Exists(probeDir))
+		topics, err := filestore.ListTopics(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"some_topic"}, topics)
+		messages, _, err := filestore.Poll(ctx, "some_topic", 1)
+		assert.Nil(t, err)
+		assert.Equal(t, []minikafka.Message{[]byte("message_1")}, messages)
+		_, err = filestore.Store(ctx, healthTopic, []byte("message_1"))
+		assert.True(t, errors.Is(err, ErrInvalidTopic))
+		err = filestore.Close()
+		assert.Nil(t, err)
+		err = filestore.HealthCheck()
+		assert.True(t, errors.Is(err, ErrStoreClosed))
+	}
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	err = ioutil.WriteFile(filenamer.IndexFile(rootDir),
+		[]byte("not an index"), 0600)
+	assert.Nil(t, err)
+	err = filestore.HealthCheck()
+	assert.True(t, errors.Is(err, ErrUnhealthy))
+	assert.Contains(t, err.Error(), "the index file cannot be decoded")
+
+	err = os.RemoveAll(rootDir)
+	assert.Nil(t, err)
+	err = filestore.HealthCheck()
+	assert.True(t, errors.Is(err, ErrUnhealthy))
+}
+
+func TestHealthCheckOnReadOnlyDisk(t *testing.T) {
+	// Make sure that a store whose root directory can no longer be written
+	// to fails its health check, with an error that says so.
+
+	if os.Geteuid() == 0 {
+		t.Skip("Directory permissions are not enforced for root.")
+	}
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	err = filestore.HealthCheck()
+	assert.Nil(t, err)
+	err = os.Chmod(rootDir, 0500)
+	if err != nil {
+		msg := fmt.Sprintf("os.Chmod(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	defer os.Chmod(rootDir, 0700)
+	err = filestore.HealthCheck()
+	assert.True(t, errors.Is(err, ErrUnhealthy))
+	assert.Contains(t, err.Error(), "root directory is not writable")
+}
+
+func TestGetMessage(t *testing.T) {
+	// Make sure that a message can be fetched by its number, with its
+	// creation time, and that one removed by retention, and one never
+	// stored, are reported as not found.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir, WithMaxFileSize(300))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	before := time.Now()
+	for i := 1; i <= 6; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	err = filestore.SetRetentionCount(topic, 4)
+	assert.Nil(t, err)
+	_, err = filestore.TrimToCount()
+	assert.Nil(t, err)
+
+	message, created, err := filestore.GetMessage(topic, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "message_5", string(message))
+	assert.False(t, created.Before(before))
+	assert.False(t, created.After(time.Now()))
+
+	_, _, err = filestore.GetMessage(topic, 1)
+	assert.True(t, errors.Is(err, contract.ErrMessageNotFound))
+	_, _, err = filestore.GetMessage(topic, 7)
+	assert.True(t, errors.Is(err, contract.ErrMessageNotFound))
+	_, _, err = filestore.GetMessage("nosuchtopic", 1)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+}
+
+func TestGetRange(t *testing.T) {
+	// Make sure that a range provides exactly the messages in it, across
+	// message files, and that a range that extends beyond the messages
+	// retained, at either end, is cut down to them.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir, WithMaxFileSize(300))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 10; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	err = filestore.SetRetentionCount(topic, 8)
+	assert.Nil(t, err)
+	_, err = filestore.TrimToCount()
+	assert.Nil(t, err)
+	stats, err := filestore.Stats()
+	assert.Nil(t, err)
+	assert.True(t, stats.SegmentFiles > 2)
+
+	expected := func(from int, to int) []minikafka.Message {
+		messages := []minikafka.Message{}
+		for i := from; i <= to; i++ {
+			messages = append(messages, []byte(fmt.Sprintf("message_%d", i)))
+		}
+		return messages
+	}
+	cases := []struct {
+		from, to                 int
+		expectedFrom, expectedTo int
+	}{
+		{4, 8, 4, 8},   // Spans files.
+		{6, 6, 6, 6},   // Just one.
+		{1, 5, 3, 5},   // Starts before those retained.
+		{9, 20, 9, 10}, // Ends after the newest.
+		{1, 20, 3, 10}, // Both.
+		{11, 20, 11, 10},
+		{1, 2, 1, 0},
+	}
+	for _, c := range cases {
+		messages, err := filestore.GetRange(ctx, topic, c.from, c.to)
+		assert.Nil(t, err)
+		assert.Equal(t, expected(c.expectedFrom, c.expectedTo), messages,
+			"from %d to %d", c.from, c.to)
+	}
+
+	_, err = filestore.GetRange(ctx, topic, 5, 4)
+	assert.EqualError(t, err, "range start (5) is beyond its end (4)")
+	_, err = filestore.GetRange(ctx, "nosuchtopic", 1, 2)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+}
+
+func TestJanitor(t *testing.T) {
+	// Make sure that once the janitor is started, messages are removed when
+	// they expire, without anything else being called, and that it stops
+	// when the store is closed. The store's clock is moved forward, rather
+	// than waiting for the messages to expire.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	reported := make(chan error, 100)
+	clock := newFakeClock()
+	filestore, err := NewFileStore(rootDir, WithClock(clock),
+		WithJanitorErrorHandler(func(err error) { reported <- err }))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, _, err = filestore.StoreBatch(ctx, topic, []minikafka.Message{
+		[]byte("message_1"), []byte("message_2")})
+	assert.Nil(t, err)
+
+	err = filestore.StartJanitor(time.Minute, time.Millisecond)
+	assert.Nil(t, err)
+	err = filestore.StartJanitor(time.Minute, time.Millisecond)
+	assert.EqualError(t, err, "janitor is already running")
+
+	// The messages have not expired yet.
+	time.Sleep(20 * time.Millisecond)
+	count, err := filestore.MessageCount(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	clock.advance(time.Hour)
+	deadline := time.Now().Add(5 * time.Second)
+	for count != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		count, err = filestore.MessageCount(topic)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 0, count)
+
+	err = filestore.Close()
+	assert.Nil(t, err)
+	select {
+	case err = <-reported:
+		assert.Fail(t, fmt.Sprintf("Janitor reported: %v", err))
+	default:
+	}
+	filestore.StopJanitor()
+}
+
+func TestMaxSegmentAge(t *testing.T) {
+	// Make sure that messages stored either side of the maximum segment age
+	// land in separate files, even though they would fit in one, so that
+	// the older ones can be removed on their own by RemoveOldMessages.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir,
+		WithMaxSegmentAge(50*time.Millisecond))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, _, err = filestore.StoreBatch(ctx, topic, []minikafka.Message{
+		[]byte("message_1"), []byte("message_2")})
+	assert.Nil(t, err)
+	time.Sleep(60 * time.Millisecond)
+	boundary := time.Now()
+	_, _, err = filestore.StoreBatch(ctx, topic, []minikafka.Message{
+		[]byte("message_3"), []byte("message_4")})
+	assert.Nil(t, err)
+
+	fileList := filestore.index.MessageFileLists[topic]
+	assert.Equal(t, 2, len(fileList.Names))
+	assert.Equal(t, 2, fileList.NumMessagesInFile(fileList.Names[0]))
+	assert.Equal(t, 2, fileList.NumMessagesInFile(fileList.Names[1]))
+	assert.True(t, fileList.Meta[fileList.Names[1]].Opened.After(boundary))
+
+	err = filestore.RemoveOldMessages(ctx, boundary)
+	assert.Nil(t, err)
+	messages, _, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []minikafka.Message{
+		[]byte("message_3"), []byte("message_4")}, messages)
+}
+
+func TestClockAgesMessagesPrecisely(t *testing.T) {
+	// Make sure that, with a clock the test moves forward itself, the message
+	// files roll over at exactly the maximum segment age, and that
+	// RemoveOldMessages removes exactly the messages created before the
+	// given time - those created at it are kept - without waiting for any
+	// time to pass.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	clock := newFakeClock()
+	start := clock.Now()
+	filestore, err := NewFileStore(rootDir, WithClock(clock),
+		WithMaxSegmentAge(10*time.Minute))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	// A message a minute, for half an hour.
+	topic := "some_topic"
+	for i := 0; i < 30; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i+1)))
+		assert.Nil(t, err)
+		clock.advance(time.Minute)
+	}
+	fileList := filestore.index.MessageFileLists[topic]
+	assert.Equal(t, 3, len(fileList.Names))
+	for i, name := range fileList.Names {
+		opened := start.Add(time.Duration(i*10) * time.Minute)
+		assert.True(t, fileList.Meta[name].Opened.Equal(opened))
+		assert.Equal(t, 10, fileList.NumMessagesInFile(name))
+	}
+
+	// Message 16 was created 15 minutes in.
+	err = filestore.RemoveOldMessages(ctx, start.Add(15*time.Minute))
+	assert.Nil(t, err)
+	messages, _, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 15, len(messages))
+	assert.Equal(t, minikafka.Message("message_16"), messages[0])
+	assert.Equal(t, 2, len(fileList.Names))
+
+	// Nothing more has expired until the clock moves on.
+	err = filestore.RemoveOldMessages(ctx, start.Add(15*time.Minute))
+	assert.Nil(t, err)
+	count, err := filestore.MessageCount(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 15, count)
+	err = filestore.RemoveOldMessages(ctx,
+		start.Add(15*time.Minute+time.Nanosecond))
+	assert.Nil(t, err)
+	count, err = filestore.MessageCount(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 14, count)
+}
+
+func TestWritePathGivesIdenticalFiles(t *testing.T) {
+	// Make sure that the store, which appends to one handle it holds open
+	// for each topic, and keeps the index in memory, writes exactly what
+	// storing each message from scratch does - the same message files (bar
+	// their random names), and the same index - and that it opens each
+	// message file only once.
+
+	ctx := context.Background()
+	naiveDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(naiveDir)
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	clock := newFakeClock()
+	maxFileSize := 10 * storedSizeOf("message_NN")
+	filestore, err := NewFileStore(rootDir, WithClock(clock),
+		WithMaxFileSize(maxFileSize), WithIndexFlushInterval(time.Hour))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topics := []string{"topic_a", "topic_b"}
+	for i := 1; i <= 45; i++ {
+		for _, topic := range topics {
+			message := minikafka.Message(fmt.Sprintf("message_%02d", i))
+			err = storeNaively(naiveDir, topic, message, maxFileSize,
+				clock.Now)
+			assert.Nil(t, err)
+			_, err = filestore.Store(ctx, topic, message)
+			assert.Nil(t, err)
+		}
+		clock.advance(time.Second)
+	}
+	err = filestore.Flush()
+	assert.Nil(t, err)
+	// Five files for each topic.
+	assert.Equal(t, 10, filestore.handles.Opens())
+
+	naiveIndex := indexing.NewIndex()
+	err = naiveIndex.PopulateFromDisk(filenamer.IndexFile(naiveDir))
+	assert.Nil(t, err)
+	index := indexing.NewIndex()
+	err = index.PopulateFromDisk(filenamer.IndexFile(rootDir))
+	assert.Nil(t, err)
+	for _, topic := range topics {
+		assert.Equal(t, naiveIndex.NextMessageNumbers[topic],
+			index.NextMessageNumbers[topic])
+		naiveList := naiveIndex.MessageFileLists[topic]
+		fileList := index.MessageFileLists[topic]
+		if len(fileList.Names) != len(naiveList.Names) {
+			msg := fmt.Sprintf("%d files, not %d", len(fileList.Names),
+				len(naiveList.Names))
+			assert.FailNow(t, msg)
+		}
+		for i, name := range fileList.Names {
+			naiveName := naiveList.Names[i]
+			assert.Equal(t, naiveList.Meta[naiveName], fileList.Meta[name])
+			naiveContents, err := ioutil.ReadFile(
+				filenamer.MessageFilePath(naiveName, topic, naiveDir))
+			assert.Nil(t, err)
+			contents, err := ioutil.ReadFile(
+				filenamer.MessageFilePath(name, topic, rootDir))
+			assert.Nil(t, err)
+			assert.Equal(t, naiveContents, contents)
+		}
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	// Make sure that a batch holding a message just over the maximum message
+	// size is refused at that message, and that one just under it is
+	// accepted - and that an impossible limit is refused.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	// Find out how large a record the messages make.
+	probe, err := records.DefaultSerializer.Encode(records.StoredMessage{
+		MsgNum: 1, Created: time.Now(), Message: []byte("0123456789")})
+	assert.Nil(t, err)
+	limit := int64(len(records.Frame(probe)))
+
+	filestore, err := NewFileStore(rootDir, WithMaxMessageSize(limit))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, err = filestore.Store(ctx, topic, []byte("012345678"))
+	assert.Nil(t, err)
+	_, _, err = filestore.StoreBatch(ctx, topic, []minikafka.Message{
+		[]byte("0123456789"), []byte("01234567890")})
+	assert.True(t, errors.Is(err, contract.ErrMessageTooLarge))
+	count, err := filestore.MessageCount(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	_, err = NewFileStore(rootDir, WithMaxMessageSize(records.MaxFramedSize+1))
+	assert.NotNil(t, err)
+}
+
+func TestOnStore(t *testing.T) {
+	// Make sure that the on-store function is called for each message
+	// stored, with its topic, number and creation time, that it can use the
+	// store, and that a panic in it does not affect the store.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	type event struct {
+		topic         string
+		messageNumber int
+		message       string
+	}
+	events := []event{}
+	before := time.Now()
+	filestore.SetOnStore(func(topic string, messageNumber int,
+		created time.Time) {
+		message, _, err := filestore.GetMessage(topic, messageNumber)
+		assert.Nil(t, err)
+		assert.False(t, created.Before(before))
+		events = append(events, event{topic, messageNumber, string(message)})
+	})
+	_, err = filestore.Store(ctx, "topic_a", []byte("message_1"))
+	assert.Nil(t, err)
+	_, _, err = filestore.StoreBatch(ctx, "topic_b", []minikafka.Message{
+		[]byte("message_2"), []byte("message_3")})
+	assert.Nil(t, err)
+	assert.Equal(t, []event{
+		{"topic_a", 1, "message_1"},
+		{"topic_b", 1, "message_2"},
+		{"topic_b", 2, "message_3"},
+	}, events)
+
+	filestore.SetOnStore(func(string, int, time.Time) {
+		panic("on purpose")
+	})
+	_, err = filestore.Store(ctx, "topic_a", []byte("message_4"))
+	assert.Nil(t, err)
+	filestore.SetOnStore(nil)
+	messageNumber, err := filestore.Store(ctx, "topic_a", []byte("message_5"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, messageNumber)
+	assert.Equal(t, 3, len(events))
+}
+
+func TestStoreTransaction(t *testing.T) {
+	// Make sure that a transaction stores all its messages, and that one
+	// which fails part way through - having started a new message file -
+	// leaves the topic's messages, files and numbering exactly as they were.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	// Size the files so that exactly 3 messages fit in each, and make the
+	// maximum message size provide the failure.
+	probe, err := records.DefaultSerializer.Encode(records.StoredMessage{
+		MsgNum: 1, Created: time.Now(), Message: []byte("message_1")})
+	assert.Nil(t, err)
+	msgSize := int64(len(records.Frame(probe)))
+	filestore, err := NewFileStore(rootDir,
+		WithMaxFileSize(3*msgSize), WithMaxMessageSize(msgSize))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	first, last, err := filestore.StoreTransaction(ctx, topic,
+		[]minikafka.Message{[]byte("message_1"), []byte("message_2")})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, first)
+	assert.Equal(t, 2, last)
+	fileList := filestore.index.MessageFileLists[topic]
+	fileName := fileList.Names[0]
+	filePath := filenamer.MessageFilePath(fileName, topic, rootDir)
+
+	_, _, err = filestore.StoreTransaction(ctx, topic, []minikafka.Message{
+		[]byte("message_3"), []byte("message_4"),
+		[]byte("message_5 is too large"), []byte("message_6"),
+		[]byte("message_7")})
+	assert.True(t, errors.Is(err, contract.ErrMessageTooLarge))
+	count, err := filestore.MessageCount(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	oldest, newest, err := filestore.Bounds(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, oldest)
+	assert.Equal(t, 2, newest)
+	fileList = filestore.index.MessageFileLists[topic]
+	assert.Equal(t, []string{fileName}, fileList.Names)
+	info, err := os.Stat(filePath)
+	assert.Nil(t, err)
+	assert.Equal(t, 2*msgSize, info.Size())
+	n, err := ioutils.CountEntitiesInDir(
+		filenamer.DirectoryForTopic(topic, rootDir))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	// The numbering carries on as if the failed transaction never happened.
+	msgNum, err := filestore.Store(ctx, topic, []byte("message_3"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, msgNum)
+	messages, _, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []minikafka.Message{[]byte("message_1"),
+		[]byte("message_2"), []byte("message_3")}, messages)
+
+	// A failed transaction to a new topic leaves no trace of it.
+	_, _, err = filestore.StoreTransaction(ctx, "new_topic",
+		[]minikafka.Message{[]byte("message_1"), []byte("far too large")})
+	assert.True(t, errors.Is(err, contract.ErrMessageTooLarge))
+	topics, err := filestore.ListTopics(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{topic}, topics)
+	assert.False(t, ioutils.Exists(
+		filenamer.DirectoryForTopic("new_topic", rootDir)))
+}
+
+func TestStoreIdempotent(t *testing.T) {
+	// Make sure that repeating an idempotency key stores nothing, and
+	// provides the original message number, whereas a distinct key - or one
+	// that has dropped out of the window - stores a new message.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+	filestore, err := NewFileStore(rootDir, WithIdempotencyWindow(2))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+
+	msgNum, err := filestore.StoreIdempotent(
+		ctx, topic, "key_a", []byte("message_1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, msgNum)
+	fileList := filestore.index.MessageFileLists[topic]
+	sizeOfOne := fileList.Meta[fileList.Names[0]].Size
+
+	// The retry is not stored.
+	msgNum, err = filestore.StoreIdempotent(
+		ctx, topic, "key_a", []byte("message_1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, msgNum)
+	count, err := filestore.MessageCount(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	info, err := os.Stat(filenamer.MessageFilePath(
+		fileList.Names[0], topic, rootDir))
+	assert.Nil(t, err)
+	assert.Equal(t, sizeOfOne, info.Size())
+
+	// A different key is.
+	msgNum, err = filestore.StoreIdempotent(
+		ctx, topic, "key_b", []byte("message_2"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, msgNum)
+	messages, _, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []minikafka.Message{[]byte("message_1"),
+		[]byte("message_2")}, messages)
+
+	// Keys are per topic.
+	msgNum, err = filestore.StoreIdempotent(
+		ctx, "other_topic", "key_a", []byte("message_1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, msgNum)
+
+	// Only the most recent keys are remembered.
+	msgNum, err = filestore.StoreIdempotent(
+		ctx, topic, "key_c", []byte("message_3"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, msgNum)
+	msgNum, err = filestore.StoreIdempotent(
+		ctx, topic, "key_b", []byte("message_2"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, msgNum)
+	msgNum, err = filestore.StoreIdempotent(
+		ctx, topic, "key_a", []byte("message_1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, msgNum)
+
+	// Deleting the topic forgets its keys.
+	err = filestore.DeleteTopic(ctx, topic)
+	assert.Nil(t, err)
+	msgNum, err = filestore.StoreIdempotent(
+		ctx, topic, "key_a", []byte("message_1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, msgNum)
+
+	// The window must be able to hold at least one key.
+	_, err = NewFileStore(rootDir, WithIdempotencyWindow(0))
+	assert.EqualError(t, err, "idempotency window must be positive: 0")
+}
+
+func TestTruncateBefore(t *testing.T) {
+	// Make sure that truncating part way through a topic removes the
+	// messages before the given one, so that a poll from before the cut
+	// starts at it, and that it copes with the gaps compaction leaves.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	// Size the files so that exactly 3 messages fit in each.
+	probe, err := records.DefaultSerializer.Encode(records.StoredMessage{
+		MsgNum: 1, Created: time.Now(), Message: []byte("message_1")})
+	assert.Nil(t, err)
+	msgSize := int64(len(records.Frame(probe)))
+	filestore, err := NewFileStore(rootDir, WithMaxFileSize(3*msgSize))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 8; i++ {
+		_, err = filestore.Store(
+			ctx, topic, []byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	// Files now hold 1-3, 4-6 and 7-8.
+
+	removed, err := filestore.TruncateBefore(topic, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4}, removed)
+	oldest, newest, err := filestore.Bounds(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, oldest)
+	assert.Equal(t, 8, newest)
+	messages, newReadFrom, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []minikafka.Message{[]byte("message_5"),
+		[]byte("message_6"), []byte("message_7"), []byte("message_8")},
+		messages)
+	assert.Equal(t, 9, newReadFrom)
+
+	// Truncating at, or before the oldest does nothing.
+	removed, err = filestore.TruncateBefore(topic, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{}, removed)
+	removed, err = filestore.TruncateBefore(topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{}, removed)
+
+	// The truncation survives reopening the store.
+	filestore, err = NewFileStore(rootDir, WithMaxFileSize(3*msgSize))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	oldest, _, err = filestore.Bounds(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, oldest)
+
+	// A cut that falls in a gap left by compaction starts the topic at the
+	// next surviving message.
+	keyedTopic := "keyed_topic"
+	for i, key := range []string{"b", "a", "a", "c"} {
+		_, err = filestore.StoreKeyed(ctx, keyedTopic, []byte(key),
+			[]byte(fmt.Sprintf("message_%d", i+1)))
+		assert.Nil(t, err)
+	}
+	_, err = filestore.Compact(keyedTopic)
+	assert.Nil(t, err)
+	// Leaving 1, 3 and 4.
+	removed, err = filestore.TruncateBefore(keyedTopic, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1}, removed)
+	messages, _, err = filestore.Poll(ctx, keyedTopic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []minikafka.Message{[]byte("message_3"),
+		[]byte("message_4")}, messages)
+	removed, err = filestore.TruncateBefore(keyedTopic, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3}, removed)
+	messages, _, err = filestore.Poll(ctx, keyedTopic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []minikafka.Message{[]byte("message_4")}, messages)
+
+	_, err = filestore.TruncateBefore("nosuchtopic", 1)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+}
+
+func TestVersion1RecordsAreReadable(t *testing.T) {
+	// Make sure that a message file holding both a Version1 record - written
+	// by hand, as it was before frames held a version - and current ones
+	// can be polled.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, err = filestore.Store(ctx, topic, []byte("message_1"))
+	assert.Nil(t, err)
+	encoded, err := records.DefaultSerializer.Encode(records.StoredMessage{
+		MsgNum: 2, Created: time.Now(), Message: []byte("message_2")})
+	assert.Nil(t, err)
+	legacy := make([]byte, 8+len(encoded))
+	binary.LittleEndian.PutUint32(legacy[0:4], uint32(len(encoded)))
+	binary.LittleEndian.PutUint32(legacy[4:8], crc32.ChecksumIEEE(encoded))
+	copy(legacy[8:], encoded)
+	msgFileName := filestore.index.CurrentMsgFileNameFor(topic)
+	err = ioutils.AppendToFile(
+		filenamer.MessageFilePath(msgFileName, topic, rootDir), legacy)
+	if err != nil {
+		msg := fmt.Sprintf("ioutils.AppendToFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, err = filestore.RebuildIndex()
+	assert.Nil(t, err)
+	msgNum, err := filestore.Store(ctx, topic, []byte("message_3"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, msgNum)
+
+	messages, _, err := filestore.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []minikafka.Message{[]byte("message_1"),
+		[]byte("message_2"), []byte("message_3")}, messages)
+	message, _, err := filestore.GetMessage(topic, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "message_2", string(message))
+}
+
+func TestWatchTopics(t *testing.T) {
+	// Make sure that a watcher is told of each topic created after it starts
+	// watching, exactly once, and that its channel is closed when its
+	// context is done, or the store is closed.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, err = filestore.Store(ctx, "old_topic", []byte("message_1"))
+	assert.Nil(t, err)
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	topicsC, err := filestore.WatchTopics(watchCtx)
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, "old_topic", []byte("message_2"))
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, "topic_a", []byte("message_1"))
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, "topic_a", []byte("message_2"))
+	assert.Nil(t, err)
+	_, _, err = filestore.StoreTransaction(ctx, "topic_b",
+		[]minikafka.Message{[]byte("message_1"), []byte("message_2")})
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, "topic_b", []byte("message_3"))
+	assert.Nil(t, err)
+
+	received := receiveTopics(t, topicsC, 2)
+	assert.Equal(t, []string{"topic_a", "topic_b"}, received)
+	select {
+	case topic := <-topicsC:
+		msg := fmt.Sprintf("unexpected topic: %v", topic)
+		assert.FailNow(t, msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	_, ok := <-topicsC
+	assert.False(t, ok)
+
+	// A topic that is deleted, and then stored to again, is delivered again.
+	// And closing the store closes the channel.
+	topicsC, err = filestore.WatchTopics(ctx)
+	assert.Nil(t, err)
+	err = filestore.DeleteTopic(ctx, "topic_a")
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, "topic_a", []byte("message_1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"topic_a"}, receiveTopics(t, topicsC, 1))
+	err = filestore.Close()
+	assert.Nil(t, err)
+	_, ok = <-topicsC
+	assert.False(t, ok)
+	_, err = filestore.WatchTopics(ctx)
+	assert.True(t, errors.Is(err, ErrStoreClosed))
+}
+
+// receiveTopics receives the given number of topic names from the channel,
+// failing the test should they take too long to arrive.
+func receiveTopics(t *testing.T, topicsC <-chan string, n int) []string {
+	received := []string{}
+	for len(received) < n {
+		select {
+		case topic := <-topicsC:
+			received = append(received, topic)
+		case <-time.After(5 * time.Second):
+			msg := fmt.Sprintf("only received topics: %v", received)
+			assert.FailNow(t, msg)
+		}
+	}
+	return received
+}
+
+func TestExportAndImportTopic(t *testing.T) {
+	// Make sure that a topic exported from a FileStore, imported into a
+	// MemStore, and exported from that into another FileStore, has exactly
+	// the records it started with - including the gap left by compaction.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+	otherRootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(otherRootDir)
+
+	source, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, err = source.Store(ctx, topic, []byte("message_1"))
+	assert.Nil(t, err)
+	_, err = source.StoreKeyed(ctx, topic, []byte("key"), []byte("message_2"))
+	assert.Nil(t, err)
+	_, err = source.StoreWithHeaders(ctx, topic, []byte("message_3"),
+		map[string]string{"trace-id": "abc123"})
+	assert.Nil(t, err)
+	_, err = source.StoreKeyed(ctx, topic, []byte("key"), []byte("message_4"))
+	assert.Nil(t, err)
+	_, err = source.Compact(topic)
+	assert.Nil(t, err)
+	want, _, err := source.PollRecords(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(want))
+
+	var buf bytes.Buffer
+	err = source.ExportTopic(ctx, topic, &buf)
+	assert.Nil(t, err)
+	inMemory := memstore.NewMemStore()
+	err = inMemory.ImportTopic(ctx, topic, &buf)
+	assert.Nil(t, err)
+	got, _, err := inMemory.PollRecords(ctx, topic, 1)
+	assert.Nil(t, err)
+	assertSameRecords(t, want, got)
+
+	buf.Reset()
+	err = inMemory.ExportTopic(ctx, topic, &buf)
+	assert.Nil(t, err)
+	destination, err := NewFileStore(otherRootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	err = destination.ImportTopic(ctx, "copied_topic", &buf)
+	assert.Nil(t, err)
+	got, newReadFrom, err := destination.PollRecords(ctx, "copied_topic", 1)
+	assert.Nil(t, err)
+	assertSameRecords(t, want, got)
+	assert.Equal(t, 5, newReadFrom)
+
+	err = source.ExportTopic(ctx, "nosuchtopic", &buf)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+}
+
+// assertSameRecords asserts that the records are the same, allowing for the
+// times being in different locations.
+func assertSameRecords(t *testing.T, want []contract.Record,
+	got []contract.Record) {
+	assert.Equal(t, len(want), len(got))
+	for i := 0; i < len(want) && i < len(got); i++ {
+		assert.Equal(t, want[i].Number, got[i].Number)
+		assert.True(t, want[i].Time.Equal(got[i].Time))
+		assert.Equal(t, want[i].Key, got[i].Key)
+		assert.Equal(t, want[i].Headers, got[i].Headers)
+		assert.Equal(t, want[i].Message, got[i].Message)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	// Make sure that a store restored from a backup has the same topics,
+	// records and committed offsets as the store backed up, and that
+	// restoring is refused when it would be unsafe.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+	otherRootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(otherRootDir)
+
+	source, err := NewFileStore(rootDir, WithMaxFileSize(500))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topics := []string{"topic_a", "topic_b"}
+	for _, topic := range topics {
+		for i := 0; i < 5; i++ {
+			_, err = source.Store(ctx, topic, []byte("message"))
+			assert.Nil(t, err)
+		}
+	}
+	_, err = source.StoreKeyed(ctx, "topic_a", []byte("key"), []byte("keyed"))
+	assert.Nil(t, err)
+	_, err = source.StoreWithHeaders(ctx, "topic_b", []byte("headed"),
+		map[string]string{"trace-id": "abc123"})
+	assert.Nil(t, err)
+	err = source.CommitOffset("some_group", "topic_a", 4)
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	err = source.Backup(&buf)
+	assert.Nil(t, err)
+	destination, err := NewFileStore(otherRootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	err = destination.Restore(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+
+	wantStats, err := source.Stats()
+	assert.Nil(t, err)
+	gotStats, err := destination.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, wantStats, gotStats)
+	for _, topic := range topics {
+		want, _, err := source.PollRecords(ctx, topic, 1)
+		assert.Nil(t, err)
+		got, _, err := destination.PollRecords(ctx, topic, 1)
+		assert.Nil(t, err)
+		assertSameRecords(t, want, got)
+	}
+	offset, err := destination.FetchOffset("some_group", "topic_a")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, offset)
+
+	// The restored store carries on from where the backup left off.
+	messageNumber, err := destination.Store(ctx, "topic_a", []byte("more"))
+	assert.Nil(t, err)
+	assert.Equal(t, 7, messageNumber)
+
+	// It is not empty now.
+	err = destination.Restore(bytes.NewReader(buf.Bytes()))
+	assert.NotNil(t, err)
+
+	// A backup that would write outside the store is rejected.
+	err = destination.DeleteContents(ctx)
+	assert.Nil(t, err)
+	var malicious bytes.Buffer
+	archive := tar.NewWriter(&malicious)
+	err = archive.WriteHeader(&tar.Header{Name: "../escaped", Mode: 0600,
+		Size: 1, Typeflag: tar.TypeReg})
+	assert.Nil(t, err)
+	_, err = archive.Write([]byte("x"))
+	assert.Nil(t, err)
+	assert.Nil(t, archive.Close())
+	err = destination.Restore(&malicious)
+	assert.NotNil(t, err)
+	assert.False(t, ioutils.Exists(path.Join(otherRootDir, "..", "escaped")))
+	topicsLeft, err := destination.ListTopics(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(topicsLeft))
+}
+
+func TestMigrate(t *testing.T) {
+	// Make sure that a store migrated to a new root directory has the same
+	// topics, records and committed offsets there, and carries on from
+	// there - both when the root directory can be renamed, and when it must
+	// be copied - and that a migration that would be unsafe is refused,
+	// leaving the store where it was.
+
+	ctx := context.Background()
+	cases := []struct {
+		name   string
+		rename func(oldPath, newPath string) error
+	}{
+		{"renamed", os.Rename},
+		{"copied", func(oldPath, newPath string) error {
+			return errors.New("invalid cross-device link")
+		}},
+	}
+	for _, c := range cases {
+		rootDir := ioutils.TmpRootDir(t)
+		defer os.RemoveAll(rootDir)
+		otherRootDir := ioutils.TmpRootDir(t)
+		defer os.RemoveAll(otherRootDir)
+
+		filestore, err := NewFileStore(rootDir, WithMaxFileSize(500))
+		if err != nil {
+			msg := fmt.Sprintf("NewFileStore(): %v", err)
+			assert.FailNow(t, msg)
+		}
+		filestore.rename = c.rename
+		topics := []string{"topic_a", "topic_b"}
+		for _, topic := range topics {
+			for i := 0; i < 10; i++ {
+				_, err = filestore.Store(ctx, topic,
+					[]byte(fmt.Sprintf("message_%d", i)))
+				assert.Nil(t, err, c.name)
+			}
+		}
+		err = filestore.CommitOffset("some_group", "topic_a", 4)
+		assert.Nil(t, err, c.name)
+		want := map[string][]contract.Record{}
+		for _, topic := range topics {
+			want[topic], _, err = filestore.PollRecords(ctx, topic, 1)
+			assert.Nil(t, err, c.name)
+		}
+
+		// Into a directory that is not empty is refused.
+		occupant := path.Join(otherRootDir, "occupant")
+		err = os.Mkdir(occupant, 0755)
+		assert.Nil(t, err, c.name)
+		err = filestore.Migrate(otherRootDir)
+		assert.NotNil(t, err, c.name)
+		assert.Equal(t, rootDir, filestore.RootDir, c.name)
+		err = os.Remove(occupant)
+		assert.Nil(t, err, c.name)
+
+		// Into a directory of its own is refused.
+		err = filestore.Migrate(path.Join(rootDir, "inside"))
+		assert.NotNil(t, err, c.name)
+		assert.False(t, ioutils.Exists(path.Join(rootDir, "inside")),
+			c.name)
+
+		newRoot := path.Join(otherRootDir, "moved")
+		err = filestore.Migrate(newRoot)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, newRoot, filestore.RootDir, c.name)
+		assert.False(t, ioutils.Exists(rootDir), c.name)
+		assertMigrated := func(filestore *FileStore) {
+			for _, topic := range topics {
+				got, _, err := filestore.PollRecords(ctx, topic, 1)
+				assert.Nil(t, err, c.name)
+				assertSameRecords(t, want[topic], got)
+			}
+			offset, err := filestore.FetchOffset("some_group", "topic_a")
+			assert.Nil(t, err, c.name)
+			assert.Equal(t, 4, offset, c.name)
+		}
+		assertMigrated(filestore)
+		messageNumber, err := filestore.Store(ctx, "topic_a",
+			[]byte("more"))
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, 11, messageNumber, c.name)
+		want["topic_a"], _, err = filestore.PollRecords(ctx, "topic_a", 1)
+		assert.Nil(t, err, c.name)
+		err = filestore.Close()
+		assert.Nil(t, err, c.name)
+
+		// The store is found at its new root directory when reopened.
+		filestore, err = NewFileStore(newRoot, WithMaxFileSize(500))
+		if err != nil {
+			msg := fmt.Sprintf("NewFileStore(): %v", err)
+			assert.FailNow(t, msg)
+		}
+		assertMigrated(filestore)
+		err = filestore.Close()
+		assert.Nil(t, err, c.name)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	// Make sure that the messages stored, by whatever means, are tallied by
+	// topic, and that the stores and polls are tallied, along with which of
+	// them failed.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, err = filestore.Store(ctx, "topic_a", []byte("12345"))
+	assert.Nil(t, err)
+	_, _, err = filestore.StoreBatch(ctx, "topic_a",
+		[]minikafka.Message{[]byte("123"), []byte("12")})
+	assert.Nil(t, err)
+	_, _, err = filestore.StoreTransaction(ctx, "topic_b",
+		[]minikafka.Message{[]byte("1")})
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, "bad/topic", []byte("1"))
+	assert.NotNil(t, err)
+	_, _, err = filestore.Poll(ctx, "topic_a", 1)
+	assert.Nil(t, err)
+
+	counters := filestore.Counters()
+	assert.Equal(t, map[string]TopicCounters{
+		"topic_a": {MessagesStored: 3, BytesStored: 10},
+		"topic_b": {MessagesStored: 1, BytesStored: 1},
+	}, counters.PerTopic)
+	assert.Equal(t, int64(4), counters.Operations[StoreOperation].Count)
+	assert.Equal(t, int64(1), counters.Operations[StoreOperation].Errors)
+	assert.Equal(t, int64(1), counters.Operations[PollOperation].Count)
+	assert.Equal(t, int64(0), counters.Operations[PollOperation].Errors)
+	assert.True(t, counters.Operations[StoreOperation].Duration > 0)
+}
+
+func TestSpansAreCreated(t *testing.T) {
+	// Make sure that a span, with the topic and message count attributes, is
+	// created for each call to Store, Poll and a retention operation - and
+	// that a failure is recorded in the span.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(recorder))
+	filestore, err := NewFileStore(rootDir,
+		WithTracer(provider.Tracer("test")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = filestore.Store(ctx, "some_topic", []byte("message"))
+		assert.Nil(t, err)
+	}
+	_, err = filestore.Store(ctx, "bad/topic", []byte("message"))
+	assert.NotNil(t, err)
+	_, _, err = filestore.Poll(ctx, "some_topic", 2)
+	assert.Nil(t, err)
+	_, err = filestore.TruncateBefore("some_topic", 3)
+	assert.Nil(t, err)
+
+	spansNamed := func(name string) []sdktrace.ReadOnlySpan {
+		spans := []sdktrace.ReadOnlySpan{}
+		for _, span := range recorder.Ended() {
+			if span.Name() == name {
+				spans = append(spans, span)
+			}
+		}
+		return spans
+	}
+	attributesOf := func(span sdktrace.ReadOnlySpan) map[string]string {
+		attributes := map[string]string{}
+		for _, kv := range span.Attributes() {
+			attributes[string(kv.Key)] = kv.Value.Emit()
+		}
+		return attributes
+	}
+	stores := spansNamed("filestore.Store")
+	assert.Equal(t, 4, len(stores))
+	assert.Equal(t, map[string]string{"topic": "some_topic",
+		"message_count": "1", "bytes": "7"}, attributesOf(stores[0]))
+	assert.Equal(t, "bad/topic", attributesOf(stores[3])["topic"])
+	assert.Equal(t, codes.Error, stores[3].Status().Code)
+	assert.Equal(t, codes.Unset, stores[0].Status().Code)
+
+	polls := spansNamed("filestore.Poll")
+	assert.Equal(t, 1, len(polls))
+	assert.Equal(t, map[string]string{"topic": "some_topic",
+		"message_count": "2", "bytes": "14"}, attributesOf(polls[0]))
+	truncations := spansNamed("filestore.TruncateBefore")
+	assert.Equal(t, 1, len(truncations))
+	assert.Equal(t, "2", attributesOf(truncations[0])["message_count"])
+}
+
+func TestQuotaRefusesStoresBeyondIt(t *testing.T) {
+	// Fill a topic to its message quota, and make sure that the next store
+	// to it is refused, while other topics remain writable - until messages
+	// are removed, or the quota is. Then do likewise for a byte quota.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	limited := "limited_topic"
+	err = filestore.SetQuota(limited, 0, 3)
+	assert.Nil(t, err)
+	_, _, err = filestore.StoreBatch(ctx, limited,
+		[]minikafka.Message{[]byte("message_1"), []byte("message_2")})
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, limited, []byte("message_3"))
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, limited, []byte("message_4"))
+	assert.True(t, errors.Is(err, contract.ErrQuotaExceeded))
+	_, _, err = filestore.StoreBatch(ctx, limited,
+		[]minikafka.Message{[]byte("message_4"), []byte("message_5")})
+	assert.True(t, errors.Is(err, contract.ErrQuotaExceeded))
+	for i := 1; i <= 5; i++ {
+		_, err = filestore.Store(ctx, "other_topic",
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	count, err := filestore.MessageCount(limited)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+
+	_, err = filestore.TruncateBefore(limited, 2)
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, limited, []byte("message_4"))
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, limited, []byte("message_5"))
+	assert.True(t, errors.Is(err, contract.ErrQuotaExceeded))
+	err = filestore.SetQuota(limited, 0, 0)
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, limited, []byte("message_5"))
+	assert.Nil(t, err)
+
+	sized := "sized_topic"
+	err = filestore.SetQuota(sized, 2*storedSizeOf("0123456789"), 0)
+	assert.Nil(t, err)
+	for i := 1; i <= 2; i++ {
+		_, err = filestore.Store(ctx, sized, []byte("0123456789"))
+		assert.Nil(t, err)
+	}
+	_, err = filestore.Store(ctx, sized, []byte("0"))
+	assert.True(t, errors.Is(err, contract.ErrQuotaExceeded))
+
+	err = filestore.SetQuota(sized, -1, 0)
+	assert.NotNil(t, err)
+}
+
+func TestPollTokenPagesThroughTopic(t *testing.T) {
+	// Make sure that continuation tokens page through a topic, that a page
+	// carries on from the oldest message retained - and says a gap was
+	// skipped - when messages have been removed between pages, or the topic
+	// has started afresh, and that a token that has been altered is refused.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 10; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	messageStrings := func(messages []minikafka.Message) []string {
+		strs := []string{}
+		for _, message := range messages {
+			strs = append(strs, string(message))
+		}
+		return strs
+	}
+
+	messages, token, err := filestore.PollWithToken(ctx, topic, 1, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"message_1", "message_2", "message_3"},
+		messageStrings(messages))
+	messages, token, gapSkipped, err := filestore.PollToken(ctx, token, 3)
+	assert.Nil(t, err)
+	assert.False(t, gapSkipped)
+	assert.Equal(t, []string{"message_4", "message_5", "message_6"},
+		messageStrings(messages))
+
+	// Messages 7 and 8 are removed before they are read.
+	_, err = filestore.TruncateBefore(topic, 9)
+	assert.Nil(t, err)
+	messages, token, gapSkipped, err = filestore.PollToken(ctx, token, 3)
+	assert.Nil(t, err)
+	assert.True(t, gapSkipped)
+	assert.Equal(t, []string{"message_9", "message_10"},
+		messageStrings(messages))
+
+	// Having caught up.
+	messages, token, gapSkipped, err = filestore.PollToken(ctx, token, 3)
+	assert.Nil(t, err)
+	assert.False(t, gapSkipped)
+	assert.Equal(t, 0, len(messages))
+	_, err = filestore.Store(ctx, topic, []byte("message_11"))
+	assert.Nil(t, err)
+	messages, token, gapSkipped, err = filestore.PollToken(ctx, token, 3)
+	assert.Nil(t, err)
+	assert.False(t, gapSkipped)
+	assert.Equal(t, []string{"message_11"}, messageStrings(messages))
+
+	// An altered token.
+	altered := []byte(token)
+	altered[len(altered)/2] ^= 1
+	_, _, _, err = filestore.PollToken(ctx, string(altered), 3)
+	assert.True(t, errors.Is(err, ErrInvalidToken))
+	_, _, _, err = filestore.PollToken(ctx, "not a token", 3)
+	assert.True(t, errors.Is(err, ErrInvalidToken))
+
+	// The topic starts afresh, and the token points beyond its newest
+	// message.
+	err = filestore.DeleteTopic(ctx, topic)
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, topic, []byte("afresh_1"))
+	assert.Nil(t, err)
+	messages, _, gapSkipped, err = filestore.PollToken(ctx, token, 3)
+	assert.Nil(t, err)
+	assert.True(t, gapSkipped)
+	assert.Equal(t, []string{"afresh_1"}, messageStrings(messages))
+}
+
+func TestRateLimitRefusesExcessStores(t *testing.T) {
+	// Set low message, and byte, rates and make sure that the stores that
+	// would exceed them are refused, until enough (fake) time has passed -
+	// counting every message and byte of a batch.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir, WithRateLimit(4, 100))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	clock := newFakeClock()
+	filestore.clock, filestore.sleep = clock, clock.sleep
+	topic := "some_topic"
+	tenBytes := []byte("0123456789")
+
+	// A whole second's worth of messages can be stored at once.
+	_, _, err = filestore.StoreBatch(ctx, topic,
+		[]minikafka.Message{tenBytes, tenBytes, tenBytes})
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, topic, tenBytes)
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, topic, tenBytes)
+	assert.True(t, errors.Is(err, ErrRateLimited))
+	clock.advance(250 * time.Millisecond)
+	_, err = filestore.Store(ctx, topic, tenBytes)
+	assert.Nil(t, err)
+
+	// Bytes are counted for each message in a batch. A batch bigger than a
+	// second's worth is admitted when the bucket is full, but what it
+	// overdraws is repaid before anything else is admitted.
+	clock.advance(time.Second)
+	_, _, err = filestore.StoreBatch(ctx, topic,
+		[]minikafka.Message{make([]byte, 60), make([]byte, 60)})
+	assert.Nil(t, err)
+	clock.advance(time.Second)
+	_, err = filestore.Store(ctx, topic, make([]byte, 81))
+	assert.True(t, errors.Is(err, ErrRateLimited))
+	_, err = filestore.Store(ctx, topic, make([]byte, 80))
+	assert.Nil(t, err)
+
+	count, err := filestore.MessageCount(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 8, count)
+	assert.Equal(t, time.Duration(0), clock.slept)
+
+	_, err = NewFileStore(rootDir, WithRateLimit(-1, 0))
+	assert.NotNil(t, err)
+}
+
+func TestRateLimitCanDelayExcessStores(t *testing.T) {
+	// Set a low message rate, with blocking, and make sure that the stores
+	// that would exceed it wait for as long as they should, instead of being
+	// refused - unless their context is cancelled.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir, WithRateLimit(2, 0),
+		WithRateLimitBlocking(true))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	clock := newFakeClock()
+	filestore.clock, filestore.sleep = clock, clock.sleep
+	topic := "some_topic"
+
+	for i := 1; i <= 5; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	// The first two are admitted straight away, and the rest half a second
+	// apart.
+	assert.InDelta(t, 1.5, clock.slept.Seconds(), 0.001)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = filestore.Store(cancelled, topic, []byte("message_6"))
+	assert.True(t, errors.Is(err, context.Canceled))
+	count, err := filestore.MessageCount(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, count)
+}
+
+func TestStaleIndexIsRebuiltOnOpening(t *testing.T) {
+	// Simulate a crash by abandoning a store that holds unpersisted index
+	// changes, and make sure that a store opened afterwards rebuilds the
+	// index, so that no messages are lost.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir, WithIndexFlushInterval(time.Hour))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 3; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+
+	reopened, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	messages, newReadFrom, err := reopened.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(messages))
+	assert.Equal(t, 4, newReadFrom)
+}
+
+func TestCorruptIndexIsReportedOnOpening(t *testing.T) {
+	// Flip a bit of the persisted index, and make sure that opening the
+	// store then fails with ErrCorruptIndex, rather than the index being
+	// taken to mean something else, and that once RebuildIndex has rebuilt
+	// the index from the message files, the store opens with no messages
+	// lost. And that, with WithRebuildCorruptIndex, the store rebuilds the
+	// index as it is opened.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir, WithMaxFileSize(500))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 5; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	err = filestore.Close()
+	assert.Nil(t, err)
+
+	indexPath := filenamer.IndexFile(rootDir)
+	contents, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.ReadFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	contents[len(contents)/2] ^= 0x04
+	err = ioutil.WriteFile(indexPath, contents, 0644)
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.WriteFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, err = NewFileStore(rootDir, WithMaxFileSize(500))
+	assert.True(t, errors.Is(err, contract.ErrCorruptIndex))
+	// Nothing has been changed.
+	unchanged, err := ioutil.ReadFile(indexPath)
+	assert.Nil(t, err)
+	assert.Equal(t, contents, unchanged)
+
+	err = RebuildIndex(rootDir, WithMaxFileSize(500))
+	assert.Nil(t, err)
+	// The rebuilt index has been persisted in place of the corrupt one.
+	index := indexing.NewIndex()
+	err = index.PopulateFromDisk(indexPath)
+	assert.Nil(t, err)
+	reopened, err := NewFileStore(rootDir, WithMaxFileSize(500))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	messages, newReadFrom, err := reopened.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(messages))
+	assert.Equal(t, 6, newReadFrom)
+	err = reopened.Close()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(indexPath, contents, 0644)
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.WriteFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	reopened, err = NewFileStore(rootDir, WithMaxFileSize(500),
+		WithRebuildCorruptIndex(true))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	defer reopened.Close()
+	messages, _, err = reopened.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(messages))
+}
+
+func TestJSONSerializer(t *testing.T) {
+	// Make sure that a store using the JSON serializer writes message files
+	// that can be read by eye, and that it can read them back - including
+	// when it must rebuild the index from them.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	serializer := WithSerializer(records.JSONSerializer{})
+	filestore, err := NewFileStore(rootDir, serializer)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 2; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+
+	// Inspect the message file as it was left on disk.
+	msgFileName := filestore.index.CurrentMsgFileNameFor(topic)
+	contents, err := ioutil.ReadFile(
+		filenamer.MessageFilePath(msgFileName, topic, rootDir))
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.ReadFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	// Each record is preceded by its (binary) frame header.
+	for i := 1; i <= 2; i++ {
+		assert.Contains(t, string(contents),
+			fmt.Sprintf(`{"MsgNum":%d,"Created":"`, i))
+	}
+
+	// Read the messages back, once the index has been rebuilt.
+	err = os.Remove(filenamer.IndexFile(rootDir))
+	if err != nil {
+		msg := fmt.Sprintf("os.Remove(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	reopened, err := NewFileStore(rootDir, serializer)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, err = reopened.RebuildIndex()
+	assert.Nil(t, err)
+	messages, _, err := reopened.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "message_2", string(messages[1]))
+}
+
+func TestTypedErrors(t *testing.T) {
+	// Make sure that callers can recognise the common failure modes using
+	// errors.Is(), however deeply the store wraps them.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(
+		rootDir, WithMaxFileSize(storedSizeOf("small")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, _, err = filestore.Poll(ctx, "nosuchtopic", 1)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+	_, _, err = filestore.PollKeyed(ctx, "nosuchtopic", 1)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+
+	_, err = filestore.Store(ctx, "some_topic", []byte("much too large"))
+	assert.True(t, errors.Is(err, contract.ErrMessageTooLarge))
+	_, err = filestore.StoreKeyed(ctx,
+		"some_topic", []byte("key"), []byte("small"))
+	assert.True(t, errors.Is(err, contract.ErrMessageTooLarge))
+
+	err = filestore.Close()
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(filenamer.IndexFile(rootDir), []byte("junk"), 0666)
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.WriteFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, err = NewFileStore(rootDir)
+	assert.True(t, errors.Is(err, contract.ErrCorruptIndex))
+}
+
+func TestInvalidTopicsAreRejected(t *testing.T) {
+	// Make sure that topic names which would escape the root directory, or
+	// collide with the store's own files, are refused before they reach the
+	// file system.
+
+	ctx := context.Background()
+	parentDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(parentDir)
+	rootDir := path.Join(parentDir, "store")
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+
+	_, err = filestore.Store(ctx, "../evil", []byte("a message"))
+	assert.True(t, errors.Is(err, ErrInvalidTopic))
+	assert.False(t, ioutils.Exists(path.Join(parentDir, "evil")))
+	_, _, err = filestore.Poll(ctx, "../evil", 1)
+	assert.True(t, errors.Is(err, ErrInvalidTopic))
+	err = filestore.DeleteTopic(ctx, "..")
+	assert.True(t, errors.Is(err, ErrInvalidTopic))
+	assert.True(t, ioutils.Exists(rootDir))
+
+	for _, topic := range []string{
+		"", ".", "a/b", `a\b`, "nul\x00", "tab\t", "index", "offsets"} {
+		_, err = filestore.Store(ctx, topic, []byte("a message"))
+		assert.True(t, errors.Is(err, ErrInvalidTopic), topic)
+	}
+	topics, err := filestore.ListTopics(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, topics)
+
+	// Names that are merely unusual are allowed.
+	_, err = filestore.Store(ctx, "some topic.v2", []byte("a message"))
+	assert.Nil(t, err)
+}
+
+func TestTopicPattern(t *testing.T) {
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	pattern := WithTopicPattern(regexp.MustCompile(`^[a-z_]+$`))
+	filestore, err := NewFileStore(rootDir, pattern)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, err = filestore.Store(ctx, "some_topic", []byte("a message"))
+	assert.Nil(t, err)
+	_, err = filestore.Store(ctx, "Some_Topic", []byte("a message"))
+	assert.True(t, errors.Is(err, ErrInvalidTopic))
+	// The pattern cannot relax the built in checks.
+	filestore, err = NewFileStore(rootDir, WithTopicPattern(
+		regexp.MustCompile(`.*`)))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, err = filestore.Store(ctx, "../evil", []byte("a message"))
+	assert.True(t, errors.Is(err, ErrInvalidTopic))
+}
+
+func TestCompact(t *testing.T) {
+	// Make sure that compaction keeps only the latest message for each key,
+	// that a tombstone removes its key, and that the result survives the
+	// store being reopened.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i, key := range []string{"A", "B", "A", "C", "B", "C"} {
+		message := fmt.Sprintf("%s%d", key, i+1)
+		if i == 5 {
+			message = ""
+		}
+		_, err = filestore.StoreKeyed(ctx, topic, []byte(key), []byte(message))
+		assert.Nil(t, err)
+	}
+
+	removed, err := filestore.Compact(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]int{"A": {1}, "B": {2}, "C": {4, 6}}, removed)
+
+	reopened, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	keyed, newReadFrom, err := reopened.PollKeyed(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []KeyedMessage{
+		{Key: []byte("A"), Message: []byte("A3")},
+		{Key: []byte("B"), Message: []byte("B5")},
+	}, keyed)
+	assert.Equal(t, 7, newReadFrom)
+	count, err := reopened.MessageCount(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	_, err = reopened.Compact("nosuchtopic")
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+}
+
+func TestCompactSegments(t *testing.T) {
+	// Make sure that ten small message files are merged into one, alongside
+	// the file being stored to, without the topic's records changing - and
+	// without bringing back messages that had been removed - and that the
+	// result survives the index being rebuilt, and further stores.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir,
+		WithMaxFileSize(2*storedSizeOf("message_01")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 22; i++ {
+		_, err = filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%02d", i)))
+		assert.Nil(t, err)
+	}
+	_, err = filestore.TruncateBefore(topic, 4)
+	assert.Nil(t, err)
+	msgFileList := filestore.index.MessageFileLists[topic]
+	assert.Equal(t, 10, len(msgFileList.Names))
+	want, _, err := filestore.PollRecords(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 19, len(want))
+
+	err = filestore.CompactSegments(topic, 1<<20)
+	assert.Nil(t, err)
+	msgFileList = filestore.index.MessageFileLists[topic]
+	assert.Equal(t, 2, len(msgFileList.Names))
+	assert.Equal(t, 17, msgFileList.NumMessagesInFile(msgFileList.Names[0]))
+	nFiles, err := ioutils.CountEntitiesInDir(
+		filenamer.DirectoryForTopic(topic, rootDir))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, nFiles)
+	got, _, err := filestore.PollRecords(ctx, topic, 1)
+	assert.Nil(t, err)
+	assertSameRecords(t, want, got)
+
+	// Merging again changes nothing, and a target size too small for any
+	// file to be merged with another is a no-op.
+	err = filestore.CompactSegments(topic, 1<<20)
+	assert.Nil(t, err)
+	err = filestore.CompactSegments(topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(filestore.index.MessageFileLists[topic].Names))
+	err = filestore.CompactSegments(topic, 0)
+	assert.NotNil(t, err)
+	err = filestore.CompactSegments("no_such_topic", 1<<20)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+
+	_, err = filestore.RebuildIndex()
+	assert.Nil(t, err)
+	got, _, err = filestore.PollRecords(ctx, topic, 1)
+	assert.Nil(t, err)
+	assertSameRecords(t, want, got)
+	messageNumber, err := filestore.Store(ctx, topic, []byte("more"))
+	assert.Nil(t, err)
+	assert.Equal(t, 23, messageNumber)
+}
+
+func TestCompression(t *testing.T) {
+	// Make sure that with compression switched on, the message file ends up
+	// smaller than the messages stored in it, that the messages can be read
+	// back - also after compression is switched off again, and after the
+	// index is rebuilt.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir, WithCompression(true))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	message := []byte(strings.Repeat("all work and no play ", 50))
+	payloadSize := 0
+	for i := 0; i < 5; i++ {
+		_, err = filestore.Store(ctx, topic, message)
+		assert.Nil(t, err)
+		payloadSize += len(message)
+	}
+	compressedFile := filestore.index.CurrentMsgFileNameFor(topic)
+	info, err := os.Stat(filenamer.MessageFilePath(
+		compressedFile, topic, rootDir))
+	assert.Nil(t, err)
+	assert.True(t, info.Size() < int64(payloadSize)/4)
+
+	// Switching compression off starts a new file.
+	uncompressed, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, err = uncompressed.Store(ctx, topic, message)
+	assert.Nil(t, err)
+	assert.NotEqual(t, compressedFile,
+		uncompressed.index.CurrentMsgFileNameFor(topic))
+	messages, newReadFrom, err := uncompressed.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(messages))
+	assert.Equal(t, minikafka.Message(message), messages[5])
+	assert.Equal(t, 7, newReadFrom)
+
+	_, err = uncompressed.RebuildIndex()
+	assert.Nil(t, err)
+	fileList := uncompressed.index.MessageFileLists[topic]
+	assert.True(t, fileList.Meta[compressedFile].Compressed)
+	messages, _, err = uncompressed.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(messages))
+	assert.Equal(t, minikafka.Message(message), messages[0])
+}
+
+func TestEncryption(t *testing.T) {
+	// Make sure that with an encryption key, the message file does not hold
+	// the messages in plain sight, that they can be read back with the same
+	// key, also once compressed, and that a store opened with a different
+	// key, or none, reports ErrDecryptionFailed - also when rebuilding the
+	// index, which must leave the records alone.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	key := bytes.Repeat([]byte{7}, 32)
+	filestore, err := NewFileStore(rootDir, WithEncryptionKey(key))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	message := []byte(strings.Repeat("the secret message ", 20))
+	_, err = filestore.Store(ctx, topic, message)
+	assert.Nil(t, err)
+	compressing, err := NewFileStore(rootDir, WithEncryptionKey(key),
+		WithCompression(true))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, err = compressing.Store(ctx, topic, message)
+	assert.Nil(t, err)
+	for _, fileName := range compressing.index.MessageFileLists[topic].Names {
+		contents, err := ioutil.ReadFile(filenamer.MessageFilePath(
+			fileName, topic, rootDir))
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(contents, []byte("the secret")))
+	}
+	err = compressing.Close()
+	assert.Nil(t, err)
+
+	reopened, err := NewFileStore(rootDir, WithEncryptionKey(key))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	messages, _, err := reopened.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []minikafka.Message{message, message}, messages)
+	_, err = reopened.RebuildIndex()
+	assert.Nil(t, err)
+	got, _, err := reopened.GetMessage(topic, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, minikafka.Message(message), got)
+
+	for _, options := range [][]Option{
+		{WithEncryptionKey(bytes.Repeat([]byte{8}, 32))}, {}} {
+		wrongKey, err := NewFileStore(rootDir, options...)
+		if err != nil {
+			msg := fmt.Sprintf("NewFileStore(): %v", err)
+			assert.FailNow(t, msg)
+		}
+		_, _, err = wrongKey.Poll(ctx, topic, 1)
+		assert.True(t, errors.Is(err, ErrDecryptionFailed))
+		_, _, err = wrongKey.GetMessage(topic, 1)
+		assert.True(t, errors.Is(err, ErrDecryptionFailed))
+		_, err = wrongKey.RebuildIndex()
+		assert.True(t, errors.Is(err, ErrDecryptionFailed))
+	}
+	messages, _, err = reopened.Poll(ctx, topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+
+	_, err = NewFileStore(rootDir, WithEncryptionKey([]byte("too short")))
+	assert.NotNil(t, err)
+}
+
+func TestChunkedMessages(t *testing.T) {
+	// Make sure that a message several times larger than a message file is
+	// stored in chunks, and read back byte-identical - by Poll, and by
+	// GetMessage - both with and without memory mapping. And that neither
+	// retention, nor the rebuild of an index after a store of one was
+	// interrupted, can leave part of such a message to be read.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	message := make([]byte, 3*1024*1024)
+	for i := range message {
+		message[i] = byte(i * 7 % 251)
+	}
+	topic := "some_topic"
+
+	// Without chunking, it is refused.
+	unchunked, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, err = unchunked.Store(ctx, topic, message)
+	assert.True(t, errors.Is(err, contract.ErrMessageTooLarge))
+	err = unchunked.Close()
+	assert.Nil(t, err)
+	_, err = NewFileStore(rootDir, WithChunkSize(2*1024*1024))
+	assert.NotNil(t, err)
+
+	for _, mmapReads := range []bool{false, true} {
+		filestore, err := NewFileStore(rootDir,
+			WithChunkSize(64*1024), WithMmapReads(mmapReads))
+		if err != nil {
+			msg := fmt.Sprintf("NewFileStore(): %v", err)
+			assert.FailNow(t, msg)
+		}
+		err = filestore.DeleteContents(ctx)
+		assert.Nil(t, err)
+		_, err = filestore.Store(ctx, topic, []byte("message_1"))
+		assert.Nil(t, err)
+		msgNum, err := filestore.Store(ctx, topic, message)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, msgNum)
+		_, err = filestore.Store(ctx, topic, []byte("message_3"))
+		assert.Nil(t, err)
+
+		messages, _, err := filestore.Poll(ctx, topic, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(messages))
+		assert.True(t, bytes.Equal(message, messages[1]))
+		assert.Equal(t, "message_3", string(messages[2]))
+		got, _, err := filestore.GetMessage(topic, 2)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(message, got))
+
+		// Interrupt the store of another, by cutting the file that holds
+		// its chunks short, part way through them.
+		_, err = filestore.Store(ctx, topic, message)
+		assert.Nil(t, err)
+		msgFileList := filestore.index.MessageFileLists[topic]
+		lastFile := msgFileList.Names[len(msgFileList.Names)-1]
+		filePath := filenamer.MessageFilePath(lastFile, topic, rootDir)
+		err = os.Truncate(filePath, msgFileList.Meta[lastFile].Size/2)
+		if err != nil {
+			msg := fmt.Sprintf("os.Truncate(): %v", err)
+			assert.FailNow(t, msg)
+		}
+		_, err = filestore.RebuildIndex()
+		assert.True(t, errors.Is(err, ErrUnreadableRecords))
+		messages, _, err = filestore.Poll(ctx, topic, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(messages))
+		assert.True(t, bytes.Equal(message, messages[1]))
+		msgNum, err = filestore.Store(ctx, topic, []byte("message_4"))
+		assert.Nil(t, err)
+		assert.Equal(t, 4, msgNum)
+
+		// Retention that cannot keep the whole of the chunked message
+		// removes the whole of it.
+		err = filestore.SetRetentionBytes(topic, 1024*1024)
+		assert.Nil(t, err)
+		_, err = filestore.TrimToSize()
+		assert.Nil(t, err)
+		messages, _, err = filestore.Poll(ctx, topic, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, []minikafka.Message{[]byte("message_3"),
+			[]byte("message_4")}, messages)
+		_, _, err = filestore.GetMessage(topic, 2)
+		assert.True(t, errors.Is(err, contract.ErrMessageNotFound))
+
+		err = filestore.Close()
+		assert.Nil(t, err)
+	}
+}
+
+func TestMmapReadsGiveIdenticalResults(t *testing.T) {
+	// Make sure that a store reading its message files through memory
+	// mappings gives the same results from Poll, PollN and GetMessage as one
+	// reading them in the regular way - also as the topic rolls over to new
+	// files, and after its files are rewritten - and that repeated polls of
+	// the same file do not map it afresh.
+
+	ctx := context.Background()
+	plainDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(plainDir)
+	mappedDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(mappedDir)
+
+	plain, err := NewFileStore(plainDir, WithMaxFileSize(200))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	mapped, err := NewFileStore(mappedDir, WithMaxFileSize(200),
+		WithMmapReads(true))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	nStored := 0
+	storeMore := func(n int) {
+		for i := 0; i < n; i++ {
+			nStored++
+			message := []byte(fmt.Sprintf("message_%d", nStored))
+			_, err := plain.Store(ctx, topic, message)
+			assert.Nil(t, err)
+			_, err = mapped.Store(ctx, topic, message)
+			assert.Nil(t, err)
+		}
+	}
+	assertSameResults := func() {
+		for readFrom := 1; readFrom <= nStored+1; readFrom++ {
+			want, wantReadFrom, err := plain.Poll(ctx, topic, readFrom)
+			assert.Nil(t, err)
+			got, gotReadFrom, err := mapped.Poll(ctx, topic, readFrom)
+			assert.Nil(t, err)
+			assert.Equal(t, want, got)
+			assert.Equal(t, wantReadFrom, gotReadFrom)
+			want, wantReadFrom, err = plain.PollN(ctx, topic, readFrom, 2)
+			assert.Nil(t, err)
+			got, gotReadFrom, err = mapped.PollN(ctx, topic, readFrom, 2)
+			assert.Nil(t, err)
+			assert.Equal(t, want, got)
+			assert.Equal(t, wantReadFrom, gotReadFrom)
+			wantMessage, _, wantErr := plain.GetMessage(topic, readFrom)
+			gotMessage, _, gotErr := mapped.GetMessage(topic, readFrom)
+			assert.Equal(t, wantMessage, gotMessage)
+			assert.Equal(t, wantErr == nil, gotErr == nil)
+		}
+	}
+
+	storeMore(5)
+	assertSameResults()
+	maps := mapped.mappings.Maps()
+	for i := 0; i < 3; i++ {
+		_, _, err = mapped.Poll(ctx, topic, nStored)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, maps, mapped.mappings.Maps())
+
+	// Rolling over to new files.
+	storeMore(10)
+	assert.True(t, len(mapped.index.MessageFileLists[topic].Names) > 2)
+	assertSameResults()
+
+	// Rewriting the files.
+	_, err = plain.TruncateBefore(topic, 8)
+	assert.Nil(t, err)
+	_, err = mapped.TruncateBefore(topic, 8)
+	assert.Nil(t, err)
+	assertSameResults()
+	storeMore(3)
+	assertSameResults()
+	err = mapped.Close()
+	assert.Nil(t, err)
+}
+
+func TestRecordCacheAgreesWithMessageFiles(t *testing.T) {
+	// Make sure that the polls, and calls of GetMessage, that the record
+	// cache serves give what reading the message files does - also once a
+	// retention trim has removed part of what the cache held - and that
+	// those it cannot serve are left to the message files.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir, WithRecordCache(5))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 10; i++ {
+		message := []byte(fmt.Sprintf("message_%d", i))
+		if i%2 == 0 {
+			_, err = filestore.StoreKeyed(ctx, topic,
+				[]byte(fmt.Sprintf("key_%d", i)), message)
+		} else {
+			_, err = filestore.StoreWithHeaders(ctx, topic, message,
+				map[string]string{"n": fmt.Sprintf("%d", i)})
+		}
+		assert.Nil(t, err)
+	}
+	// Polls the store with, and then without its cache, and says whether
+	// the cache served the first.
+	assertAgree := func(readFrom int) bool {
+		hits := filestore.Counters().RecordCacheHits
+		cached, cachedReadFrom, err := filestore.PollRecords(
+			ctx, topic, readFrom)
+		assert.Nil(t, err)
+		hit := filestore.Counters().RecordCacheHits > hits
+		filestore.recordCacheSize = 0
+		fromFiles, filesReadFrom, err := filestore.PollRecords(
+			ctx, topic, readFrom)
+		filestore.recordCacheSize = 5
+		assert.Nil(t, err)
+		assert.Equal(t, fromFiles, cached)
+		assert.Equal(t, filesReadFrom, cachedReadFrom)
+		return hit
+	}
+
+	for readFrom := 6; readFrom <= 10; readFrom++ {
+		assert.True(t, assertAgree(readFrom))
+	}
+	assert.False(t, assertAgree(5))
+	assert.False(t, assertAgree(11))
+	message, created, err := filestore.GetMessage(topic, 9)
+	assert.Nil(t, err)
+	filestore.recordCacheSize = 0
+	fileMessage, fileCreated, err := filestore.GetMessage(topic, 9)
+	filestore.recordCacheSize = 5
+	assert.Nil(t, err)
+	assert.Equal(t, fileMessage, message)
+	assert.Equal(t, fileCreated, created)
+
+	// What the store provides cannot alter the cache.
+	messages, _, err := filestore.Poll(ctx, topic, 10)
+	assert.Nil(t, err)
+	messages[0][0] = 'X'
+	assert.True(t, assertAgree(10))
+
+	// The trim removes messages 6 and 7 from the cache.
+	_, err = filestore.TruncateBefore(topic, 8)
+	assert.Nil(t, err)
+	for readFrom := 6; readFrom <= 10; readFrom++ {
+		assert.True(t, assertAgree(readFrom))
+	}
+	_, _, err = filestore.GetMessage(topic, 7)
+	assert.True(t, errors.Is(err, contract.ErrMessageNotFound))
+
+	// And this one removes the rest.
+	err = filestore.RemoveOldMessages(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.False(t, assertAgree(8))
+	_, err = filestore.Store(ctx, topic, []byte("message_11"))
+	assert.Nil(t, err)
+	assert.True(t, assertAgree(8))
+}
+
+func TestFileNamerNamesTheStoresFiles(t *testing.T) {
+	// Make sure that a store given a naming scheme of its own names its
+	// files and directories according to it - in this case giving message
+	// files sequential names that sort in the order they were created - and
+	// can find them again, both when reopened and when rebuilding its index.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	namer := &sequentialNamer{}
+	filestore, err := NewFileStore(rootDir, WithFileNamer(namer),
+		WithMaxFileSize(500))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	const nMessages = 30
+	for i := 1; i <= nMessages; i++ {
+		_, err := filestore.Store(ctx, topic,
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	err = filestore.CommitOffset("some_group", topic, 5)
+	assert.Nil(t, err)
+
+	// The index lists a topic's message files in the order they were
+	// created, and a directory listing sorts them by name.
+	entities, err := ioutil.ReadDir(path.Join(rootDir, "topic-"+topic))
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.ReadDir(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	listed := []string{}
+	for _, entity := range entities {
+		listed = append(listed, entity.Name())
+	}
+	msgFileList := filestore.index.MessageFileLists[topic]
+	assert.True(t, len(msgFileList.Names) > 2)
+	assert.Equal(t, msgFileList.Names, listed)
+	assert.Equal(t, "00000001.seg", listed[0])
+	assert.True(t, ioutils.Exists(path.Join(rootDir, "store.index")))
+	assert.True(t, ioutils.Exists(path.Join(rootDir, "store.offsets")))
+	assert.False(t, ioutils.Exists(filenamer.IndexFile(rootDir)))
+
+	// The files are found again, as long as the same scheme is used.
+	assertAllMessages := func(filestore *FileStore) {
+		messages, newReadFrom, err := filestore.Poll(ctx, topic, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, nMessages, len(messages))
+		assert.Equal(t, "message_1", string(messages[0]))
+		assert.Equal(t, nMessages+1, newReadFrom)
+	}
+	err = filestore.Close()
+	assert.Nil(t, err)
+	filestore, err = NewFileStore(rootDir, WithFileNamer(namer),
+		WithMaxFileSize(500))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	defer filestore.Close()
+	assertAllMessages(filestore)
+	offset, err := filestore.FetchOffset("some_group", topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, offset)
+	_, err = filestore.RebuildIndex()
+	assert.Nil(t, err)
+	assertAllMessages(filestore)
+	topics, err := filestore.ListTopics(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{topic}, topics)
+}
+
+func TestPermissions(t *testing.T) {
+	// Make sure that the directories and files the store creates have the
+	// permissions it was configured with - subject to the umask.
+
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	// Discover what the umask does to permissions, by creating a probe
+	// directory that asks for all of them.
+	probeDir := path.Join(rootDir, "probe")
+	err := os.Mkdir(probeDir, 0777)
+	if err != nil {
+		msg := fmt.Sprintf("os.Mkdir(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	probeInfo, err := os.Stat(probeDir)
+	if err != nil {
+		msg := fmt.Sprintf("os.Stat(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	allowed := probeInfo.Mode().Perm()
+
+	storeDir := path.Join(rootDir, "store")
+	filestore, err := NewFileStore(
+		storeDir, WithDirPerm(0700), WithFilePerm(0600))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	_, err = filestore.Store(ctx, topic, []byte("a message"))
+	assert.Nil(t, err)
+
+	msgFileName := filestore.index.CurrentMsgFileNameFor(topic)
+	expectations := []struct {
+		path string
+		perm os.FileMode
+	}{
+		{storeDir, 0700},
+		{filenamer.DirectoryForTopic(topic, storeDir), 0700},
+		{filenamer.MessageFilePath(msgFileName, topic, storeDir), 0600},
+		{filenamer.IndexFile(storeDir), 0600},
+	}
+	for _, expected := range expectations {
+		info, err := os.Stat(expected.path)
+		if err != nil {
+			msg := fmt.Sprintf("os.Stat(): %v", err)
+			assert.FailNow(t, msg)
+		}
+		assert.Equal(t, expected.perm&allowed, info.Mode().Perm(),
+			expected.path)
+	}
+}
+
+func TestPollIsAbandonedWhenContextIsCancelled(t *testing.T) {
+	// Make sure that a poll of a topic spread over many message files stops
+	// part way through, when its context is cancelled.
+
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	// Each message gets a file of its own.
+	filestore, err := NewFileStore(
+		rootDir, WithMaxFileSize(storedSizeOf("message_00")))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	for i := 1; i <= 20; i++ {
+		_, err = filestore.Store(context.Background(), topic,
+			[]byte(fmt.Sprintf("message_%02d", i)))
+		assert.Nil(t, err)
+	}
+
+	ctx := newCancelAfterChecks(3)
+	messages, newReadFrom, err := filestore.Poll(ctx, topic, 1)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Nil(t, messages)
+	assert.Equal(t, -1, newReadFrom)
+	assert.Equal(t, 3, ctx.checks)
+}
+
+func TestStoreBatchIsAbandonedWhenContextIsCancelled(t *testing.T) {
+	// Make sure that a batch store stops part way through when its context
+	// is cancelled, leaving the messages stored so far intact.
+
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filestore, err := NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	topic := "some_topic"
+	batch := []minikafka.Message{}
+	for i := 1; i <= 5; i++ {
+		batch = append(batch, []byte(fmt.Sprintf("message_%d", i)))
+	}
+	first, last, err := filestore.StoreBatch(
+		newCancelAfterChecks(3), topic, batch)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 1, first)
+	assert.Equal(t, 2, last)
+
+	messages, newReadFrom, err := filestore.Poll(
+		context.Background(), topic, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, last+1, newReadFrom)
+}
+
+// cancelAfterChecks is a context that cancels itself when its Err method is
+// called for the nth time - so that tests can cancel an operation at a
+// predictable point part way through.
+type cancelAfterChecks struct {
+	context.Context
+	cancel func()
+	n      int
+	checks int
+}
+
+func newCancelAfterChecks(n int) *cancelAfterChecks {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &cancelAfterChecks{Context: ctx, cancel: cancel, n: n}
+}
+
+func (c *cancelAfterChecks) Err() error {
+	c.checks++
+	if c.checks == c.n {
+		c.cancel()
+	}
+	return c.Context.Err()
+}
+
+// fakeClock is a clock for the store to use in place of the real one, whose
+// time moves on only when it is advanced, or slept on.
+type fakeClock struct {
+	mutex   sync.Mutex
+	current time.Time
+	slept   time.Duration // In total.
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{current: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.current
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.current = c.current.Add(d)
+}
+
+func (c *fakeClock) sleep(ctx context.Context, d time.Duration) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.current = c.current.Add(d)
+	c.slept += d
+	return nil
+}
+
+// storedSizeOf provides the number of bytes the given (unkeyed) message
+// occupies in a message file, on the assumption that its message number is
+// small.
+func storedSizeOf(msg string) int64 {
+	encoded, _ := records.DefaultSerializer.Encode(records.StoredMessage{
+		MsgNum:  1,
+		Created: time.Now(),
+		Message: []byte(msg),
+	})
+	return int64(len(records.Frame(encoded)))
+}
+
+// storeNaively stores a message as a store that holds nothing in memory, nor
+// open, from one store to the next would: decoding the index from disk,
+// making the topic's directory unless it exists, opening the message file to
+// append to, and closing it again, and saving the index - for comparison
+// with the FileStore's own write path.
+func storeNaively(rootDir string, topic string, message minikafka.Message,
+	maxFileSize int64, now func() time.Time) error {
+	indexFile := filenamer.IndexFile(rootDir)
+	index := indexing.NewIndex()
+	err := index.PopulateFromDisk(indexFile)
+	if err != nil && errors.Is(err, os.ErrNotExist) == false {
+		return fmt.Errorf("index.PopulateFromDisk(): %v", err)
+	}
+	err = ioutils.CreateDirIfDoesntExist(
+		filenamer.DirectoryForTopic(topic, rootDir), actions.DefaultDirPerm)
+	if err != nil {
+		return fmt.Errorf("ioutils.CreateDirIfDoesntExist(): %v", err)
+	}
+	storeAction := actions.StoreAction{Topic: topic, Message: message,
+		Index: index, RootDir: rootDir, MaxFileSize: maxFileSize, Now: now}
+	_, _, err = storeAction.Store()
+	if err != nil {
+		return fmt.Errorf("storeAction.Store(): %v", err)
+	}
+	return index.Save(indexFile, actions.DefaultFilePerm)
+}
+
+// sequentialNamer is a FileNamer that gives message files zero-padded
+// sequential names, puts topic directories under a prefix, and gives the
+// index and offsets files names of its own.
+type sequentialNamer struct {
+	filenamer.DefaultNamer
+	mutex sync.Mutex
+	last  int
+}
+
+func (n *sequentialNamer) IndexFile(rootDir string) string {
+	return path.Join(rootDir, "store.index")
+}
+
+func (n *sequentialNamer) OffsetsFile(rootDir string) string {
+	return path.Join(rootDir, "store.offsets")
+}
+
+func (n *sequentialNamer) DirectoryForTopic(topic, rootDir string) string {
+	return path.Join(rootDir, "topic-"+topic)
+}
+
+func (n *sequentialNamer) TopicForDirectory(dirName string) (string, bool) {
+	if strings.HasPrefix(dirName, "topic-") == false {
+		return "", false
+	}
+	return strings.TrimPrefix(dirName, "topic-"), true
+}
+
+func (n *sequentialNamer) NewMsgFilenameFor(topic string,
+	previouslyUsedChecker filenamer.PreviouslyUsedChecker) string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for {
+		n.last++
+		name := fmt.Sprintf("%08d.seg", n.last)
+		if previouslyUsedChecker.PreviouslyUsed(name, topic) == false {
+			return name
+		}
+	}
+}
+
+func (n *sequentialNamer) IsMessageFileName(name string) bool {
+	return regexp.MustCompile(`^[0-9]{8}\.seg$`).MatchString(name)
+}
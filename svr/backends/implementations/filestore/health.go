@@ -0,0 +1,105 @@
+package filestore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/actions"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+)
+
+// healthTopic is the topic HealthCheck writes its probe record to. It is
+// reserved, so it cannot be stored to, and it only exists while a check is
+// under way.
+const healthTopic = "__health__"
+
+// HealthCheck verifies that the store is fit for use, for the sake of
+// liveness and readiness probes. It checks that the index file decodes, that
+// files can be created in the root directory, and that a probe record can be
+// written (and committed to stable storage), and read back intact - as the
+// store's own settings would write it - in a topic of its own, which it
+// removes again. This catches a full disk, or a change of permissions, which
+// checking that the root directory exists would not. It provides nil when
+// the store is healthy, and otherwise an error that wraps ErrUnhealthy, and
+// says which check failed (or ErrStoreClosed once the store is closed).
+//
+// It is cheap enough to call every few seconds, since it writes one small
+// record, and neither changes the index, nor holds up storage operations. It
+// does hold up those that remove or rewrite message files, while it runs.
+func (s *FileStore) HealthCheck() error {
+	s.maintenanceMutex.RLock()
+	defer s.maintenanceMutex.RUnlock()
+	s.healthMutex.Lock()
+	defer s.healthMutex.Unlock()
+
+	// The index file is read while it cannot be being saved.
+	s.mutex.RLock()
+	if s.closed {
+		s.mutex.RUnlock()
+		return ErrStoreClosed
+	}
+	err := indexing.NewIndex().PopulateFromDisk(s.namer.IndexFile(s.RootDir))
+	s.mutex.RUnlock()
+	if err != nil {
+		return fmt.Errorf("%w: the index file cannot be decoded: %v",
+			ErrUnhealthy, err)
+	}
+
+	err = ioutils.CheckDirIsWritable(s.RootDir)
+	if err != nil {
+		return fmt.Errorf("%w: %v: %v", ErrUnhealthy, ErrRootDirNotWritable,
+			err)
+	}
+	err = s.probeHealthTopic()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnhealthy, err)
+	}
+	return nil
+}
+
+// probeHealthTopic stores a probe record to the health topic, and reads it
+// back, using an index of its own - so the store's index is left alone. The
+// topic's directory is removed beforehand, should an earlier check have been
+// interrupted, and afterwards.
+func (s *FileStore) probeHealthTopic() (err error) {
+	dirPath := s.namer.DirectoryForTopic(healthTopic, s.RootDir)
+	err = os.RemoveAll(dirPath)
+	if err != nil {
+		return fmt.Errorf("the probe topic cannot be removed: %v", err)
+	}
+	defer func() {
+		removeErr := os.RemoveAll(dirPath)
+		if removeErr != nil && err == nil {
+			err = fmt.Errorf("the probe topic cannot be removed: %v",
+				removeErr)
+		}
+	}()
+
+	index := indexing.NewIndex()
+	probe := []byte(fmt.Sprintf("health check %d",
+		s.clock.Now().UnixNano()))
+	storeAction := actions.StoreAction{
+		Topic: healthTopic, Message: probe, Index: index, RootDir: s.RootDir,
+		SyncOnWrite: true, Serializer: s.serializer, Compress: s.compress,
+		EncryptionKey: s.encryptionKey, DirPerm: s.dirPerm,
+		FilePerm: s.filePerm, Namer: s.namer, Now: s.clock.Now}
+	msgNumber, _, err := storeAction.Store()
+	if err != nil {
+		return fmt.Errorf("the probe record cannot be written: %v", err)
+	}
+	getAction := actions.GetMessageAction{
+		Topic: healthTopic, MessageNumber: msgNumber, Index: index,
+		RootDir: s.RootDir, Serializer: s.serializer,
+		EncryptionKey: s.encryptionKey, Namer: s.namer}
+	storedMsg, err := getAction.GetMessage()
+	if err != nil {
+		return fmt.Errorf("the probe record cannot be read: %v", err)
+	}
+	if bytes.Equal(storedMsg.Message, probe) == false {
+		return fmt.Errorf("the probe record reads back as %q, not %q",
+			storedMsg.Message, probe)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package filestore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	minikafka "github.com/peterhoward42/minikafka"
+)
+
+// DefaultIdempotencyWindow is how many of the most recent idempotency keys
+// the store remembers for each topic, unless WithIdempotencyWindow says
+// otherwise.
+const DefaultIdempotencyWindow = 1000
+
+// WithIdempotencyWindow sets how many of the most recent idempotency keys
+// given to StoreIdempotent the store remembers for each topic. A key that has
+// been forgotten is treated as a new one. The default is
+// DefaultIdempotencyWindow.
+func WithIdempotencyWindow(size int) Option {
+	return func(s *FileStore) {
+		s.idempotencyWindow = size
+	}
+}
+
+// StoreIdempotent is like Store, except that it takes a key from the
+// producer that identifies the message, so that the producer can safely retry
+// a store whose outcome it does not know. When the key is one of the topic's
+// most recent keys (see WithIdempotencyWindow), the message is not stored
+// again, and the message number it was given the first time is returned
+// instead. The keys are held in memory only, so they are forgotten when the
+// store is reopened, and when the topic is deleted.
+func (s *FileStore) StoreIdempotent(ctx context.Context, topic string,
+	key string, message minikafka.Message) (messageNumber int, err error) {
+	window := s.keyWindow(topic)
+	window.mutex.Lock()
+	messageNumber, ok := window.numbers[key]
+	if ok {
+		window.mutex.Unlock()
+		return messageNumber, nil
+	}
+	messageNumber, _, stored, err := s.appendBatch(ctx, topic,
+		[]pendingMessage{{KeyedMessage: KeyedMessage{Message: message}}})
+	if err == nil {
+		window.remember(key, messageNumber)
+	}
+	window.mutex.Unlock()
+
+	s.fireOnStore(topic, stored)
+	if err != nil {
+		return -1, fmt.Errorf("appendBatch(): %w", err)
+	}
+	return messageNumber, nil
+}
+
+// keyWindow holds the most recent idempotency keys for a topic, and the
+// message numbers given to their messages. Its mutex is held while a key is
+// checked, and its message stored, so that a key cannot be stored twice
+// concurrently.
+type keyWindow struct {
+	mutex   sync.Mutex
+	size    int
+	numbers map[string]int // Keyed on idempotency key.
+	order   []string       // Oldest first.
+}
+
+// remember records the message number given to the key's message, forgetting
+// the oldest key when the window is full.
+func (w *keyWindow) remember(key string, messageNumber int) {
+	if len(w.order) >= w.size {
+		delete(w.numbers, w.order[0])
+		w.order = w.order[1:]
+	}
+	w.numbers[key] = messageNumber
+	w.order = append(w.order, key)
+}
+
+// keyWindow provides the window of idempotency keys for the given topic,
+// creating it on first use.
+func (s *FileStore) keyWindow(topic string) *keyWindow {
+	s.keyWindowsMutex.Lock()
+	defer s.keyWindowsMutex.Unlock()
+	if s.keyWindows == nil {
+		s.keyWindows = map[string]*keyWindow{}
+	}
+	window, ok := s.keyWindows[topic]
+	if ok == false {
+		window = &keyWindow{size: s.idempotencyWindow,
+			numbers: map[string]int{}}
+		s.keyWindows[topic] = window
+	}
+	return window
+}
+
+// forgetKeyWindows discards the windows of idempotency keys for the given
+// topics, or for every topic when none are given - for when the message
+// numbers they refer to cease to exist.
+func (s *FileStore) forgetKeyWindows(topics ...string) {
+	s.keyWindowsMutex.Lock()
+	defer s.keyWindowsMutex.Unlock()
+	if len(topics) == 0 {
+		s.keyWindows = nil
+		return
+	}
+	for _, topic := range topics {
+		delete(s.keyWindows, topic)
+	}
+}
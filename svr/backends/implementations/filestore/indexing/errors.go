@@ -0,0 +1,10 @@
+package indexing
+
+import (
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+)
+
+// ErrCorruptIndex is returned (wrapped) by PopulateFromDisk when the index
+// file exists, but cannot be decoded, or does not match its checksum. It is
+// contract.ErrCorruptIndex, so that the store can pass it on to its callers.
+var ErrCorruptIndex = contract.ErrCorruptIndex
@@ -11,27 +11,54 @@ import (
 
 // FileMeta holds information about the oldest and newest message in
 // one message file, its current size, and the file-seek-offsets at which each
-// message starts.
+// message starts, and the creation time of each message. Also when the file
+// was started, and whether its records are compressed, which is decided then.
 type FileMeta struct {
-	Oldest                     MsgMeta
-	Newest                     MsgMeta
-	Size                       int64
-	SeekOffsetForMessageNumber map[int32]int64
+	Oldest                       MsgMeta
+	Newest                       MsgMeta
+	Size                         int64
+	SeekOffsetForMessageNumber   map[int32]int64
+	CreationTimeForMessageNumber map[int32]time.Time
+	Opened                       time.Time
+	Compressed                   bool
 }
 
 // NewFileMeta provides an initialised FileMeta, ready to use.
 func NewFileMeta() *FileMeta {
-	return &FileMeta{SeekOffsetForMessageNumber: map[int32]int64{}}
+	return &FileMeta{
+		SeekOffsetForMessageNumber:   map[int32]int64{},
+		CreationTimeForMessageNumber: map[int32]time.Time{},
+	}
+}
+
+// Clone provides a copy of the FileMeta, that shares nothing with it.
+func (fm *FileMeta) Clone() *FileMeta {
+	clone := *fm
+	clone.SeekOffsetForMessageNumber = map[int32]int64{}
+	for msgNumber, offset := range fm.SeekOffsetForMessageNumber {
+		clone.SeekOffsetForMessageNumber[msgNumber] = offset
+	}
+	clone.CreationTimeForMessageNumber = map[int32]time.Time{}
+	for msgNumber, created := range fm.CreationTimeForMessageNumber {
+		clone.CreationTimeForMessageNumber[msgNumber] = created
+	}
+	return &clone
 }
 
 // RegisterNewMessage updates the FileMeta object according to this new
 // message arriving in the store.
-func (fm *FileMeta) RegisterNewMessage(msgNumber int32, messageSize int64) {
+func (fm *FileMeta) RegisterNewMessage(
+	msgNumber int32, messageSize int64, creationTime time.Time) {
 
 	fm.SeekOffsetForMessageNumber[msgNumber] = fm.Size
 	fm.Size += messageSize
 
-	creationTime := time.Now()
+	// Cope with indexes persisted before creation times were tracked per
+	// message.
+	if fm.CreationTimeForMessageNumber == nil {
+		fm.CreationTimeForMessageNumber = map[int32]time.Time{}
+	}
+	fm.CreationTimeForMessageNumber[msgNumber] = creationTime
 
 	// Special case, when this is the first message to arrive for the file.
 	if fm.Oldest.MsgNum == int32(0) {
@@ -40,3 +67,54 @@ func (fm *FileMeta) RegisterNewMessage(msgNumber int32, messageSize int64) {
 	}
 	fm.Newest = MsgMeta{msgNumber, creationTime}
 }
+
+// CreationTimeOf provides the creation time of the given message number in
+// the file. When the file does not have the message's individual creation
+// time, it falls back to the creation time of the file's newest message.
+func (fm *FileMeta) CreationTimeOf(msgNumber int32) time.Time {
+	creationTime, ok := fm.CreationTimeForMessageNumber[msgNumber]
+	if ok == false {
+		return fm.Newest.Created
+	}
+	return creationTime
+}
+
+// DropMessagesBefore updates the FileMeta object to reflect the messages that
+// precede the given message number having been cut from the front of the
+// file. It provides the number of bytes they occupied, which is the amount by
+// which the file must be shortened. The given message number must be one of
+// those held by the file.
+func (fm *FileMeta) DropMessagesBefore(msgNumber int32) (bytesDropped int64) {
+	bytesDropped = fm.SeekOffsetForMessageNumber[msgNumber]
+	for n := fm.Oldest.MsgNum; n < msgNumber; n++ {
+		delete(fm.SeekOffsetForMessageNumber, n)
+		delete(fm.CreationTimeForMessageNumber, n)
+	}
+	// (Skipping the gaps that compaction leaves.)
+	for n, offset := range fm.SeekOffsetForMessageNumber {
+		fm.SeekOffsetForMessageNumber[n] = offset - bytesDropped
+	}
+	fm.Size -= bytesDropped
+	fm.Oldest = MsgMeta{msgNumber, fm.CreationTimeOf(msgNumber)}
+	return bytesDropped
+}
+
+// KeepOnly updates the FileMeta object to reflect the file having been
+// rewritten to hold only the given messages, which must be in ascending order,
+// and of which there must be at least one. They now start at the given seek
+// offsets, and the file is of the given size.
+func (fm *FileMeta) KeepOnly(
+	msgNumbers []int32, seekOffsets []int64, size int64) {
+	seekOffsetFor := map[int32]int64{}
+	creationTimeFor := map[int32]time.Time{}
+	for i, msgNumber := range msgNumbers {
+		seekOffsetFor[msgNumber] = seekOffsets[i]
+		creationTimeFor[msgNumber] = fm.CreationTimeOf(msgNumber)
+	}
+	oldest, newest := msgNumbers[0], msgNumbers[len(msgNumbers)-1]
+	fm.Oldest = MsgMeta{oldest, creationTimeFor[oldest]}
+	fm.Newest = MsgMeta{newest, creationTimeFor[newest]}
+	fm.SeekOffsetForMessageNumber = seekOffsetFor
+	fm.CreationTimeForMessageNumber = creationTimeFor
+	fm.Size = size
+}
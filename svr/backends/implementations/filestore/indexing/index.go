@@ -8,6 +8,11 @@
 // files are.
 package indexing
 
+import (
+	"fmt"
+	"sort"
+)
+
 // The types' fields are exported so they can be automatically gob-encoded
 // without bothering with structure tags.
 
@@ -35,6 +40,13 @@ func (index *Index) RegisterTopic(topic string) {
 	index.NextMessageNumbers[topic] = 1
 }
 
+// ForgetTopic removes all knowledge of the given topic from the index. It
+// copes silently with the topic being unknown.
+func (index *Index) ForgetTopic(topic string) {
+	delete(index.MessageFileLists, topic)
+	delete(index.NextMessageNumbers, topic)
+}
+
 // GetMessageFileListFor provides access to the MesageFileList for the
 // given topic. It copes gracefully with the topic being hithertoo unknown.
 func (index *Index) GetMessageFileListFor(topic string) *MessageFileList {
@@ -45,6 +57,17 @@ func (index *Index) GetMessageFileListFor(topic string) *MessageFileList {
 	return index.MessageFileLists[topic]
 }
 
+// NextMessageNumberFor provides the next message number that should be
+// allocated to a message in the given topic, without advancing it. It copes
+// gracefully with the topic being hithertoo unknown.
+func (index *Index) NextMessageNumberFor(topic string) int32 {
+	next, ok := index.NextMessageNumbers[topic]
+	if ok == false {
+		return 1
+	}
+	return next
+}
+
 // GetAndIncrementMessageNumberFor provides the next message number that
 // should be allocated to a message in the given topic, and advances its
 // internal record of this by one.
@@ -86,3 +109,43 @@ func (index Index) PreviouslyUsed(name string, topic string) bool {
 	}
 	return false
 }
+
+// FileContaining provides the name of the message file that holds the given
+// message number for the given topic. It binary-searches the topic's files
+// by the range of message numbers each holds, so it remains quick for topics
+// with very many files. It reports an error if the topic is unknown, or no
+// file holds the message.
+func (index Index) FileContaining(topic string, msgNumber int) (string, error) {
+	msgFileList, ok := index.MessageFileLists[topic]
+	if ok == false {
+		return "", fmt.Errorf("Unknown topic: %v", topic)
+	}
+	// Find the earliest file whose newest message is not before the one
+	// sought. (A file with no messages registered yet can only be the
+	// newest.)
+	names := msgFileList.Names
+	i := sort.Search(len(names), func(i int) bool {
+		fileMeta := msgFileList.Meta[names[i]]
+		return fileMeta.Oldest.MsgNum == 0 ||
+			msgNumber <= int(fileMeta.Newest.MsgNum)
+	})
+	if i < len(names) {
+		oldest := msgFileList.Meta[names[i]].Oldest.MsgNum
+		if oldest != 0 && int(oldest) <= msgNumber {
+			return names[i], nil
+		}
+	}
+	return "", fmt.Errorf("No file holds message %d in topic: %v",
+		msgNumber, topic)
+}
+
+// Topics provides the names of all the topics known to the index, sorted
+// alphabetically.
+func (index Index) Topics() []string {
+	topics := []string{}
+	for topic := range index.MessageFileLists {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
@@ -1,6 +1,7 @@
 package indexing
 
 import (
+	"fmt"
 	"sort"
 	"testing"
 	"time"
@@ -19,6 +20,16 @@ func TestGetAndIncrementMessageNumberFor(t *testing.T) {
 	assert.Equal(t, int32(8), nextNum)
 }
 
+func TestNextMessageNumberFor(t *testing.T) {
+	index, _ := MakeReferenceIndex()
+
+	// Check a prepared case, and that it has no side effect.
+	assert.Equal(t, int32(7), index.NextMessageNumberFor("topicB"))
+	assert.Equal(t, int32(7), index.NextMessageNumberFor("topicB"))
+	// Check correct when topic is unknown.
+	assert.Equal(t, int32(1), index.NextMessageNumberFor("nosuchtopic"))
+}
+
 func TestCurrentMsgFileNameFor(t *testing.T) {
 	index, _ := MakeReferenceIndex()
 	// Check correct when topic is known and has files registered.
@@ -88,6 +99,14 @@ func TestForgetFiles(t *testing.T) {
 	assert.Contains(t, lst.Meta, "file1")
 	assert.NotContains(t, lst.Meta, "file2")
 
+	// Case when the names are not in alphabetical order.
+	lst = NewMessageFileList()
+	for _, name := range []string{"ZZZ", "MMM", "AAA"} {
+		lst.RegisterNewFile(name)
+	}
+	lst.ForgetFiles([]string{"ZZZ"})
+	assert.Equal(t, []string{"MMM", "AAA"}, lst.Names)
+
 	// Check when a name is not known to the list it copes silently.
 	index, _ = MakeReferenceIndex()
 	forgetThese = []string{"neverheardof"}
@@ -116,6 +135,73 @@ func TestNumMessagesInFile(t *testing.T) {
 	assert.Equal(t, expected, n)
 }
 
+func TestNumMessages(t *testing.T) {
+	index, _ := MakeReferenceIndex()
+	lst := index.MessageFileLists["topicA"]
+	assert.Equal(t, 6, lst.NumMessages())
+
+	// Case when there are no files.
+	lst = NewMessageFileList()
+	assert.Equal(t, 0, lst.NumMessages())
+}
+
+func TestTotalSize(t *testing.T) {
+	index, _ := MakeReferenceIndex()
+	lst := index.MessageFileLists["topicA"]
+	assert.Equal(t, int64(6*1024), lst.TotalSize())
+
+	// Case when there are no files.
+	lst = NewMessageFileList()
+	assert.Equal(t, int64(0), lst.TotalSize())
+}
+
+func TestBounds(t *testing.T) {
+	index, _ := MakeReferenceIndex()
+	lst := index.MessageFileLists["topicA"]
+	oldest, newest := lst.Bounds()
+	assert.Equal(t, 1, oldest)
+	assert.Equal(t, 6, newest)
+
+	// Case when the oldest file has been forgotten.
+	lst.ForgetFiles([]string{"file1"})
+	oldest, newest = lst.Bounds()
+	assert.Equal(t, 4, oldest)
+	assert.Equal(t, 6, newest)
+
+	// Case when a file is known, but no messages registered.
+	lst = NewMessageFileList()
+	lst.RegisterNewFile("some file")
+	oldest, newest = lst.Bounds()
+	assert.Equal(t, -1, oldest)
+	assert.Equal(t, -1, newest)
+}
+
+func TestFirstMessageNumberSince(t *testing.T) {
+	index, times := MakeReferenceIndex()
+	lst := index.MessageFileLists["topicA"]
+
+	// A time earlier than all the messages should give the first one.
+	msgNum, ok := lst.FirstMessageNumberSince(times[0].Add(-time.Hour))
+	assert.True(t, ok)
+	assert.Equal(t, int32(1), msgNum)
+
+	// A time in the middle of file2 should give the next message created
+	// after it.
+	msgNum, ok = lst.FirstMessageNumberSince(times[4].Add(-time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, int32(5), msgNum)
+
+	// A time later than all the messages should give none.
+	_, ok = lst.FirstMessageNumberSince(times[5].Add(time.Hour))
+	assert.False(t, ok)
+
+	// Messages that are no longer held should be passed over.
+	lst.Meta["file2"].KeepOnly([]int32{4, 6}, []int64{0, 1024}, 2048)
+	msgNum, ok = lst.FirstMessageNumberSince(times[4].Add(-time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, int32(6), msgNum)
+}
+
 func TestMessageFilesForMessagesFrom(t *testing.T) {
 	index, _ := MakeReferenceIndex()
 	lst := index.MessageFileLists["topicA"]
@@ -146,6 +232,129 @@ func TestMessageFilesForMessagesFrom(t *testing.T) {
 	files = lst.MessageFilesForMessagesFrom(9999)
 	expected = []string{}
 	assert.Equal(t, expected, files)
+
+	// Case when the newest file has no messages registered yet.
+	lst.RegisterNewFile("file3")
+	files = lst.MessageFilesForMessagesFrom(2)
+	expected = []string{"file1", "file2", "file3"}
+	assert.Equal(t, expected, files)
+}
+
+func TestFileContaining(t *testing.T) {
+	// Build an index for a topic with many files, each holding 3 messages,
+	// and with those of the first file forgotten.
+	index := NewIndex()
+	now := time.Now()
+	msgFileList := index.GetMessageFileListFor("topicA")
+	for i := 0; i < 1000; i++ {
+		fileName := fmt.Sprintf("file%04d", i)
+		msgFileList.RegisterNewFile(fileName)
+		for j := 0; j < 3; j++ {
+			msgNumber := index.GetAndIncrementMessageNumberFor("topicA")
+			msgFileList.Meta[fileName].RegisterNewMessage(msgNumber, 10, now)
+		}
+	}
+	msgFileList.ForgetFiles([]string{"file0000"})
+
+	// Boundary and interior message numbers.
+	cases := map[int]string{
+		4:    "file0001",
+		5:    "file0001",
+		6:    "file0001",
+		7:    "file0002",
+		1500: "file0499",
+		2998: "file0999",
+		3000: "file0999",
+	}
+	for msgNumber, expected := range cases {
+		fileName, err := index.FileContaining("topicA", msgNumber)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, fileName, "message %d", msgNumber)
+	}
+
+	// Message numbers no longer, or not yet held.
+	_, err := index.FileContaining("topicA", 3)
+	assert.NotNil(t, err)
+	_, err = index.FileContaining("topicA", 3001)
+	assert.NotNil(t, err)
+	// Unknown topic.
+	_, err = index.FileContaining("nosuchtopic", 1)
+	assert.NotNil(t, err)
+}
+
+func TestTopics(t *testing.T) {
+	index, _ := MakeReferenceIndex()
+	assert.Equal(t, []string{"topicA", "topicB"}, index.Topics())
+
+	// Case when there are no topics.
+	index = NewIndex()
+	assert.Equal(t, []string{}, index.Topics())
+}
+
+func TestForgetTopic(t *testing.T) {
+	index, _ := MakeReferenceIndex()
+	index.ForgetTopic("topicA")
+	assert.Equal(t, []string{"topicB"}, index.Topics())
+	assert.NotContains(t, index.NextMessageNumbers, "topicA")
+
+	// Check it copes silently when the topic is unknown.
+	index.ForgetTopic("nosuchtopic")
+	assert.Equal(t, []string{"topicB"}, index.Topics())
 }
 
 // Add other cases.
+
+func TestDropMessagesBefore(t *testing.T) {
+	index, times := MakeReferenceIndex()
+	fileMeta := index.MessageFileLists["topicA"].Meta["file2"]
+
+	// File2 holds messages 4, 5 and 6, each of 1024 bytes.
+	bytesDropped := fileMeta.DropMessagesBefore(6)
+	assert.Equal(t, int64(2048), bytesDropped)
+	assert.Equal(t, int64(1024), fileMeta.Size)
+	assert.Equal(t, int32(6), fileMeta.Oldest.MsgNum)
+	assert.Equal(t, times[5], fileMeta.Oldest.Created)
+	assert.Equal(t, int32(6), fileMeta.Newest.MsgNum)
+	assert.Equal(t, int64(0), fileMeta.SeekOffsetForMessageNumber[6])
+	_, ok := fileMeta.SeekOffsetForMessageNumber[4]
+	assert.False(t, ok)
+	_, ok = fileMeta.CreationTimeForMessageNumber[5]
+	assert.False(t, ok)
+
+	// Dropping those before the oldest should change nothing.
+	bytesDropped = fileMeta.DropMessagesBefore(6)
+	assert.Equal(t, int64(0), bytesDropped)
+	assert.Equal(t, int64(1024), fileMeta.Size)
+}
+
+func TestKeepOnly(t *testing.T) {
+	index, times := MakeReferenceIndex()
+	fileMeta := index.MessageFileLists["topicA"].Meta["file2"]
+
+	// File2 holds messages 4, 5 and 6, each of 1024 bytes.
+	fileMeta.KeepOnly([]int32{4, 6}, []int64{0, 1024}, 2048)
+	assert.Equal(t, int64(2048), fileMeta.Size)
+	assert.Equal(t, int32(4), fileMeta.Oldest.MsgNum)
+	assert.Equal(t, times[3], fileMeta.Oldest.Created)
+	assert.Equal(t, int32(6), fileMeta.Newest.MsgNum)
+	assert.Equal(t, times[5], fileMeta.Newest.Created)
+	assert.Equal(t, map[int32]int64{4: 0, 6: 1024},
+		fileMeta.SeekOffsetForMessageNumber)
+	_, ok := fileMeta.CreationTimeForMessageNumber[5]
+	assert.False(t, ok)
+}
+
+func TestClone(t *testing.T) {
+	index, _ := MakeReferenceIndex()
+	original := index.MessageFileLists["topicA"]
+	clone := original.Clone()
+	assert.Equal(t, original, clone)
+
+	// Changing the clone must leave the original alone.
+	clone.RegisterNewFile("file3")
+	clone.Meta["file2"].RegisterNewMessage(7, 1024, time.Now())
+	clone.Meta["file1"].KeepOnly([]int32{1}, []int64{0}, 1024)
+	assert.Equal(t, []string{"file1", "file2"}, original.Names)
+	assert.Equal(t, 3, original.NumMessagesInFile("file1"))
+	assert.Equal(t, int32(6), original.Meta["file2"].Newest.MsgNum)
+}
@@ -25,6 +25,16 @@ func NewMessageFileList() *MessageFileList {
 	}
 }
 
+// Clone provides a copy of the MessageFileList, that shares nothing with it.
+func (lst *MessageFileList) Clone() *MessageFileList {
+	clone := NewMessageFileList()
+	clone.Names = append(clone.Names, lst.Names...)
+	for name, fileMeta := range lst.Meta {
+		clone.Meta[name] = fileMeta.Clone()
+	}
+	return clone
+}
+
 // RegisterNewFile .
 func (lst *MessageFileList) RegisterNewFile(filename string) {
 	lst.Names = append(lst.Names, filename)
@@ -46,25 +56,21 @@ func (lst *MessageFileList) SpentFiles(maxAge time.Time) []string {
 // ForgetFiles mandates the MessageFileList to forget about the given
 // set of file names.
 func (lst *MessageFileList) ForgetFiles(names []string) {
+	forget := map[string]bool{}
 	for _, name := range names {
 		// Get rid of this name from the map of file names to FileMeta.
 		delete(lst.Meta, name)
-		// Take the name out of the ordered list of filenames also.
-		n := len(lst.Names)
-		index := sort.SearchStrings(lst.Names, name)
-		if index == n { // Not present.
-			continue
-		}
-		if index == n-1 { // Special case when it's the last element.
-			lst.Names = lst.Names[0 : n-1]
-			continue
+		forget[name] = true
+	}
+	// Take the names out of the ordered list of filenames also. (The list
+	// is in order of introduction, not sorted, so it cannot be searched.)
+	newList := []string{}
+	for _, name := range lst.Names {
+		if forget[name] == false {
+			newList = append(newList, name)
 		}
-		// General case.
-		newList := []string{}
-		newList = append(newList, lst.Names[0:index]...)
-		newList = append(newList, lst.Names[index+1:]...)
-		lst.Names = newList
 	}
+	lst.Names = newList
 }
 
 // NumMessagesInFile provides a count of how many messages are held
@@ -75,12 +81,90 @@ func (lst *MessageFileList) NumMessagesInFile(name string) int {
 	if ok == false {
 		return 0
 	}
-	// Name known, but no messages recorded for it yet.
-	if fileMeta.Oldest.MsgNum == 0 {
-		return 0
+	// Count those recorded, since there may be gaps in the numbering, where
+	// a rebuild of the index dropped corrupt records.
+	return len(fileMeta.SeekOffsetForMessageNumber)
+}
+
+// NumMessages provides a count of how many messages are held in all of the
+// list's files.
+func (lst *MessageFileList) NumMessages() int {
+	n := 0
+	for _, name := range lst.Names {
+		n += lst.NumMessagesInFile(name)
+	}
+	return n
+}
+
+// TotalSize provides the sum of the sizes of all of the list's files.
+func (lst *MessageFileList) TotalSize() int64 {
+	var total int64
+	for _, name := range lst.Names {
+		total += lst.Meta[name].Size
+	}
+	return total
+}
+
+// Bounds provides the lowest and highest message numbers held in the list's
+// files. When no messages are held, both are returned as -1.
+func (lst *MessageFileList) Bounds() (oldest int, newest int) {
+	oldest = -1
+	for _, name := range lst.Names {
+		fileMeta := lst.Meta[name]
+		// Skip files that have no messages registered yet.
+		if fileMeta.Oldest.MsgNum == 0 {
+			continue
+		}
+		oldest = int(fileMeta.Oldest.MsgNum)
+		break
+	}
+	return oldest, lst.HighWaterMark()
+}
+
+// HighWaterMark provides the highest message number held in the list's
+// files - which is not necessarily one less than the next number to be
+// assigned, since the newest messages may have been removed. When no
+// messages are held, it is -1.
+func (lst *MessageFileList) HighWaterMark() int {
+	for i := len(lst.Names) - 1; i >= 0; i-- {
+		fileMeta := lst.Meta[lst.Names[i]]
+		// Skip files that have no messages registered yet.
+		if fileMeta.Oldest.MsgNum == 0 {
+			continue
+		}
+		return int(fileMeta.Newest.MsgNum)
+	}
+	return -1
+}
+
+// FirstMessageNumberSince provides the number of the oldest message held in
+// the list's files that was created at, or after the time given. Files whose
+// newest message is older than that are skipped without being looked at in
+// detail. The boolean return value is false when there is no such message.
+func (lst *MessageFileList) FirstMessageNumberSince(
+	since time.Time) (int32, bool) {
+	for _, name := range lst.Names {
+		fileMeta := lst.Meta[name]
+		if fileMeta.Oldest.MsgNum == 0 {
+			continue
+		}
+		if fileMeta.Newest.Created.Before(since) {
+			continue
+		}
+		// This is the boundary file. Its messages need not be contiguous,
+		// once it has been compacted.
+		oldest, newest := fileMeta.Oldest.MsgNum, fileMeta.Newest.MsgNum
+		for msgNum := oldest; msgNum <= newest; msgNum++ {
+			_, ok := fileMeta.SeekOffsetForMessageNumber[msgNum]
+			if ok == false {
+				continue
+			}
+			if fileMeta.CreationTimeOf(msgNum).Before(since) == false {
+				return msgNum, true
+			}
+		}
 	}
-	// General case.
-	return int(fileMeta.Newest.MsgNum) - int(fileMeta.Oldest.MsgNum) + 1
+	return 0, false
 }
 
 // MessageFilesForMessagesFrom provides all the message files that contain
@@ -91,12 +175,15 @@ func (lst *MessageFileList) MessageFilesForMessagesFrom(
 	if len(lst.Names) == 0 {
 		return []string{}
 	}
-	// Binary search gives us the earliest relevant file.
+	// Binary search gives us the earliest relevant file. (A file with no
+	// messages registered yet can only be the newest, so counts as
+	// relevant.)
 	n := len(lst.Names)
 	idx := sort.Search(n, func(i int) bool {
 		name := lst.Names[i]
 		fileMeta := lst.Meta[name]
-		return msgNumber <= int(fileMeta.Newest.MsgNum)
+		return fileMeta.Oldest.MsgNum == 0 ||
+			msgNumber <= int(fileMeta.Newest.MsgNum)
 	})
 	// We want the one just identified, plus all later ones.
 	return lst.Names[idx:]
@@ -3,35 +3,91 @@ package indexing
 import (
 	"fmt"
 	"os"
+	"path"
 )
 
 // Save serializes the index into a byte stream representation, and saves this
-// as a binary file.
-func (index *Index) Save(filepath string) error {
-	file, err := os.Create(filepath)
+// as a binary file, with the given permissions (before the umask). The file
+// is replaced atomically, so that an interrupted save leaves the previous
+// file intact.
+func (index *Index) Save(filepath string, perm os.FileMode) error {
+	return index.save(filepath, perm, false)
+}
+
+// SaveAndSync is like Save, except that it commits the file to stable
+// storage before returning.
+func (index *Index) SaveAndSync(filepath string, perm os.FileMode) error {
+	return index.save(filepath, perm, true)
+}
+
+func (index *Index) save(filepath string, perm os.FileMode, sync bool) error {
+	tmpPath, err := index.saveToTemp(filepath, perm, sync)
 	if err != nil {
-		return fmt.Errorf("os.Create(): %v", err)
+		return fmt.Errorf("saveToTemp(): %v", err)
 	}
-	defer file.Close()
-	err = index.Encode(file)
+	err = os.Rename(tmpPath, filepath)
 	if err != nil {
-		return fmt.Errorf("Encode(): %v", err)
+		os.Remove(tmpPath)
+		return fmt.Errorf("os.Rename(): %v", err)
+	}
+	if sync {
+		// The rename too must reach stable storage.
+		err = syncDir(path.Dir(filepath))
+		if err != nil {
+			return fmt.Errorf("syncDir(): %v", err)
+		}
 	}
 	return nil
 }
 
+// saveToTemp is the first phase of save. It writes the index to a temporary
+// file alongside the given path, whose path it provides. Should it fail, it
+// removes the temporary file.
+func (index *Index) saveToTemp(filepath string, perm os.FileMode,
+	sync bool) (tmpPath string, err error) {
+	tmpPath = filepath + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return "", fmt.Errorf("os.OpenFile(): %v", err)
+	}
+	err = index.Encode(file)
+	if err == nil && sync {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("writing %s: %v", tmpPath, err)
+	}
+	return tmpPath, nil
+}
+
+// syncDir commits the given directory's entries to stable storage.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("os.Open(): %v", err)
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
 // PopulateFromDisk reads the bytes from the nominated file which was created
 // using the SaveIndex sister method, and deserializes them popualate this
-// Index object.
+// Index object. When the file cannot be decoded, or does not match its
+// checksum, it returns an error wrapping ErrCorruptIndex.
 func (index *Index) PopulateFromDisk(filepath string) error {
 	file, err := os.Open(filepath)
 	if err != nil {
-		return fmt.Errorf("os.Open(): %v", err)
+		return fmt.Errorf("os.Open(): %w", err)
 	}
 	defer file.Close()
 	err = index.Decode(file)
 	if err != nil {
-		return fmt.Errorf("Decode(): %v", err)
+		return fmt.Errorf("%w: %v", ErrCorruptIndex, err)
 	}
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,7 +26,7 @@ func TestSaveAndRetrieve(t *testing.T) {
 	defer os.Remove(filepath)
 
 	index, _ := MakeReferenceIndex()
-	err = index.Save(filepath)
+	err = index.Save(filepath, 0644)
 	if err != nil {
 		msg := fmt.Sprintf("SaveIndex(): %v", err)
 		assert.FailNow(t, msg)
@@ -38,3 +39,44 @@ func TestSaveAndRetrieve(t *testing.T) {
 	}
 	assert.Equal(t, 2, len(index.MessageFileLists["topicA"].Names))
 }
+
+// Make sure that a save which is interrupted after writing the temporary file,
+// but before renaming it, leaves the previously saved index intact.
+func TestInterruptedSaveLeavesPreviousIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index_")
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.TempDir(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	defer os.RemoveAll(dir)
+	filepath := path.Join(dir, "index")
+
+	index, _ := MakeReferenceIndex()
+	err = index.Save(filepath, 0644)
+	if err != nil {
+		msg := fmt.Sprintf("index.Save(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	index.ForgetTopic("topicA")
+	_, err = index.saveToTemp(filepath, 0644, false)
+	if err != nil {
+		msg := fmt.Sprintf("index.saveToTemp(): %v", err)
+		assert.FailNow(t, msg)
+	}
+
+	restored := NewIndex()
+	err = restored.PopulateFromDisk(filepath)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"topicA", "topicB"}, restored.Topics())
+
+	// The next save should complete normally, over the temporary file left
+	// behind.
+	err = index.Save(filepath, 0644)
+	assert.Nil(t, err)
+	restored = NewIndex()
+	err = restored.PopulateFromDisk(filepath)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"topicB"}, restored.Topics())
+	_, err = os.Stat(filepath + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -34,7 +34,7 @@ func MakeReferenceIndex() (index *Index, creationTimes []time.Time) {
 				msgSize := int64(1024)
 				now := time.Now()
 				ctimes = append(ctimes, now)
-				fileMeta.RegisterNewMessage(msgNumber, msgSize)
+				fileMeta.RegisterNewMessage(msgNumber, msgSize, now)
 			}
 		}
 	}
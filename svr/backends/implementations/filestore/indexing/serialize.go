@@ -1,29 +1,98 @@
 package indexing
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
+)
+
+// The encoded index is prefixed with a header holding a magic number, which
+// marks it as an index, and the version of the format. It is followed by a
+// CRC32 checksum, which covers the header as well as the gob-encoded index,
+// so that an index that has been corrupted is detected, rather than being
+// taken to mean something else. The version and checksum are little-endian.
+//
+// Indexes encoded before the header was introduced are gob-encoded alone.
+// They are told apart by their first byte, which cannot be zero - because it
+// is the length of the first gob message - whereas the magic number's first
+// byte is.
+var indexMagic = []byte{0x00, 'm', 'k', 'i'}
+
+const (
+	indexHeaderSize   = 6
+	indexChecksumSize = 4
+	// The version of the index format that Encode writes.
+	indexVersion uint16 = 1
 )
 
 // Encode is a serializer. It encodes the index into a byte stream and writes
 // them to the output writer provided. See also the Decode sister method.
 func (index *Index) Encode(writer io.Writer) error {
-	encoder := gob.NewEncoder(writer)
-	err := encoder.Encode(index)
+	var buf bytes.Buffer
+	buf.Write(indexMagic)
+	err := binary.Write(&buf, binary.LittleEndian, indexVersion)
+	if err != nil {
+		return fmt.Errorf("binary.Write(): %v", err)
+	}
+	encoder := gob.NewEncoder(&buf)
+	err = encoder.Encode(index)
 	if err != nil {
 		return fmt.Errorf("encoder.Encode(): %v", err)
 	}
+	err = binary.Write(&buf, binary.LittleEndian,
+		crc32.ChecksumIEEE(buf.Bytes()))
+	if err != nil {
+		return fmt.Errorf("binary.Write(): %v", err)
+	}
+	_, err = writer.Write(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("writer.Write(): %v", err)
+	}
 	return nil
 }
 
 // Decode is a de-serializer. It populates the index by decoding the bytes
-// read from the input reader provided. See also the Encode sister method.
+// read from the input reader provided. See also the Encode sister method. It
+// returns an error when the bytes do not match their checksum, or are of a
+// version it does not know, as well as when they cannot be decoded.
 func (index *Index) Decode(reader io.Reader) error {
-	decoder := gob.NewDecoder(reader)
-	err := decoder.Decode(index)
+	encoded, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("ioutil.ReadAll(): %v", err)
+	}
+	if bytes.HasPrefix(encoded, indexMagic) {
+		encoded, err = verifyIndex(encoded)
+		if err != nil {
+			return fmt.Errorf("verifyIndex(): %v", err)
+		}
+	}
+	decoder := gob.NewDecoder(bytes.NewReader(encoded))
+	err = decoder.Decode(index)
 	if err != nil {
 		return fmt.Errorf("decoder.Decode: %v", err)
 	}
 	return nil
 }
+
+// verifyIndex checks the header and checksum of the given encoded index, and
+// provides the gob-encoded index they surround.
+func verifyIndex(encoded []byte) ([]byte, error) {
+	if len(encoded) < indexHeaderSize+indexChecksumSize {
+		return nil, fmt.Errorf("index is only %d bytes long", len(encoded))
+	}
+	version := binary.LittleEndian.Uint16(
+		encoded[len(indexMagic):indexHeaderSize])
+	if version != indexVersion {
+		return nil, fmt.Errorf("unknown index version: %d", version)
+	}
+	end := len(encoded) - indexChecksumSize
+	want := binary.LittleEndian.Uint32(encoded[end:])
+	if crc32.ChecksumIEEE(encoded[:end]) != want {
+		return nil, fmt.Errorf("index does not match its checksum")
+	}
+	return encoded[indexHeaderSize:end], nil
+}
@@ -2,6 +2,7 @@ package indexing
 
 import (
 	"bytes"
+	"encoding/gob"
 	"encoding/json"
 	"testing"
 )
@@ -45,3 +46,47 @@ func TestSerialization(t *testing.T) {
 		t.Fatalf("Restored index differs from the one saved.")
 	}
 }
+
+// TestCorruptionIsDetected makes sure that changing any one byte of an
+// encoded index, or cutting it short, makes it fail to decode, rather than
+// decoding as something else.
+func TestCorruptionIsDetected(t *testing.T) {
+	index, _ := MakeReferenceIndex()
+	var buf bytes.Buffer
+	err := index.Encode(&buf)
+	if err != nil {
+		t.Fatalf("index.Encode: %v", err)
+	}
+	encoded := buf.Bytes()
+	for i := range encoded {
+		corrupted := append([]byte{}, encoded...)
+		corrupted[i] ^= 0x01
+		err = NewIndex().Decode(bytes.NewReader(corrupted))
+		if err == nil {
+			t.Fatalf("index with byte %d changed decoded", i)
+		}
+	}
+	err = NewIndex().Decode(bytes.NewReader(encoded[:len(encoded)-1]))
+	if err == nil {
+		t.Fatalf("truncated index decoded")
+	}
+}
+
+// TestUnversionedIndexIsDecodable makes sure that an index encoded before
+// the header and checksum were introduced can still be decoded.
+func TestUnversionedIndexIsDecodable(t *testing.T) {
+	index, _ := MakeReferenceIndex()
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(index)
+	if err != nil {
+		t.Fatalf("encoder.Encode: %v", err)
+	}
+	restored := NewIndex()
+	err = restored.Decode(&buf)
+	if err != nil {
+		t.Fatalf("index.Decode: %v", err)
+	}
+	if len(restored.MessageFileLists["topicA"].Names) != 2 {
+		t.Fatalf("restored index differs from the one encoded")
+	}
+}
@@ -0,0 +1,122 @@
+package ioutils
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// AppendHandles is a cache of files held open for appending to, one per key
+// (such as a topic), so that a sequence of appends to the same file need not
+// open and close it each time. Appends for different keys can be made
+// concurrently, but those for the same key must not be. The zero value is
+// ready to use.
+type AppendHandles struct {
+	mutex sync.Mutex
+	files map[string]*os.File // Keyed on the key given to Append.
+	opens int
+}
+
+// Append appends some bytes to the specified file, using the handle cached
+// for the key. When that is for a different file, it is closed and replaced
+// by one for this file. When sync is true, the file is committed to stable
+// storage after the append.
+func (h *AppendHandles) Append(key string, filepath string, someData []byte,
+	sync bool) error {
+	file, err := h.handleFor(key, filepath)
+	if err != nil {
+		return fmt.Errorf("handleFor(): %v", err)
+	}
+	_, err = file.Write(someData)
+	if err != nil {
+		// Don't rely on the handle again.
+		h.forget(key)
+		return fmt.Errorf("file.Write(): %v", err)
+	}
+	if sync {
+		err = file.Sync()
+		if err != nil {
+			h.forget(key)
+			return fmt.Errorf("file.Sync(): %v", err)
+		}
+	}
+	return nil
+}
+
+// CloseAll closes all the cached handles. It must be called before any of
+// the files they are for are removed or replaced, and when the cache is
+// finished with. The cache remains usable afterwards.
+func (h *AppendHandles) CloseAll() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	var firstErr error
+	for key, file := range h.files {
+		err := file.Close()
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("file.Close(): %v", err)
+		}
+		delete(h.files, key)
+	}
+	return firstErr
+}
+
+// Close closes the handle cached for the key, if there is one. It must be
+// called before the file it is for is removed or replaced.
+func (h *AppendHandles) Close(key string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	file, ok := h.files[key]
+	if ok == false {
+		return nil
+	}
+	delete(h.files, key)
+	err := file.Close()
+	if err != nil {
+		return fmt.Errorf("file.Close(): %v", err)
+	}
+	return nil
+}
+
+// Opens provides how many times the cache has had to open a file.
+func (h *AppendHandles) Opens() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.opens
+}
+
+// handleFor provides the cached handle for the key, when it is for the given
+// file, or otherwise opens one and caches it in place of the existing one.
+func (h *AppendHandles) handleFor(key string, filepath string) (
+	*os.File, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	file, ok := h.files[key]
+	if ok && file.Name() == filepath {
+		return file, nil
+	}
+	if ok {
+		file.Close()
+		delete(h.files, key)
+	}
+	file, err := os.OpenFile(filepath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("os.OpenFile(): %v", err)
+	}
+	if h.files == nil {
+		h.files = map[string]*os.File{}
+	}
+	h.files[key] = file
+	h.opens++
+	return file, nil
+}
+
+// forget closes and discards the handle cached for the key, if there is one.
+func (h *AppendHandles) forget(key string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	file, ok := h.files[key]
+	if ok {
+		file.Close()
+		delete(h.files, key)
+	}
+}
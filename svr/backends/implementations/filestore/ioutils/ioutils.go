@@ -27,10 +27,10 @@ func DeleteDirectoryContents(dir string) error {
 	return nil
 }
 
-// CreateDirIfDoesntExist creates a directory with the given path,
-// if one is not there already.
-func CreateDirIfDoesntExist(path string) error {
-	err := os.Mkdir(path, 0777)
+// CreateDirIfDoesntExist creates a directory with the given path and
+// permissions (before the umask), if one is not there already.
+func CreateDirIfDoesntExist(path string, perm os.FileMode) error {
+	err := os.Mkdir(path, perm)
 	if err == nil {
 		return nil
 	}
@@ -40,8 +40,33 @@ func CreateDirIfDoesntExist(path string) error {
 	return fmt.Errorf("os.Mkdir(): %v", err)
 }
 
+// CheckDirIsWritable makes sure that files can be created in the given
+// directory, by creating (and then removing) a temporary probe file in it.
+func CheckDirIsWritable(dir string) error {
+	file, err := ioutil.TempFile(dir, ".probe")
+	if err != nil {
+		return fmt.Errorf("ioutil.TempFile(): %v", err)
+	}
+	file.Close()
+	err = os.Remove(file.Name())
+	if err != nil {
+		return fmt.Errorf("os.Remove(): %v", err)
+	}
+	return nil
+}
+
 // AppendToFile appends some bytes to the specified file, and re-closes it.
 func AppendToFile(filepath string, someData []byte) error {
+	return appendToFile(filepath, someData, false)
+}
+
+// AppendToFileAndSync is like AppendToFile, except that it commits the file
+// to stable storage before closing it.
+func AppendToFileAndSync(filepath string, someData []byte) error {
+	return appendToFile(filepath, someData, true)
+}
+
+func appendToFile(filepath string, someData []byte, sync bool) error {
 	file, err := os.OpenFile(filepath, os.O_APPEND|os.O_WRONLY, os.ModeAppend)
 	if err != nil {
 		return fmt.Errorf("os.OpenFile(): %v", err)
@@ -51,6 +76,48 @@ func AppendToFile(filepath string, someData []byte) error {
 	if err != nil {
 		return fmt.Errorf("file.Write(): %v", err)
 	}
+	if sync {
+		err = file.Sync()
+		if err != nil {
+			return fmt.Errorf("file.Sync(): %v", err)
+		}
+	}
+	return nil
+}
+
+// ReplaceFile replaces the contents of the given file with those given. The
+// replacement is written alongside the original, with the same permissions,
+// and then renamed over it - so that a failure part way through leaves the
+// original intact.
+func ReplaceFile(filepath string, contents []byte) error {
+	info, err := os.Stat(filepath)
+	if err != nil {
+		return fmt.Errorf("os.Stat(): %v", err)
+	}
+	tmpPath := filepath + ".tmp"
+	err = ioutil.WriteFile(tmpPath, contents, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("ioutil.WriteFile(): %v", err)
+	}
+	err = os.Rename(tmpPath, filepath)
+	if err != nil {
+		return fmt.Errorf("os.Rename(): %v", err)
+	}
+	return nil
+}
+
+// SyncFile commits the current contents of the specified file to stable
+// storage.
+func SyncFile(filepath string) error {
+	file, err := os.OpenFile(filepath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile(): %w", err)
+	}
+	defer file.Close()
+	err = file.Sync()
+	if err != nil {
+		return fmt.Errorf("file.Sync(): %v", err)
+	}
 	return nil
 }
 
@@ -70,6 +137,22 @@ func CountEntitiesInDir(dir string) (int, error) {
 	return len(entities), nil
 }
 
+// SubDirectories provides the base names of the directories found in the
+// given directory, sorted alphabetically.
+func SubDirectories(dir string) ([]string, error) {
+	entities, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadDir(): %v", err)
+	}
+	names := []string{}
+	for _, entity := range entities {
+		if entity.IsDir() {
+			names = append(names, entity.Name())
+		}
+	}
+	return names, nil
+}
+
 // TmpRootDir creates a temporary directory with a name that begins
 // with "filestore" - in the context of a testing.T object passed in.
 // It handles errors by calling assert.Fail(t,...).
@@ -0,0 +1,157 @@
+package ioutils
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// errMmapUnsupported is returned by mmapFile where memory mapping is not
+// available.
+var errMmapUnsupported = errors.New("memory mapping is not supported")
+
+// ReadMappings is a cache of files mapped into memory for reading, so that
+// repeated reads of the same file need neither open it, nor copy its
+// contents, each time. For each key (such as a topic) it holds at most two
+// mappings: one of the file currently being appended to, and one of the
+// other file read most recently. Where memory mapping is not available, the
+// files are read in the regular way instead. The zero value is ready to use.
+type ReadMappings struct {
+	mutex sync.Mutex
+	// Keyed on the key given to Contents.
+	current map[string]*mapping
+	recent  map[string]*mapping
+	maps    int
+}
+
+// mapping is one file's contents, mapped into memory.
+type mapping struct {
+	filepath string
+	data     []byte
+	// How many callers of Contents have yet to release the mapping, and
+	// whether it has been dropped from the cache. It is unmapped when both
+	// it has been dropped, and it has no callers left.
+	users   int
+	dropped bool
+}
+
+// Contents provides the contents of the file, which must not be used after
+// calling the release function it also provides. They are read from the
+// mapping cached for the key, unless that is for a different file, or is
+// shorter than size, in which case the file is mapped afresh, to replace it.
+// The current parameter says which of the key's mappings is meant: that of
+// the file being appended to, or that of the one read most recently. (So a
+// new current file, when the old one is rolled over, replaces its mapping.)
+func (m *ReadMappings) Contents(key string, filepath string, size int64,
+	current bool) (contents []byte, release func(), err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.current == nil {
+		m.current = map[string]*mapping{}
+		m.recent = map[string]*mapping{}
+	}
+	cache := m.recent
+	if current {
+		cache = m.current
+	}
+	cached, ok := cache[key]
+	if ok && cached.filepath == filepath && int64(len(cached.data)) >= size {
+		cached.users++
+		return cached.data, func() { m.release(cached) }, nil
+	}
+
+	data, err := mmapFile(filepath)
+	if errors.Is(err, errMmapUnsupported) {
+		data, err = ioutil.ReadFile(filepath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ioutil.ReadFile(): %v", err)
+		}
+		return data, func() {}, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("mmapFile(): %v", err)
+	}
+	if ok {
+		// It has gone from the cache regardless, should this fail.
+		m.drop(cached)
+	}
+	mapped := &mapping{filepath: filepath, data: data, users: 1}
+	cache[key] = mapped
+	m.maps++
+	return data, func() { m.release(mapped) }, nil
+}
+
+// UnmapAll drops all the cached mappings, unmapping those that are not in
+// use. It must be called before any of the files they are for are truncated
+// or replaced, and when the cache is finished with. The cache remains usable
+// afterwards.
+func (m *ReadMappings) UnmapAll() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var firstErr error
+	for _, cache := range []map[string]*mapping{m.current, m.recent} {
+		for key, cached := range cache {
+			err := m.drop(cached)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			delete(cache, key)
+		}
+	}
+	return firstErr
+}
+
+// Unmap is like UnmapAll, except that it drops only the mappings cached for
+// the key.
+func (m *ReadMappings) Unmap(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var firstErr error
+	for _, cache := range []map[string]*mapping{m.current, m.recent} {
+		cached, ok := cache[key]
+		if ok == false {
+			continue
+		}
+		err := m.drop(cached)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(cache, key)
+	}
+	return firstErr
+}
+
+// Maps provides how many times the cache has had to map a file.
+func (m *ReadMappings) Maps() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.maps
+}
+
+// drop marks the mapping as no longer cached, and unmaps it if it is not in
+// use. The caller must hold the mutex.
+func (m *ReadMappings) drop(cached *mapping) error {
+	cached.dropped = true
+	if cached.users > 0 {
+		return nil
+	}
+	err := munmapFile(cached.data)
+	if err != nil {
+		return fmt.Errorf("munmapFile(): %v", err)
+	}
+	return nil
+}
+
+// release records that a caller of Contents has finished with the mapping,
+// and unmaps it if it has been dropped, and this was the last of them.
+func (m *ReadMappings) release(cached *mapping) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cached.users--
+	if cached.dropped && cached.users == 0 {
+		// There is nobody to report a failure to, and the mapping is gone
+		// from the cache regardless.
+		munmapFile(cached.data)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package ioutils
+
+// mmapFile is not available on this platform, so always returns
+// errMmapUnsupported, and the files are read in the regular way instead.
+func mmapFile(filepath string) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+// munmapFile has nothing to do, since nothing is ever mapped.
+func munmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,45 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package ioutils
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the whole of the file into memory, read-only. An empty file
+// is not mapped, but provides no bytes.
+func mmapFile(filepath string) ([]byte, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open(): %v", err)
+	}
+	// The mapping outlives the file descriptor.
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("file.Stat(): %v", err)
+	}
+	if info.Size() == 0 {
+		return []byte{}, nil
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()),
+		syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("syscall.Mmap(): %v", err)
+	}
+	return data, nil
+}
+
+// munmapFile unmaps the bytes provided by mmapFile.
+func munmapFile(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	err := syscall.Munmap(data)
+	if err != nil {
+		return fmt.Errorf("syscall.Munmap(): %v", err)
+	}
+	return nil
+}
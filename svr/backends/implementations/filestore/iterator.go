@@ -0,0 +1,129 @@
+package filestore
+
+import (
+	"fmt"
+
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/actions"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
+)
+
+// Iterator walks forward through the messages of a topic, as they were when
+// it was made by NewIterator. It is not safe for concurrent use.
+type Iterator struct {
+	store    *FileStore
+	topic    string
+	readFrom int
+	// The rest of the snapshot of the topic's message files, and their
+	// FileMetas, still to be read.
+	fileNames []string
+	fileMetas map[string]*indexing.FileMeta
+	// What was read from the last file, that Next has still to provide.
+	pending []records.StoredMessage
+	corrupt []int32
+	// The error that ended the iteration.
+	err error
+}
+
+// NewIterator provides an Iterator over the messages the store retains for
+// the given topic, from the message number given onwards, which reads them as
+// they are now - for bulk export, or analysis. A snapshot is taken of the
+// index's list of the topic's message files, under the store's mutex, and
+// the files are then read one at a time without it, so that the iteration
+// neither holds up stores and polls, nor is held up by them. Messages stored
+// later are not provided. Should retention, or compaction remove messages the
+// iteration has yet to provide, it stops short, having provided those before
+// them. An unknown topic is reported as it is by Poll.
+func (s *FileStore) NewIterator(topic string, from int) (*Iterator, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+
+	err := s.validateTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	msgFileList, ok := s.index.MessageFileLists[topic]
+	if ok == false {
+		return nil, fmt.Errorf("%w: %v", contract.ErrTopicNotFound, topic)
+	}
+	fileNames := msgFileList.MessageFilesForMessagesFrom(from)
+	iterator := &Iterator{store: s, topic: topic, readFrom: from,
+		fileNames: append([]string{}, fileNames...),
+		fileMetas: map[string]*indexing.FileMeta{}}
+	for _, fileName := range fileNames {
+		iterator.fileMetas[fileName] = msgFileList.Meta[fileName].Clone()
+	}
+	return iterator, nil
+}
+
+// Next provides the next message as a Record, and true - or false, once the
+// iteration is over. When the next message's record is found to be corrupt,
+// the error returned wraps ErrCorruptRecords, and says which message it is,
+// and the iteration can be carried on beyond it. After any other error, Next
+// goes on returning it - which is ErrStoreClosed, once the store is closed.
+func (it *Iterator) Next() (contract.Record, bool, error) {
+	for {
+		if it.err != nil {
+			return contract.Record{}, false, it.err
+		}
+		if len(it.corrupt) != 0 && (len(it.pending) == 0 ||
+			it.corrupt[0] < it.pending[0].MsgNum) {
+			msgNum := it.corrupt[0]
+			it.corrupt = it.corrupt[1:]
+			return contract.Record{}, false, fmt.Errorf(
+				"%w: skipped message %d in topic: %v", ErrCorruptRecords,
+				msgNum, it.topic)
+		}
+		if len(it.pending) != 0 {
+			storedMsg := it.pending[0]
+			it.pending = it.pending[1:]
+			record := contract.Record{Number: int(storedMsg.MsgNum),
+				Time: storedMsg.Created, Key: storedMsg.Key,
+				Headers: storedMsg.Headers, Message: storedMsg.Message}
+			return record, true, nil
+		}
+		if len(it.fileNames) == 0 {
+			return contract.Record{}, false, nil
+		}
+		it.err = it.readNextFile()
+	}
+}
+
+// readNextFile reads what Next is to provide from the next of the files in
+// the snapshot, unless the store has been closed.
+func (it *Iterator) readNextFile() error {
+	s := it.store
+	s.mutex.RLock()
+	closed, rootDir := s.closed, s.RootDir
+	s.mutex.RUnlock()
+	if closed {
+		return ErrStoreClosed
+	}
+
+	fileName := it.fileNames[0]
+	it.fileNames = it.fileNames[1:]
+	readAction := actions.ReadFileAction{
+		Topic:         it.topic,
+		FileName:      fileName,
+		FileMeta:      it.fileMetas[fileName],
+		ReadFrom:      it.readFrom,
+		RootDir:       rootDir,
+		Serializer:    s.serializer,
+		EncryptionKey: s.encryptionKey,
+		Namer:         s.namer}
+	found, corrupt, complete, err := readAction.ReadFile()
+	if err != nil {
+		return fmt.Errorf("readAction.ReadFile(): %w", err)
+	}
+	delete(it.fileMetas, fileName)
+	// The messages that follow are no longer all there to be read.
+	if complete == false {
+		it.fileNames = nil
+	}
+	it.pending, it.corrupt = found, corrupt
+	return nil
+}
@@ -0,0 +1,95 @@
+package filestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// WithJanitorErrorHandler sets the function to which the janitor (see
+// StartJanitor) reports the errors it encounters. The janitor carries on
+// regardless. The default logs them with the standard logger.
+func WithJanitorErrorHandler(handler func(error)) Option {
+	return func(s *FileStore) {
+		s.janitorErrorHandler = handler
+	}
+}
+
+// StartJanitor launches a goroutine that enforces a maximum age on the
+// messages in all topics, by calling RemoveOldMessages every interval. It
+// runs until StopJanitor or Close is called. Errors are reported to the
+// handler set by WithJanitorErrorHandler. Only one janitor can run at a
+// time.
+func (s *FileStore) StartJanitor(maxAge time.Duration,
+	interval time.Duration) error {
+	if maxAge <= 0 || interval <= 0 {
+		return fmt.Errorf(
+			"janitor max age (%v) and interval (%v) must be positive",
+			maxAge, interval)
+	}
+	s.janitorMutex.Lock()
+	defer s.janitorMutex.Unlock()
+	if s.janitorStop != nil {
+		return fmt.Errorf("janitor is already running")
+	}
+	s.mutex.RLock()
+	closed := s.closed
+	s.mutex.RUnlock()
+	if closed {
+		return ErrStoreClosed
+	}
+	s.janitorStop = make(chan struct{})
+	s.janitorDone = make(chan struct{})
+	go s.runJanitor(maxAge, interval, s.janitorStop, s.janitorDone)
+	return nil
+}
+
+// StopJanitor halts the janitor started by StartJanitor, waiting for any
+// removal it has in progress to finish. It does nothing when no janitor is
+// running.
+func (s *FileStore) StopJanitor() {
+	s.janitorMutex.Lock()
+	defer s.janitorMutex.Unlock()
+	if s.janitorStop == nil {
+		return
+	}
+	close(s.janitorStop)
+	<-s.janitorDone
+	s.janitorStop, s.janitorDone = nil, nil
+}
+
+// runJanitor is the janitor's goroutine. It takes the store's locks only by
+// way of RemoveOldMessages, so it holds none of them while it waits.
+func (s *FileStore) runJanitor(maxAge time.Duration, interval time.Duration,
+	stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		err := s.RemoveOldMessages(
+			context.Background(), s.clock.Now().Add(-maxAge))
+		if errors.Is(err, ErrStoreClosed) {
+			return
+		}
+		if err != nil {
+			s.reportJanitorError(fmt.Errorf("RemoveOldMessages(): %w", err))
+		}
+	}
+}
+
+// reportJanitorError passes the error to the janitor's error handler, or logs
+// it when there is none.
+func (s *FileStore) reportJanitorError(err error) {
+	if s.janitorErrorHandler == nil {
+		log.Printf("filestore janitor: %v", err)
+		return
+	}
+	s.janitorErrorHandler(err)
+}
@@ -0,0 +1,117 @@
+// Package metrics provides a Prometheus collector that reports on the health
+// of a FileStore, so that it can be scraped.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore"
+)
+
+// namespace prefixes the names of all the metrics reported.
+const namespace = "minikafka"
+
+// Collector is a prometheus.Collector that reports on a FileStore. The
+// figures are taken from the store's Stats and Counters each time it is
+// collected, so it holds no state of its own, and no figures go astray
+// should it be registered after the store has been in use for a while.
+type Collector struct {
+	store *filestore.FileStore
+
+	messagesStored    *prometheus.Desc
+	bytesStored       *prometheus.Desc
+	retainedMessages  *prometheus.Desc
+	retainedBytes     *prometheus.Desc
+	segmentFiles      *prometheus.Desc
+	operationDuration *prometheus.Desc
+	operationErrors   *prometheus.Desc
+}
+
+// NewCollector provides a Collector that reports on the given store. It must
+// still be registered, for example with prometheus.MustRegister.
+func NewCollector(store *filestore.FileStore) *Collector {
+	topic := []string{"topic"}
+	operation := []string{"operation"}
+	return &Collector{
+		store: store,
+		messagesStored: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "messages_stored_total"),
+			"How many messages have been stored, since the store was opened.",
+			topic, nil),
+		bytesStored: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "bytes_stored_total"),
+			"The total size of the messages stored, since the store was "+
+				"opened.",
+			topic, nil),
+		retainedMessages: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "retained_messages"),
+			"How many messages the store currently holds.",
+			topic, nil),
+		retainedBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "retained_bytes"),
+			"The total size of the message files the store currently holds.",
+			topic, nil),
+		segmentFiles: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "segment_files"),
+			"How many message files the store currently holds.",
+			topic, nil),
+		operationDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "operation_duration_seconds"),
+			"How long the store's operations have taken, since it was opened.",
+			operation, nil),
+		operationErrors: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "operation_errors_total"),
+			"How many of the store's operations have failed, since it was "+
+				"opened.",
+			operation, nil),
+	}
+}
+
+// Describe is defined by, and documented in the prometheus.Collector
+// interface.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.messagesStored
+	ch <- c.bytesStored
+	ch <- c.retainedMessages
+	ch <- c.retainedBytes
+	ch <- c.segmentFiles
+	ch <- c.operationDuration
+	ch <- c.operationErrors
+}
+
+// Collect is defined by, and documented in the prometheus.Collector
+// interface. When the store's Stats cannot be had (because it has been
+// closed), the figures that derive from them are reported as invalid, and
+// so fail the scrape.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	counters := c.store.Counters()
+	for topic, topicCounters := range counters.PerTopic {
+		ch <- prometheus.MustNewConstMetric(c.messagesStored,
+			prometheus.CounterValue, float64(topicCounters.MessagesStored),
+			topic)
+		ch <- prometheus.MustNewConstMetric(c.bytesStored,
+			prometheus.CounterValue, float64(topicCounters.BytesStored),
+			topic)
+	}
+	for operation, opCounters := range counters.Operations {
+		ch <- prometheus.MustNewConstSummary(c.operationDuration,
+			uint64(opCounters.Count), opCounters.Duration.Seconds(), nil,
+			operation)
+		ch <- prometheus.MustNewConstMetric(c.operationErrors,
+			prometheus.CounterValue, float64(opCounters.Errors), operation)
+	}
+
+	stats, err := c.store.Stats()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.retainedMessages, err)
+		return
+	}
+	for topic, topicStats := range stats.PerTopic {
+		ch <- prometheus.MustNewConstMetric(c.retainedMessages,
+			prometheus.GaugeValue, float64(topicStats.Messages), topic)
+		ch <- prometheus.MustNewConstMetric(c.retainedBytes,
+			prometheus.GaugeValue, float64(topicStats.Bytes), topic)
+		ch <- prometheus.MustNewConstMetric(c.segmentFiles,
+			prometheus.GaugeValue, float64(topicStats.SegmentFiles), topic)
+	}
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+)
+
+// TestCollectorReportsOnStore makes sure that, after some stores and polls,
+// the collector reports every metric family, with a series for each topic,
+// or operation, and that the figures which can be known in advance are
+// right.
+func TestCollectorReportsOnStore(t *testing.T) {
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	store, err := filestore.NewFileStore(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = store.Store(ctx, "topic_a", []byte("hello"))
+		assert.Nil(t, err)
+	}
+	_, err = store.Store(ctx, "topic_b", []byte("hi"))
+	assert.Nil(t, err)
+	_, _, err = store.Poll(ctx, "topic_a", 1)
+	assert.Nil(t, err)
+	_, _, err = store.Poll(ctx, "bad/topic", 1)
+	assert.NotNil(t, err)
+
+	collector := NewCollector(store)
+	expected := `
+# HELP minikafka_messages_stored_total How many messages have been stored, since the store was opened.
+# TYPE minikafka_messages_stored_total counter
+minikafka_messages_stored_total{topic="topic_a"} 3
+minikafka_messages_stored_total{topic="topic_b"} 1
+# HELP minikafka_bytes_stored_total The total size of the messages stored, since the store was opened.
+# TYPE minikafka_bytes_stored_total counter
+minikafka_bytes_stored_total{topic="topic_a"} 15
+minikafka_bytes_stored_total{topic="topic_b"} 2
+# HELP minikafka_retained_messages How many messages the store currently holds.
+# TYPE minikafka_retained_messages gauge
+minikafka_retained_messages{topic="topic_a"} 3
+minikafka_retained_messages{topic="topic_b"} 1
+# HELP minikafka_segment_files How many message files the store currently holds.
+# TYPE minikafka_segment_files gauge
+minikafka_segment_files{topic="topic_a"} 1
+minikafka_segment_files{topic="topic_b"} 1
+# HELP minikafka_operation_errors_total How many of the store's operations have failed, since it was opened.
+# TYPE minikafka_operation_errors_total counter
+minikafka_operation_errors_total{operation="poll"} 1
+minikafka_operation_errors_total{operation="store"} 0
+`
+	err = testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"minikafka_messages_stored_total", "minikafka_bytes_stored_total",
+		"minikafka_retained_messages", "minikafka_segment_files",
+		"minikafka_operation_errors_total")
+	assert.Nil(t, err)
+
+	// The figures that depend on timing, or on how messages are framed, can
+	// only be checked for their presence, and labels.
+	registry := prometheus.NewPedanticRegistry()
+	err = registry.Register(collector)
+	assert.Nil(t, err)
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	labels := map[string][]string{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				labels[family.GetName()] = append(
+					labels[family.GetName()], label.GetValue())
+			}
+		}
+	}
+	assert.Equal(t, []string{"poll", "store"},
+		labels["minikafka_operation_duration_seconds"])
+	assert.Equal(t, []string{"topic_a", "topic_b"},
+		labels["minikafka_retained_bytes"])
+	count := testutil.CollectAndCount(collector,
+		"minikafka_operation_duration_seconds")
+	assert.Equal(t, 2, count)
+
+	// A closed store fails the scrape, rather than reporting it as empty.
+	err = store.Close()
+	assert.Nil(t, err)
+	_, err = registry.Gather()
+	assert.NotNil(t, err)
+}
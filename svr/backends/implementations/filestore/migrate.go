@@ -0,0 +1,280 @@
+package filestore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/actions"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/offsets"
+)
+
+// migrateBatchSize is how many messages at a time Migrate reads back from
+// the new root directory, when making sure that they decode.
+const migrateBatchSize = 1000
+
+// Migrate relocates the store to a new root directory - the message files,
+// the index, and the committed offsets - and carries on using it from there.
+// The store's locks are held throughout, and the index is persisted first,
+// so that nothing is caught part way through being written. The root
+// directory is renamed, when the new one is on the same filesystem, and
+// copied otherwise. Either way, every message is read back from the new
+// root directory, to make sure that it decodes, before the store switches
+// over to it. Should anything fail until then, the new root directory is
+// left as it was found, and the original is left intact and in use. Once
+// the store has switched over, a copied original is removed. (Should that
+// fail, the error says so, but the store uses the new root directory
+// regardless.) The new root directory must be empty, or not yet exist, and
+// must not be inside the existing one. It returns ErrRootDirIsFile if its
+// path is occupied by a file.
+func (s *FileStore) Migrate(newRoot string) error {
+	s.maintenanceMutex.Lock()
+	defer s.maintenanceMutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	existed, err := s.checkMigrationTarget(newRoot)
+	if err != nil {
+		return err
+	}
+	err = s.flush()
+	if err != nil {
+		return fmt.Errorf("flush(): %v", err)
+	}
+	// Nothing may be held open, or mapped, at the old paths.
+	err = s.closeFiles()
+	if err != nil {
+		return fmt.Errorf("closeFiles(): %v", err)
+	}
+
+	oldRoot := s.RootDir
+	renamed := s.rename(oldRoot, newRoot) == nil
+	if renamed == false {
+		copyErr := s.copyDirectory(oldRoot, newRoot, existed)
+		if copyErr != nil {
+			return s.abandonMigration(newRoot, existed,
+				fmt.Errorf("copyDirectory(): %v", copyErr))
+		}
+	}
+	verifyErr := s.verifyMigration(newRoot)
+	if verifyErr != nil {
+		verifyErr = fmt.Errorf("verifyMigration(): %v", verifyErr)
+		if renamed {
+			err = s.rename(newRoot, oldRoot)
+			if err != nil {
+				return fmt.Errorf("rename(): %v (after: %v)", err,
+					verifyErr)
+			}
+			if existed {
+				// Leave behind the empty directory that was found.
+				err = os.Mkdir(newRoot, s.dirPerm)
+				if err != nil {
+					return fmt.Errorf("os.Mkdir(): %v (after: %v)", err,
+						verifyErr)
+				}
+			}
+			return verifyErr
+		}
+		return s.abandonMigration(newRoot, existed, verifyErr)
+	}
+
+	s.RootDir = newRoot
+	if renamed == false {
+		err = os.RemoveAll(oldRoot)
+		if err != nil {
+			return fmt.Errorf(
+				"the store has moved to %s, but os.RemoveAll(): %v",
+				newRoot, err)
+		}
+	}
+	return nil
+}
+
+// checkMigrationTarget makes sure that the given directory is one which
+// Migrate can move the store to, and says whether it exists already.
+func (s *FileStore) checkMigrationTarget(newRoot string) (
+	existed bool, err error) {
+	oldAbs, err := filepath.Abs(s.RootDir)
+	if err != nil {
+		return false, fmt.Errorf("filepath.Abs(): %v", err)
+	}
+	newAbs, err := filepath.Abs(newRoot)
+	if err != nil {
+		return false, fmt.Errorf("filepath.Abs(): %v", err)
+	}
+	rel, err := filepath.Rel(oldAbs, newAbs)
+	if err == nil && rel != ".." &&
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) == false {
+		return false, fmt.Errorf(
+			"cannot migrate the store to inside its own root directory: %s",
+			newRoot)
+	}
+	info, err := os.Stat(newRoot)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("os.Stat(): %v", err)
+	}
+	if info.IsDir() == false {
+		return false, fmt.Errorf("%w: %s", ErrRootDirIsFile, newRoot)
+	}
+	n, err := ioutils.CountEntitiesInDir(newRoot)
+	if err != nil {
+		return false, fmt.Errorf("ioutils.CountEntitiesInDir(): %v", err)
+	}
+	if n != 0 {
+		return false, fmt.Errorf(
+			"cannot migrate the store to a directory that is not empty: %s",
+			newRoot)
+	}
+	return true, nil
+}
+
+// copyDirectory copies the directories and files in the given directory to
+// the other, creating it unless it exists already, and commits the files to
+// stable storage. They keep their permissions.
+func (s *FileStore) copyDirectory(fromDir string, toDir string,
+	existed bool) error {
+	if existed == false {
+		err := os.Mkdir(toDir, s.dirPerm)
+		if err != nil {
+			return fmt.Errorf("os.Mkdir(): %v", err)
+		}
+	}
+	return filepath.Walk(fromDir,
+		func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if filePath == fromDir {
+				return nil
+			}
+			name, err := filepath.Rel(fromDir, filePath)
+			if err != nil {
+				return fmt.Errorf("filepath.Rel(): %v", err)
+			}
+			targetPath := filepath.Join(toDir, name)
+			if info.IsDir() {
+				err = os.Mkdir(targetPath, info.Mode().Perm())
+				if err != nil {
+					return fmt.Errorf("os.Mkdir(): %v", err)
+				}
+				return nil
+			}
+			err = copyFile(filePath, targetPath, info.Mode().Perm())
+			if err != nil {
+				return fmt.Errorf("copyFile(): %v", err)
+			}
+			return nil
+		})
+}
+
+// copyFile copies the file to the given path, where there must be no file
+// yet, and commits the copy to stable storage.
+func copyFile(fromPath string, toPath string, perm os.FileMode) error {
+	from, err := os.Open(fromPath)
+	if err != nil {
+		return fmt.Errorf("os.Open(): %v", err)
+	}
+	defer from.Close()
+	to, err := os.OpenFile(toPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile(): %v", err)
+	}
+	_, err = io.Copy(to, from)
+	if err == nil {
+		err = to.Sync()
+	}
+	closeErr := to.Close()
+	if err != nil {
+		return fmt.Errorf("io.Copy(): %v", err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("to.Close(): %v", closeErr)
+	}
+	return nil
+}
+
+// verifyMigration makes sure that the store's index, and committed offsets,
+// can be read from the given root directory, and that every message the
+// index has registered can be read from there, without any being found to
+// be corrupt.
+func (s *FileStore) verifyMigration(rootDir string) error {
+	index := indexing.NewIndex()
+	err := index.PopulateFromDisk(s.namer.IndexFile(rootDir))
+	if err != nil {
+		return fmt.Errorf("index.PopulateFromDisk(): %v", err)
+	}
+	committed := offsets.NewOffsets()
+	err = committed.PopulateFromDisk(s.namer.OffsetsFile(rootDir))
+	if err != nil && errors.Is(err, os.ErrNotExist) == false {
+		return fmt.Errorf("committed.PopulateFromDisk(): %v", err)
+	}
+	for _, topic := range s.index.Topics() {
+		want := s.index.MessageFileLists[topic].NumMessages()
+		got, err := s.countReadableMessages(index, topic, rootDir)
+		if err != nil {
+			return fmt.Errorf("countReadableMessages(): %v", err)
+		}
+		if got != want {
+			return fmt.Errorf("topic %s has %d messages, not %d",
+				topic, got, want)
+		}
+	}
+	return nil
+}
+
+// countReadableMessages polls the whole of the topic, according to the given
+// index, from the given root directory, and provides how many messages it
+// found. Corrupt records fail the poll.
+func (s *FileStore) countReadableMessages(index *indexing.Index,
+	topic string, rootDir string) (int, error) {
+	count := 0
+	readFrom := 0
+	for {
+		pollAction := actions.PollAction{
+			Topic:         topic,
+			ReadFrom:      readFrom,
+			Index:         index,
+			RootDir:       rootDir,
+			MaxMessages:   migrateBatchSize,
+			Serializer:    s.serializer,
+			EncryptionKey: s.encryptionKey,
+			Namer:         s.namer}
+		found, newReadFrom, err := pollAction.Poll()
+		if err != nil {
+			return -1, fmt.Errorf("pollAction.Poll(): %v", err)
+		}
+		count += len(found)
+		if len(found) == 0 || newReadFrom <= readFrom {
+			return count, nil
+		}
+		readFrom = newReadFrom
+	}
+}
+
+// abandonMigration leaves the given new root directory as Migrate found it,
+// having copied the store there, and provides the error that made it give
+// up.
+func (s *FileStore) abandonMigration(newRoot string, existed bool,
+	migrateErr error) error {
+	var err error
+	if existed {
+		err = ioutils.DeleteDirectoryContents(newRoot)
+	} else {
+		err = os.RemoveAll(newRoot)
+	}
+	if err != nil {
+		return fmt.Errorf("removing the copy: %v (after: %v)", err,
+			migrateErr)
+	}
+	return migrateErr
+}
@@ -0,0 +1,80 @@
+// Package offsets is centred around its Offsets type, which keeps track of
+// the read position that each consumer group has committed for each topic.
+// It provides methods to save this to disk, and to retrieve it again, as a
+// gob-serialized file.
+package offsets
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+)
+
+// The types' fields are exported so they can be automatically gob-encoded
+// without bothering with structure tags.
+
+// Offsets holds the committed offsets, organised by consumer group, and then
+// by topic.
+type Offsets struct {
+	Committed map[string]map[string]int
+}
+
+// NewOffsets creates and initializes an Offsets.
+func NewOffsets() *Offsets {
+	return &Offsets{map[string]map[string]int{}}
+}
+
+// Commit records the given offset for the group and topic, replacing any
+// that was recorded previously.
+func (o *Offsets) Commit(group string, topic string, offset int) {
+	_, ok := o.Committed[group]
+	if ok == false {
+		o.Committed[group] = map[string]int{}
+	}
+	o.Committed[group][topic] = offset
+}
+
+// Fetch provides the offset committed for the group and topic. The boolean
+// returned is false when none has been committed.
+func (o *Offsets) Fetch(group string, topic string) (int, bool) {
+	offset, ok := o.Committed[group][topic]
+	return offset, ok
+}
+
+// ForgetTopic removes the offsets of every group for the given topic. It
+// copes silently with the topic being unknown.
+func (o *Offsets) ForgetTopic(topic string) {
+	for _, byTopic := range o.Committed {
+		delete(byTopic, topic)
+	}
+}
+
+// Save serializes the offsets and saves them as a binary file, with the
+// given permissions (before the umask) should it be created.
+func (o *Offsets) Save(filepath string, perm os.FileMode) error {
+	file, err := os.OpenFile(filepath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile(): %v", err)
+	}
+	defer file.Close()
+	err = gob.NewEncoder(file).Encode(o)
+	if err != nil {
+		return fmt.Errorf("encoder.Encode(): %v", err)
+	}
+	return nil
+}
+
+// PopulateFromDisk reads the file created by the Save sister method, and
+// deserializes it to populate this Offsets object.
+func (o *Offsets) PopulateFromDisk(filepath string) error {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("os.Open(): %w", err)
+	}
+	defer file.Close()
+	err = gob.NewDecoder(file).Decode(o)
+	if err != nil {
+		return fmt.Errorf("decoder.Decode(): %v", err)
+	}
+	return nil
+}
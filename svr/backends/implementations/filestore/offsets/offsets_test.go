@@ -0,0 +1,75 @@
+package offsets
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+)
+
+func TestCommitAndFetch(t *testing.T) {
+	offsets := NewOffsets()
+	offsets.Commit("groupA", "topicA", 3)
+	offsets.Commit("groupA", "topicA", 5)
+	offsets.Commit("groupB", "topicA", 7)
+
+	offset, ok := offsets.Fetch("groupA", "topicA")
+	assert.True(t, ok)
+	assert.Equal(t, 5, offset)
+	offset, ok = offsets.Fetch("groupB", "topicA")
+	assert.True(t, ok)
+	assert.Equal(t, 7, offset)
+
+	// Unknown group, and unknown topic.
+	_, ok = offsets.Fetch("nosuchgroup", "topicA")
+	assert.False(t, ok)
+	_, ok = offsets.Fetch("groupA", "nosuchtopic")
+	assert.False(t, ok)
+}
+
+func TestForgetTopic(t *testing.T) {
+	offsets := NewOffsets()
+	offsets.Commit("groupA", "topicA", 3)
+	offsets.Commit("groupA", "topicB", 4)
+	offsets.Commit("groupB", "topicA", 5)
+
+	offsets.ForgetTopic("topicA")
+	_, ok := offsets.Fetch("groupA", "topicA")
+	assert.False(t, ok)
+	_, ok = offsets.Fetch("groupB", "topicA")
+	assert.False(t, ok)
+	offset, ok := offsets.Fetch("groupA", "topicB")
+	assert.True(t, ok)
+	assert.Equal(t, 4, offset)
+
+	// Unknown topic.
+	offsets.ForgetTopic("nosuchtopic")
+}
+
+func TestSaveThenPopulateFromDisk(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	filepath := path.Join(rootDir, "offsets")
+	offsets := NewOffsets()
+	offsets.Commit("groupA", "topicA", 3)
+	err := offsets.Save(filepath, 0644)
+	if err != nil {
+		msg := fmt.Sprintf("offsets.Save(): %v", err)
+		assert.FailNow(t, msg)
+	}
+
+	restored := NewOffsets()
+	err = restored.PopulateFromDisk(filepath)
+	if err != nil {
+		msg := fmt.Sprintf("restored.PopulateFromDisk(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	offset, ok := restored.Fetch("groupA", "topicA")
+	assert.True(t, ok)
+	assert.Equal(t, 3, offset)
+}
@@ -0,0 +1,135 @@
+package filestore
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+
+	minikafka "github.com/peterhoward42/minikafka"
+)
+
+// pollToken is what a continuation token encodes: the topic, the number of
+// the next message to read from it, and a checksum of both, which guards
+// against a token that has been altered, or truncated, being taken to mean a
+// different read position.
+type pollToken struct {
+	Topic    string `json:"t"`
+	Next     int    `json:"n"`
+	Checksum uint32 `json:"c"`
+}
+
+// PollWithToken is like PollN, except that in place of the advised new
+// read-from message number, it provides an opaque continuation token, from
+// which PollToken can carry on. The token is safe to put in a URL.
+func (s *FileStore) PollWithToken(ctx context.Context, topic string,
+	readFrom int, maxMessages int) (
+	foundMessages []minikafka.Message, token string, err error) {
+	foundMessages, newReadFrom, err := s.PollN(ctx, topic, readFrom,
+		maxMessages)
+	if err != nil && errors.Is(err, ErrCorruptRecords) == false {
+		return nil, "", err
+	}
+	return foundMessages, encodePollToken(topic, newReadFrom), err
+}
+
+// PollToken carries on polling from where the continuation token says, which
+// was provided by PollWithToken, or by PollToken itself. It provides at most
+// maxMessages messages (with zero meaning no limit), and the token to carry
+// on from next. Unlike a bare read-from number, a token survives messages
+// being removed by the retention methods between polls: should the token
+// point before the oldest message still retained, polling resumes from that
+// message instead, and gapSkipped says that the messages in between were
+// missed. The same goes when the token points beyond the next message to be
+// stored - as it can once the topic has been deleted, and stored to again.
+// But a topic that has started afresh like that is not otherwise told apart
+// from the one the token was provided for: once its numbering has caught up
+// with the token, polling carries on in it as though it were the same. A
+// token that is not one the store provided is refused with an error that
+// wraps ErrInvalidToken. Otherwise errors, including those for corrupt
+// records, are as for PollN.
+func (s *FileStore) PollToken(ctx context.Context, token string,
+	maxMessages int) (foundMessages []minikafka.Message, nextToken string,
+	gapSkipped bool, err error) {
+	decoded, err := decodePollToken(token)
+	if err != nil {
+		return nil, "", false, err
+	}
+	topic := decoded.Topic
+	defer s.deliverDeadLetters()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, "", false, ErrStoreClosed
+	}
+
+	// Where to resume is worked out from the same index that is polled, so
+	// that a removal cannot come between them.
+	index := s.index
+	readFrom := decoded.Next
+	next := int(index.NextMessageNumbers[topic])
+	oldest := next
+	msgFileList, ok := index.MessageFileLists[topic]
+	if ok {
+		first, _ := msgFileList.Bounds()
+		if first != -1 {
+			oldest = first
+		}
+	}
+	if ok && (readFrom < oldest || readFrom > next) {
+		readFrom = oldest
+		gapSkipped = true
+	}
+
+	foundMessages, newReadFrom, err := s.poll(
+		ctx, index, topic, readFrom, maxMessages)
+	if errors.Is(err, ErrCorruptRecords) {
+		return foundMessages, encodePollToken(topic, newReadFrom), gapSkipped,
+			err
+	}
+	if err != nil {
+		return nil, "", false, fmt.Errorf("poll(): %w", err)
+	}
+	return foundMessages, encodePollToken(topic, newReadFrom), gapSkipped, nil
+}
+
+// encodePollToken provides the continuation token for the given topic and
+// read-from message number.
+func encodePollToken(topic string, next int) string {
+	// Message numbers start at 1.
+	if next < 1 {
+		next = 1
+	}
+	encoded, _ := json.Marshal(pollToken{Topic: topic, Next: next,
+		Checksum: pollTokenChecksum(topic, next)})
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// decodePollToken is the inverse of encodePollToken. It returns an error that
+// wraps ErrInvalidToken when the token is not one that encodePollToken could
+// have provided.
+func decodePollToken(token string) (pollToken, error) {
+	encoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return pollToken{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	var decoded pollToken
+	err = json.Unmarshal(encoded, &decoded)
+	if err != nil {
+		return pollToken{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if decoded.Checksum != pollTokenChecksum(decoded.Topic, decoded.Next) ||
+		decoded.Next < 1 {
+		return pollToken{}, fmt.Errorf("%w: %q", ErrInvalidToken, token)
+	}
+	return decoded, nil
+}
+
+// pollTokenChecksum provides the checksum of a continuation token's fields.
+func pollTokenChecksum(topic string, next int) uint32 {
+	return crc32.ChecksumIEEE([]byte(topic + "\x00" + strconv.Itoa(next)))
+}
@@ -0,0 +1,154 @@
+package filestore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WithRateLimit limits how fast messages can be stored, to protect a shared
+// store from a runaway producer. The limits are a number of messages, and a
+// number of bytes (of the messages themselves), per second - either of which
+// can be zero, to mean no limit. Each is enforced by a token bucket that
+// holds up to a second's worth, so short bursts are allowed. A batch is
+// admitted, or not, as a whole, according to how many messages and bytes it
+// holds. (A batch bigger than a second's worth is admitted when the bucket
+// is full, and what it overdraws must be repaid before anything else is
+// admitted.) What happens to a store that would exceed the limit is set by
+// WithRateLimitBlocking. The limits apply to Store, StoreBatch, StoreKeyed,
+// StoreWithHeaders and StoreRecords. The default is no limit.
+func WithRateLimit(messagesPerSecond float64,
+	bytesPerSecond float64) Option {
+	return func(s *FileStore) {
+		s.messageRate = newTokenBucket(messagesPerSecond)
+		s.byteRate = newTokenBucket(bytesPerSecond)
+	}
+}
+
+// WithRateLimitBlocking sets whether a store that would exceed the limit set
+// by WithRateLimit waits until it can be admitted (or its context is done),
+// rather than being refused with an error that wraps ErrRateLimited. The
+// default is to refuse it.
+func WithRateLimitBlocking(enabled bool) Option {
+	return func(s *FileStore) {
+		s.rateLimitBlocking = enabled
+	}
+}
+
+// tokenBucket is a token bucket, that is refilled at a constant rate, up to
+// a second's worth of tokens. A nil tokenBucket never runs out.
+type tokenBucket struct {
+	rate     float64 // Tokens per second.
+	capacity float64
+	tokens   float64
+	// When the tokens were last topped up. Zero means the bucket has not
+	// been used yet, and so is full.
+	updated time.Time
+}
+
+// newTokenBucket provides a tokenBucket that is refilled at the given rate
+// (per second), or nil when the rate is zero.
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate == 0 {
+		return nil
+	}
+	// A rate below one a second must still be able to admit one.
+	capacity := rate
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &tokenBucket{rate: rate, capacity: capacity}
+}
+
+// wait provides how long it will be, from now, until the bucket can give
+// the given number of tokens. Should that be more than the bucket can hold,
+// it is until the bucket is full.
+func (b *tokenBucket) wait(now time.Time, n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill(now)
+	if n > b.capacity {
+		n = b.capacity
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	seconds := (n - b.tokens) / b.rate
+	// Round up, so that the wait is long enough.
+	return time.Duration(seconds*float64(time.Second)) + 1
+}
+
+// take removes the given number of tokens from the bucket, which may leave
+// it overdrawn.
+func (b *tokenBucket) take(n float64) {
+	if b == nil {
+		return
+	}
+	b.tokens -= n
+}
+
+// refill tops up the tokens for the time elapsed since they were last.
+func (b *tokenBucket) refill(now time.Time) {
+	if b.updated.IsZero() {
+		b.tokens = b.capacity
+	} else if now.After(b.updated) {
+		b.tokens += now.Sub(b.updated).Seconds() * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+	}
+	b.updated = now
+}
+
+// admit applies the limit set by WithRateLimit to the given batch, either
+// refusing it, or waiting until it can be admitted, according to
+// WithRateLimitBlocking.
+func (s *FileStore) admit(ctx context.Context, batch []pendingMessage) error {
+	if s.messageRate == nil && s.byteRate == nil {
+		return nil
+	}
+	nBytes := 0
+	for _, pending := range batch {
+		nBytes += len(pending.Message)
+	}
+	nMessages := float64(len(batch))
+	for {
+		s.rateMutex.Lock()
+		now := s.clock.Now()
+		wait := s.messageRate.wait(now, nMessages)
+		byteWait := s.byteRate.wait(now, float64(nBytes))
+		if byteWait > wait {
+			wait = byteWait
+		}
+		if wait == 0 {
+			s.messageRate.take(nMessages)
+			s.byteRate.take(float64(nBytes))
+		}
+		s.rateMutex.Unlock()
+		if wait == 0 {
+			return nil
+		}
+		if s.rateLimitBlocking == false {
+			return fmt.Errorf("%w: %d message(s) of %d bytes must wait %v",
+				ErrRateLimited, len(batch), nBytes, wait)
+		}
+		err := s.sleep(ctx, wait)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sleepContext waits for the given duration, or until the context is done,
+// in which case it returns ctx.Err().
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,205 @@
+package filestore
+
+import (
+	"time"
+
+	minikafka "github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/indexing"
+)
+
+// WithRecordCache sets how many of each topic's most recent messages the
+// store keeps in memory, already decoded, as they are stored - so that Poll,
+// and its variants, and GetMessage can provide them without reading the
+// message files. That pays off when consumers repeatedly re-read the tail of
+// a topic, as dashboards do. The oldest message is evicted from a topic's
+// cache to make room for each new one, once it is full, and the messages
+// removed by the retention methods, or by Compact, are removed from it too.
+// The cache starts out empty when the store is opened, and is emptied when
+// the index is rebuilt. Whether polls are served from it is tallied by
+// Counters. The default of zero means no cache.
+func WithRecordCache(maxPerTopic int) Option {
+	return func(s *FileStore) {
+		s.recordCacheSize = maxPerTopic
+	}
+}
+
+// recordCache holds the most recent records stored to a topic.
+type recordCache struct {
+	records []contract.Record // Oldest first.
+	// Every message the topic retains from this number onwards is in
+	// records. (Those before it may have been evicted.)
+	coveredFrom int
+}
+
+// cacheRecord adds a message that has just been registered in the index to
+// its topic's cache, evicting the oldest if the cache is full. The caller
+// must hold the store's mutex for writing.
+func (s *FileStore) cacheRecord(topic string, messageNumber int,
+	created time.Time, pending pendingMessage) {
+	if s.recordCacheSize <= 0 {
+		return
+	}
+	cache, ok := s.recordCaches[topic]
+	if ok == false {
+		cache = &recordCache{coveredFrom: messageNumber}
+		s.recordCaches[topic] = cache
+	}
+	// The record is made to match what decoding it from the message file
+	// would give, and copied, so that the caller can reuse what it gave.
+	record := copyRecord(contract.Record{Number: messageNumber,
+		Time: created.Round(0), Key: pending.Key, Headers: pending.Headers,
+		Message: pending.Message})
+	if len(record.Key) == 0 {
+		record.Key = nil
+	}
+	if record.Message == nil {
+		record.Message = minikafka.Message{}
+	}
+	if len(record.Headers) == 0 {
+		record.Headers = nil
+	}
+	cache.records = append(cache.records, record)
+	if len(cache.records) > s.recordCacheSize {
+		cache.coveredFrom = cache.records[0].Number + 1
+		cache.records[0] = contract.Record{}
+		cache.records = cache.records[1:]
+	}
+}
+
+// cachedRecords provides the records the topic holds from readFrom onwards
+// (at most maxMessages of them, unless that is zero), along with the advised
+// new read-from number, as polling the message files would - provided the
+// topic's cache holds all of them. Otherwise ok is false. The caller must
+// hold the store's mutex, and the index must be the store's own.
+func (s *FileStore) cachedRecords(index *indexing.Index, topic string,
+	readFrom int, maxMessages int) (
+	found []contract.Record, newReadFrom int, ok bool) {
+	if s.recordCacheSize <= 0 || index != s.index {
+		return nil, -1, false
+	}
+	cache := s.recordCaches[topic]
+	// A cache that holds nothing from readFrom onwards is a miss, since
+	// polling the message files then finds nothing without reading them.
+	if cache == nil || readFrom < cache.coveredFrom ||
+		len(cache.records) == 0 ||
+		cache.records[len(cache.records)-1].Number < readFrom {
+		s.countRecordCache(false)
+		return nil, -1, false
+	}
+	s.countRecordCache(true)
+	newReadFrom = int(index.NextMessageNumbers[topic])
+	found = []contract.Record{}
+	for _, record := range cache.records {
+		if record.Number < readFrom {
+			continue
+		}
+		if maxMessages > 0 && len(found) == maxMessages {
+			newReadFrom = found[len(found)-1].Number + 1
+			break
+		}
+		found = append(found, copyRecord(record))
+	}
+	return found, newReadFrom, true
+}
+
+// cachedRecord provides the topic's record with the given number, when it is
+// in the topic's cache. The caller must hold the store's mutex.
+func (s *FileStore) cachedRecord(topic string, messageNumber int) (
+	record contract.Record, ok bool) {
+	if s.recordCacheSize <= 0 {
+		return contract.Record{}, false
+	}
+	cache := s.recordCaches[topic]
+	if cache != nil {
+		for _, record := range cache.records {
+			if record.Number == messageNumber {
+				s.countRecordCache(true)
+				return copyRecord(record), true
+			}
+		}
+	}
+	s.countRecordCache(false)
+	return contract.Record{}, false
+}
+
+// pruneCachedRecords removes the records that are no longer retained from
+// the caches, and the caches of topics that no longer exist. It is for the
+// operations that remove messages to call once they have changed the index.
+// The caller must hold the store's mutex for writing.
+func (s *FileStore) pruneCachedRecords() {
+	for topic, cache := range s.recordCaches {
+		msgFileList, ok := s.index.MessageFileLists[topic]
+		if ok == false {
+			delete(s.recordCaches, topic)
+			continue
+		}
+		kept := cache.records[:0]
+		for _, record := range cache.records {
+			if retained(msgFileList, record.Number) {
+				kept = append(kept, record)
+			}
+		}
+		for i := len(kept); i < len(cache.records); i++ {
+			cache.records[i] = contract.Record{}
+		}
+		cache.records = kept
+	}
+}
+
+// forgetCachedRecords empties the caches of the given topics, or of every
+// topic when none are given - for when the message files may no longer hold
+// what was cached. The caller must hold the store's mutex for writing.
+func (s *FileStore) forgetCachedRecords(topics ...string) {
+	if len(topics) == 0 {
+		s.recordCaches = map[string]*recordCache{}
+	}
+	for _, topic := range topics {
+		delete(s.recordCaches, topic)
+	}
+}
+
+// countRecordCache adds a hit or a miss of the record cache to the tally.
+func (s *FileStore) countRecordCache(hit bool) {
+	s.countersMutex.Lock()
+	defer s.countersMutex.Unlock()
+	if hit {
+		s.counters.RecordCacheHits++
+	} else {
+		s.counters.RecordCacheMisses++
+	}
+}
+
+// retained evaluates whether the topic whose message file list is given
+// still holds the message with the given number.
+func retained(msgFileList *indexing.MessageFileList, messageNumber int) bool {
+	msgNum := int32(messageNumber)
+	for _, name := range msgFileList.Names {
+		fileMeta := msgFileList.Meta[name]
+		if msgNum < fileMeta.Oldest.MsgNum ||
+			msgNum > fileMeta.Newest.MsgNum {
+			continue
+		}
+		_, ok := fileMeta.SeekOffsetForMessageNumber[msgNum]
+		return ok
+	}
+	return false
+}
+
+// copyRecord provides a copy of the record that shares none of its storage.
+func copyRecord(record contract.Record) contract.Record {
+	copied := record
+	if record.Key != nil {
+		copied.Key = append([]byte{}, record.Key...)
+	}
+	if record.Message != nil {
+		copied.Message = append(minikafka.Message{}, record.Message...)
+	}
+	if record.Headers != nil {
+		copied.Headers = map[string]string{}
+		for name, value := range record.Headers {
+			copied.Headers[name] = value
+		}
+	}
+	return copied
+}
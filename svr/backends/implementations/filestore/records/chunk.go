@@ -0,0 +1,106 @@
+package records
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// A chunk record (see Version4) holds a header of the message number of the
+// record it is a chunk of, the position of the chunk among its chunks
+// (counting from zero), and how many chunks there are - each a little-endian
+// uint32 - followed by the chunk itself.
+const chunkHeaderSize = 12
+
+// MaxChunkSize is the most of a framed record that a single chunk record can
+// hold (see Chunk).
+const MaxChunkSize int64 = math.MaxInt32 - chunkHeaderSize
+
+// Chunk provides the given framed record as it should be written to a message
+// file. When it is larger than the given chunk size, it is split into
+// consecutive chunk records, each holding at most the chunk size of it, and
+// all of them the given message number. Otherwise, or when the chunk size is
+// zero, it is provided unchanged. Readers reassemble the chunks, so that to
+// them they are a single record, which starts where the first chunk does.
+func Chunk(framed []byte, msgNum int32, chunkSize int64) []byte {
+	if chunkSize <= 0 || int64(len(framed)) <= chunkSize {
+		return framed
+	}
+	if chunkSize > MaxChunkSize {
+		chunkSize = MaxChunkSize
+	}
+	count := (int64(len(framed)) + chunkSize - 1) / chunkSize
+	chunked := make([]byte, 0,
+		int64(len(framed))+count*(frameHeaderSize+chunkHeaderSize))
+	for i := int64(0); i < count; i++ {
+		end := (i + 1) * chunkSize
+		if end > int64(len(framed)) {
+			end = int64(len(framed))
+		}
+		chunk := make([]byte, chunkHeaderSize, chunkHeaderSize+end-i*chunkSize)
+		binary.LittleEndian.PutUint32(chunk[0:4], uint32(msgNum))
+		binary.LittleEndian.PutUint32(chunk[4:8], uint32(i))
+		binary.LittleEndian.PutUint32(chunk[8:12], uint32(count))
+		chunk = append(chunk, framed[i*chunkSize:end]...)
+		chunked = append(chunked, frameAs(chunk, Version4)...)
+	}
+	return chunked
+}
+
+// unchunk reassembles the framed record that was split into the chunk records
+// at the start of the given bytes, given the first of them already unframed,
+// and its frame length. It then provides what unframe does for the
+// reassembled record, except that the frame length is that of all of the
+// chunk records. When the bytes end before the last chunk does, the error
+// wraps ErrIncompleteRecord. When a chunk is corrupt, or missing, it wraps
+// ErrCorruptRecord, and the frame length is that of the chunk records read,
+// so that a reader can skip them.
+func unchunk(framed []byte, first []byte, firstLength int64) (
+	encoded []byte, version byte, frameLength int64, err error) {
+	msgNum, index, count, ok := chunkHeader(first)
+	if ok == false || index != 0 {
+		return nil, 0, firstLength, fmt.Errorf(
+			"%w: chunk record is not the first of its message",
+			ErrCorruptRecord)
+	}
+	reassembled := append([]byte{}, first[chunkHeaderSize:]...)
+	frameLength = firstLength
+	for i := uint32(1); i < count; i++ {
+		chunk, chunkVersion, chunkLength, err := unframeOne(
+			framed[frameLength:])
+		if err != nil {
+			return nil, 0, frameLength + chunkLength, err
+		}
+		n, index, c, ok := chunkHeader(chunk)
+		if chunkVersion != Version4 || ok == false || n != msgNum ||
+			index != i || c != count {
+			return nil, 0, frameLength, fmt.Errorf(
+				"%w: chunk %d of %d of message %d is missing",
+				ErrCorruptRecord, i+1, count, msgNum)
+		}
+		reassembled = append(reassembled, chunk[chunkHeaderSize:]...)
+		frameLength += chunkLength
+	}
+	encoded, version, _, err = unframeOne(reassembled)
+	if err == nil && version == Version4 {
+		err = fmt.Errorf("chunks of chunks are not allowed")
+	}
+	if err != nil {
+		return nil, 0, frameLength, fmt.Errorf(
+			"%w: record reassembled from chunks: %v", ErrCorruptRecord, err)
+	}
+	return encoded, version, frameLength, nil
+}
+
+// chunkHeader provides what the header of the given chunk record holds, or
+// says it cannot, when the record is too short to hold one.
+func chunkHeader(chunk []byte) (msgNum int32, index uint32, count uint32,
+	ok bool) {
+	if len(chunk) < chunkHeaderSize {
+		return 0, 0, 0, false
+	}
+	msgNum = int32(binary.LittleEndian.Uint32(chunk[0:4]))
+	index = binary.LittleEndian.Uint32(chunk[4:8])
+	count = binary.LittleEndian.Uint32(chunk[8:12])
+	return msgNum, index, count, true
+}
@@ -0,0 +1,94 @@
+package records
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkedRecordRoundTrips(t *testing.T) {
+	message := bytes.Repeat([]byte("0123456789"), 1000)
+	for name, serializer := range serializers {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := serializer.Encode(StoredMessage{
+				MsgNum: 7, Created: time.Now(), Message: message})
+			assert.Nil(t, err)
+			framed := Frame(encoded)
+
+			// Records no larger than the chunk size are left alone.
+			assert.Equal(t, framed, Chunk(framed, 7, int64(len(framed))))
+			assert.Equal(t, framed, Chunk(framed, 7, 0))
+
+			chunked := Chunk(framed, 7, int64(len(framed)+2)/3)
+			assert.Equal(t, len(framed)+3*(frameHeaderSize+chunkHeaderSize),
+				len(chunked))
+			_, frameLength, err := Unframe(chunked)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(len(chunked)), frameLength)
+
+			decoded, err := DecodeFramed(chunked, serializer, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, message, []byte(decoded.Message))
+
+			// Alongside an unchunked record.
+			sequence := append(append([]byte{}, chunked...), framed...)
+			found, seekOffsets, skipped, decodedLength, err := DecodeSequence(
+				sequence, serializer, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(found))
+			assert.Equal(t, message, []byte(found[0].Message))
+			assert.Equal(t, []int64{0, int64(len(chunked))}, seekOffsets)
+			assert.Equal(t, []int64{}, skipped)
+			assert.Equal(t, int64(len(sequence)), decodedLength)
+		})
+	}
+}
+
+func TestIncompleteOrCorruptChunks(t *testing.T) {
+	encoded, err := DefaultSerializer.Encode(StoredMessage{
+		MsgNum: 1, Created: time.Now(),
+		Message: bytes.Repeat([]byte("x"), 5000)})
+	assert.Nil(t, err)
+	chunked := Chunk(Frame(encoded), 1, 1000)
+	chunkLength := int64(frameHeaderSize + chunkHeaderSize + 1000)
+	following := Frame(encoded)
+
+	// When the chunks stop short of the last, as they will if an append
+	// was interrupted, that is the end of the data.
+	for _, end := range []int64{chunkLength, 3*chunkLength + 10} {
+		_, _, err = Unframe(chunked[:end])
+		assert.True(t, errors.Is(err, ErrIncompleteRecord))
+		found, _, skipped, decodedLength, err := DecodeSequence(
+			chunked[:end], DefaultSerializer, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(found))
+		assert.Equal(t, []int64{}, skipped)
+		assert.Equal(t, int64(0), decodedLength)
+	}
+
+	// A corrupt chunk spoils the record, but not the one that follows.
+	corrupted := append([]byte{}, chunked...)
+	corrupted[2*chunkLength+frameHeaderSize+chunkHeaderSize+5] ^= 0xff
+	_, err = DecodeFramed(corrupted, DefaultSerializer, nil)
+	assert.True(t, errors.Is(err, ErrCorruptRecord))
+	sequence := append(corrupted, following...)
+	found, _, skipped, decodedLength, err := DecodeSequence(
+		sequence, DefaultSerializer, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, int64(0), skipped[0])
+	assert.Equal(t, int64(len(sequence)), decodedLength)
+
+	// As does a missing one.
+	missing := append(append([]byte{}, chunked[:chunkLength]...),
+		chunked[2*chunkLength:]...)
+	_, err = DecodeFramed(missing, DefaultSerializer, nil)
+	assert.True(t, errors.Is(err, ErrCorruptRecord))
+	found, _, _, _, err = DecodeSequence(
+		append(missing, following...), DefaultSerializer, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(found))
+}
@@ -0,0 +1,84 @@
+package records
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+)
+
+// gzipMagic is how every gzip stream starts.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Compressed provides a Serializer that compresses each record encoded by the
+// given Serializer with gzip, and decompresses it again before it is decoded.
+// Each record is compressed on its own - rather than the message file as a
+// whole - so that records can still be appended to a file, and read from the
+// middle of one, without decompressing those that precede them. Compression
+// therefore pays off for large, repetitive messages, rather than for small
+// ones.
+func Compressed(serializer Serializer) Serializer {
+	return compressed{serializer}
+}
+
+type compressed struct {
+	serializer Serializer
+}
+
+func (c compressed) Encode(sm StoredMessage) ([]byte, error) {
+	encoded, err := c.serializer.Encode(sm)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err = writer.Write(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("writer.Write(): %v", err)
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, fmt.Errorf("writer.Close(): %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (c compressed) Decode(encoded []byte) (StoredMessage, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(encoded))
+	if err != nil {
+		return StoredMessage{}, fmt.Errorf("gzip.NewReader(): %v", err)
+	}
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return StoredMessage{}, fmt.Errorf("ioutil.ReadAll(): %v", err)
+	}
+	return c.serializer.Decode(decompressed)
+}
+
+// SequenceIsCompressed evaluates whether the records in the given sequence of
+// framed records - as found in a message file - were encoded by a Compressed
+// Serializer. It judges by the first record that matches its checksum, and
+// relies on the records encoded by the Serializers in this package never
+// starting as a gzip stream does. Encrypted records are judged once they are
+// decrypted with the given key, and are taken to be uncompressed when they
+// cannot be.
+func SequenceIsCompressed(sequence []byte, key *Key) bool {
+	offset := int64(0)
+	for offset < int64(len(sequence)) {
+		encoded, version, frameLength, err := unframe(sequence[offset:])
+		if err == nil && version == Version3 {
+			encoded, err = decrypt(encoded, key)
+			if err != nil {
+				return false
+			}
+		}
+		if err == nil {
+			return bytes.HasPrefix(encoded, gzipMagic)
+		}
+		if frameLength == 0 {
+			break
+		}
+		offset += frameLength
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package records
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressedRecordIsSmaller(t *testing.T) {
+	original := StoredMessage{
+		MsgNum:  1,
+		Created: time.Now(),
+		Message: []byte(strings.Repeat("all work and no play ", 500)),
+	}
+	plain, err := GobSerializer{}.Encode(original)
+	assert.Nil(t, err)
+	compressed, err := Compressed(GobSerializer{}).Encode(original)
+	assert.Nil(t, err)
+	assert.True(t, len(compressed) < len(plain)/10)
+}
+
+func TestSequenceIsCompressed(t *testing.T) {
+	for _, serializer := range []Serializer{GobSerializer{}, JSONSerializer{}} {
+		sm := StoredMessage{MsgNum: 1, Created: time.Now()}
+		plain, err := serializer.Encode(sm)
+		assert.Nil(t, err)
+		compressed, err := Compressed(serializer).Encode(sm)
+		assert.Nil(t, err)
+		assert.False(t, SequenceIsCompressed(Frame(plain), nil))
+		assert.True(t, SequenceIsCompressed(Frame(compressed), nil))
+
+		// The judgement should skip a corrupt first record.
+		corrupted := Frame(plain)
+		corrupted[frameHeaderSize] ^= 0xff
+		assert.True(t, SequenceIsCompressed(
+			append(corrupted, Frame(compressed)...), nil))
+	}
+	assert.False(t, SequenceIsCompressed([]byte{}, nil))
+}
@@ -0,0 +1,91 @@
+package records
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrDecryptionFailed means an encrypted record cannot be decrypted, because
+// it was encrypted with a different key from the one given, or no key was
+// given. It is distinct from ErrCorruptRecord, since the record itself is
+// intact (it matches its checksum), and must not be discarded.
+var ErrDecryptionFailed = errors.New("record cannot be decrypted")
+
+// An encrypted record (see Version3) is the id of the key that encrypted it,
+// followed by the nonce it was encrypted with, and then the encoded record
+// sealed with AES-GCM. Each record has a nonce of its own, drawn at random.
+const (
+	keyIDSize = 1
+	nonceSize = 12
+)
+
+// Key is a key with which to encrypt records, and decrypt them again. Its id
+// is written with each record it encrypts, so that a store can tell which
+// key a record needs - for when keys come to be rotated.
+type Key struct {
+	id   byte
+	aead cipher.AEAD
+}
+
+// NewKey provides a Key with the given id, which encrypts with AES-GCM using
+// the given secret. The secret must be 16, 24, or 32 bytes long, to select
+// AES-128, AES-192, or AES-256.
+func NewKey(id byte, secret []byte) (*Key, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewCipher(): %v", err)
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, nonceSize)
+	if err != nil {
+		return nil, fmt.Errorf("cipher.NewGCMWithNonceSize(): %v", err)
+	}
+	return &Key{id: id, aead: aead}, nil
+}
+
+// ID provides the key's id.
+func (key *Key) ID() byte {
+	return key.id
+}
+
+// seal provides the encrypted form of the given encoded record.
+func (key *Key) seal(encoded []byte) ([]byte, error) {
+	sealed := make([]byte, keyIDSize+nonceSize,
+		keyIDSize+nonceSize+len(encoded)+key.aead.Overhead())
+	sealed[0] = key.id
+	nonce := sealed[keyIDSize:]
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("rand.Read(): %v", err)
+	}
+	return key.aead.Seal(sealed, nonce, encoded, key.additionalData()), nil
+}
+
+// open provides the encoded record from the encrypted form of it made by
+// seal. The error it returns wraps ErrDecryptionFailed.
+func (key *Key) open(sealed []byte) ([]byte, error) {
+	if len(sealed) < keyIDSize+nonceSize {
+		return nil, fmt.Errorf("%w: encrypted record is truncated",
+			ErrDecryptionFailed)
+	}
+	if sealed[0] != key.id {
+		return nil, fmt.Errorf("%w: encrypted with key %d, not key %d",
+			ErrDecryptionFailed, sealed[0], key.id)
+	}
+	nonce := sealed[keyIDSize : keyIDSize+nonceSize]
+	encoded, err := key.aead.Open(nil, nonce, sealed[keyIDSize+nonceSize:],
+		key.additionalData())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+	return encoded, nil
+}
+
+// additionalData is what is authenticated along with each record the key
+// encrypts, besides the record itself - so that neither the version of its
+// frame, nor the key id, can be altered unnoticed.
+func (key *Key) additionalData() []byte {
+	return []byte{Version3, key.id}
+}
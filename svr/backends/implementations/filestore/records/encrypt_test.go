@@ -0,0 +1,64 @@
+package records
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedRecordRoundTrips(t *testing.T) {
+	key, err := NewKey(1, bytes.Repeat([]byte{7}, 32))
+	assert.Nil(t, err)
+	sm := StoredMessage{MsgNum: 1, Created: time.Now(),
+		Message: []byte("the secret message")}
+	for name, serializer := range serializers {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := serializer.Encode(sm)
+			assert.Nil(t, err)
+			framed, err := FrameEncrypted(encoded, key)
+			assert.Nil(t, err)
+			assert.False(t, bytes.Contains(framed, sm.Message))
+			assert.Equal(t, key.ID(), framed[frameHeaderSize])
+
+			decoded, err := DecodeFramed(framed, serializer, key)
+			assert.Nil(t, err)
+			assert.Equal(t, sm.Message, decoded.Message)
+			found, _, _, _, err := DecodeSequence(framed, serializer, key)
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(found))
+
+			// Each record has a nonce of its own.
+			again, err := FrameEncrypted(encoded, key)
+			assert.Nil(t, err)
+			assert.NotEqual(t, framed, again)
+		})
+	}
+}
+
+func TestWrongKeyFailsToDecrypt(t *testing.T) {
+	key, err := NewKey(1, bytes.Repeat([]byte{7}, 16))
+	assert.Nil(t, err)
+	otherSecret, err := NewKey(1, bytes.Repeat([]byte{8}, 16))
+	assert.Nil(t, err)
+	otherID, err := NewKey(2, bytes.Repeat([]byte{7}, 16))
+	assert.Nil(t, err)
+	encoded, err := DefaultSerializer.Encode(StoredMessage{
+		MsgNum: 1, Created: time.Now(), Message: []byte("message_1")})
+	assert.Nil(t, err)
+	framed, err := FrameEncrypted(encoded, key)
+	assert.Nil(t, err)
+
+	for _, wrongKey := range []*Key{otherSecret, otherID, nil} {
+		_, err = DecodeFramed(framed, DefaultSerializer, wrongKey)
+		assert.True(t, errors.Is(err, ErrDecryptionFailed))
+		assert.False(t, errors.Is(err, ErrCorruptRecord))
+		_, _, _, _, err = DecodeSequence(framed, DefaultSerializer, wrongKey)
+		assert.True(t, errors.Is(err, ErrDecryptionFailed))
+	}
+
+	_, err = NewKey(1, []byte("too short"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,245 @@
+package records
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+// Each encoded record is written to a message file inside a frame, which
+// prefixes it with a header holding its length, a CRC32 checksum, and the
+// version of the record format. The length lets a reader step from one record
+// to the next without decoding them, the checksum lets it detect a record
+// that has been corrupted, and the version tells it how the record should be
+// decoded. The length and checksum are little-endian uint32(s), and the
+// checksum covers the version as well as the record.
+//
+// Records written before the version was introduced have no version byte,
+// and are taken to be Version1. They are told apart by the top bit of the
+// length, which is set in the frames of every later version. (So a Version1
+// record of 2 GiB or more, which could only have been written before the
+// store limited the size of messages, cannot be read.)
+//
+// Encrypted records are written as Version3, and hold the id of the key
+// that encrypted them, and their nonce, along with the encrypted record (see
+// Key). The checksum covers them too, so a record that matches its checksum,
+// yet cannot be decrypted, was encrypted with some other key.
+//
+// A record too large to be written whole can be split into chunk records,
+// which are written as Version4, one after another (see Chunk). Each holds a
+// chunk of the framed record, which readers reassemble, so that to them the
+// chunks are one record of the version it was framed as.
+const (
+	legacyHeaderSize = 8
+	frameHeaderSize  = legacyHeaderSize + 1
+	versionedFlag    = 1 << 31
+)
+
+// The versions of the record format. A store can hold records of any of
+// them, but writes only CurrentVersion.
+const (
+	// Version1 is the original format, whose frames hold no version.
+	Version1 byte = 1
+	// Version2 records are encoded just as Version1 records are, but
+	// their frames hold the version.
+	Version2 byte = 2
+	// Version3 records are encrypted Version2 records. FrameEncrypted
+	// writes them.
+	Version3 byte = 3
+	// Version4 records are chunks of a record of any other version. Chunk
+	// writes them.
+	Version4 byte = 4
+	// CurrentVersion is the version Frame writes.
+	CurrentVersion = Version2
+)
+
+// MaxFramedSize is the size of the largest frame - being the header plus the
+// largest encoded record whose length its header can represent.
+const MaxFramedSize int64 = frameHeaderSize + math.MaxInt32
+
+// Errors that Unframe returns (wrapped), so that callers can distinguish
+// them using errors.Is().
+var (
+	// ErrIncompleteRecord means the bytes end before the record does.
+	ErrIncompleteRecord = errors.New("incomplete record")
+
+	// ErrCorruptRecord means the record does not match its checksum, or
+	// cannot be decoded despite matching it.
+	ErrCorruptRecord = errors.New("corrupt record")
+)
+
+// Frame provides the given encoded record wrapped in a frame, marked as
+// being of CurrentVersion.
+func Frame(encoded []byte) []byte {
+	return frameAs(encoded, CurrentVersion)
+}
+
+// FrameEncrypted is like Frame, except that it encrypts the encoded record
+// with the given key, and marks the frame as being of Version3.
+func FrameEncrypted(encoded []byte, key *Key) ([]byte, error) {
+	sealed, err := key.seal(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("key.seal(): %v", err)
+	}
+	return frameAs(sealed, Version3), nil
+}
+
+// frameAs provides the given record wrapped in a frame, marked as being of
+// the given version.
+func frameAs(encoded []byte, version byte) []byte {
+	framed := make([]byte, frameHeaderSize+len(encoded))
+	binary.LittleEndian.PutUint32(
+		framed[0:4], uint32(len(encoded))|versionedFlag)
+	framed[legacyHeaderSize] = version
+	copy(framed[frameHeaderSize:], encoded)
+	binary.LittleEndian.PutUint32(
+		framed[4:8], crc32.ChecksumIEEE(framed[legacyHeaderSize:]))
+	return framed
+}
+
+// Unframe extracts the encoded record from the frame at the start of the
+// given bytes, having verified its checksum. It also provides the length of
+// the whole frame. Any bytes beyond the frame are ignored. When the frame is
+// the first of a record's chunk records, the record is reassembled from all
+// of them, and the length is theirs.
+func Unframe(framed []byte) (encoded []byte, frameLength int64, err error) {
+	encoded, _, frameLength, err = unframe(framed)
+	return encoded, frameLength, err
+}
+
+// unframe is Unframe, but also provides the version of the record format.
+func unframe(framed []byte) (encoded []byte, version byte,
+	frameLength int64, err error) {
+	encoded, version, frameLength, err = unframeOne(framed)
+	if err != nil || version != Version4 {
+		return encoded, version, frameLength, err
+	}
+	return unchunk(framed, encoded, frameLength)
+}
+
+// unframeOne is unframe, except that it leaves a chunk record as it is, to
+// be reassembled with the others by the caller.
+func unframeOne(framed []byte) (encoded []byte, version byte,
+	frameLength int64, err error) {
+	if len(framed) < legacyHeaderSize {
+		return nil, 0, 0, fmt.Errorf("%w: frame header is truncated",
+			ErrIncompleteRecord)
+	}
+	lengthWord := binary.LittleEndian.Uint32(framed[0:4])
+	checksum := binary.LittleEndian.Uint32(framed[4:8])
+	headerSize := int64(legacyHeaderSize)
+	if lengthWord&versionedFlag != 0 {
+		headerSize = frameHeaderSize
+	}
+	frameLength = headerSize + int64(lengthWord&^versionedFlag)
+	if frameLength > int64(len(framed)) {
+		return nil, 0, 0, fmt.Errorf("%w: needs %d bytes, but has %d",
+			ErrIncompleteRecord, frameLength, len(framed))
+	}
+	// The checksum covers the version byte, when there is one.
+	if crc32.ChecksumIEEE(framed[legacyHeaderSize:frameLength]) != checksum {
+		return nil, 0, frameLength, fmt.Errorf("%w: checksum mismatch",
+			ErrCorruptRecord)
+	}
+	version = Version1
+	if headerSize == frameHeaderSize {
+		version = framed[legacyHeaderSize]
+	}
+	return framed[headerSize:frameLength], version, frameLength, nil
+}
+
+// decode reconstructs the StoredMessage from an encoded record of the given
+// version, using the given Serializer - having decrypted it with the given
+// key, when it is encrypted.
+func decode(encoded []byte, version byte, serializer Serializer,
+	key *Key) (StoredMessage, error) {
+	switch version {
+	case Version1, Version2:
+		return serializer.Decode(encoded)
+	case Version3:
+		decrypted, err := decrypt(encoded, key)
+		if err != nil {
+			return StoredMessage{}, err
+		}
+		return serializer.Decode(decrypted)
+	}
+	return StoredMessage{}, fmt.Errorf("unknown record format version: %d",
+		version)
+}
+
+// decrypt provides the encoded record from an encrypted one, using the given
+// key. The error it returns wraps ErrDecryptionFailed - including when the
+// key is nil.
+func decrypt(encrypted []byte, key *Key) ([]byte, error) {
+	if key == nil {
+		return nil, fmt.Errorf("%w: record is encrypted, and no key is set",
+			ErrDecryptionFailed)
+	}
+	return key.open(encrypted)
+}
+
+// DecodeFramed reconstructs the StoredMessage from the frame at the start of
+// the given bytes, using the given Serializer. The record can be of any of
+// the known versions, and chunked. An encrypted one is decrypted with the
+// given key, which can be nil when there is none, and should that fail, the
+// error returned wraps ErrDecryptionFailed, rather than ErrCorruptRecord.
+func DecodeFramed(framed []byte, serializer Serializer, key *Key) (
+	StoredMessage, error) {
+	encoded, version, _, err := unframe(framed)
+	if err != nil {
+		return StoredMessage{}, err
+	}
+	sm, err := decode(encoded, version, serializer, key)
+	if errors.Is(err, ErrDecryptionFailed) {
+		return StoredMessage{}, err
+	}
+	if err != nil {
+		return StoredMessage{}, fmt.Errorf("%w: %v", ErrCorruptRecord, err)
+	}
+	return sm, nil
+}
+
+// DecodeSequence reconstructs each of the StoredMessage(s) in the given
+// concatenation of framed records - as found in a message file - using the
+// given Serializer, and key (as DecodeFramed does). The records can be of a
+// mixture of versions, and chunked. It also provides the offset in the
+// sequence at which each record starts (its first chunk, when chunked).
+// Corrupt records are skipped, and their offsets provided in skipped. Should
+// the sequence end part way through a record - or its chunks - as it will if
+// an append was interrupted, that is treated as the end of the data. So the
+// decodedLength provided is then less than the length of the sequence. A
+// record that cannot be decrypted is not skipped, since it is not corrupt;
+// instead the error returned wraps ErrDecryptionFailed.
+func DecodeSequence(sequence []byte, serializer Serializer, key *Key) (
+	found []StoredMessage, seekOffsets []int64, skipped []int64,
+	decodedLength int64, err error) {
+	found = []StoredMessage{}
+	seekOffsets = []int64{}
+	skipped = []int64{}
+	offset := int64(0)
+	total := int64(len(sequence))
+	for offset < total {
+		encoded, version, frameLength, err := unframe(sequence[offset:])
+		if errors.Is(err, ErrIncompleteRecord) {
+			break
+		}
+		var sm StoredMessage
+		if err == nil {
+			sm, err = decode(encoded, version, serializer, key)
+		}
+		if errors.Is(err, ErrDecryptionFailed) {
+			return nil, nil, nil, 0, fmt.Errorf("record at offset %d: %w",
+				offset, err)
+		}
+		if err != nil {
+			skipped = append(skipped, offset)
+		} else {
+			found = append(found, sm)
+			seekOffsets = append(seekOffsets, offset)
+		}
+		offset += frameLength
+	}
+	return found, seekOffsets, skipped, offset, nil
+}
@@ -0,0 +1,131 @@
+package records
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameAndUnframe(t *testing.T) {
+	framed := Frame([]byte("some record"))
+	assert.Equal(t, frameHeaderSize+len("some record"), len(framed))
+
+	// Bytes beyond the frame should be ignored.
+	encoded, frameLength, err := Unframe(append(framed, []byte("more")...))
+	assert.Nil(t, err)
+	assert.Equal(t, "some record", string(encoded))
+	assert.Equal(t, int64(len(framed)), frameLength)
+
+	// A truncated frame.
+	_, _, err = Unframe(framed[:len(framed)-1])
+	assert.True(t, errors.Is(err, ErrIncompleteRecord))
+	_, _, err = Unframe(framed[:3])
+	assert.True(t, errors.Is(err, ErrIncompleteRecord))
+
+	// A corrupted frame should still report its length.
+	framed[frameHeaderSize] ^= 0xff
+	_, frameLength, err = Unframe(framed)
+	assert.True(t, errors.Is(err, ErrCorruptRecord))
+	assert.Equal(t, int64(len(framed)), frameLength)
+}
+
+func TestDecodeSequence(t *testing.T) {
+	for name, serializer := range serializers {
+		t.Run(name, func(t *testing.T) {
+			sequence := []byte{}
+			offsets := []int64{}
+			for i := 1; i <= 3; i++ {
+				encoded, err := serializer.Encode(StoredMessage{
+					MsgNum:  int32(i),
+					Created: time.Now(),
+					Message: []byte("some message"),
+				})
+				assert.Nil(t, err)
+				offsets = append(offsets, int64(len(sequence)))
+				sequence = append(sequence, Frame(encoded)...)
+			}
+			found, seekOffsets, skipped, decodedLength, err := DecodeSequence(
+				sequence, serializer, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, 3, len(found))
+			assert.Equal(t, int32(3), found[2].MsgNum)
+			assert.Equal(t, offsets, seekOffsets)
+			assert.Equal(t, []int64{}, skipped)
+			assert.Equal(t, int64(len(sequence)), decodedLength)
+
+			// With a truncated final record, which should be treated as the
+			// end of the data.
+			truncated := sequence[:len(sequence)-5]
+			found, seekOffsets, skipped, decodedLength, err = DecodeSequence(
+				truncated, serializer, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, []int64{}, skipped)
+			assert.Equal(t, 2, len(found))
+			assert.Equal(t, offsets[:2], seekOffsets)
+			assert.Equal(t, offsets[2], decodedLength)
+
+			// With a corrupted byte in the middle record.
+			corrupted := append([]byte{}, sequence...)
+			corrupted[offsets[1]+frameHeaderSize+2] ^= 0xff
+			found, seekOffsets, skipped, decodedLength, err = DecodeSequence(
+				corrupted, serializer, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(found))
+			assert.Equal(t, int32(1), found[0].MsgNum)
+			assert.Equal(t, int32(3), found[1].MsgNum)
+			assert.Equal(t, []int64{offsets[0], offsets[2]}, seekOffsets)
+			assert.Equal(t, []int64{offsets[1]}, skipped)
+			assert.Equal(t, int64(len(sequence)), decodedLength)
+		})
+	}
+}
+
+func TestVersionsCanBeMixed(t *testing.T) {
+	// A sequence holding a Version1 record (written by hand, as it was
+	// before frames held a version), a current one, and one of a version
+	// from the future.
+	first, err := DefaultSerializer.Encode(StoredMessage{
+		MsgNum: 1, Created: time.Now(), Message: []byte("message_1")})
+	assert.Nil(t, err)
+	second, err := DefaultSerializer.Encode(StoredMessage{
+		MsgNum: 2, Created: time.Now(), Message: []byte("message_2")})
+	assert.Nil(t, err)
+	future := Frame(second)
+	future[legacyHeaderSize] = Version4 + 1
+	binary.LittleEndian.PutUint32(
+		future[4:8], crc32.ChecksumIEEE(future[legacyHeaderSize:]))
+	sequence := legacyFrame(first)
+	sequence = append(sequence, Frame(second)...)
+	sequence = append(sequence, future...)
+
+	encoded, frameLength, err := Unframe(sequence)
+	assert.Nil(t, err)
+	assert.Equal(t, first, encoded)
+	assert.Equal(t, int64(legacyHeaderSize+len(first)), frameLength)
+
+	found, seekOffsets, skipped, decodedLength, err := DecodeSequence(
+		sequence, DefaultSerializer, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(found))
+	assert.Equal(t, "message_1", string(found[0].Message))
+	assert.Equal(t, "message_2", string(found[1].Message))
+	assert.Equal(t, []int64{0, frameLength}, seekOffsets)
+	assert.Equal(t, []int64{frameLength + int64(len(Frame(second)))}, skipped)
+	assert.Equal(t, int64(len(sequence)), decodedLength)
+
+	_, err = DecodeFramed(future, DefaultSerializer, nil)
+	assert.True(t, errors.Is(err, ErrCorruptRecord))
+}
+
+// legacyFrame provides the given encoded record wrapped in a Version1 frame.
+func legacyFrame(encoded []byte) []byte {
+	framed := make([]byte, legacyHeaderSize+len(encoded))
+	binary.LittleEndian.PutUint32(framed[0:4], uint32(len(encoded)))
+	binary.LittleEndian.PutUint32(framed[4:8], crc32.ChecksumIEEE(encoded))
+	copy(framed[legacyHeaderSize:], encoded)
+	return framed
+}
@@ -0,0 +1,42 @@
+package records
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	minikafka "github.com/peterhoward42/minikafka"
+)
+
+// GobSerializer is a Serializer that writes each record as a self-contained
+// gob encoding. It is compact, but Go-specific.
+type GobSerializer struct{}
+
+// Encode is defined by, and documented in the Serializer interface.
+func (GobSerializer) Encode(sm StoredMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := gob.NewEncoder(&buf)
+	err := encoder.Encode(sm)
+	if err != nil {
+		return nil, fmt.Errorf("encoder.Encode(): %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode is defined by, and documented in the Serializer interface.
+func (GobSerializer) Decode(encoded []byte) (StoredMessage, error) {
+	var sm StoredMessage
+	decoder := gob.NewDecoder(bytes.NewReader(encoded))
+	err := decoder.Decode(&sm)
+	if err != nil {
+		return StoredMessage{}, fmt.Errorf("decoder.Decode(): %v", err)
+	}
+	// Gob cannot distinguish an empty message from a nil one.
+	if sm.Message == nil {
+		sm.Message = minikafka.Message{}
+	}
+	if len(sm.Headers) == 0 {
+		sm.Headers = nil
+	}
+	return sm, nil
+}
@@ -0,0 +1,43 @@
+package records
+
+import (
+	"encoding/json"
+	"fmt"
+
+	minikafka "github.com/peterhoward42/minikafka"
+)
+
+// JSONSerializer is a Serializer that writes each record as a single line of
+// JSON, so that message files can be inspected by eye, or by tools in other
+// languages. (The message and key appear base64-encoded, as is usual for
+// bytes in JSON.) It is bulkier and slower than the GobSerializer.
+type JSONSerializer struct{}
+
+// Encode is defined by, and documented in the Serializer interface.
+func (JSONSerializer) Encode(sm StoredMessage) ([]byte, error) {
+	encoded, err := json.Marshal(sm)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal(): %v", err)
+	}
+	// End with a newline, so that each record appears on a line of its
+	// own when a message file is viewed.
+	return append(encoded, '\n'), nil
+}
+
+// Decode is defined by, and documented in the Serializer interface.
+func (JSONSerializer) Decode(encoded []byte) (StoredMessage, error) {
+	var sm StoredMessage
+	err := json.Unmarshal(encoded, &sm)
+	if err != nil {
+		return StoredMessage{}, fmt.Errorf("json.Unmarshal(): %v", err)
+	}
+	// Follow GobSerializer, which cannot distinguish an empty message from
+	// a nil one.
+	if sm.Message == nil {
+		sm.Message = minikafka.Message{}
+	}
+	if len(sm.Headers) == 0 {
+		sm.Headers = nil
+	}
+	return sm, nil
+}
@@ -0,0 +1,40 @@
+// Package records defines the representation in which each message is
+// written to a message storage file, and the Serializer(s) that encode and
+// decode it.
+package records
+
+import (
+	"time"
+
+	minikafka "github.com/peterhoward42/minikafka"
+)
+
+// The types' fields are exported so they can be automatically encoded
+// without bothering with structure tags. (Save for Headers, which is omitted
+// from JSON when there are none, so that the records of messages without
+// headers are encoded just as they were before headers were introduced.)
+
+// StoredMessage is what gets written to a message storage file for each
+// message. It carries the message itself, along with its message number,
+// creation time, and optional key and headers.
+type StoredMessage struct {
+	MsgNum  int32
+	Created time.Time
+	Key     []byte            // Nil when the message has no key.
+	Headers map[string]string `json:",omitempty"` // Nil when none.
+	Message minikafka.Message
+}
+
+// Serializer is a thing that can encode a StoredMessage into a
+// self-contained record, and decode it again.
+type Serializer interface {
+	// Encode encodes the StoredMessage into a self-contained byte sequence.
+	Encode(sm StoredMessage) ([]byte, error)
+	// Decode reconstructs a StoredMessage from the byte sequence produced
+	// by Encode. A nil Message is always restored as an empty one, and
+	// empty Headers as nil ones.
+	Decode(encoded []byte) (StoredMessage, error)
+}
+
+// DefaultSerializer is the Serializer used when none is specified.
+var DefaultSerializer Serializer = GobSerializer{}
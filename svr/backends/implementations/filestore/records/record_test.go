@@ -0,0 +1,118 @@
+package records
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serializers is the set of Serializer(s) the round-trip tests are run
+// against.
+var serializers = map[string]Serializer{
+	"gob":             GobSerializer{},
+	"json":            JSONSerializer{},
+	"compressed gob":  Compressed(GobSerializer{}),
+	"compressed json": Compressed(JSONSerializer{}),
+}
+
+func TestRoundTripWithKey(t *testing.T) {
+	for name, serializer := range serializers {
+		t.Run(name, func(t *testing.T) {
+			original := StoredMessage{
+				MsgNum:  42,
+				Created: time.Now(),
+				Key:     []byte("some key"),
+				Message: []byte("some message"),
+			}
+			encoded, err := serializer.Encode(original)
+			assert.Nil(t, err)
+			restored, err := serializer.Decode(encoded)
+			assert.Nil(t, err)
+			assert.Equal(t, int32(42), restored.MsgNum)
+			assert.True(t, original.Created.Equal(restored.Created))
+			assert.Equal(t, "some key", string(restored.Key))
+			assert.Equal(t, "some message", string(restored.Message))
+		})
+	}
+}
+
+func TestRoundTripWithoutKey(t *testing.T) {
+	for name, serializer := range serializers {
+		t.Run(name, func(t *testing.T) {
+			original := StoredMessage{
+				MsgNum:  1,
+				Created: time.Now(),
+				Message: []byte("some message"),
+			}
+			encoded, err := serializer.Encode(original)
+			assert.Nil(t, err)
+			restored, err := serializer.Decode(encoded)
+			assert.Nil(t, err)
+			assert.Nil(t, restored.Key)
+			assert.Equal(t, "some message", string(restored.Message))
+		})
+	}
+}
+
+func TestRoundTripWithHeaders(t *testing.T) {
+	for name, serializer := range serializers {
+		t.Run(name, func(t *testing.T) {
+			headers := map[string]string{"trace": "abc", "route": "x"}
+			original := StoredMessage{MsgNum: 1, Created: time.Now(),
+				Headers: headers, Message: []byte("some message")}
+			encoded, err := serializer.Encode(original)
+			assert.Nil(t, err)
+			restored, err := serializer.Decode(encoded)
+			assert.Nil(t, err)
+			assert.Equal(t, headers, restored.Headers)
+
+			// Empty headers are restored as nil ones.
+			original.Headers = map[string]string{}
+			encoded, err = serializer.Encode(original)
+			assert.Nil(t, err)
+			restored, err = serializer.Decode(encoded)
+			assert.Nil(t, err)
+			assert.Nil(t, restored.Headers)
+		})
+	}
+}
+
+func TestRoundTripOfEmptyMessage(t *testing.T) {
+	for name, serializer := range serializers {
+		t.Run(name, func(t *testing.T) {
+			for _, message := range [][]byte{{}, nil} {
+				original := StoredMessage{
+					MsgNum: 1, Created: time.Now(), Message: message}
+				encoded, err := serializer.Encode(original)
+				assert.Nil(t, err)
+				restored, err := serializer.Decode(encoded)
+				assert.Nil(t, err)
+				assert.NotNil(t, restored.Message)
+				assert.Equal(t, 0, len(restored.Message))
+			}
+		})
+	}
+}
+
+func TestDecodeOfGarbage(t *testing.T) {
+	for name, serializer := range serializers {
+		t.Run(name, func(t *testing.T) {
+			_, err := serializer.Decode([]byte("garbage"))
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestJSONRecordIsHumanReadable(t *testing.T) {
+	created := time.Date(2020, 6, 30, 12, 0, 0, 0, time.UTC)
+	encoded, err := JSONSerializer{}.Encode(StoredMessage{
+		MsgNum:  7,
+		Created: created,
+		Message: []byte("hello"),
+	})
+	assert.Nil(t, err)
+	expected := `{"MsgNum":7,"Created":"2020-06-30T12:00:00Z",` +
+		`"Key":null,"Message":"aGVsbG8="}` + "\n"
+	assert.Equal(t, expected, string(encoded))
+}
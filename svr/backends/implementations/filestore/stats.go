@@ -0,0 +1,54 @@
+package filestore
+
+// Stats is a summary of what a FileStore holds, as provided by its Stats
+// method, for reporting purposes. It is plain data, so it can be serialized
+// with encoding/json, or the like, as it stands.
+type Stats struct {
+	Topics       int
+	Messages     int
+	Bytes        int64 // The total size of the message files.
+	SegmentFiles int   // The number of message files.
+
+	// The same figures, broken down by topic. (Keyed on topic.)
+	PerTopic map[string]TopicStats
+}
+
+// TopicStats is the part of Stats that concerns a single topic. Oldest and
+// Newest are the topic's bounds, as provided by Bounds.
+type TopicStats struct {
+	Messages     int
+	Bytes        int64
+	SegmentFiles int
+	Oldest       int
+	Newest       int
+}
+
+// Stats provides a summary of what the store holds. Like MessageCount, it is
+// derived from the index, without looking inside any message files. Bytes
+// count only the message files, not the index, or the other files the store
+// keeps.
+func (s *FileStore) Stats() (Stats, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return Stats{}, ErrStoreClosed
+	}
+
+	stats := Stats{PerTopic: map[string]TopicStats{}}
+	for topic, msgFileList := range s.index.MessageFileLists {
+		oldest, newest := msgFileList.Bounds()
+		topicStats := TopicStats{
+			Messages:     msgFileList.NumMessages(),
+			Bytes:        msgFileList.TotalSize(),
+			SegmentFiles: len(msgFileList.Names),
+			Oldest:       oldest,
+			Newest:       newest,
+		}
+		stats.PerTopic[topic] = topicStats
+		stats.Topics++
+		stats.Messages += topicStats.Messages
+		stats.Bytes += topicStats.Bytes
+		stats.SegmentFiles += topicStats.SegmentFiles
+	}
+	return stats, nil
+}
@@ -0,0 +1,72 @@
+package filestore
+
+import (
+	"context"
+)
+
+// createdTopicsKey is the key under which topicNotifier is notified, there
+// being nothing to tell its watchers apart.
+const createdTopicsKey = ""
+
+// WatchTopics launches a goroutine that delivers, on the channel provided,
+// the name of each topic that comes into being from now on - which is when
+// the first message is stored to it. A topic that is deleted, and then stored
+// to again, is delivered again. The channel is closed when the context is
+// done, or the store is closed. Every watcher receives every topic, in the
+// order they came into being, and a watcher that is slow to receive them
+// does not hold up the stores that create them.
+func (s *FileStore) WatchTopics(ctx context.Context) (<-chan string, error) {
+	s.mutex.RLock()
+	closed := s.closed
+	watchFrom := len(s.createdTopics)
+	s.mutex.RUnlock()
+	if closed {
+		return nil, ErrStoreClosed
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	topicsC := make(chan string)
+	go s.runTopicWatch(ctx, watchFrom, topicsC)
+	return topicsC, nil
+}
+
+// runTopicWatch is a watcher's goroutine. It keeps its own advancing position
+// in the store's record of the topics created, in the way Subscribe does in a
+// topic.
+func (s *FileStore) runTopicWatch(ctx context.Context, watchFrom int,
+	topicsC chan<- string) {
+	defer close(topicsC)
+	for {
+		// Obtain the channel before looking, so that a topic created in
+		// between is not missed.
+		wakeUp := s.topicNotifier.Wait(createdTopicsKey)
+		s.mutex.RLock()
+		created := s.createdTopics[watchFrom:]
+		closed := s.closed
+		s.mutex.RUnlock()
+		for _, topic := range created {
+			select {
+			case topicsC <- topic:
+			case <-ctx.Done():
+				return
+			}
+		}
+		watchFrom += len(created)
+		if closed {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wakeUp:
+		}
+	}
+}
+
+// noteNewTopic records that the given topic has come into being, and wakes up
+// the watchers. The caller must hold the store's mutex.
+func (s *FileStore) noteNewTopic(topic string) {
+	s.createdTopics = append(s.createdTopics, topic)
+	s.topicNotifier.Notify(createdTopicsKey)
+}
@@ -0,0 +1,66 @@
+package filestore
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	minikafka "github.com/peterhoward42/minikafka"
+)
+
+// The attributes given to the spans the store creates. The message count,
+// and bytes, are those stored, found, or removed, according to the
+// operation.
+const (
+	topicAttribute        = attribute.Key("topic")
+	messageCountAttribute = attribute.Key("message_count")
+	bytesAttribute        = attribute.Key("bytes")
+)
+
+// WithTracer sets the OpenTelemetry tracer with which the store creates a
+// span for each Store, StoreBatch, Poll, PollN, and each retention operation
+// (RemoveOldMessages, TrimToCount, TrimToSize, TruncateBefore and Compact).
+// The spans are named for the method, like "filestore.Store", and are
+// children of any span in the context the method is given. (The methods that
+// are not given a context start a new trace.) The default is a tracer that
+// does nothing.
+func WithTracer(tracer trace.Tracer) Option {
+	return func(s *FileStore) {
+		s.tracer = tracer
+	}
+}
+
+// defaultTracer is the tracer used unless WithTracer says otherwise.
+func defaultTracer() trace.Tracer {
+	return noop.NewTracerProvider().Tracer("filestore")
+}
+
+// startSpan starts a span for the given method, with the given attributes.
+func (s *FileStore) startSpan(ctx context.Context, method string,
+	attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "filestore."+method,
+		trace.WithAttributes(attributes...))
+}
+
+// endSpan ends the span, marking it as failed when there is an error.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// messageAttributes provides the message count, and bytes, attributes for
+// the given messages.
+func messageAttributes(messages []minikafka.Message) []attribute.KeyValue {
+	var nBytes int64
+	for _, message := range messages {
+		nBytes += int64(len(message))
+	}
+	return []attribute.KeyValue{messageCountAttribute.Int(len(messages)),
+		bytesAttribute.Int64(nBytes)}
+}
@@ -1,16 +1,19 @@
 package memstore
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"sort"
 	"sync"
 	"time"
 
 	minikafka "github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/notify"
+	"github.com/peterhoward42/minikafka/svr/backends/snapshot"
 )
 
-var mutex = &sync.Mutex{} // Guards concurrent access of the MemStore.
-
 // MemStore implements the svr/backends/contract/BackingStore interface using
 // a volatile, in-process memory store. It exists principally to aid
 // development and testing without being dependent on real storage.
@@ -21,14 +24,46 @@ type MemStore struct {
 	// message-number.)
 	messagesPerTopic    map[string][]storedMessage // Keyed on topic.
 	newestMessageNumber map[string]int             // Keyed on topic.
+
+	// Guards concurrent access of this MemStore. Poll takes only a read lock
+	// so that concurrent readers do not block each other.
+	mutex sync.RWMutex
+
+	// Wakes up blocking polls when messages are stored.
+	notifier notify.Notifier
+
+	// The most messages each topic may hold, or zero for no limit. See
+	// WithMaxPerTopic.
+	maxPerTopic int
+}
+
+// Option is a functional option that configures a MemStore at construction
+// time. See NewMemStore.
+type Option func(*MemStore)
+
+// WithMaxPerTopic bounds each topic to holding at most the given number of
+// messages, like a circular buffer. Storing a message to a topic that is
+// full evicts its oldest message, so that polling from the number of an
+// evicted message resumes at the oldest message that remains. Message
+// numbers are unaffected by eviction. Zero, the default, means there is no
+// limit - as does a negative number.
+func WithMaxPerTopic(maxMessages int) Option {
+	return func(m *MemStore) {
+		m.maxPerTopic = maxMessages
+	}
 }
 
-// NewMemStore instantiates, initializes and returns a MemStore.
-func NewMemStore() *MemStore {
-	return &MemStore{
+// NewMemStore instantiates, initializes and returns a MemStore, configured
+// by the given options.
+func NewMemStore(options ...Option) *MemStore {
+	m := &MemStore{
 		messagesPerTopic:    map[string][]storedMessage{},
 		newestMessageNumber: map[string]int{},
 	}
+	for _, option := range options {
+		option(m)
+	}
+	return m
 }
 
 // ------------------------------------------------------------------------
@@ -36,9 +71,12 @@ func NewMemStore() *MemStore {
 // ------------------------------------------------------------------------
 
 // DeleteContents is defined in the BackingStore interface.
-func (m MemStore) DeleteContents() error {
-	mutex.Lock()
-	defer mutex.Unlock()
+func (m *MemStore) DeleteContents(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	for k := range m.messagesPerTopic {
 		delete(m.messagesPerTopic, k)
 	}
@@ -48,13 +86,29 @@ func (m MemStore) DeleteContents() error {
 	return nil
 }
 
+// DeleteTopic is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (m *MemStore) DeleteTopic(ctx context.Context, topic string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.messagesPerTopic, topic)
+	delete(m.newestMessageNumber, topic)
+	return nil
+}
+
 // Store is defined by, and documented in the backends/contract/BackingStore
 // interface.
-func (m MemStore) Store(topic string, message minikafka.Message) (
-	messageNumber int, err error) {
+func (m *MemStore) Store(ctx context.Context, topic string,
+	message minikafka.Message) (messageNumber int, err error) {
+	if ctx.Err() != nil {
+		return -1, ctx.Err()
+	}
 
-	mutex.Lock()
-	defer mutex.Unlock()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
 	// Bit of extra work if this is a new topic.
 	if _, ok := m.messagesPerTopic[topic]; ok == false {
@@ -68,18 +122,24 @@ func (m MemStore) Store(topic string, message minikafka.Message) (
 	m.newestMessageNumber[topic]++
 
 	// Make and add the new message.
-	msgToAdd := storedMessage{message, time.Now(),
-		m.newestMessageNumber[topic]}
+	msgToAdd := storedMessage{message: message, creationTime: time.Now(),
+		messageNumber: m.newestMessageNumber[topic]}
 	m.messagesPerTopic[topic] = append(m.messagesPerTopic[topic], msgToAdd)
+	m.evictOverflow(topic)
+	m.notifier.Notify(topic)
 
 	return m.newestMessageNumber[topic], nil
 }
 
 // RemoveOldMessages is defined by, and documented in the
 // backends/contract/BackingStore interface.
-func (m MemStore) RemoveOldMessages(maxAge time.Time) (err error) {
-	mutex.Lock()
-	defer mutex.Unlock()
+func (m *MemStore) RemoveOldMessages(ctx context.Context,
+	maxAge time.Time) (err error) {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	for topic := range m.messagesPerTopic {
 		_, err := m.removeOldMessagesFromTopic(topic, maxAge)
 		if err != nil {
@@ -91,23 +151,200 @@ func (m MemStore) RemoveOldMessages(maxAge time.Time) (err error) {
 
 // Poll is defined by, and documented in the backends/contract/BackingStore
 // interface.
-func (m MemStore) Poll(topic string, readFrom int) (
+func (m *MemStore) Poll(ctx context.Context, topic string, readFrom int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	return m.PollN(ctx, topic, readFrom, 0)
+}
+
+// PollN is defined by, and documented in the backends/contract/BackingStore
+// interface.
+func (m *MemStore) PollN(ctx context.Context, topic string, readFrom int,
+	maxMessages int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	if ctx.Err() != nil {
+		return nil, -1, ctx.Err()
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.pollN(topic, readFrom, maxMessages)
+}
+
+// PollBlocking is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (m *MemStore) PollBlocking(
+	ctx context.Context, topic string, readFrom int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	for {
+		// Obtain the wake-up channel before looking, so that a message stored
+		// in between cannot be missed.
+		wakeUp := m.notifier.Wait(topic)
+		foundMessages, newReadFrom, err = m.pollIfTopicKnown(topic, readFrom)
+		if err != nil {
+			return nil, -1, err
+		}
+		if len(foundMessages) > 0 {
+			return foundMessages, newReadFrom, nil
+		}
+		select {
+		case <-ctx.Done():
+			return []minikafka.Message{}, readFrom, ctx.Err()
+		case <-wakeUp:
+		}
+	}
+}
+
+// Subscribe is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (m *MemStore) Subscribe(ctx context.Context, topic string, readFrom int) (
+	<-chan minikafka.Message, <-chan error) {
+	return notify.Subscribe(ctx, m.PollBlocking, topic, readFrom)
+}
+
+// ListTopics is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (m *MemStore) ListTopics(ctx context.Context) (
+	topics []string, err error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	topics = []string{}
+	for topic := range m.messagesPerTopic {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// ------------------------------------------------------------------------
+// METHODS TO SATISFY THE RecordStore INTERFACE, AND THOSE BUILT ON IT.
+// ------------------------------------------------------------------------
+
+// PollRecords is defined by, and documented in the
+// backends/contract/RecordStore interface. Messages stored by Store have no
+// key, nor headers.
+func (m *MemStore) PollRecords(ctx context.Context, topic string,
+	readFrom int) (records []contract.Record, newReadFrom int, err error) {
+	if ctx.Err() != nil {
+		return nil, -1, ctx.Err()
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	storedMessages, ok := m.messagesPerTopic[topic]
+	if !ok {
+		return nil, -1, fmt.Errorf("%w: %s", contract.ErrTopicNotFound, topic)
+	}
+	serveFromIndex := sort.Search(len(storedMessages), func(i int) bool {
+		return storedMessages[i].messageNumber >= readFrom
+	})
+	records = []contract.Record{}
+	newReadFrom = readFrom
+	for _, msg := range storedMessages[serveFromIndex:] {
+		records = append(records, contract.Record{
+			Number: msg.messageNumber, Time: msg.creationTime, Key: msg.key,
+			Headers: msg.headers, Message: msg.message})
+		newReadFrom = msg.messageNumber + 1
+	}
+	return records, newReadFrom, nil
+}
+
+// StoreRecords is defined by, and documented in the
+// backends/contract/RecordStore interface. It stores either all of the
+// records, or, when their numbers are not ascending, none of them.
+func (m *MemStore) StoreRecords(ctx context.Context, topic string,
+	records []contract.Record) (firstNumber int, lastNumber int, err error) {
+	if ctx.Err() != nil {
+		return -1, -1, ctx.Err()
+	}
+	if len(records) == 0 {
+		return -1, -1, fmt.Errorf("no messages to store")
+	}
+	for i := 1; i < len(records); i++ {
+		if records[i].Number <= records[i-1].Number {
+			return -1, -1, fmt.Errorf(
+				"record numbers are not ascending: %d follows %d",
+				records[i].Number, records[i-1].Number)
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Number the first as Store would, and the rest relative to it.
+	offset := m.newestMessageNumber[topic] + 1 - records[0].Number
+	for _, record := range records {
+		created := record.Time
+		if created.IsZero() {
+			created = time.Now()
+		}
+		msgToAdd := storedMessage{message: record.Message,
+			creationTime: created, messageNumber: record.Number + offset,
+			key: record.Key, headers: record.Headers}
+		m.messagesPerTopic[topic] = append(m.messagesPerTopic[topic], msgToAdd)
+	}
+	firstNumber = records[0].Number + offset
+	lastNumber = records[len(records)-1].Number + offset
+	m.newestMessageNumber[topic] = lastNumber
+	m.evictOverflow(topic)
+	m.notifier.Notify(topic)
+	return firstNumber, lastNumber, nil
+}
+
+// ExportTopic writes all the messages held for the topic to the given
+// writer, in the format of snapshot.ExportTopic.
+func (m *MemStore) ExportTopic(ctx context.Context, topic string,
+	w io.Writer) error {
+	return snapshot.ExportTopic(ctx, m, topic, w)
+}
+
+// ImportTopic stores the messages in a snapshot written by ExportTopic - of
+// this, or any other store - in the given topic. See snapshot.ImportTopic.
+func (m *MemStore) ImportTopic(ctx context.Context, topic string,
+	r io.Reader) error {
+	return snapshot.ImportTopic(ctx, m, topic, r)
+}
+
+// ------------------------------------------------------------------------
+// Helper functions.
+// ------------------------------------------------------------------------
+
+// pollIfTopicKnown is like Poll, except that it treats a topic that has
+// never been stored to as simply having no messages yet. (The check and the
+// poll are made atomically.)
+func (m *MemStore) pollIfTopicKnown(topic string, readFrom int) (
 	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if _, ok := m.messagesPerTopic[topic]; ok == false {
+		return []minikafka.Message{}, readFrom, nil
+	}
+	return m.pollN(topic, readFrom, 0)
+}
 
-	mutex.Lock()
-	defer mutex.Unlock()
+// pollN is the implementation of PollN. It is not responsible for mutex
+// protection.
+func (m *MemStore) pollN(topic string, readFrom int, maxMessages int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
 
 	storedMessages, ok := m.messagesPerTopic[topic]
 	if !ok {
-		return nil, -1, fmt.Errorf("No such topic: %s", topic)
+		return nil, -1, fmt.Errorf("%w: %s", contract.ErrTopicNotFound, topic)
 	}
 	serveFromIndex := sort.Search(len(storedMessages), func(i int) bool {
 		return storedMessages[i].messageNumber >= readFrom
 	})
 
+	toServe := storedMessages[serveFromIndex:]
+	if maxMessages > 0 && len(toServe) > maxMessages {
+		toServe = toServe[:maxMessages]
+	}
+
 	foundMessages = []minikafka.Message{}
 	var highest int
-	for _, msg := range storedMessages[serveFromIndex:] {
+	for _, msg := range toServe {
 		foundMessages = append(foundMessages, msg.message)
 		highest = msg.messageNumber
 	}
@@ -120,13 +357,9 @@ func (m MemStore) Poll(topic string, readFrom int) (
 	return foundMessages, unchangedReadFrom, nil
 }
 
-// ------------------------------------------------------------------------
-// Helper functions.
-// ------------------------------------------------------------------------
-
 // RemoveOldMessagesFromTopic is a topic-specific helper function for the
 // whole-store RemoveOldMessages method.
-func (m MemStore) removeOldMessagesFromTopic(
+func (m *MemStore) removeOldMessagesFromTopic(
 	topic string, maxAge time.Time) (nRemoved int, err error) {
 
 	// Find the boundary between the messages to keep and those to remove.
@@ -152,16 +385,32 @@ func (m MemStore) removeOldMessagesFromTopic(
 	return nRemoved, nil
 }
 
+// evictOverflow discards the topic's oldest messages, when it holds more than
+// the configured maximum. Re-slicing, rather than copying, is enough to keep
+// the memory used bounded, because the next append that outgrows the slice's
+// capacity copies only the messages that remain. It is not responsible for
+// mutex protection.
+func (m *MemStore) evictOverflow(topic string) {
+	messages := m.messagesPerTopic[topic]
+	if m.maxPerTopic <= 0 || len(messages) <= m.maxPerTopic {
+		return
+	}
+	m.messagesPerTopic[topic] = messages[len(messages)-m.maxPerTopic:]
+}
+
 // ------------------------------------------------------------------------
 // AUXILLIARY CODE
 // ------------------------------------------------------------------------
 
 // storedMessage is a private type for the MemStore backing store
 // implementation which encapsulates a message itself, along with its creation
-// time, and message number.
+// time, message number, and the key and headers it may have been imported
+// with (see StoreRecords).
 
 type storedMessage struct {
 	message       minikafka.Message
 	creationTime  time.Time
 	messageNumber int
+	key           []byte
+	headers       map[string]string
 }
@@ -1,7 +1,12 @@
 package memstore
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 
 	"github.com/peterhoward42/minikafka/svr/backends/contract"
 )
@@ -13,5 +18,130 @@ func TestMemStore(t *testing.T) {
 	memstore := NewMemStore()
 	// Delegate to a test suite that takes a contract.BackingStore
 	// (interface) argument.
-	contract.RunBackingStoreTests(t, *memstore)
+	contract.RunBackingStoreTests(t, memstore)
+}
+
+// TestConcurrentPollsAndStores makes sure that readers and writers can
+// operate on the same MemStore concurrently without disturbing one
+// another's view of the message sequence.
+func TestConcurrentPollsAndStores(t *testing.T) {
+	ctx := context.Background()
+	memstore := NewMemStore()
+	const nMessages = 100
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < nMessages; i++ {
+			_, err := memstore.Store(ctx, "topicA", []byte("foo"))
+			assert.Nil(t, err)
+		}
+		done <- true
+	}()
+	// Poll repeatedly while the writer is busy, checking that the read
+	// position only ever advances.
+	readFrom := 1
+	writerFinished := false
+	for writerFinished == false {
+		select {
+		case <-done:
+			writerFinished = true
+		default:
+		}
+		_, newReadFrom, err := memstore.Poll(ctx, "topicA", readFrom)
+		if err != nil {
+			// The topic may not have come into being yet.
+			continue
+		}
+		assert.True(t, newReadFrom >= readFrom)
+		readFrom = newReadFrom
+	}
+	messages, newReadFrom, err := memstore.Poll(ctx, "topicA", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, nMessages, len(messages))
+	assert.Equal(t, nMessages+1, newReadFrom)
+}
+
+// TestStoreRecordsThenPollRecords makes sure that records keep their time,
+// key and headers, and are numbered on from those already in the topic, with
+// their gaps preserved.
+func TestStoreRecordsThenPollRecords(t *testing.T) {
+	ctx := context.Background()
+	memstore := NewMemStore()
+	_, err := memstore.Store(ctx, "topicA", []byte("foo"))
+	assert.Nil(t, err)
+	created := time.Date(2020, 6, 30, 12, 0, 0, 0, time.UTC)
+	first, last, err := memstore.StoreRecords(ctx, "topicA",
+		[]contract.Record{
+			{Number: 7, Time: created, Key: []byte("key"),
+				Message: []byte("bar")},
+			{Number: 9, Time: created,
+				Headers: map[string]string{"trace": "abc"},
+				Message: []byte("baz")},
+		})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, first)
+	assert.Equal(t, 4, last)
+
+	records, newReadFrom, err := memstore.PollRecords(ctx, "topicA", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, newReadFrom)
+	assert.Equal(t, []contract.Record{
+		{Number: 2, Time: created, Key: []byte("key"), Message: []byte("bar")},
+		{Number: 4, Time: created, Headers: map[string]string{"trace": "abc"},
+			Message: []byte("baz")},
+	}, records)
+	number, err := memstore.Store(ctx, "topicA", []byte("qux"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, number)
+
+	_, _, err = memstore.StoreRecords(ctx, "topicA", []contract.Record{
+		{Number: 2, Message: []byte("bar")}, {Number: 1}})
+	assert.NotNil(t, err)
+	_, _, err = memstore.PollRecords(ctx, "nosuchtopic", 1)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+}
+
+// TestMaxPerTopicEvictsOldest makes sure that a topic filled past its limit
+// keeps only its newest messages, numbered as they were when stored, and that
+// polling from an evicted message resumes at the oldest that remains.
+func TestMaxPerTopicEvictsOldest(t *testing.T) {
+	ctx := context.Background()
+	memstore := NewMemStore(WithMaxPerTopic(3))
+	for i := 1; i <= 5; i++ {
+		number, err := memstore.Store(ctx, "topicA", []byte{byte(i)})
+		assert.Nil(t, err)
+		assert.Equal(t, i, number)
+	}
+	_, err := memstore.Store(ctx, "topicB", []byte{1})
+	assert.Nil(t, err)
+
+	records, newReadFrom, err := memstore.PollRecords(ctx, "topicA", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, newReadFrom)
+	assert.Equal(t, 3, len(records))
+	for i, record := range records {
+		assert.Equal(t, i+3, record.Number)
+		assert.Equal(t, []byte{byte(i + 3)}, []byte(record.Message))
+	}
+	messages, newReadFrom, err := memstore.Poll(ctx, "topicA", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, newReadFrom)
+	assert.Equal(t, 3, len(messages))
+
+	// Records stored in bulk are subject to the limit too.
+	first, last, err := memstore.StoreRecords(ctx, "topicA",
+		[]contract.Record{{Number: 1, Message: []byte{6}},
+			{Number: 3, Message: []byte{8}}})
+	assert.Nil(t, err)
+	assert.Equal(t, 6, first)
+	assert.Equal(t, 8, last)
+	records, _, err = memstore.PollRecords(ctx, "topicA", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, 5, records[0].Number)
+	assert.Equal(t, 8, records[2].Number)
+
+	// Other topics are unaffected.
+	messages, _, err = memstore.Poll(ctx, "topicB", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
 }
@@ -0,0 +1,177 @@
+// Package mirrorstore provides a BackingStore that mirrors the changes made
+// to one BackingStore in another - as a simple form of replication, or
+// back-up.
+package mirrorstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	minikafka "github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+)
+
+// Mode says what a MirrorStore does when an operation fails on the secondary
+// store.
+type Mode int
+
+const (
+	// BestEffort logs the failure, and otherwise ignores it.
+	BestEffort Mode = iota
+	// SecondaryRequired logs the failure, and reports it too - as an error
+	// that wraps ErrSecondaryFailed.
+	SecondaryRequired
+)
+
+// ErrSecondaryFailed is returned (wrapped) in SecondaryRequired mode, when an
+// operation succeeded on the primary store, but failed on the secondary. The
+// change to the primary stands regardless.
+var ErrSecondaryFailed = errors.New("secondary store failed")
+
+// MirrorStore implements the svr/backends/contract/BackingStore interface by
+// delegating to a primary BackingStore, and additionally applying every
+// operation that changes it to a secondary BackingStore. Stores, removals and
+// deletions are made to the primary first, and to the secondary only if they
+// succeed there. What they return is what the primary returned. Operations
+// that only read are delegated to the primary alone.
+//
+// The secondary is expected to start out holding the same messages as the
+// primary, so that it assigns them the same message numbers. When it does
+// not, that is treated as a failure of the secondary. Stores and deletions
+// are made one at a time, so that concurrent ones reach the secondary in the
+// order they were made to the primary.
+type MirrorStore struct {
+	primary   contract.BackingStore
+	secondary contract.BackingStore
+	mode      Mode
+
+	// Held across the calls to both stores, by the operations that change
+	// the message numbers a store gives out.
+	mutex sync.Mutex
+}
+
+// NewMirrorStore provides a MirrorStore that mirrors the primary store in the
+// secondary one, treating failures of the secondary as the mode specifies.
+func NewMirrorStore(primary contract.BackingStore,
+	secondary contract.BackingStore, mode Mode) *MirrorStore {
+	return &MirrorStore{primary: primary, secondary: secondary, mode: mode}
+}
+
+// ------------------------------------------------------------------------
+// METHODS TO SATISFY THE BackingStore INTERFACE.
+// ------------------------------------------------------------------------
+
+// Store is defined by, and documented in the backends/contract/BackingStore
+// interface.
+func (m *MirrorStore) Store(ctx context.Context, topic string,
+	message minikafka.Message) (messageNumber int, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	messageNumber, err = m.primary.Store(ctx, topic, message)
+	if err != nil {
+		return -1, err
+	}
+	mirrored, err := m.secondary.Store(ctx, topic, message)
+	if err == nil && mirrored != messageNumber {
+		err = fmt.Errorf("message number (%d) differs from the primary's (%d)",
+			mirrored, messageNumber)
+	}
+	return messageNumber, m.secondaryResult("Store", err)
+}
+
+// RemoveOldMessages is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (m *MirrorStore) RemoveOldMessages(
+	ctx context.Context, maxAge time.Time) error {
+	err := m.primary.RemoveOldMessages(ctx, maxAge)
+	if err != nil {
+		return err
+	}
+	return m.secondaryResult("RemoveOldMessages",
+		m.secondary.RemoveOldMessages(ctx, maxAge))
+}
+
+// Poll is defined by, and documented in the backends/contract/BackingStore
+// interface.
+func (m *MirrorStore) Poll(ctx context.Context, topic string, readFrom int) (
+	messages []minikafka.Message, newReadFrom int, err error) {
+	return m.primary.Poll(ctx, topic, readFrom)
+}
+
+// PollN is defined by, and documented in the backends/contract/BackingStore
+// interface.
+func (m *MirrorStore) PollN(ctx context.Context, topic string, readFrom int,
+	maxMessages int) (
+	messages []minikafka.Message, newReadFrom int, err error) {
+	return m.primary.PollN(ctx, topic, readFrom, maxMessages)
+}
+
+// PollBlocking is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (m *MirrorStore) PollBlocking(
+	ctx context.Context, topic string, readFrom int) (
+	messages []minikafka.Message, newReadFrom int, err error) {
+	return m.primary.PollBlocking(ctx, topic, readFrom)
+}
+
+// Subscribe is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (m *MirrorStore) Subscribe(ctx context.Context, topic string,
+	readFrom int) (<-chan minikafka.Message, <-chan error) {
+	return m.primary.Subscribe(ctx, topic, readFrom)
+}
+
+// ListTopics is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (m *MirrorStore) ListTopics(ctx context.Context) (
+	topics []string, err error) {
+	return m.primary.ListTopics(ctx)
+}
+
+// DeleteTopic is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (m *MirrorStore) DeleteTopic(ctx context.Context, topic string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	err := m.primary.DeleteTopic(ctx, topic)
+	if err != nil {
+		return err
+	}
+	return m.secondaryResult("DeleteTopic",
+		m.secondary.DeleteTopic(ctx, topic))
+}
+
+// DeleteContents is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (m *MirrorStore) DeleteContents(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	err := m.primary.DeleteContents(ctx)
+	if err != nil {
+		return err
+	}
+	return m.secondaryResult("DeleteContents",
+		m.secondary.DeleteContents(ctx))
+}
+
+// ------------------------------------------------------------------------
+// Miscellaneous Implementation functions.
+// ------------------------------------------------------------------------
+
+// secondaryResult logs the error from the named operation on the secondary
+// store, if there is one, and provides what the MirrorStore should return
+// for it, according to its mode.
+func (m *MirrorStore) secondaryResult(operation string, err error) error {
+	if err == nil {
+		return nil
+	}
+	log.Printf("mirrorstore: secondary %s(): %v", operation, err)
+	if m.mode == BestEffort {
+		return nil
+	}
+	return fmt.Errorf("%w: %s(): %v", ErrSecondaryFailed, operation, err)
+}
@@ -0,0 +1,158 @@
+package mirrorstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	minikafka "github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/memstore"
+)
+
+// TestMirrorStore ensures that MirrorStore passes all the tests defined for
+// the BackingStore interface it claims to satisfy.
+func TestMirrorStore(t *testing.T) {
+	mirrorstore := NewMirrorStore(
+		memstore.NewMemStore(), memstore.NewMemStore(), SecondaryRequired)
+	contract.RunBackingStoreTests(t, mirrorstore)
+}
+
+// failingStore is a BackingStore whose Store fails once it has stored a
+// given number of messages.
+type failingStore struct {
+	*memstore.MemStore
+	storesLeft int
+}
+
+func (f *failingStore) Store(ctx context.Context, topic string,
+	message minikafka.Message) (int, error) {
+	if f.storesLeft == 0 {
+		return -1, fmt.Errorf("out of space")
+	}
+	f.storesLeft--
+	return f.MemStore.Store(ctx, topic, message)
+}
+
+// slowStore is a BackingStore whose Store takes a little while, so that
+// concurrent stores overlap.
+type slowStore struct {
+	*memstore.MemStore
+}
+
+func (s *slowStore) Store(ctx context.Context, topic string,
+	message minikafka.Message) (int, error) {
+	msgNum, err := s.MemStore.Store(ctx, topic, message)
+	time.Sleep(time.Millisecond)
+	return msgNum, err
+}
+
+func TestBothStoresGetTheSameMessages(t *testing.T) {
+	// Make sure that the changes made through the MirrorStore reach both
+	// stores, so that they end up holding the same messages.
+
+	ctx := context.Background()
+	primary := memstore.NewMemStore()
+	secondary := memstore.NewMemStore()
+	mirrorstore := NewMirrorStore(primary, secondary, SecondaryRequired)
+
+	for i := 1; i <= 3; i++ {
+		for _, topic := range []string{"topic_a", "topic_b"} {
+			msgNum, err := mirrorstore.Store(
+				ctx, topic, []byte(fmt.Sprintf("%s_%d", topic, i)))
+			assert.Nil(t, err)
+			assert.Equal(t, i, msgNum)
+		}
+	}
+	err := mirrorstore.DeleteTopic(ctx, "topic_b")
+	assert.Nil(t, err)
+	err = mirrorstore.RemoveOldMessages(ctx, time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+
+	for _, store := range []contract.BackingStore{primary, secondary} {
+		topics, err := store.ListTopics(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"topic_a"}, topics)
+		messages, _, err := store.Poll(ctx, "topic_a", 1)
+		assert.Nil(t, err)
+		assert.Equal(t, []minikafka.Message{[]byte("topic_a_1"),
+			[]byte("topic_a_2"), []byte("topic_a_3")}, messages)
+	}
+
+	err = mirrorstore.DeleteContents(ctx)
+	assert.Nil(t, err)
+	for _, store := range []contract.BackingStore{primary, secondary} {
+		topics, err := store.ListTopics(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{}, topics)
+	}
+}
+
+func TestWhenSecondaryFails(t *testing.T) {
+	// Make sure that when the secondary store fails, the primary carries on
+	// regardless, and that the failure is reported only when the mode
+	// requires it.
+
+	ctx := context.Background()
+	for _, mode := range []Mode{BestEffort, SecondaryRequired} {
+		primary := memstore.NewMemStore()
+		secondary := &failingStore{memstore.NewMemStore(), 2}
+		mirrorstore := NewMirrorStore(primary, secondary, mode)
+
+		for i := 1; i <= 3; i++ {
+			msgNum, err := mirrorstore.Store(
+				ctx, "topic", []byte(fmt.Sprintf("message_%d", i)))
+			assert.Equal(t, i, msgNum)
+			if i <= 2 || mode == BestEffort {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, ErrSecondaryFailed))
+				assert.EqualError(t, err,
+					"secondary store failed: Store(): out of space")
+			}
+		}
+
+		messages, _, err := primary.Poll(ctx, "topic", 1)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(messages))
+		messages, _, err = secondary.Poll(ctx, "topic", 1)
+		assert.Nil(t, err)
+		assert.Equal(t, []minikafka.Message{
+			[]byte("message_1"), []byte("message_2")}, messages)
+	}
+}
+
+func TestConcurrentStoresAreMirroredInOrder(t *testing.T) {
+	// Make sure that when several producers store to the same topic at
+	// once, the secondary ends up holding each message under the same
+	// number as the primary.
+
+	ctx := context.Background()
+	primary := &slowStore{memstore.NewMemStore()}
+	secondary := memstore.NewMemStore()
+	mirrorstore := NewMirrorStore(primary, secondary, SecondaryRequired)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := mirrorstore.Store(
+				ctx, "topic", []byte(fmt.Sprintf("message_%d", i)))
+			assert.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	primaryMessages, _, err := primary.Poll(ctx, "topic", 1)
+	assert.Nil(t, err)
+	secondaryMessages, _, err := secondary.Poll(ctx, "topic", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, len(primaryMessages))
+	assert.Equal(t, primaryMessages, secondaryMessages)
+}
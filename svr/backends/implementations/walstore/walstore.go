@@ -0,0 +1,842 @@
+// Package walstore provides a BackingStore that appends the records of every
+// topic to a single write-ahead log, rather than keeping message files per
+// topic as the filestore does. This suits workloads with thousands of topics,
+// each holding few messages, for which a directory and files per topic is
+// syscall and inode heavy.
+//
+// The log is split into segment files, which are rolled over when they reach
+// a maximum size - for the log as a whole, rather than per topic. An index
+// of where each message's record is, by topic and message number, is held in
+// memory, and messages are read from the segments with ReadAt. The index is
+// not persisted. Instead, it is rebuilt by replaying the log when the store
+// is opened. So that deleting a topic, and removing old messages, survive
+// that, they are recorded in the log too.
+package walstore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	minikafka "github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/records"
+	"github.com/peterhoward42/minikafka/svr/backends/notify"
+)
+
+// DefaultMaxSegmentSize is the size (in bytes) at which the log is rolled
+// over to a new segment file, unless WithMaxSegmentSize says otherwise.
+const DefaultMaxSegmentSize = 16 * 1024 * 1024 // 16 MiB
+
+// ErrStoreClosed is returned by every method of a WALStore that has been
+// closed.
+var ErrStoreClosed = errors.New("store is closed")
+
+// segmentSuffix is the file name extension of the log's segment files. Their
+// names are otherwise their sequence number, padded so that they sort in
+// order.
+const segmentSuffix = ".wal"
+
+// WALStore implements the svr/backends/contract/BackingStore interface using
+// a single write-ahead log. See the package documentation.
+type WALStore struct {
+	RootDir string
+
+	// The size at which a segment file is rolled over.
+	maxSegmentSize int64
+
+	// Whether to commit each record to stable storage as it is appended.
+	syncOnWrite bool
+
+	// The log's segment files, held open, keyed on their sequence number.
+	// The active one, which is appended to, is the newest - and is nil
+	// before the first record is appended.
+	segments map[int]*segment
+	oldest   int
+	active   *segment
+
+	// The index of the messages held for each topic, keyed on topic.
+	topics map[string]*topicIndex
+
+	// Guards everything above. Polls take only a read lock, so that
+	// concurrent readers do not block each other.
+	mutex sync.RWMutex
+
+	// Whether Close has been called.
+	closed bool
+
+	// Wakes up blocking polls when messages are stored.
+	notifier notify.Notifier
+}
+
+// segment is one of the log's segment files.
+type segment struct {
+	seq  int
+	file *os.File
+	size int64
+	// How many of the messages in the index are held in this segment. It
+	// can be removed once there are none, and none in any older segment.
+	live int
+}
+
+// topicIndex says where each of a topic's messages is in the log.
+type topicIndex struct {
+	entries []entry // In message number order.
+	next    int     // The number the next message stored will be given.
+}
+
+// entry says where one message's record is in the log.
+type entry struct {
+	number  int
+	created time.Time
+	segment int   // The sequence number of the segment.
+	offset  int64 // Where the (framed) record starts in the segment.
+	length  int64
+}
+
+// Option is a functional option that can be passed to NewWALStore to
+// override one of the WALStore's default settings.
+type Option func(*WALStore)
+
+// WithMaxSegmentSize sets the size (in bytes) beyond which a segment file
+// will not be allowed to grow, and a new one will be started instead. A
+// record that is larger than this gets a segment to itself. Since old
+// messages are removed a whole segment at a time, smaller segments let
+// space be reclaimed sooner. The default is DefaultMaxSegmentSize.
+func WithMaxSegmentSize(size int64) Option {
+	return func(w *WALStore) {
+		w.maxSegmentSize = size
+	}
+}
+
+// WithSyncOnWrite sets whether the store commits the log to stable storage
+// after appending each record, so that every stored message is durable
+// before Store returns. It costs a disk flush per message. The default is
+// off, in which case callers can use Flush to force durability at points of
+// their choosing.
+func WithSyncOnWrite(enabled bool) Option {
+	return func(w *WALStore) {
+		w.syncOnWrite = enabled
+	}
+}
+
+// NewWALStore provides a WALStore that keeps its log in the given root
+// directory, creating the directory if need be. When there is a log there
+// already, it is replayed to rebuild the index. Should the log end part way
+// through a record - as it will if an append was interrupted - the record is
+// truncated away. Records that fail their checksum are skipped. Only one
+// WALStore at a time should use a given root directory. The store's default
+// settings can be overridden by passing in Options.
+func NewWALStore(rootDir string, options ...Option) (*WALStore, error) {
+	w := &WALStore{RootDir: rootDir, maxSegmentSize: DefaultMaxSegmentSize,
+		segments: map[int]*segment{}, topics: map[string]*topicIndex{}}
+	for _, option := range options {
+		option(w)
+	}
+	if w.maxSegmentSize <= 0 {
+		return nil, fmt.Errorf("maximum segment size must be positive: %d",
+			w.maxSegmentSize)
+	}
+	err := os.MkdirAll(rootDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("os.MkdirAll(): %v", err)
+	}
+	err = w.replay()
+	if err != nil {
+		w.closeSegments()
+		return nil, fmt.Errorf("replay(): %v", err)
+	}
+	return w, nil
+}
+
+// ------------------------------------------------------------------------
+// METHODS TO SATISFY THE BackingStore INTERFACE.
+// ------------------------------------------------------------------------
+
+// Store is defined by, and documented in the backends/contract/BackingStore
+// interface.
+func (w *WALStore) Store(ctx context.Context, topic string,
+	message minikafka.Message) (messageNumber int, err error) {
+	if ctx.Err() != nil {
+		return -1, ctx.Err()
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return -1, ErrStoreClosed
+	}
+
+	index, ok := w.topics[topic]
+	if ok == false {
+		index = &topicIndex{next: 1}
+	}
+	rec := walRecord{kind: messageRecord, topic: topic,
+		number: index.next, stored: records.StoredMessage{
+			MsgNum: int32(index.next), Created: time.Now(),
+			Message: message}}
+	where, err := w.append(rec)
+	if err != nil {
+		return -1, fmt.Errorf("append(): %w", err)
+	}
+	w.topics[topic] = index
+	index.entries = append(index.entries, where)
+	index.next++
+	w.segments[where.segment].live++
+	w.notifier.Notify(topic)
+	return where.number, nil
+}
+
+// RemoveOldMessages is defined by, and documented in the
+// backends/contract/BackingStore interface. The messages are removed from
+// the index straight away, but the space they take up in the log is only
+// reclaimed once every message in their segment, and in all older segments,
+// has been removed. (Or their topics deleted.)
+func (w *WALStore) RemoveOldMessages(ctx context.Context,
+	maxAge time.Time) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrStoreClosed
+	}
+
+	for topic, index := range w.topics {
+		keepFrom := sort.Search(len(index.entries), func(i int) bool {
+			return index.entries[i].created.Before(maxAge) == false
+		})
+		if keepFrom == 0 {
+			continue
+		}
+		w.forgetEntries(index.entries[:keepFrom])
+		// Copy those kept, so that the underlying array can be freed.
+		kept := make([]entry, len(index.entries)-keepFrom)
+		copy(kept, index.entries[keepFrom:])
+		index.entries = kept
+		err := w.recordRemoval(topic, index)
+		if err != nil {
+			return fmt.Errorf("recordRemoval(): %v", err)
+		}
+	}
+	err := w.removeSpentSegments()
+	if err != nil {
+		return fmt.Errorf("removeSpentSegments(): %v", err)
+	}
+	return nil
+}
+
+// Poll is defined by, and documented in the backends/contract/BackingStore
+// interface.
+func (w *WALStore) Poll(ctx context.Context, topic string, readFrom int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	return w.PollN(ctx, topic, readFrom, 0)
+}
+
+// PollN is defined by, and documented in the backends/contract/BackingStore
+// interface.
+func (w *WALStore) PollN(ctx context.Context, topic string, readFrom int,
+	maxMessages int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	if ctx.Err() != nil {
+		return nil, -1, ctx.Err()
+	}
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		return nil, -1, ErrStoreClosed
+	}
+	return w.pollN(ctx, topic, readFrom, maxMessages)
+}
+
+// PollBlocking is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (w *WALStore) PollBlocking(
+	ctx context.Context, topic string, readFrom int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	for {
+		// Obtain the wake-up channel before looking, so that a message stored
+		// in between cannot be missed.
+		wakeUp := w.notifier.Wait(topic)
+		foundMessages, newReadFrom, err = w.pollIfTopicKnown(
+			ctx, topic, readFrom)
+		if err != nil {
+			return nil, -1, err
+		}
+		if len(foundMessages) > 0 {
+			return foundMessages, newReadFrom, nil
+		}
+		select {
+		case <-ctx.Done():
+			return []minikafka.Message{}, readFrom, ctx.Err()
+		case <-wakeUp:
+		}
+	}
+}
+
+// Subscribe is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (w *WALStore) Subscribe(ctx context.Context, topic string,
+	readFrom int) (<-chan minikafka.Message, <-chan error) {
+	return notify.Subscribe(ctx, w.PollBlocking, topic, readFrom)
+}
+
+// ListTopics is defined by, and documented in the
+// backends/contract/BackingStore interface.
+func (w *WALStore) ListTopics(ctx context.Context) (
+	topics []string, err error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		return nil, ErrStoreClosed
+	}
+	topics = []string{}
+	for topic := range w.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// DeleteTopic is defined by, and documented in the
+// backends/contract/BackingStore interface. The topic's records remain in
+// the log until their segments are reclaimed, as they are for
+// RemoveOldMessages, but the deletion is recorded in the log, so that they
+// are not resurrected when the store is reopened.
+func (w *WALStore) DeleteTopic(ctx context.Context, topic string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrStoreClosed
+	}
+	index, ok := w.topics[topic]
+	if ok == false {
+		return nil
+	}
+	_, err := w.append(walRecord{kind: deleteTopicRecord, topic: topic})
+	if err != nil {
+		return fmt.Errorf("append(): %v", err)
+	}
+	w.forgetEntries(index.entries)
+	delete(w.topics, topic)
+	return nil
+}
+
+// DeleteContents is defined by, and documented in the
+// backends/contract/BackingStore interface. It removes every segment file.
+func (w *WALStore) DeleteContents(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrStoreClosed
+	}
+	for seq := range w.segments {
+		err := w.removeSegment(seq)
+		if err != nil {
+			return fmt.Errorf("removeSegment(): %v", err)
+		}
+	}
+	w.active = nil
+	w.oldest = 0
+	w.topics = map[string]*topicIndex{}
+	return nil
+}
+
+// ------------------------------------------------------------------------
+// ADDITIONAL METHODS, NOT PART OF THE BackingStore INTERFACE.
+// ------------------------------------------------------------------------
+
+// Flush commits the active segment file to stable storage, so that every
+// message stored so far is durable.
+func (w *WALStore) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrStoreClosed
+	}
+	if w.active == nil {
+		return nil
+	}
+	err := w.active.file.Sync()
+	if err != nil {
+		return fmt.Errorf("file.Sync(): %v", err)
+	}
+	return nil
+}
+
+// Close flushes the log, and closes its segment files. Every method of the
+// store returns ErrStoreClosed thereafter. Calling Close again does nothing.
+func (w *WALStore) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	var err error
+	if w.active != nil {
+		err = w.active.file.Sync()
+	}
+	closeErr := w.closeSegments()
+	if err != nil {
+		return fmt.Errorf("file.Sync(): %v", err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("closeSegments(): %v", closeErr)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------
+// Helper functions.
+// ------------------------------------------------------------------------
+
+// pollIfTopicKnown is like Poll, except that it treats a topic that has
+// never been stored to as simply having no messages yet. (The check and the
+// poll are made atomically.)
+func (w *WALStore) pollIfTopicKnown(ctx context.Context, topic string,
+	readFrom int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		return nil, -1, ErrStoreClosed
+	}
+	if _, ok := w.topics[topic]; ok == false {
+		return []minikafka.Message{}, readFrom, nil
+	}
+	return w.pollN(ctx, topic, readFrom, 0)
+}
+
+// pollN is the implementation of PollN. It is not responsible for mutex
+// protection. The context is checked before each record is read.
+func (w *WALStore) pollN(ctx context.Context, topic string, readFrom int,
+	maxMessages int) (
+	foundMessages []minikafka.Message, newReadFrom int, err error) {
+	index, ok := w.topics[topic]
+	if ok == false {
+		return nil, -1, fmt.Errorf("%w: %s", contract.ErrTopicNotFound, topic)
+	}
+	serveFrom := sort.Search(len(index.entries), func(i int) bool {
+		return index.entries[i].number >= readFrom
+	})
+	toServe := index.entries[serveFrom:]
+	if maxMessages > 0 && len(toServe) > maxMessages {
+		toServe = toServe[:maxMessages]
+	}
+
+	foundMessages = []minikafka.Message{}
+	newReadFrom = readFrom
+	for _, where := range toServe {
+		err = ctx.Err()
+		if err != nil {
+			return nil, -1, err
+		}
+		stored, err := w.read(where)
+		if err != nil {
+			return nil, -1, fmt.Errorf("read(): %v", err)
+		}
+		foundMessages = append(foundMessages, stored.Message)
+		newReadFrom = where.number + 1
+	}
+	return foundMessages, newReadFrom, nil
+}
+
+// read provides the message whose record is at the given place in the log.
+func (w *WALStore) read(where entry) (records.StoredMessage, error) {
+	framed := make([]byte, where.length)
+	_, err := w.segments[where.segment].file.ReadAt(framed, where.offset)
+	if err != nil {
+		return records.StoredMessage{}, fmt.Errorf("file.ReadAt(): %v", err)
+	}
+	encoded, _, err := records.Unframe(framed)
+	if err != nil {
+		return records.StoredMessage{}, fmt.Errorf("records.Unframe(): %w",
+			err)
+	}
+	rec, err := decodeRecord(encoded)
+	if err != nil {
+		return records.StoredMessage{}, fmt.Errorf("decodeRecord(): %w", err)
+	}
+	return rec.stored, nil
+}
+
+// append appends the record to the log, rolling over to a new segment first
+// when the active one has no room for it, and provides where it was put.
+func (w *WALStore) append(rec walRecord) (where entry, err error) {
+	framed, err := encodeRecord(rec)
+	if err != nil {
+		return entry{}, fmt.Errorf("encodeRecord(): %v", err)
+	}
+	if int64(len(framed)) > records.MaxFramedSize {
+		return entry{}, fmt.Errorf(
+			"%w: record size (%d) exceeds the largest record (%d)",
+			contract.ErrMessageTooLarge, len(framed), records.MaxFramedSize)
+	}
+	length := int64(len(framed))
+	if w.active == nil ||
+		(w.active.size > 0 && w.active.size+length > w.maxSegmentSize) {
+		err = w.startSegment()
+		if err != nil {
+			return entry{}, fmt.Errorf("startSegment(): %v", err)
+		}
+	}
+	_, err = w.active.file.Write(framed)
+	if err != nil {
+		// Do not leave part of a record behind, for the next to follow.
+		w.active.file.Truncate(w.active.size)
+		return entry{}, fmt.Errorf("file.Write(): %v", err)
+	}
+	if w.syncOnWrite {
+		err = w.active.file.Sync()
+		if err != nil {
+			return entry{}, fmt.Errorf("file.Sync(): %v", err)
+		}
+	}
+	where = entry{number: rec.number, created: rec.stored.Created,
+		segment: w.active.seq, offset: w.active.size, length: length}
+	w.active.size += length
+	return where, nil
+}
+
+// startSegment creates a new segment file, and makes it the active one.
+func (w *WALStore) startSegment() error {
+	seq := 1
+	if w.active != nil {
+		seq = w.active.seq + 1
+	}
+	file, err := os.OpenFile(segmentPath(w.RootDir, seq),
+		os.O_CREATE|os.O_EXCL|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile(): %v", err)
+	}
+	w.active = &segment{seq: seq, file: file}
+	w.segments[seq] = w.active
+	if w.oldest == 0 {
+		w.oldest = seq
+	}
+	return nil
+}
+
+// forgetEntries notes that the messages with the given entries are no
+// longer in the index.
+func (w *WALStore) forgetEntries(entries []entry) {
+	for _, where := range entries {
+		w.segments[where.segment].live--
+	}
+}
+
+// recordRemoval appends a record to the log that says which of the topic's
+// messages remain: those from the oldest in its index, or, when it has none,
+// from its next message number. So that the numbering carries on from there
+// when the store is reopened, even should every segment holding its messages
+// be removed.
+func (w *WALStore) recordRemoval(topic string, index *topicIndex) error {
+	number := index.next
+	if len(index.entries) > 0 {
+		number = index.entries[0].number
+	}
+	_, err := w.append(walRecord{kind: removeBeforeRecord, topic: topic,
+		number: number})
+	if err != nil {
+		return fmt.Errorf("append(): %v", err)
+	}
+	return nil
+}
+
+// removeSpentSegments removes the oldest segments, for as long as they hold
+// no messages that are in the index. The active segment is never removed.
+// Any record in them of a removal, or deletion, concerns only messages in
+// them, or in older segments, so can go too - except that a topic left with
+// no messages would lose its numbering. So that is recorded afresh first.
+func (w *WALStore) removeSpentSegments() error {
+	spent := []int{}
+	for seq := w.oldest; w.active != nil && seq < w.active.seq; seq++ {
+		seg, ok := w.segments[seq]
+		if ok == false {
+			continue
+		}
+		if seg.live != 0 {
+			break
+		}
+		spent = append(spent, seq)
+	}
+	if len(spent) == 0 {
+		return nil
+	}
+	for topic, index := range w.topics {
+		if len(index.entries) == 0 {
+			err := w.recordRemoval(topic, index)
+			if err != nil {
+				return fmt.Errorf("recordRemoval(): %v", err)
+			}
+		}
+	}
+	for _, seq := range spent {
+		err := w.removeSegment(seq)
+		if err != nil {
+			return fmt.Errorf("removeSegment(): %v", err)
+		}
+		w.oldest = seq + 1
+	}
+	return nil
+}
+
+// removeSegment closes, and removes the segment file with the given
+// sequence number.
+func (w *WALStore) removeSegment(seq int) error {
+	seg := w.segments[seq]
+	err := seg.file.Close()
+	if err != nil {
+		return fmt.Errorf("file.Close(): %v", err)
+	}
+	delete(w.segments, seq)
+	err = os.Remove(segmentPath(w.RootDir, seq))
+	if err != nil {
+		return fmt.Errorf("os.Remove(): %v", err)
+	}
+	return nil
+}
+
+// closeSegments closes every segment file, carrying on regardless of errors,
+// and reporting the first.
+func (w *WALStore) closeSegments() error {
+	var firstErr error
+	for seq, seg := range w.segments {
+		// A segment that is being replayed has not been opened yet.
+		if seg.file != nil {
+			err := seg.file.Close()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		delete(w.segments, seq)
+	}
+	w.active = nil
+	return firstErr
+}
+
+// replay rebuilds the index by reading every record in the segment files
+// in the root directory, in order, and opens each of them.
+func (w *WALStore) replay() error {
+	seqs, err := segmentSequenceNumbers(w.RootDir)
+	if err != nil {
+		return fmt.Errorf("segmentSequenceNumbers(): %v", err)
+	}
+	for i, seq := range seqs {
+		filePath := segmentPath(w.RootDir, seq)
+		contents, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("ioutil.ReadFile(): %v", err)
+		}
+		decodedLength := w.replaySegment(seq, contents)
+		if decodedLength < int64(len(contents)) {
+			if i != len(seqs)-1 {
+				return fmt.Errorf("%s: ends part way through a record",
+					filePath)
+			}
+			// An append was interrupted.
+			err = os.Truncate(filePath, decodedLength)
+			if err != nil {
+				return fmt.Errorf("os.Truncate(): %v", err)
+			}
+		}
+		file, err := os.OpenFile(filePath, os.O_RDWR|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("os.OpenFile(): %v", err)
+		}
+		w.active = w.segments[seq]
+		w.active.file, w.active.size = file, decodedLength
+		if w.oldest == 0 {
+			w.oldest = seq
+		}
+	}
+	return nil
+}
+
+// replaySegment applies each record in the given contents of a segment to
+// the index, and provides how many bytes it decoded - which is less than the
+// length of the contents when they end part way through a record.
+func (w *WALStore) replaySegment(seq int, contents []byte) (
+	decodedLength int64) {
+	w.segments[seq] = &segment{seq: seq}
+	offset := int64(0)
+	for offset < int64(len(contents)) {
+		encoded, frameLength, err := records.Unframe(contents[offset:])
+		if errors.Is(err, records.ErrIncompleteRecord) {
+			break
+		}
+		if err == nil {
+			var rec walRecord
+			rec, err = decodeRecord(encoded)
+			if err == nil {
+				w.apply(rec, entry{number: rec.number,
+					created: rec.stored.Created, segment: seq,
+					offset: offset, length: frameLength})
+			}
+		}
+		// Records that cannot be decoded are skipped.
+		offset += frameLength
+	}
+	return offset
+}
+
+// apply applies a record found in the log, at the given place, to the index.
+func (w *WALStore) apply(rec walRecord, where entry) {
+	index, ok := w.topics[rec.topic]
+	switch rec.kind {
+	case messageRecord:
+		if ok == false {
+			index = &topicIndex{}
+			w.topics[rec.topic] = index
+		}
+		index.entries = append(index.entries, where)
+		index.next = rec.number + 1
+		w.segments[where.segment].live++
+	case deleteTopicRecord:
+		if ok {
+			w.forgetEntries(index.entries)
+			delete(w.topics, rec.topic)
+		}
+	case removeBeforeRecord:
+		if ok == false {
+			index = &topicIndex{next: 1}
+			w.topics[rec.topic] = index
+		}
+		keepFrom := sort.Search(len(index.entries), func(i int) bool {
+			return index.entries[i].number >= rec.number
+		})
+		w.forgetEntries(index.entries[:keepFrom])
+		index.entries = index.entries[keepFrom:]
+		if index.next < rec.number {
+			index.next = rec.number
+		}
+	}
+}
+
+// segmentSequenceNumbers provides the sequence numbers of the segment files
+// in the given directory, in ascending order.
+func segmentSequenceNumbers(rootDir string) ([]int, error) {
+	entities, err := ioutil.ReadDir(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadDir(): %v", err)
+	}
+	seqs := []int{}
+	for _, entity := range entities {
+		name := entity.Name()
+		if entity.IsDir() || strings.HasSuffix(name, segmentSuffix) == false {
+			continue
+		}
+		seq, err := strconv.Atoi(strings.TrimSuffix(name, segmentSuffix))
+		if err != nil || seq < 1 {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	return seqs, nil
+}
+
+// segmentPath provides the path of the segment file with the given sequence
+// number.
+func segmentPath(rootDir string, seq int) string {
+	return filepath.Join(rootDir, fmt.Sprintf("%020d%s", seq, segmentSuffix))
+}
+
+// ------------------------------------------------------------------------
+// AUXILLIARY CODE
+// ------------------------------------------------------------------------
+
+// The kinds of record in the log.
+const (
+	messageRecord      byte = 1 // A message stored to a topic.
+	deleteTopicRecord  byte = 2 // A topic was deleted.
+	removeBeforeRecord byte = 3 // A topic's messages before number went.
+)
+
+// walRecord is the content of a record in the log, which is encoded as its
+// kind, its topic (preceded by its length), and then, according to its kind:
+// the StoredMessage (encoded with records.DefaultSerializer), nothing, or its
+// number. The whole is framed with records.Frame, so that its integrity can
+// be checked when it is read.
+type walRecord struct {
+	kind   byte
+	topic  string
+	number int
+	stored records.StoredMessage // Only for a messageRecord.
+}
+
+// encodeRecord provides the framed encoding of the record.
+func encodeRecord(rec walRecord) ([]byte, error) {
+	encoded := []byte{rec.kind}
+	encoded = binary.AppendUvarint(encoded, uint64(len(rec.topic)))
+	encoded = append(encoded, rec.topic...)
+	switch rec.kind {
+	case messageRecord:
+		stored, err := records.DefaultSerializer.Encode(rec.stored)
+		if err != nil {
+			return nil, fmt.Errorf("Encode(): %v", err)
+		}
+		encoded = append(encoded, stored...)
+	case removeBeforeRecord:
+		encoded = binary.AppendUvarint(encoded, uint64(rec.number))
+	}
+	return records.Frame(encoded), nil
+}
+
+// decodeRecord reconstructs the record from its (unframed) encoding.
+func decodeRecord(encoded []byte) (walRecord, error) {
+	if len(encoded) < 1 {
+		return walRecord{}, fmt.Errorf("%w: record is empty",
+			records.ErrCorruptRecord)
+	}
+	rec := walRecord{kind: encoded[0]}
+	topicLength, n := binary.Uvarint(encoded[1:])
+	rest := encoded[1:]
+	if n <= 0 || topicLength > uint64(len(rest)-n) {
+		return walRecord{}, fmt.Errorf("%w: topic is truncated",
+			records.ErrCorruptRecord)
+	}
+	rec.topic = string(rest[n : n+int(topicLength)])
+	rest = rest[n+int(topicLength):]
+	switch rec.kind {
+	case messageRecord:
+		stored, err := records.DefaultSerializer.Decode(rest)
+		if err != nil {
+			return walRecord{}, fmt.Errorf("%w: %v", records.ErrCorruptRecord,
+				err)
+		}
+		rec.stored = stored
+		rec.number = int(stored.MsgNum)
+	case deleteTopicRecord:
+	case removeBeforeRecord:
+		number, n := binary.Uvarint(rest)
+		if n <= 0 {
+			return walRecord{}, fmt.Errorf("%w: number is truncated",
+				records.ErrCorruptRecord)
+		}
+		rec.number = int(number)
+	default:
+		return walRecord{}, fmt.Errorf("%w: unknown kind of record: %d",
+			records.ErrCorruptRecord, rec.kind)
+	}
+	return rec, nil
+}
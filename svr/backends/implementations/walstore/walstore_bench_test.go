@@ -0,0 +1,57 @@
+package walstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore"
+)
+
+// BenchmarkManyTopicsFewMessages compares the WALStore with the FileStore
+// for a workload of many topics, each holding few messages: it stores a
+// message to each of 1000 topics in turn, polling each topic's new message
+// back.
+func BenchmarkManyTopicsFewMessages(b *testing.B) {
+	cases := []struct {
+		name string
+		open func(rootDir string) (contract.BackingStore, error)
+	}{
+		{"WALStore", func(rootDir string) (contract.BackingStore, error) {
+			return NewWALStore(rootDir)
+		}},
+		{"FileStore", func(rootDir string) (contract.BackingStore, error) {
+			return filestore.NewFileStore(rootDir)
+		}},
+	}
+	const nTopics = 1000
+	ctx := context.Background()
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			rootDir, err := os.MkdirTemp("", "walstore")
+			if err != nil {
+				b.Fatalf("os.MkdirTemp(): %v", err)
+			}
+			defer os.RemoveAll(rootDir)
+			store, err := c.open(rootDir)
+			if err != nil {
+				b.Fatalf("open(): %v", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				topic := fmt.Sprintf("topic_%d", i%nTopics)
+				msgNumber, err := store.Store(ctx, topic,
+					[]byte(fmt.Sprintf("benchmark message %d", i)))
+				if err != nil {
+					b.Fatalf("store.Store(): %v", err)
+				}
+				_, _, err = store.Poll(ctx, topic, msgNumber)
+				if err != nil {
+					b.Fatalf("store.Poll(): %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package walstore
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+)
+
+// TestWALStore ensures that WALStore passes all the tests defined for the
+// BackingStore interface it claims to satisfy.
+func TestWALStore(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+	store := openStore(t, rootDir)
+	defer store.Close()
+	contract.RunBackingStoreTests(t, store)
+}
+
+// TestReopenReplaysTheLog makes sure that a reopened store holds the same
+// messages as it did before - including when topics have been deleted, and
+// messages removed - and that message numbering carries on where it was.
+func TestReopenReplaysTheLog(t *testing.T) {
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	store := openStore(t, rootDir)
+	for i := 1; i <= 3; i++ {
+		for _, topic := range []string{"topic_a", "topic_b", "topic_c"} {
+			_, err := store.Store(ctx, topic,
+				[]byte(fmt.Sprintf("%s_%d", topic, i)))
+			assert.Nil(t, err)
+		}
+	}
+	err := store.DeleteTopic(ctx, "topic_b")
+	assert.Nil(t, err)
+	err = store.RemoveOldMessages(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	_, err = store.Store(ctx, "topic_a", []byte("topic_a_4"))
+	assert.Nil(t, err)
+	err = store.Close()
+	assert.Nil(t, err)
+	_, _, err = store.Poll(ctx, "topic_a", 1)
+	assert.Equal(t, ErrStoreClosed, err)
+
+	store = openStore(t, rootDir)
+	defer store.Close()
+	topics, err := store.ListTopics(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"topic_a", "topic_c"}, topics)
+	messages, newReadFrom, err := store.Poll(ctx, "topic_a", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, "topic_a_4", string(messages[0]))
+	assert.Equal(t, 5, newReadFrom)
+	messages, newReadFrom, err = store.Poll(ctx, "topic_c", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(messages))
+	assert.Equal(t, 1, newReadFrom)
+	msgNumber, err := store.Store(ctx, "topic_c", []byte("topic_c_4"))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, msgNumber)
+	msgNumber, err = store.Store(ctx, "topic_b", []byte("topic_b_1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, msgNumber)
+}
+
+// TestSpentSegmentsAreRemoved makes sure that the log is rolled over to new
+// segment files as it grows, and that those holding only messages that have
+// been removed are removed too - without the topics losing their numbering
+// when the store is reopened.
+func TestSpentSegmentsAreRemoved(t *testing.T) {
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	store := openStore(t, rootDir, WithMaxSegmentSize(200))
+	for i := 1; i <= 10; i++ {
+		_, err := store.Store(ctx, fmt.Sprintf("topic_%d", i%2),
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(segmentFiles(t, rootDir)) > 2)
+
+	err := store.RemoveOldMessages(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(segmentFiles(t, rootDir)))
+	err = store.Close()
+	assert.Nil(t, err)
+
+	store = openStore(t, rootDir, WithMaxSegmentSize(200))
+	defer store.Close()
+	for _, topic := range []string{"topic_0", "topic_1"} {
+		messages, _, err := store.Poll(ctx, topic, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(messages))
+		msgNumber, err := store.Store(ctx, topic, []byte("another"))
+		assert.Nil(t, err)
+		assert.Equal(t, 6, msgNumber)
+	}
+}
+
+// TestRemoveOldKeepsMessagesCreatedAtMaxAge makes sure that only messages
+// created before the time given are removed, as the contract says, and not
+// one created at exactly that time.
+func TestRemoveOldKeepsMessagesCreatedAtMaxAge(t *testing.T) {
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	store := openStore(t, rootDir)
+	defer store.Close()
+	for i := 1; i <= 2; i++ {
+		_, err := store.Store(ctx, "topic", []byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	created := store.topics["topic"].entries[1].created
+
+	err := store.RemoveOldMessages(ctx, created)
+	assert.Nil(t, err)
+	messages, _, err := store.Poll(ctx, "topic", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, "message_2", string(messages[0]))
+
+	err = store.RemoveOldMessages(ctx, created.Add(time.Nanosecond))
+	assert.Nil(t, err)
+	messages, _, err = store.Poll(ctx, "topic", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(messages))
+}
+
+// TestTornRecordIsTruncated makes sure that when the log ends part way
+// through a record, as it will if an append was interrupted, reopening the
+// store truncates it away, leaving the messages before it intact.
+func TestTornRecordIsTruncated(t *testing.T) {
+	ctx := context.Background()
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+
+	store := openStore(t, rootDir)
+	for i := 1; i <= 2; i++ {
+		_, err := store.Store(ctx, "some_topic",
+			[]byte(fmt.Sprintf("message_%d", i)))
+		assert.Nil(t, err)
+	}
+	err := store.Close()
+	assert.Nil(t, err)
+	filePath := segmentFiles(t, rootDir)[0]
+	info, err := os.Stat(filePath)
+	if err != nil {
+		msg := fmt.Sprintf("os.Stat(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	intactSize := info.Size()
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		msg := fmt.Sprintf("os.OpenFile(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	_, err = file.Write([]byte{0xff, 0xff, 0x00})
+	assert.Nil(t, err)
+	file.Close()
+
+	store = openStore(t, rootDir)
+	defer store.Close()
+	info, err = os.Stat(filePath)
+	assert.Nil(t, err)
+	assert.Equal(t, intactSize, info.Size())
+	msgNumber, err := store.Store(ctx, "some_topic", []byte("message_3"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, msgNumber)
+	messages, _, err := store.Poll(ctx, "some_topic", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(messages))
+	assert.Equal(t, "message_3", string(messages[2]))
+}
+
+// openStore provides a WALStore rooted at the given directory, failing the
+// test if it cannot.
+func openStore(t *testing.T, rootDir string, options ...Option) *WALStore {
+	store, err := NewWALStore(rootDir, options...)
+	if err != nil {
+		msg := fmt.Sprintf("NewWALStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	return store
+}
+
+// segmentFiles provides the paths of the segment files in the given
+// directory, in order.
+func segmentFiles(t *testing.T, rootDir string) []string {
+	entities, err := ioutil.ReadDir(rootDir)
+	if err != nil {
+		msg := fmt.Sprintf("ioutil.ReadDir(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	paths := []string{}
+	for _, entity := range entities {
+		if filepath.Ext(entity.Name()) == segmentSuffix {
+			paths = append(paths, filepath.Join(rootDir, entity.Name()))
+		}
+	}
+	return paths
+}
@@ -0,0 +1,56 @@
+// Package notify provides a means for a backing store to wake up clients who
+// are waiting for new messages to arrive in a topic.
+package notify
+
+import (
+	"sync"
+)
+
+// Notifier keeps a separate broadcast channel for each topic. Waiters obtain
+// the current channel for a topic, and it gets closed (and replaced) the next
+// time Notify is called for that topic. The zero value is ready to use.
+type Notifier struct {
+	mutex   sync.Mutex
+	waiting map[string]chan struct{} // Keyed on topic.
+}
+
+// Wait provides a channel that will be closed the next time Notify is called
+// for the given topic. Callers should obtain the channel *before* checking
+// for new messages, so that a message stored in between is not missed.
+func (n *Notifier) Wait(topic string) <-chan struct{} {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.waiting == nil {
+		n.waiting = map[string]chan struct{}{}
+	}
+	c, ok := n.waiting[topic]
+	if ok == false {
+		c = make(chan struct{})
+		n.waiting[topic] = c
+	}
+	return c
+}
+
+// Notify wakes up everything that is waiting on the given topic. It never
+// blocks.
+func (n *Notifier) Notify(topic string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	c, ok := n.waiting[topic]
+	if ok == false {
+		return
+	}
+	close(c)
+	delete(n.waiting, topic)
+}
+
+// NotifyAll wakes up everything that is waiting, on any topic. It never
+// blocks.
+func (n *Notifier) NotifyAll() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for topic, c := range n.waiting {
+		close(c)
+		delete(n.waiting, topic)
+	}
+}
@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyWakesAllWaiters(t *testing.T) {
+	var notifier Notifier
+	c1 := notifier.Wait("topicA")
+	c2 := notifier.Wait("topicA")
+	other := notifier.Wait("topicB")
+	notifier.Notify("topicA")
+	for _, c := range []<-chan struct{}{c1, c2} {
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			assert.FailNow(t, "Waiter was not woken.")
+		}
+	}
+	// Waiters on other topics are unaffected.
+	select {
+	case <-other:
+		assert.Fail(t, "Waiter on another topic was woken.")
+	default:
+	}
+}
+
+func TestWaitAfterNotifyGetsFreshChannel(t *testing.T) {
+	var notifier Notifier
+	// Notifying a topic nobody is waiting on is harmless.
+	notifier.Notify("topicA")
+	c := notifier.Wait("topicA")
+	select {
+	case <-c:
+		assert.Fail(t, "Fresh channel is already closed.")
+	default:
+	}
+}
+
+func TestNotifyAllWakesEveryTopic(t *testing.T) {
+	var notifier Notifier
+	cA := notifier.Wait("topicA")
+	cB := notifier.Wait("topicB")
+	notifier.NotifyAll()
+	for _, c := range []<-chan struct{}{cA, cB} {
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			assert.FailNow(t, "Waiter was not woken.")
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"context"
+
+	minikafka "github.com/peterhoward42/minikafka"
+)
+
+// PollBlockingFunc is the signature of a backing store's PollBlocking method.
+type PollBlockingFunc func(ctx context.Context, topic string, readFrom int) (
+	messages []minikafka.Message, newReadFrom int, err error)
+
+// Subscribe provides the implementation of the BackingStore Subscribe method
+// for any backing store, in terms of that store's PollBlocking method. It
+// launches a goroutine that keeps its own advancing read-from position, and
+// delivers each message in order on the returned message channel. Both
+// channels are closed when ctx is done, or after an error has been delivered
+// on the error channel.
+func Subscribe(ctx context.Context, pollBlocking PollBlockingFunc,
+	topic string, readFrom int) (<-chan minikafka.Message, <-chan error) {
+
+	messagesC := make(chan minikafka.Message)
+	errC := make(chan error, 1)
+
+	go func() {
+		defer close(messagesC)
+		defer close(errC)
+		for {
+			messages, newReadFrom, err := pollBlocking(ctx, topic, readFrom)
+			if err != nil {
+				// Cancellation is the normal way to stop, so is not reported.
+				if ctx.Err() == nil {
+					errC <- err
+				}
+				return
+			}
+			for _, msg := range messages {
+				select {
+				case messagesC <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+			readFrom = newReadFrom
+		}
+	}()
+
+	return messagesC, errC
+}
@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	minikafka "github.com/peterhoward42/minikafka"
+)
+
+func TestSubscribeReportsPollErrors(t *testing.T) {
+	failingPoll := func(ctx context.Context, topic string, readFrom int) (
+		[]minikafka.Message, int, error) {
+		return nil, -1, errors.New("disk on fire")
+	}
+	messages, errs := Subscribe(
+		context.Background(), failingPoll, "topicA", 1)
+	select {
+	case err := <-errs:
+		assert.EqualError(t, err, "disk on fire")
+	case <-time.After(time.Second):
+		assert.FailNow(t, "No error was delivered.")
+	}
+	// Both channels are then closed.
+	_, ok := <-messages
+	assert.False(t, ok)
+	_, ok = <-errs
+	assert.False(t, ok)
+}
+
+func TestSubscribeClosesChannelsOnCancel(t *testing.T) {
+	var notifier Notifier
+	blockingPoll := func(ctx context.Context, topic string, readFrom int) (
+		[]minikafka.Message, int, error) {
+		select {
+		case <-ctx.Done():
+			return []minikafka.Message{}, readFrom, ctx.Err()
+		case <-notifier.Wait(topic):
+			return []minikafka.Message{}, readFrom, nil
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, errs := Subscribe(ctx, blockingPoll, "topicA", 1)
+	cancel()
+	_, ok := <-messages
+	assert.False(t, ok)
+	// Cancellation is not reported as an error.
+	_, ok = <-errs
+	assert.False(t, ok)
+}
@@ -0,0 +1,101 @@
+// Package snapshot provides a means to export a topic from a backing store,
+// and to import it into another one - which need not be of the same kind.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+)
+
+// Format is the name that identifies a snapshot, and Version the version of
+// the format that ExportTopic writes.
+const (
+	Format  = "minikafka-topic-snapshot"
+	Version = 1
+)
+
+// ErrNotASnapshot is returned (wrapped) by ImportTopic when what it reads
+// is not a snapshot it understands.
+var ErrNotASnapshot = errors.New("not a topic snapshot")
+
+// header is the first line of a snapshot. Each line after it is a
+// contract.Record, encoded as JSON.
+type header struct {
+	Format  string
+	Version int
+	Topic   string // The topic the snapshot was exported from.
+}
+
+// ExportTopic provides the implementation of an ExportTopic method for any
+// store that offers contract.RecordStore. It writes all the records the
+// store retains for the topic to the given writer, as a line of JSON each,
+// preceded by a line that describes the snapshot. An unknown topic is
+// reported as it is by Poll, and any error from PollRecords - including one
+// that reports some records as unreadable - fails the export.
+func ExportTopic(ctx context.Context, store contract.RecordStore,
+	topic string, w io.Writer) error {
+	records, _, err := store.PollRecords(ctx, topic, 1)
+	if err != nil {
+		return fmt.Errorf("store.PollRecords(): %w", err)
+	}
+	encoder := json.NewEncoder(w)
+	err = encoder.Encode(header{Format: Format, Version: Version,
+		Topic: topic})
+	if err != nil {
+		return fmt.Errorf("encoder.Encode(): %v", err)
+	}
+	for _, record := range records {
+		err = encoder.Encode(record)
+		if err != nil {
+			return fmt.Errorf("encoder.Encode(): %v", err)
+		}
+	}
+	return nil
+}
+
+// ImportTopic provides the implementation of an ImportTopic method for any
+// store that offers contract.RecordStore. It reads a snapshot written by
+// ExportTopic, and stores its records in the given topic - which need not be
+// the one it was exported from. The records keep their times, keys and
+// headers, and the differences between their message numbers, as described
+// by StoreRecords. The snapshot is read in full before anything is stored,
+// so a malformed one stores nothing.
+func ImportTopic(ctx context.Context, store contract.RecordStore,
+	topic string, r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	var h header
+	err := decoder.Decode(&h)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotASnapshot, err)
+	}
+	if h.Format != Format || h.Version != Version {
+		return fmt.Errorf("%w: format %q, version %d", ErrNotASnapshot,
+			h.Format, h.Version)
+	}
+	records := []contract.Record{}
+	for {
+		var record contract.Record
+		err = decoder.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: record %d: %v", ErrNotASnapshot,
+				len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	_, _, err = store.StoreRecords(ctx, topic, records)
+	if err != nil {
+		return fmt.Errorf("store.StoreRecords(): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+)
+
+// sliceStore is a contract.RecordStore that holds a single topic's records
+// in a slice, and numbers those it is given as StoreRecords prescribes.
+type sliceStore struct {
+	records []contract.Record
+}
+
+func (s *sliceStore) PollRecords(ctx context.Context, topic string,
+	readFrom int) ([]contract.Record, int, error) {
+	if s.records == nil {
+		return nil, -1, contract.ErrTopicNotFound
+	}
+	return s.records, s.records[len(s.records)-1].Number + 1, nil
+}
+
+func (s *sliceStore) StoreRecords(ctx context.Context, topic string,
+	records []contract.Record) (int, int, error) {
+	next := 1
+	if len(s.records) > 0 {
+		next = s.records[len(s.records)-1].Number + 1
+	}
+	offset := next - records[0].Number
+	for _, record := range records {
+		record.Number += offset
+		s.records = append(s.records, record)
+	}
+	return records[0].Number + offset,
+		records[len(records)-1].Number + offset, nil
+}
+
+func TestExportThenImport(t *testing.T) {
+	ctx := context.Background()
+	created := time.Date(2020, 6, 30, 12, 0, 0, 0, time.UTC)
+	exported := &sliceStore{records: []contract.Record{
+		{Number: 3, Time: created, Key: []byte("key_3"),
+			Message: []byte("message_3")},
+		{Number: 5, Time: created.Add(time.Second),
+			Headers: map[string]string{"trace": "abc"},
+			Message: []byte("message_5")},
+	}}
+	var buf bytes.Buffer
+	err := ExportTopic(ctx, exported, "topic_a", &buf)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Equal(t,
+		`{"Format":"minikafka-topic-snapshot","Version":1,"Topic":"topic_a"}`,
+		lines[0])
+
+	imported := &sliceStore{records: []contract.Record{
+		{Number: 1, Time: created, Message: []byte("already here")}}}
+	err = ImportTopic(ctx, imported, "topic_b", &buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(imported.records))
+	// The numbers follow on, and keep their gap.
+	assert.Equal(t, 2, imported.records[1].Number)
+	assert.Equal(t, 4, imported.records[2].Number)
+	for i, record := range imported.records[1:] {
+		original := exported.records[i]
+		assert.True(t, original.Time.Equal(record.Time))
+		assert.Equal(t, original.Key, record.Key)
+		assert.Equal(t, original.Headers, record.Headers)
+		assert.Equal(t, original.Message, record.Message)
+	}
+}
+
+func TestExportOfUnknownTopic(t *testing.T) {
+	var buf bytes.Buffer
+	err := ExportTopic(context.Background(), &sliceStore{}, "topic_a", &buf)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+}
+
+func TestImportRefusesWhatIsNotASnapshot(t *testing.T) {
+	ctx := context.Background()
+	for _, notSnapshot := range []string{
+		"",
+		"garbage",
+		`{"Format":"something-else","Version":1}`,
+		`{"Format":"minikafka-topic-snapshot","Version":99}`,
+		`{"Format":"minikafka-topic-snapshot","Version":1}` + "\n" +
+			`{"Number":1,"Message":"bWVzc2FnZV8x"}` + "\ngarbage",
+	} {
+		store := &sliceStore{}
+		err := ImportTopic(ctx, store, "topic_a",
+			strings.NewReader(notSnapshot))
+		assert.True(t, errors.Is(err, ErrNotASnapshot), notSnapshot)
+		assert.Nil(t, store.records)
+	}
+}
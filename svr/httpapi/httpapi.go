@@ -0,0 +1,273 @@
+// Package httpapi provides an HTTP/JSON gateway to a backing store, for
+// clients that do not speak gRPC - and for debugging with curl.
+//
+// The routes are:
+//
+//	POST   /topics/{topic}/messages                - store the request's body
+//	GET    /topics/{topic}/messages?from=N&limit=M - poll the topic
+//	GET    /topics                                 - list the topics
+//	DELETE /topics/{topic}                         - delete the topic
+//
+// The limit is optional, and zero means no limit, as it does for PollN.
+// Responses are JSON, in which messages are base64 encoded, as is usual for
+// binary data in JSON. Errors are reported with a JSON body holding the
+// error, and a status code that reflects the contract's typed errors: 400
+// for contract.ErrInvalidTopic, 404 for contract.ErrTopicNotFound, 413 for
+// contract.ErrMessageTooLarge, and 429 for contract.ErrQuotaExceeded. A
+// message body larger than the Handler will read (see WithMaxBodySize) is
+// also reported with 413, without the store being asked to store it.
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	minikafka "github.com/peterhoward42/minikafka"
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+)
+
+// DefaultMaxBodySize is the size (in bytes) of the largest request body that
+// a Handler reads, unless WithMaxBodySize says otherwise. It matches the
+// FileStore's default maximum file size, since no larger message would fit.
+const DefaultMaxBodySize = 1024 * 1024 // 1 MiB
+
+// StoreResponse is the response to storing a message.
+type StoreResponse struct {
+	MessageNumber int `json:"messageNumber"`
+}
+
+// PollResponse is the response to polling a topic. NewReadFrom is where the
+// next poll should read from, to carry on after these messages.
+type PollResponse struct {
+	Messages    []minikafka.Message `json:"messages"`
+	NewReadFrom int                 `json:"newReadFrom"`
+}
+
+// TopicsResponse is the response to listing the topics.
+type TopicsResponse struct {
+	Topics []string `json:"topics"`
+}
+
+// ErrorResponse is the response when a request fails.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler is an http.Handler that serves the routes described in the
+// package documentation, by delegating to a backing store.
+type Handler struct {
+	store contract.BackingStore
+
+	// The size of the largest request body that is read.
+	maxBodySize int64
+}
+
+// Option is a functional option that can be passed to NewHandler to override
+// one of the Handler's default settings.
+type Option func(*Handler)
+
+// WithMaxBodySize sets the size (in bytes) of the largest request body that
+// the Handler reads. A message that is larger is refused with 413, before it
+// reaches the store - so that a client cannot make the server hold an
+// unbounded body in memory. The default is DefaultMaxBodySize.
+func WithMaxBodySize(size int64) Option {
+	return func(h *Handler) {
+		h.maxBodySize = size
+	}
+}
+
+// NewHandler provides a Handler that serves the given store.
+func NewHandler(store contract.BackingStore, options ...Option) *Handler {
+	h := &Handler{store: store, maxBodySize: DefaultMaxBodySize}
+	for _, option := range options {
+		option(h)
+	}
+	return h
+}
+
+// ServeHTTP is defined by, and documented in the http.Handler interface.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Route on the path's segments: "topics", then optionally a topic,
+	// then optionally "messages". The path is split before it is unescaped,
+	// so that an escaped slash in a topic reaches the store, which can say
+	// whether the topic is allowed.
+	segments := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			writeError(w, http.StatusBadRequest,
+				fmt.Errorf("url.PathUnescape: %v", err))
+			return
+		}
+		segments[i] = unescaped
+	}
+	switch {
+	case len(segments) == 1 && segments[0] == "topics":
+		h.serveTopics(w, r)
+	case len(segments) == 2 && segments[0] == "topics" && segments[1] != "":
+		h.serveTopic(w, r, segments[1])
+	case len(segments) == 3 && segments[0] == "topics" &&
+		segments[1] != "" && segments[2] == "messages":
+		h.serveMessages(w, r, segments[1])
+	default:
+		writeError(w, http.StatusNotFound,
+			fmt.Errorf("no such route: %s", r.URL.Path))
+	}
+}
+
+// serveTopics serves the /topics route.
+func (h *Handler) serveTopics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	topics, err := h.store.ListTopics(r.Context())
+	if err != nil {
+		writeStoreError(w, fmt.Errorf("store.ListTopics: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, TopicsResponse{Topics: topics})
+}
+
+// serveTopic serves the /topics/{topic} route.
+func (h *Handler) serveTopic(w http.ResponseWriter, r *http.Request,
+	topic string) {
+	if r.Method != http.MethodDelete {
+		writeMethodNotAllowed(w, http.MethodDelete)
+		return
+	}
+	err := h.store.DeleteTopic(r.Context(), topic)
+	if err != nil {
+		writeStoreError(w, fmt.Errorf("store.DeleteTopic: %w", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveMessages serves the /topics/{topic}/messages route.
+func (h *Handler) serveMessages(w http.ResponseWriter, r *http.Request,
+	topic string) {
+	switch r.Method {
+	case http.MethodPost:
+		h.storeMessage(w, r, topic)
+	case http.MethodGet:
+		h.poll(w, r, topic)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// storeMessage stores the request's body as a message in the topic.
+func (h *Handler) storeMessage(w http.ResponseWriter, r *http.Request,
+	topic string) {
+	message, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body,
+		h.maxBodySize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge,
+			fmt.Errorf("message body is larger than %d bytes", tooLarge.Limit))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest,
+			fmt.Errorf("ioutil.ReadAll: %v", err))
+		return
+	}
+	messageNumber, err := h.store.Store(r.Context(), topic, message)
+	if err != nil {
+		writeStoreError(w, fmt.Errorf("store.Store: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated,
+		StoreResponse{MessageNumber: messageNumber})
+}
+
+// poll provides the messages in the topic, from the read-from given by the
+// request's query.
+func (h *Handler) poll(w http.ResponseWriter, r *http.Request,
+	topic string) {
+	readFrom, err := queryInt(r, "from", 1)
+	if err == nil && readFrom < 0 {
+		err = fmt.Errorf("from must not be negative: %d", readFrom)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := queryInt(r, "limit", 0)
+	if err == nil && limit < 0 {
+		err = fmt.Errorf("limit must not be negative: %d", limit)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	messages, newReadFrom, err := h.store.PollN(
+		r.Context(), topic, readFrom, limit)
+	if err != nil {
+		writeStoreError(w, fmt.Errorf("store.PollN: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK,
+		PollResponse{Messages: messages, NewReadFrom: newReadFrom})
+}
+
+// queryInt provides the integer value of the named query parameter, or the
+// default given, when it is absent.
+func queryInt(r *http.Request, name string, defaultValue int) (int, error) {
+	text := r.URL.Query().Get(name)
+	if text == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not an integer: %q", name, text)
+	}
+	return value, nil
+}
+
+// writeStoreError reports an error from the store, with the status code that
+// reflects it.
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, contract.ErrInvalidTopic):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, contract.ErrTopicNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, contract.ErrMessageTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+	case errors.Is(err, contract.ErrQuotaExceeded):
+		writeError(w, http.StatusTooManyRequests, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// writeMethodNotAllowed reports that the route does not support the
+// request's method, and which methods it does.
+func writeMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed,
+		fmt.Errorf("method not allowed"))
+}
+
+// writeError reports the error with the given status code.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
+
+// writeJSON writes the given value as the response's JSON body, with the
+// given status code.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	// The status has already been sent, so there is nothing more to be done
+	// if the value cannot be written.
+	json.NewEncoder(w).Encode(value)
+}
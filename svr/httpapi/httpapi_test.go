@@ -0,0 +1,166 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/peterhoward42/minikafka/svr/backends/contract"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/filestore/ioutils"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/memstore"
+)
+
+// TestRoutes makes sure that each route does what it should, when it is used
+// correctly, delegating to a MemStore.
+func TestRoutes(t *testing.T) {
+	server := httptest.NewServer(NewHandler(memstore.NewMemStore()))
+	defer server.Close()
+
+	for i := 1; i <= 3; i++ {
+		var stored StoreResponse
+		status := do(t, server, http.MethodPost, "/topics/topic_a/messages",
+			fmt.Sprintf("message_%d", i), &stored)
+		assert.Equal(t, http.StatusCreated, status)
+		assert.Equal(t, i, stored.MessageNumber)
+	}
+	status := do(t, server, http.MethodPost, "/topics/topic_b/messages",
+		"other", nil)
+	assert.Equal(t, http.StatusCreated, status)
+
+	var polled PollResponse
+	status = do(t, server, http.MethodGet,
+		"/topics/topic_a/messages?from=2&limit=1", "", &polled)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 1, len(polled.Messages))
+	assert.Equal(t, "message_2", string(polled.Messages[0]))
+	assert.Equal(t, 3, polled.NewReadFrom)
+	status = do(t, server, http.MethodGet, "/topics/topic_a/messages", "",
+		&polled)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 3, len(polled.Messages))
+	assert.Equal(t, 4, polled.NewReadFrom)
+
+	var listed TopicsResponse
+	status = do(t, server, http.MethodGet, "/topics", "", &listed)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"topic_a", "topic_b"}, listed.Topics)
+
+	status = do(t, server, http.MethodDelete, "/topics/topic_a", "", nil)
+	assert.Equal(t, http.StatusNoContent, status)
+	status = do(t, server, http.MethodGet, "/topics", "", &listed)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"topic_b"}, listed.Topics)
+}
+
+// TestErrorMappings makes sure that the errors the store reports, and bad
+// requests, are reported with the right status code, and an error in the
+// body.
+func TestErrorMappings(t *testing.T) {
+	rootDir := ioutils.TmpRootDir(t)
+	defer os.RemoveAll(rootDir)
+	store, err := filestore.NewFileStore(rootDir,
+		filestore.WithMaxMessageSize(500))
+	if err != nil {
+		msg := fmt.Sprintf("NewFileStore(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	server := httptest.NewServer(NewHandler(store))
+	defer server.Close()
+	status := do(t, server, http.MethodPost, "/topics/topic_a/messages",
+		"message", nil)
+	assert.Equal(t, http.StatusCreated, status)
+	err = store.SetQuota("topic_b", 0, 1)
+	if err != nil {
+		msg := fmt.Sprintf("SetQuota(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	status = do(t, server, http.MethodPost, "/topics/topic_b/messages",
+		"message", nil)
+	assert.Equal(t, http.StatusCreated, status)
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodGet, "/topics/nosuchtopic/messages", "",
+			http.StatusNotFound},
+		{http.MethodPost, "/topics/topic_a/messages",
+			strings.Repeat("x", 1000), http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "/topics/topic_b/messages", "message",
+			http.StatusTooManyRequests},
+		{http.MethodPost, "/topics/..%2F/messages", "message",
+			http.StatusBadRequest},
+		{http.MethodGet, "/topics/..%2F/messages", "", http.StatusBadRequest},
+		{http.MethodGet, "/topics/topic_a/messages?from=abc", "",
+			http.StatusBadRequest},
+		{http.MethodGet, "/topics/topic_a/messages?limit=-1", "",
+			http.StatusBadRequest},
+		{http.MethodPut, "/topics/topic_a/messages", "",
+			http.StatusMethodNotAllowed},
+		{http.MethodPost, "/topics", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/topics/topic_a", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/nosuchroute", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		var failed ErrorResponse
+		status := do(t, server, test.method, test.path, test.body, &failed)
+		assert.Equal(t, test.status, status, test.method+" "+test.path)
+		assert.NotEqual(t, "", failed.Error, test.method+" "+test.path)
+	}
+}
+
+// TestMaxBodySize makes sure that a message body larger than the Handler
+// will read is refused, without the message being stored.
+func TestMaxBodySize(t *testing.T) {
+	store := memstore.NewMemStore()
+	server := httptest.NewServer(NewHandler(store, WithMaxBodySize(10)))
+	defer server.Close()
+
+	var failed ErrorResponse
+	status := do(t, server, http.MethodPost, "/topics/topic_a/messages",
+		strings.Repeat("x", 11), &failed)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+	assert.Contains(t, failed.Error, "10 bytes")
+	_, _, err := store.Poll(context.Background(), "topic_a", 1)
+	assert.True(t, errors.Is(err, contract.ErrTopicNotFound))
+
+	var stored StoreResponse
+	status = do(t, server, http.MethodPost, "/topics/topic_a/messages",
+		strings.Repeat("x", 10), &stored)
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, 1, stored.MessageNumber)
+}
+
+// do makes a request of the server, decoding the JSON response into the
+// value given, unless it is nil, and provides the response's status code.
+func do(t *testing.T, server *httptest.Server, method string, path string,
+	body string, value interface{}) int {
+	req, err := http.NewRequest(method, server.URL+path,
+		strings.NewReader(body))
+	if err != nil {
+		msg := fmt.Sprintf("http.NewRequest(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		msg := fmt.Sprintf("http.DefaultClient.Do(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	defer resp.Body.Close()
+	if value != nil {
+		err = json.NewDecoder(resp.Body).Decode(value)
+		assert.Nil(t, err)
+	}
+	return resp.StatusCode
+}
@@ -70,7 +70,7 @@ func (s *Server) Produce(
 
 	topicStr := req.GetTopic().Topic
 	messageBytes := req.GetPayload().Payload
-	msgNumber, err := s.store.Store(topicStr, messageBytes)
+	msgNumber, err := s.store.Store(ctx, topicStr, messageBytes)
 	if err != nil {
 		return nil, fmt.Errorf("store.Store: %v", err)
 	}
@@ -86,7 +86,8 @@ func (s *Server) Poll(ctx context.Context, req *pb.PollRequest) (
 
 	topicStr := req.GetTopic()
 	fromMsgNumber := req.GetReadFrom().GetMsgNumber()
-	messages, nextMsgNumber, err := s.store.Poll(topicStr, int(fromMsgNumber))
+	messages, nextMsgNumber, err := s.store.Poll(
+		ctx, topicStr, int(fromMsgNumber))
 	if err != nil {
 		return nil, fmt.Errorf("store.Poll: %v", err)
 	}
@@ -99,6 +100,63 @@ func (s *Server) Poll(ctx context.Context, req *pb.PollRequest) (
 		NewReadFrom: &pb.MsgNumber{MsgNumber: uint32(nextMsgNumber)}}, nil
 }
 
+// Consume is the server's handler function for the *Consume* API call. It
+// streams the messages in the topic, in the batches that PollBlocking
+// provides them, until the client goes away.
+func (s *Server) Consume(
+	req *pb.PollRequest, stream pb.MiniKafka_ConsumeServer) error {
+	// Long-poll the backing store repeatedly, advancing the read-from
+	// message number each time, and send on each batch of messages as it
+	// arrives.
+
+	ctx := stream.Context()
+	topicStr := req.GetTopic()
+	readFrom := int(req.GetReadFrom().GetMsgNumber())
+	for {
+		messages, nextMsgNumber, err := s.store.PollBlocking(
+			ctx, topicStr, readFrom)
+		if ctx.Err() != nil {
+			// The client has cancelled, or gone away.
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("store.PollBlocking: %v", err)
+		}
+		payloads := []*pb.Payload{}
+		for _, msg := range messages {
+			payloads = append(payloads, &pb.Payload{Payload: msg})
+		}
+		err = stream.Send(&pb.PollResponse{
+			Payloads:    payloads,
+			NewReadFrom: &pb.MsgNumber{MsgNumber: uint32(nextMsgNumber)}})
+		if err != nil {
+			return fmt.Errorf("stream.Send: %v", err)
+		}
+		readFrom = nextMsgNumber
+	}
+}
+
+// ListTopics is the server's handler function for the *ListTopics* API call.
+func (s *Server) ListTopics(ctx context.Context, req *pb.ListTopicsRequest) (
+	*pb.TopicList, error) {
+	topics, err := s.store.ListTopics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("store.ListTopics: %v", err)
+	}
+	return &pb.TopicList{Topics: topics}, nil
+}
+
+// DeleteTopic is the server's handler function for the *DeleteTopic* API
+// call.
+func (s *Server) DeleteTopic(ctx context.Context, req *pb.Topic) (
+	*pb.DeleteTopicResponse, error) {
+	err := s.store.DeleteTopic(ctx, req.GetTopic())
+	if err != nil {
+		return nil, fmt.Errorf("store.DeleteTopic: %v", err)
+	}
+	return &pb.DeleteTopicResponse{}, nil
+}
+
 //------------------------------------------------------------------------
 // Internal helpers
 //------------------------------------------------------------------------
@@ -147,7 +205,7 @@ func (s *Server) startCullingService(
 		// unary-minus on the *retentionTime* time.Duration struct.
 		maxAge := time.Now().Add(-retentionTime)
 		// Delegate to the backing store implementation.
-		err := s.store.RemoveOldMessages(maxAge)
+		err := s.store.RemoveOldMessages(context.Background(), maxAge)
 		if err != nil {
 			errc <- fmt.Errorf("store.RemoveOldMessages: %v", err)
 			return
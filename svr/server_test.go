@@ -0,0 +1,127 @@
+package svr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "github.com/peterhoward42/minikafka/protocol"
+	"github.com/peterhoward42/minikafka/svr/backends/implementations/memstore"
+)
+
+// TestProduceThenConsume makes sure that messages produced over gRPC are
+// streamed back by Consume, from the read-from requested, and that the
+// topics can be listed and deleted. The server is backed by a MemStore, and
+// served over an in-memory connection.
+func TestProduceThenConsume(t *testing.T) {
+	ctx := context.Background()
+	client, stop := startServer(t)
+	defer stop()
+
+	tests := []struct {
+		topic    string
+		produce  []string
+		readFrom uint32
+		want     []string
+	}{
+		{"topic_a", []string{"a1", "a2", "a3"}, 1, []string{"a1", "a2", "a3"}},
+		{"topic_b", []string{"b1", "b2", "b3"}, 3, []string{"b3"}},
+		{"topic_c", []string{"c1"}, 0, []string{"c1"}},
+	}
+	for _, test := range tests {
+		for _, message := range test.produce {
+			_, err := client.Produce(ctx, &pb.ProduceRequest{
+				Topic:   &pb.Topic{Topic: test.topic},
+				Payload: &pb.Payload{Payload: []byte(message)}})
+			assert.Nil(t, err)
+		}
+		got := consume(t, client, test.topic, test.readFrom, len(test.want))
+		assert.Equal(t, test.want, got, test.topic)
+	}
+
+	topicList, err := client.ListTopics(ctx, &pb.ListTopicsRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"topic_a", "topic_b", "topic_c"},
+		topicList.GetTopics())
+	_, err = client.DeleteTopic(ctx, &pb.Topic{Topic: "topic_b"})
+	assert.Nil(t, err)
+	topicList, err = client.ListTopics(ctx, &pb.ListTopicsRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"topic_a", "topic_c"}, topicList.GetTopics())
+}
+
+// TestConsumeWaitsForMessages makes sure that Consume streams messages that
+// are produced after it is called, including to a topic that did not exist
+// when it was called.
+func TestConsumeWaitsForMessages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, stop := startServer(t)
+	defer stop()
+
+	stream, err := client.Consume(ctx, &pb.PollRequest{Topic: "new_topic",
+		ReadFrom: &pb.MsgNumber{MsgNumber: 1}})
+	assert.Nil(t, err)
+	for i := 1; i <= 2; i++ {
+		_, err = client.Produce(ctx, &pb.ProduceRequest{
+			Topic:   &pb.Topic{Topic: "new_topic"},
+			Payload: &pb.Payload{Payload: []byte(fmt.Sprintf("m%d", i))}})
+		assert.Nil(t, err)
+		resp, err := stream.Recv()
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(i+1), resp.GetNewReadFrom().GetMsgNumber())
+	}
+}
+
+// startServer starts a server, backed by a MemStore, on an in-memory
+// connection, and provides a client connected to it, and a function to stop
+// them both.
+func startServer(t *testing.T) (pb.MiniKafkaClient, func()) {
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	pb.RegisterMiniKafkaServer(grpcServer, NewServer(memstore.NewMemStore()))
+	go grpcServer.Serve(listener)
+
+	conn, err := grpc.Dial("bufconn", grpc.WithInsecure(),
+		grpc.WithContextDialer(
+			func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}))
+	if err != nil {
+		msg := fmt.Sprintf("grpc.Dial(): %v", err)
+		assert.FailNow(t, msg)
+	}
+	stop := func() {
+		conn.Close()
+		grpcServer.Stop()
+	}
+	return pb.NewMiniKafkaClient(conn), stop
+}
+
+// consume calls Consume, and receives from the stream until it has the
+// given number of messages, before cancelling the call.
+func consume(t *testing.T, client pb.MiniKafkaClient, topic string,
+	readFrom uint32, nWanted int) []string {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Consume(ctx, &pb.PollRequest{Topic: topic,
+		ReadFrom: &pb.MsgNumber{MsgNumber: readFrom}})
+	assert.Nil(t, err)
+	got := []string{}
+	for len(got) < nWanted {
+		resp, err := stream.Recv()
+		if err != nil {
+			msg := fmt.Sprintf("stream.Recv(): %v", err)
+			assert.FailNow(t, msg)
+		}
+		for _, payload := range resp.GetPayloads() {
+			got = append(got, string(payload.GetPayload()))
+		}
+	}
+	return got
+}